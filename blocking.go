@@ -0,0 +1,318 @@
+package radio
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Wait describes a request blocked on keys using Blocker.
+type Wait struct {
+	// Keys are the keys the request waits on.
+	Keys []string
+
+	// Timeout is the maximum duration to wait. Zero waits forever.
+	Timeout time.Duration
+
+	// Ready is invoked when one of the keys is signalled ready. It must
+	// return true if it served the request (i.e., wrote the reply) or false
+	// to keep waiting (e.g., if another waiter consumed the data).
+	Ready func(wr ResponseWriter, key string) bool
+
+	// OnTimeout is invoked when the timeout expires or the client is
+	// unblocked using CLIENT UNBLOCK with TIMEOUT. Defaults to writing a
+	// null array.
+	OnTimeout func(wr ResponseWriter)
+}
+
+// Blocker parks requests waiting for keys to become ready (e.g., BLPOP).
+// Parked requests do not hold the goroutine that served them. The client of
+// a parked request is blocked and its further requests are not served until
+// the parked request is served, times out, or the client is unblocked. When
+// pipelined requests are executed concurrently (see Server.Concurrency), the
+// requests read before the client is blocked are served as usual.
+// Parked requests are cancelled when the client disconnects or the server
+// shuts down. Waiters of a key are served in the order they were parked.
+//
+// Blocker is a handler that serves CLIENT UNBLOCK and passes all the other
+// requests to the wrapped handler.
+type Blocker struct {
+	Handler Handler
+
+	mu       sync.Mutex
+	keys     map[string][]*waiter
+	clients  map[uint64]*waiter
+	serving  map[string]bool
+	signaled map[string]bool
+}
+
+type waiter struct {
+	wr     ResponseWriter
+	wait   Wait
+	client *Client
+
+	mu      sync.Mutex
+	state   int
+	pending func()
+	// finished is closed when the waiter is served, times out or is
+	// cancelled.
+	finished chan struct{}
+}
+
+const (
+	waiterIdle = iota
+	waiterServing
+	waiterDone
+)
+
+// Block parks the request until one of the keys is signalled using Ready,
+// the timeout expires or the client is unblocked or disconnects. Block must
+// be invoked by the handler after checking that none of the keys is ready.
+// If the request has no client (e.g., when handlers are invoked directly),
+// Block waits until the request is served or times out before returning.
+func (b *Blocker) Block(wr ResponseWriter, req *Request, wait Wait) {
+	w := &waiter{
+		wr:       wr,
+		wait:     wait,
+		client:   req.Client,
+		finished: make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	if b.keys == nil {
+		b.keys = map[string][]*waiter{}
+		b.clients = map[uint64]*waiter{}
+		b.serving = map[string]bool{}
+		b.signaled = map[string]bool{}
+	}
+
+	for _, key := range wait.Keys {
+		b.keys[key] = append(b.keys[key], w)
+	}
+	if w.client != nil {
+		b.clients[w.client.ID] = w
+	}
+	b.mu.Unlock()
+
+	if w.client == nil {
+		b.watch(w)
+		return
+	}
+
+	unblocked := w.client.block(req)
+	go func() {
+		b.watch(w)
+		w.client.unblock(unblocked)
+	}()
+}
+
+// Ready signals that the key is ready and serves the requests waiting on it
+// in the order they were parked until one of them is not served.
+func (b *Blocker) Ready(key string) {
+	b.mu.Lock()
+	if len(b.keys[key]) == 0 {
+		b.mu.Unlock()
+		return
+	}
+
+	if b.serving[key] {
+		// the key is being served (possibly by this goroutine through a
+		// waiter serving another key) and will be checked again.
+		b.signaled[key] = true
+		b.mu.Unlock()
+		return
+	}
+	b.serving[key] = true
+
+	for {
+		waiters := append([]*waiter(nil), b.keys[key]...)
+		b.mu.Unlock()
+
+		for _, w := range waiters {
+			served, ok := w.serve(key)
+			if !ok {
+				continue // finished or being served elsewhere
+			}
+
+			if !served {
+				break
+			}
+		}
+
+		b.mu.Lock()
+		if !b.signaled[key] {
+			break
+		}
+		delete(b.signaled, key)
+	}
+
+	delete(b.serving, key)
+	b.mu.Unlock()
+}
+
+// Unblock unblocks the client blocked by Block. The waiting request is
+// timed out, or fails with an UNBLOCKED error if withError is set. Returns
+// false if the client is not blocked.
+func (b *Blocker) Unblock(clientID uint64, withError bool) bool {
+	b.mu.Lock()
+	w, found := b.clients[clientID]
+	b.mu.Unlock()
+
+	if !found {
+		return false
+	}
+
+	if withError {
+		return w.finish(func() {
+			w.wr.Write(ErrorStr("UNBLOCKED client unblocked via CLIENT UNBLOCK"))
+		})
+	}
+	return w.finish(w.timeout)
+}
+
+// ServeRESP serves CLIENT UNBLOCK and passes other requests to the wrapped
+// handler.
+func (b *Blocker) ServeRESP(wr ResponseWriter, req *Request) {
+	if !strings.EqualFold(req.Command, "client") || len(req.Args) == 0 ||
+		!strings.EqualFold(req.Args[0], "unblock") {
+		b.Handler.ServeRESP(wr, req)
+		return
+	}
+
+	if len(req.Args) < 2 || len(req.Args) > 3 {
+		wr.Write(ErrorStr("ERR wrong number of arguments for 'client|unblock' command"))
+		return
+	}
+
+	id, err := strconv.ParseUint(req.Args[1], 10, 64)
+	if err != nil {
+		wr.Write(ErrorStr("ERR value is not an integer or out of range"))
+		return
+	}
+
+	withError := false
+	if len(req.Args) == 3 {
+		switch strings.ToLower(req.Args[2]) {
+		case "timeout":
+		case "error":
+			withError = true
+		default:
+			wr.Write(ErrorStr("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR"))
+			return
+		}
+	}
+
+	if b.Unblock(id, withError) {
+		wr.Write(Integer(1))
+	} else {
+		wr.Write(Integer(0))
+	}
+}
+
+// watch waits until the waiter is finished, times out or the client goes
+// away and removes the waiter.
+func (b *Blocker) watch(w *waiter) {
+	var timeout <-chan time.Time
+	if w.wait.Timeout > 0 {
+		timer := time.NewTimer(w.wait.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-w.finished:
+	case <-timeout:
+		w.finish(w.timeout)
+	case <-w.client.Context().Done():
+		w.finish(func() {})
+	}
+
+	b.remove(w)
+}
+
+func (b *Blocker) remove(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range w.wait.Keys {
+		waiters := b.keys[key]
+		for i, other := range waiters {
+			if other == w {
+				waiters = append(waiters[:i:i], waiters[i+1:]...)
+				break
+			}
+		}
+
+		if len(waiters) == 0 {
+			delete(b.keys, key)
+		} else {
+			b.keys[key] = waiters
+		}
+	}
+
+	if w.client != nil && b.clients[w.client.ID] == w {
+		delete(b.clients, w.client.ID)
+	}
+}
+
+// serve invokes the ready callback of the waiter. ok is false if the waiter
+// is already finished or being served. The lock is not held while invoking
+// the callback so that it can signal other keys.
+func (w *waiter) serve(key string) (served bool, ok bool) {
+	w.mu.Lock()
+	if w.state != waiterIdle {
+		w.mu.Unlock()
+		return false, false
+	}
+	w.state = waiterServing
+	w.mu.Unlock()
+
+	served = w.wait.Ready(w.wr, key)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if served || w.pending != nil {
+		if !served {
+			w.pending()
+		}
+		w.state = waiterDone
+		close(w.finished)
+		return served, true
+	}
+
+	w.state = waiterIdle
+	return false, true
+}
+
+// finish finishes the waiter by invoking fn if it is not already finished.
+// If the waiter is being served, fn is invoked only if it is not served.
+func (w *waiter) finish(fn func()) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch w.state {
+	case waiterDone:
+		return false
+
+	case waiterServing:
+		if w.pending == nil {
+			w.pending = fn
+		}
+		return true
+	}
+
+	fn()
+	w.state = waiterDone
+	close(w.finished)
+	return true
+}
+
+func (w *waiter) timeout() {
+	if w.wait.OnTimeout != nil {
+		w.wait.OnTimeout(w.wr)
+		return
+	}
+	w.wr.Write(&Array{})
+}
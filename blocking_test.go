@@ -0,0 +1,325 @@
+package radio_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+)
+
+func TestBlocker(suite *testing.T) {
+	suite.Parallel()
+
+	servers := map[string]func(ctx context.Context, l net.Listener, h radio.Handler) error{
+		"ClientLoop": radio.ListenAndServe,
+		"Concurrent": func(ctx context.Context, l net.Listener, h radio.Handler) error {
+			srv := &radio.Server{Handler: h, Concurrency: 4}
+			return srv.Serve(ctx, l)
+		},
+		"SingleExecutor": func(ctx context.Context, l net.Listener, h radio.Handler) error {
+			srv := &radio.Server{Handler: h, SingleExecutor: true}
+			return srv.Serve(ctx, l)
+		},
+		"EventLoop": func(ctx context.Context, l net.Listener, h radio.Handler) error {
+			return radio.ListenAndServeEventLoop(ctx, l, h, radio.EventLoopOptions{Loops: 2})
+		},
+	}
+
+	for name, serve := range servers {
+		serve := serve
+		suite.Run(name, func(t *testing.T) {
+			lh := newListHandler()
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go serve(ctx, l, lh.blocker)
+
+			dial := func() *radio.Conn {
+				conn, err := radio.Dial(l.Addr().String())
+				if err != nil {
+					t.Fatalf("failed to dial: %v", err)
+				}
+				return conn
+			}
+
+			t.Run("FIFO", func(t *testing.T) {
+				first, second, pusher := dial(), dial(), dial()
+				defer first.Close()
+				defer second.Close()
+				defer pusher.Close()
+
+				first.Send("BLPOP", "fifo", "0")
+				first.Send("PING")
+				first.Flush()
+				lh.waitBlocked(t, "fifo", 1)
+
+				second.Send("BLPOP", "fifo", "0")
+				second.Flush()
+				lh.waitBlocked(t, "fifo", 2)
+
+				if v, err := pusher.Do("RPUSH", "fifo", "a", "b"); err != nil || v != radio.Integer(2) {
+					t.Fatalf("expecting 2, got '%v' (err=%v)", v, err)
+				}
+
+				expectPopped(t, first, "fifo", "a")
+				if v, err := first.Receive(); err != nil || v != radio.SimpleStr("PONG") {
+					t.Errorf("expecting 'PONG' after unblocking, got '%v' (err=%v)", v, err)
+				}
+				expectPopped(t, second, "fifo", "b")
+			})
+
+			t.Run("PipelinedBefore", func(t *testing.T) {
+				conn, pusher := dial(), dial()
+				defer conn.Close()
+				defer pusher.Close()
+
+				// GET is still executing when BLPOP blocks the client if
+				// the requests are executed concurrently.
+				conn.Send("GET", "slow")
+				conn.Send("BLPOP", "pipelined", "0")
+				conn.Flush()
+
+				received := make(chan radio.Value, 1)
+				go func() {
+					v, _ := conn.Receive()
+					received <- v
+				}()
+
+				select {
+				case v := <-received:
+					if v != radio.SimpleStr("OK") {
+						t.Errorf("expecting 'OK', got '%v'", v)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("expecting reply of the request before the blocked one")
+				}
+
+				lh.waitBlocked(t, "pipelined", 1)
+				pusher.Do("RPUSH", "pipelined", "a")
+				expectPopped(t, conn, "pipelined", "a")
+			})
+
+			t.Run("Timeout", func(t *testing.T) {
+				conn := dial()
+				defer conn.Close()
+
+				start := time.Now()
+				v, err := conn.Do("BLPOP", "empty", "50")
+				if err != nil || !reflect.DeepEqual(&radio.Array{}, v) {
+					t.Errorf("expecting null array, got '%v' (err=%v)", v, err)
+				}
+
+				if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+					t.Errorf("expecting to block for the timeout, took %s", elapsed)
+				}
+			})
+
+			t.Run("Disconnect", func(t *testing.T) {
+				conn, pusher := dial(), dial()
+				defer pusher.Close()
+
+				conn.Send("BLPOP", "gone", "0")
+				conn.Flush()
+				lh.waitBlocked(t, "gone", 1)
+				conn.Close()
+				lh.waitBlocked(t, "gone", 0)
+
+				pusher.Do("RPUSH", "gone", "a")
+				if n := lh.len("gone"); n != 1 {
+					t.Errorf("expecting item to not be consumed by disconnected client, got %d items", n)
+				}
+			})
+
+			t.Run("Unblock", func(t *testing.T) {
+				conn, other := dial(), dial()
+				defer conn.Close()
+				defer other.Close()
+
+				id, _ := conn.Do("CLIENT", "ID")
+				conn.Send("BLPOP", "unblock", "0")
+				conn.Flush()
+				lh.waitBlocked(t, "unblock", 1)
+
+				if v, err := other.Do("CLIENT", "UNBLOCK", id.(radio.Integer).String(), "ERROR"); err != nil || v != radio.Integer(1) {
+					t.Errorf("expecting 1, got '%v' (err=%v)", v, err)
+				}
+
+				expected := radio.ErrorStr("UNBLOCKED client unblocked via CLIENT UNBLOCK")
+				if v, err := conn.Receive(); err != nil || v != expected {
+					t.Errorf("expecting '%v', got '%v' (err=%v)", expected, v, err)
+				}
+
+				if v, err := other.Do("CLIENT", "UNBLOCK", id.(radio.Integer).String()); err != nil || v != radio.Integer(0) {
+					t.Errorf("expecting 0 for client that is not blocked, got '%v' (err=%v)", v, err)
+				}
+			})
+
+			t.Run("Shutdown", func(t *testing.T) {
+				conn := dial()
+				defer conn.Close()
+
+				conn.Send("BLPOP", "shutdown", "0")
+				conn.Flush()
+				lh.waitBlocked(t, "shutdown", 1)
+
+				cancel()
+				l.Close()
+				lh.waitBlocked(t, "shutdown", 0)
+			})
+		})
+	}
+}
+
+func TestBlocker_NoClient(t *testing.T) {
+	lh := newListHandler()
+
+	rec := radiotest.NewRecorder()
+	start := time.Now()
+	lh.blocker.ServeRESP(rec, &radio.Request{Command: "BLPOP", Args: []string{"a", "20"}})
+
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expecting request without client to block synchronously, took %s", elapsed)
+	}
+
+	if vals := rec.Values(); len(vals) != 1 || !reflect.DeepEqual(&radio.Array{}, vals[0]) {
+		t.Errorf("expecting null array, got %v", vals)
+	}
+}
+
+func expectPopped(t *testing.T, conn *radio.Conn, key, val string) {
+	expected := &radio.Array{
+		Items: []radio.Value{
+			&radio.BulkStr{Value: []byte(key)},
+			&radio.BulkStr{Value: []byte(val)},
+		},
+	}
+
+	v, err := conn.Receive()
+	if err != nil || !reflect.DeepEqual(expected, v) {
+		t.Errorf("expecting '%v', got '%v' (err=%v)", expected, v, err)
+	}
+}
+
+// listHandler implements RPUSH and BLPOP (with a timeout in milliseconds)
+// on top of a Blocker.
+type listHandler struct {
+	blocker *radio.Blocker
+
+	mu      sync.Mutex
+	lists   map[string][]string
+	waiting map[string]int
+}
+
+func newListHandler() *listHandler {
+	lh := &listHandler{
+		lists:   map[string][]string{},
+		waiting: map[string]int{},
+	}
+	lh.blocker = &radio.Blocker{Handler: lh}
+	return lh
+}
+
+func (lh *listHandler) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	switch req.Command {
+	case "PING":
+		wr.Write(radio.SimpleStr("PONG"))
+
+	case "CLIENT":
+		wr.Write(radio.Integer(req.Client.ID))
+
+	case "GET":
+		time.Sleep(50 * time.Millisecond)
+		wr.Write(radio.SimpleStr("OK"))
+
+	case "RPUSH":
+		lh.mu.Lock()
+		lh.lists[req.Args[0]] = append(lh.lists[req.Args[0]], req.Args[1:]...)
+		n := len(lh.lists[req.Args[0]])
+		lh.mu.Unlock()
+
+		wr.Write(radio.Integer(n))
+		lh.blocker.Ready(req.Args[0])
+
+	case "BLPOP":
+		key := req.Args[0]
+		if lh.pop(wr, key) {
+			return
+		}
+
+		ms, _ := strconv.Atoi(req.Args[1])
+		lh.mu.Lock()
+		lh.waiting[key]++
+		lh.mu.Unlock()
+
+		lh.blocker.Block(wr, req, radio.Wait{
+			Keys:    []string{key},
+			Timeout: time.Duration(ms) * time.Millisecond,
+			Ready: func(wr radio.ResponseWriter, key string) bool {
+				return lh.pop(wr, key)
+			},
+		})
+
+		go func() {
+			// track the number of blocked clients for the tests.
+			for req.Client != nil && req.Client.Blocked() {
+				time.Sleep(time.Millisecond)
+			}
+
+			lh.mu.Lock()
+			lh.waiting[key]--
+			lh.mu.Unlock()
+		}()
+	}
+}
+
+func (lh *listHandler) pop(wr radio.ResponseWriter, key string) bool {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+
+	list := lh.lists[key]
+	if len(list) == 0 {
+		return false
+	}
+	lh.lists[key] = list[1:]
+
+	wr.Write(&radio.Array{
+		Items: []radio.Value{
+			&radio.BulkStr{Value: []byte(key)},
+			&radio.BulkStr{Value: []byte(list[0])},
+		},
+	})
+	return true
+}
+
+func (lh *listHandler) len(key string) int {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return len(lh.lists[key])
+}
+
+func (lh *listHandler) waitBlocked(t *testing.T, key string, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		lh.mu.Lock()
+		waiting := lh.waiting[key]
+		lh.mu.Unlock()
+
+		if waiting == n {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting %d clients blocked on '%s', got %d", n, key, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
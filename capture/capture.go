@@ -0,0 +1,175 @@
+package capture
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/internal/reply"
+)
+
+// ErrInvalidRecord is returned when the capture contains a value that is
+// not a valid record.
+var ErrInvalidRecord = errors.New("invalid capture record")
+
+// errRecorderClosed is returned by Flush once the recorder is closed.
+var errRecorderClosed = errors.New("capture recorder closed")
+
+// Record is a single request captured along with the replies written for it.
+// A Record is serialized as a RESP array of the timestamp in nanoseconds, the
+// client ID, the request as an array of bulk strings and an array of the
+// replies. A capture file is a sequence of such arrays and can be read using
+// a client-mode radio.Reader.
+type Record struct {
+	Time     time.Time
+	ClientID uint64
+	Request  *radio.Request
+	Replies  []radio.Value
+}
+
+// Serialize returns the RESP representation of the record.
+func (rec *Record) Serialize() string {
+	replies := &radio.Array{Items: rec.Replies}
+	if replies.Items == nil {
+		replies.Items = []radio.Value{}
+	}
+
+	arr := &radio.Array{
+		Items: []radio.Value{
+			radio.Integer(rec.Time.UnixNano()),
+			radio.Integer(rec.ClientID),
+			rec.Request,
+			replies,
+		},
+	}
+	return arr.Serialize()
+}
+
+// NewRecorder initializes a recorder that serves requests using the handler
+// and writes a record for each of them to w. Records are buffered and Flush
+// or Close must be called to write them out.
+func NewRecorder(handler radio.Handler, w io.Writer) *Recorder {
+	return &Recorder{
+		handler: handler,
+		w:       w,
+		bw:      bufio.NewWriter(w),
+	}
+}
+
+// Recorder is a radio.Handler which records every request with its replies,
+// client ID and timestamp. Recorder is safe for concurrent use.
+type Recorder struct {
+	handler radio.Handler
+
+	mu  sync.Mutex
+	w   io.Writer
+	bw  *bufio.Writer
+	err error
+}
+
+// ServeRESP serves the request using the wrapped handler and records it.
+func (rec *Recorder) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	record := &Record{
+		Time:    time.Now(),
+		Request: &radio.Request{Command: req.Command, Args: req.Args},
+	}
+	if req.Client != nil {
+		record.ClientID = req.Client.ID
+	}
+
+	tw := &reply.Recorder{Next: wr}
+	rec.handler.ServeRESP(tw, req)
+	record.Replies = tw.Stop()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.err == nil {
+		_, rec.err = rec.bw.WriteString(record.Serialize())
+	}
+}
+
+// Flush writes the buffered records and returns the first error that
+// occurred while writing records, if any.
+func (rec *Recorder) Flush() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.err != nil {
+		return rec.err
+	}
+	return rec.bw.Flush()
+}
+
+// Close flushes the buffered records and closes the underlying writer if it
+// is an io.Closer. Requests served after Close are not recorded.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.err == errRecorderClosed {
+		return rec.err
+	}
+
+	err := rec.err
+	if err == nil {
+		err = rec.bw.Flush()
+	}
+	rec.err = errRecorderClosed
+
+	if c, ok := rec.w.(io.Closer); ok {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// NewReader initializes a reader for the capture in r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		rd: radio.NewReader(r, false),
+	}
+}
+
+// Reader reads records from a capture.
+type Reader struct {
+	rd *radio.Reader
+}
+
+// Next returns the next record in the capture. io.EOF is returned when there
+// are no more records.
+func (rd *Reader) Next() (*Record, error) {
+	v, err := rd.rd.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	arr, ok := v.(*radio.Array)
+	if !ok || len(arr.Items) != 4 {
+		return nil, ErrInvalidRecord
+	}
+
+	ts, ok1 := arr.Items[0].(radio.Integer)
+	id, ok2 := arr.Items[1].(radio.Integer)
+	replies, ok3 := arr.Items[3].(*radio.Array)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, ErrInvalidRecord
+	}
+
+	req, err := radio.NewRequest(arr.Items[2])
+	if err != nil || req == nil {
+		return nil, fmt.Errorf("%v: bad request", ErrInvalidRecord)
+	}
+
+	return &Record{
+		Time:     time.Unix(0, int64(ts)),
+		ClientID: uint64(id),
+		Request:  req,
+		Replies:  replies.Items,
+	}, nil
+}
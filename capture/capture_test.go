@@ -0,0 +1,316 @@
+package capture_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/capture"
+)
+
+func TestRecorder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rec := capture.NewRecorder(counterHandler(), buf)
+
+	client := &radio.Client{ID: 7}
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "INCR", Args: []string{"a"}, Client: client})
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "NOOP", Client: client})
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "INCR", Args: []string{"a"}})
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	// capture must be readable as plain RESP.
+	rd := radio.NewReader(bytes.NewReader(buf.Bytes()), false)
+	count := 0
+	for {
+		v, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+
+		if _, ok := v.(*radio.Array); !ok {
+			t.Errorf("expecting record to be an array, got %#v", v)
+		}
+		count++
+	}
+
+	if count != 3 {
+		t.Fatalf("expecting 3 records, got %d", count)
+	}
+
+	crd := capture.NewReader(bytes.NewReader(buf.Bytes()))
+	expected := []struct {
+		clientID uint64
+		command  string
+		replies  []radio.Value
+	}{
+		{clientID: 7, command: "INCR", replies: []radio.Value{radio.Integer(1)}},
+		{clientID: 7, command: "NOOP", replies: []radio.Value{}},
+		{clientID: 0, command: "INCR", replies: []radio.Value{radio.Integer(2)}},
+	}
+
+	for _, exp := range expected {
+		r, err := crd.Next()
+		if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+
+		if r.ClientID != exp.clientID || r.Request.Command != exp.command {
+			t.Errorf("expecting client %d with command '%s', got %d with '%s'",
+				exp.clientID, exp.command, r.ClientID, r.Request.Command)
+		}
+
+		if !reflect.DeepEqual(exp.replies, r.Replies) {
+			t.Errorf("expecting replies %v, got %v", exp.replies, r.Replies)
+		}
+
+		if time.Since(r.Time) > time.Minute {
+			t.Errorf("expecting recent timestamp, got %s", r.Time)
+		}
+	}
+
+	if _, err := crd.Next(); err != io.EOF {
+		t.Errorf("expecting io.EOF, got '%v'", err)
+	}
+}
+
+func TestRecorder_Close(t *testing.T) {
+	w := &closeBuffer{}
+	rec := capture.NewRecorder(counterHandler(), w)
+
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "PING"})
+	if err := rec.Close(); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+	if !w.closed {
+		t.Errorf("expecting the writer to be closed")
+	}
+
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "PING"})
+	if err := rec.Close(); err == nil {
+		t.Errorf("expecting error on closing twice")
+	}
+
+	crd := capture.NewReader(bytes.NewReader(w.Bytes()))
+	if r, err := crd.Next(); err != nil || r.Request.Command != "PING" {
+		t.Fatalf("expecting the record of PING, got %v (err=%v)", r, err)
+	}
+	if _, err := crd.Next(); err != io.EOF {
+		t.Errorf("expecting requests served after Close not to be recorded, got '%v'", err)
+	}
+}
+
+func TestReplayer_ReplayHandler(t *testing.T) {
+	capt := record(t, counterHandler())
+
+	var diffs []*capture.Record
+	rp := &capture.Replayer{
+		OnDiff: func(rec *capture.Record, replies []radio.Value) {
+			diffs = append(diffs, rec)
+		},
+	}
+
+	stats, err := rp.ReplayHandler(context.Background(), capture.NewReader(bytes.NewReader(capt)), counterHandler())
+	if err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	if stats.Replayed != 4 || stats.Matched != 4 || len(diffs) != 0 {
+		t.Errorf("expecting all records to match, got %+v", stats)
+	}
+
+	// replaying twice into the same handler changes the counter values.
+	h := counterHandler()
+	rp.ReplayHandler(context.Background(), capture.NewReader(bytes.NewReader(capt)), h)
+	stats, _ = rp.ReplayHandler(context.Background(), capture.NewReader(bytes.NewReader(capt)), h)
+	if stats.Mismatched != 3 || len(diffs) != 3 || diffs[0].Request.Command != "INCR" {
+		t.Errorf("expecting 3 mismatches, got %+v", stats)
+	}
+}
+
+func TestReplayer_ReplayAddr(t *testing.T) {
+	capt := record(t, counterHandler())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go radio.ListenAndServe(ctx, l, counterHandler())
+
+	rp := &capture.Replayer{}
+	stats, err := rp.ReplayAddr(ctx, capture.NewReader(bytes.NewReader(capt)), l.Addr().String())
+	if err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	if stats.Replayed != 4 || stats.Matched != 4 {
+		t.Errorf("expecting all records to match, got %+v", stats)
+	}
+}
+
+func TestReplayer_ReplayAddrSkipped(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rec := capture.NewRecorder(counterHandler(), buf)
+	c1 := &radio.Client{ID: 1}
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "NOOP", Client: c1})
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "PING", Client: c1})
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go radio.ListenAndServe(ctx, l, counterHandler())
+
+	rp := &capture.Replayer{Timeout: time.Second}
+	stats, err := rp.ReplayAddr(ctx, capture.NewReader(bytes.NewReader(buf.Bytes())), l.Addr().String())
+	if err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	if stats.Replayed != 1 || stats.Matched != 1 || stats.Skipped != 1 {
+		t.Errorf("expecting the record without replies to be skipped, got %+v", stats)
+	}
+}
+
+func TestReplayer_ReplayAddrTimeout(t *testing.T) {
+	capt := record(t, counterHandler())
+
+	// the server accepts the connections but never replies.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			con, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer con.Close()
+		}
+	}()
+
+	rp := &capture.Replayer{Timeout: 50 * time.Millisecond}
+
+	began := time.Now()
+	stats, err := rp.ReplayAddr(context.Background(), capture.NewReader(bytes.NewReader(capt)), l.Addr().String())
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("expecting timeout error, got '%v'", err)
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Errorf("expecting replay to time out after 50ms, took %s", elapsed)
+	}
+	if stats.Replayed != 0 {
+		t.Errorf("expecting no records to be replayed, got %+v", stats)
+	}
+}
+
+func TestReplayer_Speed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		rec := &capture.Record{
+			Time:    start.Add(time.Duration(i) * 100 * time.Millisecond),
+			Request: &radio.Request{Command: "PING"},
+			Replies: []radio.Value{radio.SimpleStr("PONG")},
+		}
+		buf.WriteString(rec.Serialize())
+	}
+
+	table := []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{speed: 0, min: 0, max: 50 * time.Millisecond},
+		{speed: 1, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{speed: 4, min: 50 * time.Millisecond, max: 150 * time.Millisecond},
+	}
+
+	for _, tt := range table {
+		rp := &capture.Replayer{Speed: tt.speed}
+
+		began := time.Now()
+		stats, err := rp.ReplayHandler(context.Background(), capture.NewReader(bytes.NewReader(buf.Bytes())), counterHandler())
+		elapsed := time.Since(began)
+
+		if err != nil || stats.Matched != 3 {
+			t.Errorf("expecting 3 matches, got %+v (err=%v)", stats, err)
+		}
+
+		if elapsed < tt.min || elapsed > tt.max {
+			t.Errorf("speed=%v: expecting replay to take between %s and %s, took %s", tt.speed, tt.min, tt.max, elapsed)
+		}
+	}
+}
+
+func record(t *testing.T, h radio.Handler) []byte {
+	buf := &bytes.Buffer{}
+	rec := capture.NewRecorder(h, buf)
+
+	c1, c2 := &radio.Client{ID: 1}, &radio.Client{ID: 2}
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "INCR", Args: []string{"a"}, Client: c1})
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "INCR", Args: []string{"a"}, Client: c2})
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "PING", Client: c1})
+	rec.ServeRESP(&recorder{}, &radio.Request{Command: "INCR", Args: []string{"a"}, Client: c1})
+
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+	return buf.Bytes()
+}
+
+// counterHandler supports INCR on a single shared counter, PING and NOOP
+// which writes no reply.
+func counterHandler() radio.Handler {
+	counter := 0
+	return radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		switch req.Command {
+		case "INCR":
+			counter++
+			wr.Write(radio.Integer(counter))
+
+		case "PING":
+			wr.Write(radio.SimpleStr("PONG"))
+
+		case "NOOP":
+
+		default:
+			wr.Write(radio.ErrorStr("ERR unknown command"))
+		}
+	})
+}
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (cb *closeBuffer) Close() error {
+	cb.closed = true
+	return nil
+}
+
+type recorder struct {
+	vals []radio.Value
+}
+
+func (rec *recorder) Write(v radio.Value) (int, error) {
+	rec.vals = append(rec.vals, v)
+	return 0, nil
+}
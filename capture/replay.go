@@ -0,0 +1,174 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/internal/reply"
+)
+
+// errSkipped is returned for the records that are not replayed.
+var errSkipped = errors.New("record skipped")
+
+// Stats holds the results of a replay. Skipped counts the records not
+// replayed by ReplayAddr because no replies were captured for them (e.g.,
+// blocked commands) and waiting for their replies could block the replay.
+type Stats struct {
+	Replayed   int
+	Matched    int
+	Mismatched int
+	Skipped    int
+}
+
+// Replayer feeds the records of a capture into a handler or a remote server
+// and compares the replies with the captured ones. Records are replayed in
+// the captured order and requests of each captured client are sent using a
+// separate client so that per-connection state is preserved.
+type Replayer struct {
+	// Speed controls the pacing of the replay relative to the original
+	// timing of the requests. 1 replays at the original speed, 2 twice as
+	// fast and so on. Zero replays as fast as possible.
+	Speed float64
+
+	// OnDiff, if set, is invoked for every record for which the replayed
+	// replies differ from the captured ones.
+	OnDiff func(rec *Record, replies []radio.Value)
+
+	// Timeout limits the time ReplayAddr waits to send a record and to
+	// receive its replies. Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// ReplayHandler replays the capture against the handler.
+func (rp *Replayer) ReplayHandler(ctx context.Context, rd *Reader, handler radio.Handler) (Stats, error) {
+	clients := map[uint64]*radio.Client{}
+
+	return rp.replay(ctx, rd, func(rec *Record) ([]radio.Value, error) {
+		client, found := clients[rec.ClientID]
+		if !found {
+			client = &radio.Client{ID: rec.ClientID}
+			clients[rec.ClientID] = client
+		}
+
+		req := &radio.Request{
+			Command: rec.Request.Command,
+			Args:    rec.Request.Args,
+			Client:  client,
+		}
+
+		rw := &reply.Recorder{}
+		handler.ServeRESP(rw, req)
+		return rw.Values(), nil
+	})
+}
+
+// ReplayAddr replays the capture against the RESP server at the address.
+// Records without captured replies are skipped as the server may not reply
+// to them either.
+func (rp *Replayer) ReplayAddr(ctx context.Context, rd *Reader, addr string) (Stats, error) {
+	timeout := rp.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	type replayConn struct {
+		*radio.Conn
+		con net.Conn
+	}
+
+	conns := map[uint64]*replayConn{}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	return rp.replay(ctx, rd, func(rec *Record) ([]radio.Value, error) {
+		if len(rec.Replies) == 0 {
+			return nil, errSkipped
+		}
+
+		conn, found := conns[rec.ClientID]
+		if !found {
+			con, err := net.DialTimeout("tcp", addr, timeout)
+			if err != nil {
+				return nil, err
+			}
+			conn = &replayConn{Conn: radio.NewConn(con), con: con}
+			conns[rec.ClientID] = conn
+		}
+		conn.con.SetDeadline(time.Now().Add(timeout))
+
+		if err := conn.Send(rec.Request.Command, rec.Request.Args...); err != nil {
+			return nil, err
+		}
+
+		if err := conn.Flush(); err != nil {
+			return nil, err
+		}
+
+		var replies []radio.Value
+		for i := 0; i < len(rec.Replies); i++ {
+			v, err := conn.Receive()
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, v)
+		}
+		return replies, nil
+	})
+}
+
+func (rp *Replayer) replay(ctx context.Context, rd *Reader, exec func(rec *Record) ([]radio.Value, error)) (Stats, error) {
+	var stats Stats
+	var first time.Time
+	start := time.Now()
+
+	for {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			return stats, err
+		}
+
+		if first.IsZero() {
+			first = rec.Time
+		}
+
+		if rp.Speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / rp.Speed))
+			select {
+			case <-ctx.Done():
+				return stats, ctx.Err()
+
+			case <-time.After(time.Until(due)):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		replies, err := exec(rec)
+		if err == errSkipped {
+			stats.Skipped++
+			continue
+		} else if err != nil {
+			return stats, err
+		}
+
+		stats.Replayed++
+		if reply.Equal(rec.Replies, replies) {
+			stats.Matched++
+			continue
+		}
+
+		stats.Mismatched++
+		if rp.OnDiff != nil {
+			rp.OnDiff(rec, replies)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package radio
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var lastClientID uint64
+
+// Client represents a client connected to the server. Handlers can use the
+// client available in the request to maintain per-connection state. All
+// methods are safe to call on a nil client.
+type Client struct {
+	// ID uniquely identifies the client within the process.
+	ID uint64
+
+	// Addr is the remote address of the client if available.
+	Addr string
+
+	proto   int32
+	ctx     context.Context
+	mu      sync.Mutex
+	vals    map[interface{}]interface{}
+	blocked chan struct{}
+	onClose []func()
+	closed  bool
+}
+
+// Context returns the context of the client which is cancelled when the
+// client disconnects or the server stops.
+func (cl *Client) Context() context.Context {
+	if cl == nil || cl.ctx == nil {
+		return context.Background()
+	}
+	return cl.ctx
+}
+
+// Value returns the value associated with the key for this client or nil
+// if there is no such value.
+func (cl *Client) Value(key interface{}) interface{} {
+	if cl == nil {
+		return nil
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.vals[key]
+}
+
+// SetValue associates the value with the key for this client. Setting a nil
+// value removes the key.
+func (cl *Client) SetValue(key, val interface{}) {
+	if cl == nil {
+		return
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if val == nil {
+		delete(cl.vals, key)
+		return
+	}
+
+	if cl.vals == nil {
+		cl.vals = map[interface{}]interface{}{}
+	}
+	cl.vals[key] = val
+}
+
+// OnClose registers fn to be called once the server closes the connection
+// of the client. fn is called on a new goroutine if the connection is
+// already closed. Clients not served by the server (e.g., clients created by
+// handlers or replays) are never closed and fn is not called for them.
+func (cl *Client) OnClose(fn func()) {
+	if cl == nil {
+		return
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.closed {
+		go fn()
+		return
+	}
+	cl.onClose = append(cl.onClose, fn)
+}
+
+// close calls the functions registered using OnClose once the connection of
+// the client is closed.
+func (cl *Client) close() {
+	cl.mu.Lock()
+	fns := cl.onClose
+	cl.onClose = nil
+	cl.closed = true
+	cl.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// Protocol returns the RESP protocol version used by the client. Defaults to
+// 2 unless set using SetProtocol (e.g., by Hello serving HELLO).
+func (cl *Client) Protocol() int {
+	if cl == nil {
+		return 2
+	}
+
+	if proto := atomic.LoadInt32(&cl.proto); proto != 0 {
+		return int(proto)
+	}
+	return 2
+}
+
+// SetProtocol sets the RESP protocol version used by the client. Handlers
+// must only write RESP3 values (e.g., Push) to clients using version 3.
+func (cl *Client) SetProtocol(version int) {
+	if cl == nil {
+		return
+	}
+	atomic.StoreInt32(&cl.proto, int32(version))
+}
+
+// Blocked returns true if the client is blocked by a Blocker.
+func (cl *Client) Blocked() bool {
+	return cl.unblocked() != nil
+}
+
+// block marks the client as blocked by the request and returns the channel
+// to be passed to unblock once the client is unblocked.
+func (cl *Client) block(req *Request) chan struct{} {
+	ch := make(chan struct{})
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.blocked = ch
+	req.parked = ch
+	return ch
+}
+
+func (cl *Client) unblock(ch chan struct{}) {
+	cl.mu.Lock()
+	if cl.blocked == ch {
+		cl.blocked = nil
+	}
+	cl.mu.Unlock()
+
+	close(ch)
+}
+
+// unblocked returns a channel which is closed when the client is unblocked
+// or nil if the client is not blocked.
+func (cl *Client) unblocked() <-chan struct{} {
+	if cl == nil {
+		return nil
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.blocked == nil {
+		return nil
+	}
+	return cl.blocked
+}
+
+// parked returns a channel which is closed when the request parked by a
+// Blocker is served or nil if the request was not parked. Unlike unblocked,
+// it only reports the block of the given request, which matters when the
+// requests of the client are executed concurrently.
+func (cl *Client) parked(req *Request) <-chan struct{} {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if req.parked == nil {
+		return nil
+	}
+	return req.parked
+}
+
+func newClient(ctx context.Context, rwc io.ReadWriteCloser) *Client {
+	cl := &Client{
+		ID:  atomic.AddUint64(&lastClientID, 1),
+		ctx: ctx,
+	}
+
+	if con, ok := rwc.(net.Conn); ok {
+		cl.Addr = con.RemoteAddr().String()
+	}
+
+	return cl
+}
@@ -0,0 +1,520 @@
+package cluster
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+const (
+	defaultMaxRedirects = 16
+	defaultMinBackoff   = 8 * time.Millisecond
+	defaultMaxBackoff   = 512 * time.Millisecond
+	defaultMaxIdle      = 8
+)
+
+var (
+	// ErrNoNodes is returned when none of the known nodes can be reached.
+	ErrNoNodes = errors.New("no reachable cluster nodes")
+
+	// ErrTooManyRedirects is returned along with the last reply when the
+	// request is still being redirected after MaxRedirects attempts.
+	ErrTooManyRedirects = errors.New("too many cluster redirections")
+
+	// ErrClosed is returned when using a closed client.
+	ErrClosed = errors.New("cluster client is closed")
+)
+
+// Client is a Redis Cluster client which routes commands to the nodes
+// serving the slots of their keys. The slot map is bootstrapped from the
+// seed nodes using CLUSTER SLOTS and is refreshed when MOVED redirections
+// are received. ASK redirections are followed by sending ASKING and the
+// TRYAGAIN and CLUSTERDOWN errors are retried with exponential backoff.
+// Client is safe for concurrent use.
+type Client struct {
+	// Addrs are the seed nodes used to discover the cluster.
+	Addrs []string
+
+	// MaxRedirects is the maximum number of redirections or retries
+	// followed for a single command. Defaults to 16.
+	MaxRedirects int
+
+	// MinBackoff and MaxBackoff bound the delay between retries of
+	// TRYAGAIN and CLUSTERDOWN errors. Default to 8ms and 512ms.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// KeySpecs is used to find the keys of commands. Defaults to
+	// radio.DefaultKeySpecs. Commands without keys are sent to any node.
+	KeySpecs radio.KeySpecs
+
+	// Dial is used to connect to the nodes. Defaults to radio.Dial.
+	Dial func(addr string) (*radio.Conn, error)
+
+	// MaxIdle is the maximum number of idle connections kept per node.
+	// Defaults to 8.
+	MaxIdle int
+
+	mu     sync.RWMutex
+	slots  [SlotCount]string
+	known  []string
+	stale  bool
+	loaded bool
+	idle   map[string][]*radio.Conn
+	closed bool
+}
+
+// Do executes the command on the node serving the slot of its keys and
+// returns the reply. Error replies other than redirections are returned as
+// radio.ErrorStr values.
+func (cl *Client) Do(cmd string, args ...string) (radio.Value, error) {
+	return cl.do(&radio.Request{Command: cmd, Args: args})
+}
+
+// Pipeline executes the requests by sending them pipelined to the nodes
+// serving them. Requests are grouped per node and the replies are returned
+// in the order of the requests. Requests that get redirected and requests
+// that could not be sent are retried individually. If a connection fails
+// after the requests were written, the error is returned without retrying
+// them as they may have been executed already.
+func (cl *Client) Pipeline(reqs []*radio.Request) ([]radio.Value, error) {
+	if err := cl.ensureSlots(); err != nil {
+		return nil, err
+	}
+
+	groups := map[string][]int{}
+	for i, req := range reqs {
+		addr := cl.addrFor(req)
+		groups[addr] = append(groups[addr], i)
+	}
+
+	replies := make([]radio.Value, len(reqs))
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for addr, indices := range groups {
+		wg.Add(1)
+		go func(addr string, indices []int) {
+			defer wg.Done()
+			cl.pipelineNode(addr, reqs, indices, replies, errs)
+		}(addr, indices)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			cl.markStale()
+			return replies, err
+		}
+	}
+
+	// requests that were not sent or got redirected are retried
+	// individually so that redirections and retries are handled.
+	for i, reply := range replies {
+		if reply != nil && !isRetryable(reply) {
+			continue
+		}
+
+		v, err := cl.do(reqs[i])
+		if err != nil {
+			return replies, err
+		}
+		replies[i] = v
+	}
+
+	return replies, nil
+}
+
+// Refresh reloads the slot map using CLUSTER SLOTS from any of the known
+// nodes or seeds.
+func (cl *Client) Refresh() error {
+	cl.mu.RLock()
+	addrs := append(append([]string(nil), cl.known...), cl.Addrs...)
+	cl.mu.RUnlock()
+
+	var lastErr error = ErrNoNodes
+	for _, addr := range addrs {
+		v, _, err := cl.exec(addr, &radio.Request{Command: "CLUSTER", Args: []string{"SLOTS"}}, false)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if err := cl.loadSlots(v); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+
+	return lastErr
+}
+
+// Close closes all the idle connections. Client cannot be used after close.
+func (cl *Client) Close() error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for _, conns := range cl.idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	cl.idle = nil
+	cl.closed = true
+	return nil
+}
+
+func (cl *Client) do(req *radio.Request) (radio.Value, error) {
+	if err := cl.ensureSlots(); err != nil {
+		return nil, err
+	}
+
+	addr := cl.addrFor(req)
+	asking := false
+
+	var reply radio.Value
+	for attempt := 0; attempt <= cl.maxRedirects(); attempt++ {
+		v, written, err := cl.exec(addr, req, asking)
+		asking = false
+		if err != nil {
+			if err == ErrClosed {
+				return nil, err
+			}
+
+			// the request may have been executed before the connection
+			// failed and is not retried as it may not be idempotent.
+			if written {
+				cl.markStale()
+				return nil, err
+			}
+
+			// node may have failed, the slot map must be reloaded.
+			cl.sleep(attempt)
+			if cl.Refresh() != nil {
+				return nil, err
+			}
+			addr = cl.addrFor(req)
+			continue
+		}
+
+		reply = v
+		errStr, ok := v.(radio.ErrorStr)
+		if !ok {
+			return v, nil
+		}
+
+		fields := strings.Fields(string(errStr))
+		switch {
+		case len(fields) == 3 && fields[0] == "MOVED":
+			slot, _ := strconv.Atoi(fields[1])
+			addr = fields[2]
+			cl.moved(slot, addr)
+
+		case len(fields) == 3 && fields[0] == "ASK":
+			addr = fields[2]
+			asking = true
+
+		case len(fields) > 0 && (fields[0] == "TRYAGAIN" || fields[0] == "CLUSTERDOWN"):
+			cl.sleep(attempt)
+			if fields[0] == "CLUSTERDOWN" {
+				cl.Refresh()
+				addr = cl.addrFor(req)
+			}
+
+		default:
+			return v, nil
+		}
+	}
+
+	return reply, ErrTooManyRedirects
+}
+
+// exec sends the request to the node and returns the reply. written is
+// true if the request may have been written before an error occurred.
+func (cl *Client) exec(addr string, req *radio.Request, asking bool) (v radio.Value, written bool, err error) {
+	conn, err := cl.getConn(addr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if asking {
+		conn.Send("ASKING")
+	}
+	conn.Send(req.Command, req.Args...)
+
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return nil, true, err
+	}
+
+	if asking {
+		if _, err := conn.Receive(); err != nil {
+			conn.Close()
+			return nil, true, err
+		}
+	}
+
+	v, err = conn.Receive()
+	if err != nil {
+		conn.Close()
+		return nil, true, err
+	}
+
+	cl.putConn(addr, conn)
+	return v, true, nil
+}
+
+// pipelineNode sends the requests at the indices to the node. Requests
+// that could not be sent are left without reply and error, requests that
+// may have been written before the connection failed get the error.
+func (cl *Client) pipelineNode(addr string, reqs []*radio.Request, indices []int, replies []radio.Value, errs []error) {
+	conn, err := cl.getConn(addr)
+	if err != nil {
+		return
+	}
+
+	for _, i := range indices {
+		conn.Send(reqs[i].Command, reqs[i].Args...)
+	}
+
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		for _, i := range indices {
+			errs[i] = err
+		}
+		return
+	}
+
+	for n, i := range indices {
+		v, err := conn.Receive()
+		if err != nil {
+			conn.Close()
+			for _, i := range indices[n:] {
+				errs[i] = err
+			}
+			return
+		}
+		replies[i] = v
+	}
+
+	cl.putConn(addr, conn)
+}
+
+func (cl *Client) ensureSlots() error {
+	cl.mu.RLock()
+	loaded, stale := cl.loaded, cl.stale
+	cl.mu.RUnlock()
+
+	if loaded && !stale {
+		return nil
+	}
+
+	if err := cl.Refresh(); err != nil && !loaded {
+		return err
+	}
+	return nil
+}
+
+func (cl *Client) loadSlots(v radio.Value) error {
+	arr, ok := v.(*radio.Array)
+	if !ok {
+		return errors.New("unexpected CLUSTER SLOTS reply")
+	}
+
+	var slots [SlotCount]string
+	var known []string
+	for _, item := range arr.Items {
+		entry, ok := item.(*radio.Array)
+		if !ok || len(entry.Items) < 3 {
+			return errors.New("unexpected CLUSTER SLOTS entry")
+		}
+
+		start, ok1 := entry.Items[0].(radio.Integer)
+		end, ok2 := entry.Items[1].(radio.Integer)
+		if !ok1 || !ok2 || start < 0 || end >= SlotCount || start > end {
+			return errors.New("invalid slot range in CLUSTER SLOTS entry")
+		}
+
+		addr, err := endpointAddr(entry.Items[2])
+		if err != nil {
+			return err
+		}
+
+		for slot := int(start); slot <= int(end); slot++ {
+			slots[slot] = addr
+		}
+		known = append(known, addr)
+	}
+
+	cl.mu.Lock()
+	cl.slots = slots
+	cl.known = known
+	cl.loaded = true
+	cl.stale = false
+	cl.mu.Unlock()
+	return nil
+}
+
+// markStale makes the slot map to be reloaded before the next request as
+// the node of a failed connection may be down.
+func (cl *Client) markStale() {
+	cl.mu.Lock()
+	cl.stale = true
+	cl.mu.Unlock()
+}
+
+func (cl *Client) moved(slot int, addr string) {
+	if !validSlot(slot) {
+		return
+	}
+
+	cl.mu.Lock()
+	cl.slots[slot] = addr
+	cl.stale = true
+	cl.mu.Unlock()
+}
+
+func (cl *Client) addrFor(req *radio.Request) string {
+	specs := cl.KeySpecs
+	if specs == nil {
+		specs = radio.DefaultKeySpecs
+	}
+
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	if keys, _ := specs.Resolve(req); len(keys) > 0 {
+		if addr := cl.slots[KeySlot(keys[0])]; addr != "" {
+			return addr
+		}
+	}
+
+	if len(cl.known) > 0 {
+		return cl.known[0]
+	}
+	if len(cl.Addrs) > 0 {
+		return cl.Addrs[0]
+	}
+	return ""
+}
+
+// getConn returns an idle connection to the node or a new one. Idle
+// connections closed by the node (e.g., after a restart or an idle timeout)
+// are discarded before any request is written to them.
+func (cl *Client) getConn(addr string) (*radio.Conn, error) {
+	for {
+		cl.mu.Lock()
+		if cl.closed {
+			cl.mu.Unlock()
+			return nil, ErrClosed
+		}
+
+		conns := cl.idle[addr]
+		if len(conns) == 0 {
+			cl.mu.Unlock()
+			return cl.dial(addr)
+		}
+		conn := conns[len(conns)-1]
+		cl.idle[addr] = conns[:len(conns)-1]
+		cl.mu.Unlock()
+
+		if alive(conn) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+func (cl *Client) dial(addr string) (*radio.Conn, error) {
+	if addr == "" {
+		return nil, ErrNoNodes
+	}
+
+	dial := cl.Dial
+	if dial == nil {
+		dial = radio.Dial
+	}
+	return dial(addr)
+}
+
+func (cl *Client) putConn(addr string, conn *radio.Conn) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.closed || len(cl.idle[addr]) >= cl.maxIdle() {
+		conn.Close()
+		return
+	}
+
+	if cl.idle == nil {
+		cl.idle = map[string][]*radio.Conn{}
+	}
+	cl.idle[addr] = append(cl.idle[addr], conn)
+}
+
+func (cl *Client) sleep(attempt int) {
+	min, max := cl.MinBackoff, cl.MaxBackoff
+	if min <= 0 {
+		min = defaultMinBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	time.Sleep(d)
+}
+
+func (cl *Client) maxIdle() int {
+	if cl.MaxIdle <= 0 {
+		return defaultMaxIdle
+	}
+	return cl.MaxIdle
+}
+
+func (cl *Client) maxRedirects() int {
+	if cl.MaxRedirects <= 0 {
+		return defaultMaxRedirects
+	}
+	return cl.MaxRedirects
+}
+
+func endpointAddr(v radio.Value) (string, error) {
+	endpoint, ok := v.(*radio.Array)
+	if !ok || len(endpoint.Items) < 2 {
+		return "", errors.New("invalid node in CLUSTER SLOTS entry")
+	}
+
+	host, ok1 := endpoint.Items[0].(*radio.BulkStr)
+	port, ok2 := endpoint.Items[1].(radio.Integer)
+	if !ok1 || !ok2 {
+		return "", errors.New("invalid node in CLUSTER SLOTS entry")
+	}
+
+	node := &Node{Host: host.String(), Port: int(port)}
+	return node.Addr(), nil
+}
+
+func isRetryable(v radio.Value) bool {
+	errStr, ok := v.(radio.ErrorStr)
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range []string{"MOVED ", "ASK ", "TRYAGAIN", "CLUSTERDOWN"} {
+		if strings.HasPrefix(string(errStr), prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,505 @@
+package cluster_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/cluster"
+	"github.com/spy16/radio/radiotest"
+)
+
+func TestClient_Do(t *testing.T) {
+	nodes := startCluster(t, 3)
+	defer nodes.close()
+
+	cl := &cluster.Client{Addrs: []string{nodes[0].addr}}
+	defer cl.Close()
+
+	for i := 0; i < 50; i++ {
+		key := "key" + strconv.Itoa(i)
+		if v, err := cl.Do("SET", key, strconv.Itoa(i)); err != nil || v != radio.SimpleStr("OK") {
+			t.Fatalf("SET %s failed: %v, %v", key, v, err)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		key := "key" + strconv.Itoa(i)
+		owner := nodes.owner(key)
+		if val, found := owner.get(key); !found || val != strconv.Itoa(i) {
+			t.Errorf("expecting '%s' to be stored at node serving its slot", key)
+		}
+
+		v, err := cl.Do("GET", key)
+		if err != nil || v.(*radio.BulkStr).String() != strconv.Itoa(i) {
+			t.Errorf("expecting GET %s to return '%d', got '%v' (err=%v)", key, i, v, err)
+		}
+	}
+}
+
+func TestClient_Moved(t *testing.T) {
+	nodes := startCluster(t, 2)
+	defer nodes.close()
+
+	cl := &cluster.Client{Addrs: []string{nodes[0].addr}}
+	defer cl.Close()
+
+	cl.Do("SET", "foo", "bar")
+	from := nodes.owner("foo")
+	to := nodes[0]
+	if from == to {
+		to = nodes[1]
+	}
+
+	// move the slot of 'foo' along with its data.
+	slot := cluster.KeySlot("foo")
+	val, _ := from.get("foo")
+	to.set("foo", val)
+	for _, node := range nodes {
+		node.cluster.AssignSlots(to.cluster.Self().ID, slot, slot)
+	}
+
+	v, err := cl.Do("GET", "foo")
+	if err != nil || v.(*radio.BulkStr).String() != "bar" {
+		t.Fatalf("expecting 'bar', got '%v' (err=%v)", v, err)
+	}
+
+	if from.count("GET") != 1 || to.count("GET") != 1 {
+		t.Errorf("expecting GET to be redirected once, old owner served %d and new owner %d",
+			from.count("GET"), to.count("GET"))
+	}
+
+	// slot map must be refreshed and the next request should go directly
+	// to the new owner.
+	cl.Do("GET", "foo")
+	if from.count("GET") != 1 {
+		t.Errorf("expecting request to be routed using refreshed slot map")
+	}
+}
+
+func TestClient_Ask(t *testing.T) {
+	nodes := startCluster(t, 2)
+	defer nodes.close()
+
+	cl := &cluster.Client{Addrs: []string{nodes[0].addr}}
+	defer cl.Close()
+
+	from := nodes.owner("foo")
+	to := nodes[0]
+	if from == to {
+		to = nodes[1]
+	}
+
+	slot := cluster.KeySlot("foo")
+	from.cluster.SetMigrating(slot, to.cluster.Self().ID)
+	to.cluster.SetImporting(slot, from.cluster.Self().ID)
+	to.set("foo", "migrated")
+
+	v, err := cl.Do("GET", "foo")
+	if err != nil || v.(*radio.BulkStr).String() != "migrated" {
+		t.Fatalf("expecting 'migrated', got '%v' (err=%v)", v, err)
+	}
+
+	if to.count("ASKING") != 1 {
+		t.Errorf("expecting ASKING to be sent to the importing node")
+	}
+}
+
+func TestClient_MovableKeys(t *testing.T) {
+	nodes := startCluster(t, 3)
+	defer nodes.close()
+
+	cl := &cluster.Client{Addrs: []string{nodes[0].addr}}
+	defer cl.Close()
+
+	// the key of EVAL is found using the number of keys and the request is
+	// sent to the node serving it without a redirection.
+	key := "key1"
+	for i := 2; nodes.owner(key) == nodes[0]; i++ {
+		key = "key" + strconv.Itoa(i)
+	}
+
+	if _, err := cl.Do("EVAL", "return 1", "1", key); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	for _, node := range nodes {
+		expected := 0
+		if node == nodes.owner(key) {
+			expected = 1
+		}
+		if n := node.count("EVAL"); n != expected {
+			t.Errorf("expecting node %s to receive EVAL %d times, got %d", node.addr, expected, n)
+		}
+	}
+}
+
+func TestClient_TryAgain(t *testing.T) {
+	nodes := startCluster(t, 1)
+	defer nodes.close()
+	nodes[0].failures = 2
+
+	cl := &cluster.Client{Addrs: []string{nodes[0].addr}}
+	defer cl.Close()
+
+	v, err := cl.Do("SET", "foo", "bar")
+	if err != nil || v != radio.SimpleStr("OK") {
+		t.Fatalf("expecting 'OK' after retries, got '%v' (err=%v)", v, err)
+	}
+
+	if nodes[0].count("SET") != 3 {
+		t.Errorf("expecting 3 attempts, got %d", nodes[0].count("SET"))
+	}
+}
+
+func TestClient_Pipeline(t *testing.T) {
+	nodes := startCluster(t, 3)
+	defer nodes.close()
+
+	cl := &cluster.Client{Addrs: []string{nodes[0].addr}}
+	defer cl.Close()
+
+	var reqs []*radio.Request
+	for i := 0; i < 20; i++ {
+		reqs = append(reqs, &radio.Request{Command: "SET", Args: []string{"k" + strconv.Itoa(i), strconv.Itoa(i)}})
+	}
+	for i := 0; i < 20; i++ {
+		reqs = append(reqs, &radio.Request{Command: "GET", Args: []string{"k" + strconv.Itoa(i)}})
+	}
+
+	replies, err := cl.Pipeline(reqs)
+	if err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if replies[i] != radio.SimpleStr("OK") {
+			t.Errorf("expecting reply %d to be 'OK', got '%v'", i, replies[i])
+		}
+
+		if bs, ok := replies[20+i].(*radio.BulkStr); !ok || bs.String() != strconv.Itoa(i) {
+			t.Errorf("expecting reply %d to be '%d', got '%v'", 20+i, i, replies[20+i])
+		}
+	}
+}
+
+func TestClient_ConnectionFailure(t *testing.T) {
+	node := startDroppingNode(t)
+	defer node.Close()
+
+	cl := &cluster.Client{Addrs: []string{node.Addr().String()}}
+	defer cl.Close()
+
+	// the connection fails after INCR was executed so retrying it would
+	// increment the counter twice.
+	if _, err := cl.Do("INCR", "counter"); err == nil {
+		t.Errorf("expecting error when connection fails after sending the request")
+	}
+
+	reqs := []*radio.Request{
+		{Command: "INCR", Args: []string{"counter"}},
+		{Command: "INCR", Args: []string{"counter"}},
+	}
+	if _, err := cl.Pipeline(reqs); err == nil {
+		t.Errorf("expecting error when connection fails after sending the requests")
+	}
+
+	v, err := cl.Do("GET", "counter")
+	if err != nil || v != radio.Integer(2) {
+		t.Errorf("expecting INCR to be executed once per call, got '%v' (err=%v)", v, err)
+	}
+}
+
+func TestClient_IdleConnections(t *testing.T) {
+	var srv *radiotest.Server
+	var incrs int64
+	srv = radiotest.NewServer(radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		switch req.Command {
+		case "CLUSTER":
+			wr.Write(slotsOf(srv.Addr))
+		case "SLOW":
+			time.Sleep(20 * time.Millisecond)
+			wr.Write(radio.SimpleStr("OK"))
+		default:
+			wr.Write(radio.Integer(atomic.AddInt64(&incrs, 1)))
+		}
+	}))
+	defer srv.Close()
+
+	var dials, closes int64
+	cl := &cluster.Client{
+		Addrs:   []string{srv.Addr},
+		MaxIdle: 2,
+		Dial: func(addr string) (*radio.Conn, error) {
+			con, err := net.Dial("tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			atomic.AddInt64(&dials, 1)
+			return radio.NewConn(&closeCounter{TCPConn: con.(*net.TCPConn), closes: &closes}), nil
+		},
+	}
+	defer cl.Close()
+
+	// idle connections closed by the node (e.g., after a restart) are not
+	// used for the requests.
+	for i := 1; i <= 3; i++ {
+		if v, err := cl.Do("INCR", "counter"); err != nil || v != radio.Integer(i) {
+			t.Fatalf("expecting %d, got '%v' (err=%v)", i, v, err)
+		}
+		srv.CloseClientConnections()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if replies, err := cl.Pipeline([]*radio.Request{{Command: "INCR", Args: []string{"counter"}}}); err != nil || replies[0] != radio.Integer(4) {
+		t.Fatalf("expecting 4, got '%v' (err=%v)", replies, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cl.Do("SLOW")
+		}()
+	}
+	wg.Wait()
+
+	if idle := atomic.LoadInt64(&dials) - atomic.LoadInt64(&closes); idle > 2 {
+		t.Errorf("expecting at most 2 idle connections, got %d", idle)
+	}
+}
+
+type testNode struct {
+	addr     string
+	cluster  *cluster.Cluster
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	data     map[string]string
+	received map[string]int
+	failures int
+}
+
+func (node *testNode) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	switch req.Command {
+	case "SET":
+		if node.failures > 0 {
+			node.failures--
+			wr.Write(radio.ErrorStr("TRYAGAIN Multiple keys request during rehashing of slot"))
+			return
+		}
+		node.data[req.Args[0]] = req.Args[1]
+		wr.Write(radio.SimpleStr("OK"))
+
+	case "GET":
+		val, found := node.data[req.Args[0]]
+		if !found {
+			wr.Write(&radio.BulkStr{})
+			return
+		}
+		wr.Write(&radio.BulkStr{Value: []byte(val)})
+
+	default:
+		wr.Write(radio.ErrorStr("ERR unknown command"))
+	}
+}
+
+func (node *testNode) get(key string) (string, bool) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	val, found := node.data[key]
+	return val, found
+}
+
+func (node *testNode) set(key, val string) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.data[key] = val
+}
+
+func (node *testNode) count(cmd string) int {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.received[cmd]
+}
+
+type testNodes []*testNode
+
+// startCluster starts n nodes on loopback with the slots evenly distributed
+// among them.
+func startCluster(t *testing.T, n int) testNodes {
+	var nodes testNodes
+	var infos []*cluster.Node
+	var listeners []net.Listener
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		listeners = append(listeners, l)
+
+		addr := l.Addr().(*net.TCPAddr)
+		infos = append(infos, &cluster.Node{
+			ID:   cluster.NewNodeID(),
+			Host: "127.0.0.1",
+			Port: addr.Port,
+		})
+	}
+
+	for i, l := range listeners {
+		self := *infos[i]
+		cl := cluster.New(&self)
+		for j, info := range infos {
+			if j != i {
+				node := *info
+				cl.AddNode(&node)
+			}
+		}
+
+		for j, info := range infos {
+			start := j * cluster.SlotCount / n
+			end := (j+1)*cluster.SlotCount/n - 1
+			cl.AssignSlots(info.ID, start, end)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		node := &testNode{
+			addr:     l.Addr().String(),
+			cluster:  cl,
+			cancel:   cancel,
+			data:     map[string]string{},
+			received: map[string]int{},
+		}
+
+		h := &cluster.Handler{
+			Cluster: cl,
+			Handler: node,
+			Exists: func(key string) bool {
+				_, found := node.get(key)
+				return found
+			},
+		}
+
+		go func(l net.Listener) {
+			<-ctx.Done()
+			l.Close()
+		}(l)
+		// count all the requests received by the node including the ones
+		// redirected by the cluster handler.
+		counter := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+			node.mu.Lock()
+			node.received[req.Command]++
+			node.mu.Unlock()
+			h.ServeRESP(wr, req)
+		})
+		go radio.ListenAndServe(ctx, l, counter)
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+func (nodes testNodes) owner(key string) *testNode {
+	slot := cluster.KeySlot(key)
+	for _, node := range nodes {
+		if node.cluster.Owner(slot) == node.cluster.Self() {
+			return node
+		}
+	}
+	return nil
+}
+
+func (nodes testNodes) close() {
+	for _, node := range nodes {
+		node.cancel()
+	}
+}
+
+// startDroppingNode starts a node serving all the slots that closes the
+// connection after executing INCR without replying.
+func startDroppingNode(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	slots := slotsOf(l.Addr().String())
+
+	var mu sync.Mutex
+	counter := 0
+	serve := func(c net.Conn) {
+		defer c.Close()
+
+		rd := radio.NewReader(c, true)
+		wr := radio.NewWriter(c)
+		for {
+			v, err := rd.Read()
+			if err != nil {
+				return
+			}
+			req, err := radio.NewRequest(v)
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			switch req.Command {
+			case "CLUSTER":
+				wr.Write(slots)
+			case "INCR":
+				counter++
+				mu.Unlock()
+				return
+			default:
+				wr.Write(radio.Integer(counter))
+			}
+			mu.Unlock()
+		}
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+	return l
+}
+
+// slotsOf returns the CLUSTER SLOTS reply of a node at the address serving
+// all the slots.
+func slotsOf(addr string) radio.Value {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return &radio.Array{Items: []radio.Value{
+		&radio.Array{Items: []radio.Value{
+			radio.Integer(0),
+			radio.Integer(cluster.SlotCount - 1),
+			&radio.Array{Items: []radio.Value{
+				&radio.BulkStr{Value: []byte(host)},
+				radio.Integer(p),
+			}},
+		}},
+	}}
+}
+
+type closeCounter struct {
+	*net.TCPConn
+	closes *int64
+}
+
+func (cc *closeCounter) Close() error {
+	atomic.AddInt64(cc.closes, 1)
+	return cc.TCPConn.Close()
+}
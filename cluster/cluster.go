@@ -0,0 +1,288 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrUnknownNode is returned when a node ID is not known to the cluster.
+	ErrUnknownNode = errors.New("unknown node")
+
+	// ErrInvalidSlot is returned when a slot is out of range.
+	ErrInvalidSlot = errors.New("invalid or out of range slot")
+)
+
+// Node represents a node in the cluster.
+type Node struct {
+	// ID is the 40 characters unique identifier of the node.
+	ID string
+
+	// Host and Port form the address at which clients can reach the node.
+	Host string
+	Port int
+
+	// BusPort is the cluster bus port of the node. Defaults to Port+10000.
+	BusPort int
+
+	// Master is the ID of the master node if the node is a replica.
+	Master string
+}
+
+// Addr returns the client address of the node.
+func (node *Node) Addr() string {
+	return net.JoinHostPort(node.Host, strconv.Itoa(node.Port))
+}
+
+func (node *Node) busPort() int {
+	if node.BusPort == 0 {
+		return node.Port + 10000
+	}
+	return node.BusPort
+}
+
+// NewNodeID returns a random node identifier.
+func NewNodeID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// New initializes a cluster with only the given local node and no slots
+// assigned. A random ID is generated for the node if not set.
+func New(self *Node) *Cluster {
+	if self.ID == "" {
+		self.ID = NewNodeID()
+	}
+
+	return &Cluster{
+		self:      self,
+		nodes:     map[string]*Node{self.ID: self},
+		importing: map[int]*Node{},
+		migrating: map[int]*Node{},
+	}
+}
+
+// Cluster holds the slot to node mapping of the cluster as seen by the local
+// node along with the migration states of the slots. Cluster is safe for
+// concurrent use.
+type Cluster struct {
+	mu        sync.RWMutex
+	self      *Node
+	nodes     map[string]*Node
+	slots     [SlotCount]*Node
+	importing map[int]*Node
+	migrating map[int]*Node
+}
+
+// Self returns the local node.
+func (cl *Cluster) Self() *Node {
+	return cl.self
+}
+
+// AddNode adds the node to the cluster or replaces the existing node with
+// the same ID.
+func (cl *Cluster) AddNode(node *Node) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if old, found := cl.nodes[node.ID]; found {
+		for i, owner := range cl.slots {
+			if owner == old {
+				cl.slots[i] = node
+			}
+		}
+	}
+	cl.nodes[node.ID] = node
+}
+
+// RemoveNode removes the node from the cluster and unassigns all the slots
+// owned by it. The local node cannot be removed.
+func (cl *Cluster) RemoveNode(id string) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	node, found := cl.nodes[id]
+	if !found || node == cl.self {
+		return ErrUnknownNode
+	}
+
+	for i, owner := range cl.slots {
+		if owner == node {
+			cl.slots[i] = nil
+		}
+	}
+	delete(cl.nodes, id)
+	return nil
+}
+
+// Node returns the node with given ID or nil if not known.
+func (cl *Cluster) Node(id string) *Node {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.nodes[id]
+}
+
+// Nodes returns all the known nodes ordered by their IDs.
+func (cl *Cluster) Nodes() []*Node {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return sortedNodes(cl.nodes)
+}
+
+// AssignSlots assigns the slots in the range [start, end] to the node with
+// given ID. Any migration state of the slots is cleared.
+func (cl *Cluster) AssignSlots(id string, start, end int) error {
+	if !validSlot(start) || !validSlot(end) || start > end {
+		return ErrInvalidSlot
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	node, found := cl.nodes[id]
+	if !found {
+		return ErrUnknownNode
+	}
+
+	for slot := start; slot <= end; slot++ {
+		cl.slots[slot] = node
+		delete(cl.importing, slot)
+		delete(cl.migrating, slot)
+	}
+	return nil
+}
+
+// UnassignSlots removes the owner of slots in range [start, end].
+func (cl *Cluster) UnassignSlots(start, end int) error {
+	if !validSlot(start) || !validSlot(end) || start > end {
+		return ErrInvalidSlot
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for slot := start; slot <= end; slot++ {
+		cl.slots[slot] = nil
+		delete(cl.importing, slot)
+		delete(cl.migrating, slot)
+	}
+	return nil
+}
+
+// Owner returns the node serving the slot or nil if the slot is not assigned.
+func (cl *Cluster) Owner(slot int) *Node {
+	if !validSlot(slot) {
+		return nil
+	}
+
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.slots[slot]
+}
+
+// SetImporting marks the slot as being imported into the local node from the
+// node with given ID.
+func (cl *Cluster) SetImporting(slot int, from string) error {
+	return cl.setMigration(cl.importing, slot, from)
+}
+
+// SetMigrating marks the slot owned by the local node as being migrated to
+// the node with given ID.
+func (cl *Cluster) SetMigrating(slot int, to string) error {
+	return cl.setMigration(cl.migrating, slot, to)
+}
+
+// SetStable clears the importing and migrating states of the slot.
+func (cl *Cluster) SetStable(slot int) error {
+	if !validSlot(slot) {
+		return ErrInvalidSlot
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	delete(cl.importing, slot)
+	delete(cl.migrating, slot)
+	return nil
+}
+
+// Importing returns the node from which the slot is being imported or nil.
+func (cl *Cluster) Importing(slot int) *Node {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.importing[slot]
+}
+
+// Migrating returns the node to which the slot is being migrated or nil.
+func (cl *Cluster) Migrating(slot int) *Node {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.migrating[slot]
+}
+
+func (cl *Cluster) setMigration(states map[int]*Node, slot int, id string) error {
+	if !validSlot(slot) {
+		return ErrInvalidSlot
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	node, found := cl.nodes[id]
+	if !found {
+		return ErrUnknownNode
+	}
+
+	if node == cl.self {
+		return fmt.Errorf("can't migrate slot %d to or from myself", slot)
+	}
+
+	states[slot] = node
+	return nil
+}
+
+// slotRanges returns the contiguous ranges of slots owned by the node. Must
+// be called with the read lock held.
+func (cl *Cluster) slotRanges(node *Node) [][2]int {
+	var ranges [][2]int
+	start := -1
+	for slot := 0; slot <= SlotCount; slot++ {
+		owned := slot < SlotCount && cl.slots[slot] == node
+		if owned && start < 0 {
+			start = slot
+		} else if !owned && start >= 0 {
+			ranges = append(ranges, [2]int{start, slot - 1})
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// replicasOf returns the replicas of the master sorted by ID. Must be called
+// with the read lock held.
+func (cl *Cluster) replicasOf(master *Node) []*Node {
+	var replicas []*Node
+	for _, node := range cl.nodes {
+		if node.Master == master.ID {
+			replicas = append(replicas, node)
+		}
+	}
+
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].ID < replicas[j].ID
+	})
+	return replicas
+}
+
+func validSlot(slot int) bool {
+	return slot >= 0 && slot < SlotCount
+}
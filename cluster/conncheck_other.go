@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package cluster
+
+import "github.com/spy16/radio"
+
+// alive assumes that idle connections are alive on platforms where they
+// cannot be checked without blocking.
+func alive(conn *radio.Conn) bool {
+	return true
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package cluster
+
+import (
+	"syscall"
+
+	"github.com/spy16/radio"
+)
+
+// alive checks without blocking that the idle connection was not closed by
+// the peer. Idle connections have nothing to read, so reading anything
+// including the end of the stream means the connection cannot be used.
+// Connections without a raw connection are assumed to be alive.
+func alive(conn *radio.Conn) bool {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return true
+	}
+
+	var buf [1]byte
+	idle := false
+	err = rc.Read(func(fd uintptr) bool {
+		_, _, err := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		idle = err == syscall.EAGAIN || err == syscall.EWOULDBLOCK
+		return true
+	})
+	return err == nil && idle
+}
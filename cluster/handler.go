@@ -0,0 +1,458 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+)
+
+// Handler implements radio.Handler for a node of the cluster. It answers the
+// CLUSTER and ASKING commands and replies with MOVED, ASK, CROSSSLOT,
+// TRYAGAIN or CLUSTERDOWN errors for requests that must not be served by the
+// local node before they ever reach the wrapped handler.
+type Handler struct {
+	// Cluster is the cluster state as seen by the local node.
+	Cluster *Cluster
+
+	// Handler receives the requests that are served by the local node.
+	Handler radio.Handler
+
+	// KeySpecs is used to find the keys of requests. Defaults to
+	// radio.DefaultKeySpecs. Keys of the commands in radio.MovableKeys are
+	// found by parsing the arguments and requests whose keys cannot be
+	// found this way are rejected with CROSSSLOT. Requests for other
+	// commands without a key spec are always served locally.
+	KeySpecs radio.KeySpecs
+
+	// Exists reports whether the key is present in the local node. This is
+	// used to redirect requests for slots being migrated. If not set, all
+	// keys are assumed to be present.
+	Exists func(key string) bool
+}
+
+type askingKey struct{}
+
+const (
+	errInvalidSlot = radio.ErrorStr("ERR Invalid or out of range slot")
+	errCrossSlot   = radio.ErrorStr("CROSSSLOT Keys in request don't hash to the same slot")
+	errTryAgain    = radio.ErrorStr("TRYAGAIN Multiple keys request during rehashing of slot")
+)
+
+// ServeRESP redirects or serves the request.
+func (h *Handler) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	switch strings.ToUpper(req.Command) {
+	case "CLUSTER":
+		h.serveCluster(wr, req)
+		return
+
+	case "ASKING":
+		req.Client.SetValue(askingKey{}, true)
+		wr.Write(radio.SimpleStr("OK"))
+		return
+	}
+
+	// ASKING flag is only valid for the request following it.
+	asking := req.Client.Value(askingKey{}) != nil
+	if asking {
+		req.Client.SetValue(askingKey{}, nil)
+	}
+
+	if errStr := h.redirect(req, asking); errStr != "" {
+		wr.Write(errStr)
+		return
+	}
+
+	h.Handler.ServeRESP(wr, req)
+}
+
+func (h *Handler) redirect(req *radio.Request, asking bool) radio.ErrorStr {
+	specs := h.KeySpecs
+	if specs == nil {
+		specs = radio.DefaultKeySpecs
+	}
+
+	keys, ok := specs.Resolve(req)
+	if !ok && radio.MovableKeys[strings.ToLower(req.Command)] {
+		// serving the request locally may access keys of other nodes.
+		return errCrossSlot
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return errCrossSlot
+		}
+	}
+
+	owner := h.Cluster.Owner(slot)
+	if owner == nil {
+		return "CLUSTERDOWN Hash slot not served"
+	}
+
+	self := h.Cluster.Self()
+	if owner == self {
+		target := h.Cluster.Migrating(slot)
+		if target == nil {
+			return ""
+		}
+
+		// keys split between the nodes cannot be served by either of them
+		// until the migration of the slot completes.
+		switch missing := h.missing(keys); {
+		case missing == len(keys):
+			return radio.ErrorStr(fmt.Sprintf("ASK %d %s", slot, target.Addr()))
+		case missing > 0:
+			return errTryAgain
+		}
+		return ""
+	}
+
+	if asking && h.Cluster.Importing(slot) != nil {
+		if len(keys) > 1 && h.missing(keys) > 0 {
+			return errTryAgain
+		}
+		return ""
+	}
+
+	return radio.ErrorStr(fmt.Sprintf("MOVED %d %s", slot, owner.Addr()))
+}
+
+func (h *Handler) missing(keys []string) int {
+	if h.Exists == nil {
+		return 0
+	}
+
+	count := 0
+	for _, key := range keys {
+		if !h.Exists(key) {
+			count++
+		}
+	}
+	return count
+}
+
+func (h *Handler) serveCluster(wr radio.ResponseWriter, req *radio.Request) {
+	if len(req.Args) == 0 {
+		wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'cluster' command"))
+		return
+	}
+
+	args := req.Args[1:]
+	switch sub := strings.ToUpper(req.Args[0]); {
+	case sub == "SLOTS" && len(args) == 0:
+		wr.Write(h.slots())
+
+	case sub == "SHARDS" && len(args) == 0:
+		wr.Write(h.shards())
+
+	case sub == "NODES" && len(args) == 0:
+		wr.Write(bulk(h.nodes()))
+
+	case sub == "INFO" && len(args) == 0:
+		wr.Write(bulk(h.info()))
+
+	case sub == "MYID" && len(args) == 0:
+		wr.Write(bulk(h.Cluster.Self().ID))
+
+	case sub == "KEYSLOT" && len(args) == 1:
+		wr.Write(radio.Integer(KeySlot(args[0])))
+
+	case (sub == "ADDSLOTS" || sub == "DELSLOTS") && len(args) > 0:
+		h.serveSlots(wr, sub == "ADDSLOTS", args, false)
+
+	case (sub == "ADDSLOTSRANGE" || sub == "DELSLOTSRANGE") && len(args) > 0 && len(args)%2 == 0:
+		h.serveSlots(wr, sub == "ADDSLOTSRANGE", args, true)
+
+	case sub == "SETSLOT" && len(args) >= 2:
+		h.serveSetSlot(wr, args)
+
+	default:
+		wr.Write(radio.ErrorStr(fmt.Sprintf("ERR Unknown subcommand or wrong number of arguments for '%s'. Try CLUSTER HELP.", req.Args[0])))
+	}
+}
+
+func (h *Handler) serveSlots(wr radio.ResponseWriter, add bool, args []string, ranges bool) {
+	var slots [][2]int
+	for i := 0; i < len(args); i++ {
+		start, ok := parseSlot(args[i])
+		if !ok {
+			wr.Write(errInvalidSlot)
+			return
+		}
+
+		end := start
+		if ranges {
+			i++
+			if end, ok = parseSlot(args[i]); !ok {
+				wr.Write(errInvalidSlot)
+				return
+			}
+
+			if start > end {
+				wr.Write(radio.ErrorStr(fmt.Sprintf("ERR start slot number %d is greater than end slot number %d", start, end)))
+				return
+			}
+		}
+		slots = append(slots, [2]int{start, end})
+	}
+
+	for _, rng := range slots {
+		for slot := rng[0]; slot <= rng[1]; slot++ {
+			owner := h.Cluster.Owner(slot)
+			if add && owner != nil {
+				wr.Write(radio.ErrorStr(fmt.Sprintf("ERR Slot %d is already busy", slot)))
+				return
+			} else if !add && owner == nil {
+				wr.Write(radio.ErrorStr(fmt.Sprintf("ERR Slot %d is already unassigned", slot)))
+				return
+			}
+		}
+	}
+
+	for _, rng := range slots {
+		if add {
+			h.Cluster.AssignSlots(h.Cluster.Self().ID, rng[0], rng[1])
+		} else {
+			h.Cluster.UnassignSlots(rng[0], rng[1])
+		}
+	}
+
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func (h *Handler) serveSetSlot(wr radio.ResponseWriter, args []string) {
+	slot, ok := parseSlot(args[0])
+	if !ok {
+		wr.Write(errInvalidSlot)
+		return
+	}
+
+	var err error
+	switch action := strings.ToUpper(args[1]); {
+	case action == "STABLE" && len(args) == 2:
+		err = h.Cluster.SetStable(slot)
+
+	case action == "IMPORTING" && len(args) == 3:
+		err = h.Cluster.SetImporting(slot, args[2])
+
+	case action == "MIGRATING" && len(args) == 3:
+		if h.Cluster.Owner(slot) != h.Cluster.Self() {
+			wr.Write(radio.ErrorStr(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot)))
+			return
+		}
+		err = h.Cluster.SetMigrating(slot, args[2])
+
+	case action == "NODE" && len(args) == 3:
+		err = h.Cluster.AssignSlots(args[2], slot, slot)
+
+	default:
+		wr.Write(radio.ErrorStr("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP"))
+		return
+	}
+
+	if err == ErrUnknownNode {
+		wr.Write(radio.ErrorStr(fmt.Sprintf("ERR I don't know about node %s", args[len(args)-1])))
+		return
+	} else if err != nil {
+		wr.Write(radio.ErrorStr("ERR " + err.Error()))
+		return
+	}
+
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func (h *Handler) slots() *radio.Array {
+	cl := h.Cluster
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	reply := &radio.Array{Items: []radio.Value{}}
+	start := 0
+	for slot := 1; slot <= SlotCount; slot++ {
+		if slot < SlotCount && cl.slots[slot] == cl.slots[start] {
+			continue
+		}
+
+		if owner := cl.slots[start]; owner != nil {
+			entry := &radio.Array{
+				Items: []radio.Value{
+					radio.Integer(start),
+					radio.Integer(slot - 1),
+					nodeEndpoint(owner),
+				},
+			}
+
+			for _, replica := range cl.replicasOf(owner) {
+				entry.Items = append(entry.Items, nodeEndpoint(replica))
+			}
+			reply.Items = append(reply.Items, entry)
+		}
+		start = slot
+	}
+
+	return reply
+}
+
+func (h *Handler) shards() *radio.Array {
+	cl := h.Cluster
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	reply := &radio.Array{Items: []radio.Value{}}
+	for _, node := range sortedNodes(cl.nodes) {
+		if node.Master != "" {
+			continue
+		}
+
+		slots := &radio.Array{Items: []radio.Value{}}
+		for _, rng := range cl.slotRanges(node) {
+			slots.Items = append(slots.Items, radio.Integer(rng[0]), radio.Integer(rng[1]))
+		}
+
+		nodes := &radio.Array{
+			Items: []radio.Value{shardNode(node, "master")},
+		}
+		for _, replica := range cl.replicasOf(node) {
+			nodes.Items = append(nodes.Items, shardNode(replica, "replica"))
+		}
+
+		reply.Items = append(reply.Items, &radio.Array{
+			Items: []radio.Value{bulk("slots"), slots, bulk("nodes"), nodes},
+		})
+	}
+
+	return reply
+}
+
+func (h *Handler) nodes() string {
+	cl := h.Cluster
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	var sb strings.Builder
+	for _, node := range sortedNodes(cl.nodes) {
+		flags, master := "master", "-"
+		if node.Master != "" {
+			flags, master = "slave", node.Master
+		}
+		if node == cl.self {
+			flags = "myself," + flags
+		}
+
+		fmt.Fprintf(&sb, "%s %s:%d@%d %s %s 0 0 0 connected",
+			node.ID, node.Host, node.Port, node.busPort(), flags, master)
+
+		for _, rng := range cl.slotRanges(node) {
+			if rng[0] == rng[1] {
+				fmt.Fprintf(&sb, " %d", rng[0])
+			} else {
+				fmt.Fprintf(&sb, " %d-%d", rng[0], rng[1])
+			}
+		}
+
+		if node == cl.self {
+			for _, slot := range sortedSlots(cl.migrating) {
+				fmt.Fprintf(&sb, " [%d->-%s]", slot, cl.migrating[slot].ID)
+			}
+			for _, slot := range sortedSlots(cl.importing) {
+				fmt.Fprintf(&sb, " [%d-<-%s]", slot, cl.importing[slot].ID)
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func (h *Handler) info() string {
+	cl := h.Cluster
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	assigned := 0
+	masters := map[*Node]bool{}
+	for _, owner := range cl.slots {
+		if owner != nil {
+			assigned++
+			masters[owner] = true
+		}
+	}
+
+	state := "ok"
+	if assigned < SlotCount {
+		state = "fail"
+	}
+
+	return fmt.Sprintf("cluster_enabled:1\r\n"+
+		"cluster_state:%s\r\n"+
+		"cluster_slots_assigned:%d\r\n"+
+		"cluster_slots_ok:%d\r\n"+
+		"cluster_slots_pfail:0\r\n"+
+		"cluster_slots_fail:0\r\n"+
+		"cluster_known_nodes:%d\r\n"+
+		"cluster_size:%d\r\n",
+		state, assigned, assigned, len(cl.nodes), len(masters))
+}
+
+func nodeEndpoint(node *Node) *radio.Array {
+	return &radio.Array{
+		Items: []radio.Value{
+			bulk(node.Host),
+			radio.Integer(node.Port),
+			bulk(node.ID),
+		},
+	}
+}
+
+func shardNode(node *Node, role string) *radio.Array {
+	return &radio.Array{
+		Items: []radio.Value{
+			bulk("id"), bulk(node.ID),
+			bulk("port"), radio.Integer(node.Port),
+			bulk("ip"), bulk(node.Host),
+			bulk("endpoint"), bulk(node.Host),
+			bulk("role"), bulk(role),
+			bulk("replication-offset"), radio.Integer(0),
+			bulk("health"), bulk("online"),
+		},
+	}
+}
+
+func sortedNodes(nodes map[string]*Node) []*Node {
+	list := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		list = append(list, node)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func sortedSlots(states map[int]*Node) []int {
+	slots := make([]int, 0, len(states))
+	for slot := range states {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+func parseSlot(s string) (int, bool) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || !validSlot(slot) {
+		return 0, false
+	}
+	return slot, true
+}
+
+func bulk(s string) *radio.BulkStr {
+	return &radio.BulkStr{Value: []byte(s)}
+}
@@ -0,0 +1,240 @@
+package cluster_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/cluster"
+)
+
+func TestHandler_Redirect(suite *testing.T) {
+	suite.Parallel()
+
+	fooSlot := cluster.KeySlot("foo")
+	cases := []struct {
+		title string
+		setup func(cl *cluster.Cluster)
+		req   *radio.Request
+		reply radio.Value
+	}{
+		{
+			title: "Local",
+			req:   &radio.Request{Command: "GET", Args: []string{"foo"}},
+			reply: radio.SimpleStr("served"),
+		},
+		{
+			title: "Keyless",
+			req:   &radio.Request{Command: "PING"},
+			reply: radio.SimpleStr("served"),
+		},
+		{
+			title: "Moved",
+			req:   &radio.Request{Command: "GET", Args: []string{"bar"}},
+			reply: radio.ErrorStr(fmt.Sprintf("MOVED %d 127.0.0.1:7001", cluster.KeySlot("bar"))),
+		},
+		{
+			title: "CrossSlot",
+			req:   &radio.Request{Command: "MGET", Args: []string{"foo", "bar"}},
+			reply: radio.ErrorStr("CROSSSLOT Keys in request don't hash to the same slot"),
+		},
+		{
+			title: "HashTag",
+			req:   &radio.Request{Command: "MGET", Args: []string{"{foo}.a", "{foo}.b"}},
+			reply: radio.SimpleStr("served"),
+		},
+		{
+			title: "MovableCrossSlot",
+			req:   &radio.Request{Command: "ZUNIONSTORE", Args: []string{"foo", "2", "foo", "bar"}},
+			reply: radio.ErrorStr("CROSSSLOT Keys in request don't hash to the same slot"),
+		},
+		{
+			title: "MovableLocal",
+			req:   &radio.Request{Command: "ZUNIONSTORE", Args: []string{"{foo}.d", "2", "{foo}.a", "{foo}.b", "WEIGHTS", "1", "2"}},
+			reply: radio.SimpleStr("served"),
+		},
+		{
+			title: "MovableMoved",
+			req:   &radio.Request{Command: "XREAD", Args: []string{"COUNT", "1", "STREAMS", "bar", "0"}},
+			reply: radio.ErrorStr(fmt.Sprintf("MOVED %d 127.0.0.1:7001", cluster.KeySlot("bar"))),
+		},
+		{
+			title: "MovableGroup",
+			req:   &radio.Request{Command: "XREADGROUP", Args: []string{"GROUP", "g", "c", "STREAMS", "foo", ">"}},
+			reply: radio.SimpleStr("served"),
+		},
+		{
+			title: "MovableEval",
+			req:   &radio.Request{Command: "EVAL", Args: []string{"return 1", "1", "bar", "arg"}},
+			reply: radio.ErrorStr(fmt.Sprintf("MOVED %d 127.0.0.1:7001", cluster.KeySlot("bar"))),
+		},
+		{
+			title: "MovableNoKeys",
+			req:   &radio.Request{Command: "EVAL", Args: []string{"return 1", "0"}},
+			reply: radio.SimpleStr("served"),
+		},
+		{
+			title: "MovableUnresolved",
+			req:   &radio.Request{Command: "EVAL", Args: []string{"return 1", "3", "foo"}},
+			reply: radio.ErrorStr("CROSSSLOT Keys in request don't hash to the same slot"),
+		},
+		{
+			title: "Unassigned",
+			setup: func(cl *cluster.Cluster) { cl.UnassignSlots(fooSlot, fooSlot) },
+			req:   &radio.Request{Command: "GET", Args: []string{"foo"}},
+			reply: radio.ErrorStr("CLUSTERDOWN Hash slot not served"),
+		},
+		{
+			title: "MigratingExisting",
+			setup: func(cl *cluster.Cluster) { cl.SetMigrating(fooSlot, "node-b") },
+			req:   &radio.Request{Command: "GET", Args: []string{"foo"}},
+			reply: radio.SimpleStr("served"),
+		},
+		{
+			title: "MigratingMissing",
+			setup: func(cl *cluster.Cluster) { cl.SetMigrating(fooSlot, "node-b") },
+			req:   &radio.Request{Command: "GET", Args: []string{"{foo}.missing"}},
+			reply: radio.ErrorStr(fmt.Sprintf("ASK %d 127.0.0.1:7001", fooSlot)),
+		},
+		{
+			title: "MigratingAllMissing",
+			setup: func(cl *cluster.Cluster) { cl.SetMigrating(fooSlot, "node-b") },
+			req:   &radio.Request{Command: "MGET", Args: []string{"{foo}.a", "{foo}.b"}},
+			reply: radio.ErrorStr(fmt.Sprintf("ASK %d 127.0.0.1:7001", fooSlot)),
+		},
+		{
+			title: "MigratingSomeMissing",
+			setup: func(cl *cluster.Cluster) { cl.SetMigrating(fooSlot, "node-b") },
+			req:   &radio.Request{Command: "MGET", Args: []string{"foo", "{foo}.missing"}},
+			reply: radio.ErrorStr("TRYAGAIN Multiple keys request during rehashing of slot"),
+		},
+	}
+
+	for _, cs := range cases {
+		suite.Run(cs.title, func(t *testing.T) {
+			h, _ := newHandler()
+			if cs.setup != nil {
+				cs.setup(h.Cluster)
+			}
+
+			rec := &recorder{}
+			h.ServeRESP(rec, cs.req)
+			if !reflect.DeepEqual([]radio.Value{cs.reply}, rec.vals) {
+				t.Errorf("expecting reply '%v', got %v", cs.reply, rec.vals)
+			}
+		})
+	}
+}
+
+func TestHandler_Asking(t *testing.T) {
+	h, _ := newHandler()
+	barSlot := cluster.KeySlot("bar")
+	h.Cluster.SetImporting(barSlot, "node-b")
+
+	client := &radio.Client{}
+	get := &radio.Request{Command: "GET", Args: []string{"bar"}, Client: client}
+
+	rec := &recorder{}
+	h.ServeRESP(rec, get)
+	h.ServeRESP(rec, &radio.Request{Command: "ASKING", Client: client})
+	h.ServeRESP(rec, get)
+	h.ServeRESP(rec, get)
+
+	moved := radio.ErrorStr(fmt.Sprintf("MOVED %d 127.0.0.1:7001", barSlot))
+	expected := []radio.Value{moved, radio.SimpleStr("OK"), radio.SimpleStr("served"), moved}
+	if !reflect.DeepEqual(expected, rec.vals) {
+		t.Errorf("expecting replies %v, got %v", expected, rec.vals)
+	}
+}
+
+func TestHandler_ClusterCommands(t *testing.T) {
+	h, served := newHandler()
+
+	rec := &recorder{}
+	h.ServeRESP(rec, &radio.Request{Command: "CLUSTER", Args: []string{"KEYSLOT", "foo"}})
+	if rec.vals[0] != radio.Integer(12182) {
+		t.Errorf("expecting keyslot 12182, got '%v'", rec.vals[0])
+	}
+
+	rec = &recorder{}
+	h.ServeRESP(rec, &radio.Request{Command: "cluster", Args: []string{"slots"}})
+	expected := "*2\r\n" +
+		"*4\r\n:0\r\n:8191\r\n*3\r\n$9\r\n127.0.0.1\r\n:7001\r\n$6\r\nnode-b\r\n*3\r\n$9\r\n127.0.0.1\r\n:7003\r\n$6\r\nnode-c\r\n" +
+		"*3\r\n:8192\r\n:16383\r\n*3\r\n$9\r\n127.0.0.1\r\n:7000\r\n$6\r\nnode-a\r\n"
+	if actual := rec.vals[0].Serialize(); actual != expected {
+		t.Errorf("expecting CLUSTER SLOTS reply\n%q\ngot\n%q", expected, actual)
+	}
+
+	h.ServeRESP(rec, &radio.Request{Command: "CLUSTER", Args: []string{"SETSLOT", "100", "IMPORTING", "node-b"}})
+	rec = &recorder{}
+	h.ServeRESP(rec, &radio.Request{Command: "CLUSTER", Args: []string{"NODES"}})
+	nodes := strings.Split(strings.TrimSpace(rec.vals[0].(*radio.BulkStr).String()), "\n")
+	expectedNodes := []string{
+		"node-a 127.0.0.1:7000@17000 myself,master - 0 0 0 connected 8192-16383 [100-<-node-b]",
+		"node-b 127.0.0.1:7001@17001 master - 0 0 0 connected 0-8191",
+		"node-c 127.0.0.1:7003@17003 slave node-b 0 0 0 connected",
+	}
+	if !reflect.DeepEqual(expectedNodes, nodes) {
+		t.Errorf("expecting CLUSTER NODES\n%s\ngot\n%s", strings.Join(expectedNodes, "\n"), strings.Join(nodes, "\n"))
+	}
+
+	rec = &recorder{}
+	h.ServeRESP(rec, &radio.Request{Command: "CLUSTER", Args: []string{"SHARDS"}})
+	shards := rec.vals[0].(*radio.Array)
+	if len(shards.Items) != 2 {
+		t.Fatalf("expecting 2 shards, got %d", len(shards.Items))
+	}
+
+	rec = &recorder{}
+	h.ServeRESP(rec, &radio.Request{Command: "CLUSTER", Args: []string{"ADDSLOTS", "0"}})
+	h.ServeRESP(rec, &radio.Request{Command: "CLUSTER", Args: []string{"DELSLOTSRANGE", "8192", "8200"}})
+	h.ServeRESP(rec, &radio.Request{Command: "CLUSTER", Args: []string{"ADDSLOTSRANGE", "8192", "8200"}})
+	h.ServeRESP(rec, &radio.Request{Command: "CLUSTER", Args: []string{"SETSLOT", "1", "NODE", "unknown"}})
+	expectedReplies := []radio.Value{
+		radio.ErrorStr("ERR Slot 0 is already busy"),
+		radio.SimpleStr("OK"),
+		radio.SimpleStr("OK"),
+		radio.ErrorStr("ERR I don't know about node unknown"),
+	}
+	if !reflect.DeepEqual(expectedReplies, rec.vals) {
+		t.Errorf("expecting replies %v, got %v", expectedReplies, rec.vals)
+	}
+
+	if *served != 0 {
+		t.Errorf("CLUSTER commands must not reach the wrapped handler")
+	}
+}
+
+// newHandler returns a handler for node-a in a cluster of two masters with
+// node-a serving slots 8192-16383 and node-b serving 0-8191. node-c is a
+// replica of node-b.
+func newHandler() (*cluster.Handler, *int) {
+	cl := cluster.New(&cluster.Node{ID: "node-a", Host: "127.0.0.1", Port: 7000})
+	cl.AddNode(&cluster.Node{ID: "node-b", Host: "127.0.0.1", Port: 7001})
+	cl.AddNode(&cluster.Node{ID: "node-c", Host: "127.0.0.1", Port: 7003, Master: "node-b"})
+	cl.AssignSlots("node-b", 0, 8191)
+	cl.AssignSlots("node-a", 8192, cluster.SlotCount-1)
+
+	served := 0
+	return &cluster.Handler{
+		Cluster: cl,
+		Handler: radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+			served++
+			wr.Write(radio.SimpleStr("served"))
+		}),
+		Exists: func(key string) bool {
+			return key == "foo"
+		},
+	}, &served
+}
+
+type recorder struct {
+	vals []radio.Value
+}
+
+func (rec *recorder) Write(v radio.Value) (int, error) {
+	rec.vals = append(rec.vals, v)
+	return 0, nil
+}
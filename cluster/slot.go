@@ -0,0 +1,47 @@
+package cluster
+
+import "strings"
+
+// SlotCount is the number of hash slots in a cluster.
+const SlotCount = 16384
+
+// KeySlot returns the hash slot of the key. If the key contains a non-empty
+// hash tag (i.e., a substring enclosed in the first '{' and the following
+// '}'), only the hash tag is hashed so that related keys can be forced into
+// the same slot.
+//
+// Refer https://redis.io/topics/cluster-spec#keys-distribution-model
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key) & (SlotCount - 1))
+}
+
+// crc16 implements the CRC16-CCITT (XMODEM) checksum used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
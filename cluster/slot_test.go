@@ -0,0 +1,37 @@
+package cluster_test
+
+import (
+	"testing"
+
+	"github.com/spy16/radio/cluster"
+)
+
+func TestKeySlot(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		key  string
+		slot int
+	}{
+		{key: "123456789", slot: 12739},
+		{key: "foo", slot: 12182},
+		{key: "", slot: 0},
+		{key: "{user1000}.following", slot: cluster.KeySlot("user1000")},
+		{key: "{user1000}.followers", slot: cluster.KeySlot("user1000")},
+		{key: "foo{}{bar}", slot: cluster.KeySlot("foo{}{bar}")},
+		{key: "foo{{bar}}zap", slot: cluster.KeySlot("{bar")},
+		{key: "foo{bar}{zap}", slot: cluster.KeySlot("bar")},
+	}
+
+	for _, cs := range cases {
+		suite.Run(cs.key, func(t *testing.T) {
+			if actual := cluster.KeySlot(cs.key); actual != cs.slot {
+				t.Errorf("expecting slot %d, got %d", cs.slot, actual)
+			}
+		})
+	}
+
+	if cluster.KeySlot("foo{}{bar}") == cluster.KeySlot("bar") {
+		suite.Errorf("empty hash tag must not be used for hashing")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/capture"
+)
+
+const usage = `usage: radiocap <command> [flags]
+
+commands:
+  record   proxy clients to an upstream server and capture the traffic
+  replay   replay a capture against a server and report differing replies
+  dump     print the records of a capture
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = record(os.Args[2:])
+
+	case "replay":
+		err = replay(os.Args[2:])
+
+	case "dump":
+		err = dump(os.Args[2:])
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%s: %v", os.Args[1], err)
+	}
+}
+
+func record(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	addr := fs.String("addr", ":9736", "TCP address to listen for connections")
+	upstream := fs.String("upstream", "localhost:6379", "address of the server to forward requests to")
+	out := fs.String("out", "capture.resp", "file to write the capture to")
+	fs.Parse(args)
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	rec := capture.NewRecorder(&forwarder{addr: *upstream}, f)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				if err := rec.Flush(); err != nil {
+					log.Printf("failed to write capture: %v", err)
+				}
+			}
+		}
+	}()
+
+	log.Printf("capturing traffic to '%s' on '%s' into '%s'...", *upstream, *addr, *out)
+	err = radio.ListenAndServe(ctx, l, rec)
+	if closeErr := rec.Close(); closeErr != nil {
+		return closeErr
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:6379", "address of the server to replay the capture against")
+	in := fs.String("in", "capture.resp", "capture file to replay")
+	speed := fs.Float64("speed", 1, "replay speed relative to the capture (0 replays as fast as possible)")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout for the replies of each request")
+	fs.Parse(args)
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	rp := &capture.Replayer{
+		Speed:   *speed,
+		Timeout: *timeout,
+		OnDiff: func(rec *capture.Record, replies []radio.Value) {
+			fmt.Printf("client=%d %s %v\n", rec.ClientID, rec.Request.Command, rec.Request.Args)
+			fmt.Printf("  captured: %q\n", serialize(rec.Replies))
+			fmt.Printf("  replayed: %q\n", serialize(replies))
+		},
+	}
+
+	stats, err := rp.ReplayAddr(ctx, capture.NewReader(f), *addr)
+	fmt.Printf("replayed=%d matched=%d mismatched=%d skipped=%d\n", stats.Replayed, stats.Matched, stats.Mismatched, stats.Skipped)
+	return err
+}
+
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	in := fs.String("in", "capture.resp", "capture file to print")
+	fs.Parse(args)
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rd := capture.NewReader(f)
+	for {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s client=%d %s %v -> %q\n", rec.Time.Format(time.RFC3339Nano),
+			rec.ClientID, rec.Request.Command, rec.Request.Args, serialize(rec.Replies))
+	}
+}
+
+// forwarder is a radio.Handler that forwards requests to the upstream server
+// using a separate connection for every client.
+type forwarder struct {
+	addr string
+}
+
+type upstreamKey struct{}
+
+func (fw *forwarder) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	conn, err := fw.conn(req.Client)
+	if err != nil {
+		wr.Write(radio.ErrorStr(fmt.Sprintf("ERR upstream unavailable: %v", err)))
+		return
+	}
+
+	v, err := conn.Do(req.Command, req.Args...)
+	if err != nil {
+		conn.Close()
+		req.Client.SetValue(upstreamKey{}, nil)
+		wr.Write(radio.ErrorStr(fmt.Sprintf("ERR upstream failed: %v", err)))
+		return
+	}
+	wr.Write(v)
+}
+
+func (fw *forwarder) conn(client *radio.Client) (*radio.Conn, error) {
+	if conn, ok := client.Value(upstreamKey{}).(*radio.Conn); ok {
+		return conn, nil
+	}
+
+	conn, err := radio.Dial(fw.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if client != nil {
+		client.SetValue(upstreamKey{}, conn)
+		go func() {
+			<-client.Context().Done()
+			conn.Close()
+		}()
+	}
+	return conn, nil
+}
+
+func serialize(vals []radio.Value) string {
+	s := ""
+	for _, v := range vals {
+		s += v.Serialize()
+	}
+	return s
+}
+
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigCh)
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,608 @@
+package radio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rewriteSignature precedes the parameters appended by CONFIG REWRITE.
+const rewriteSignature = "# Generated by CONFIG REWRITE"
+
+// ConfigParam describes a configuration parameter registered with Config.
+type ConfigParam struct {
+	// Name is the name of the parameter (e.g., "maxmemory"). Names are
+	// matched case insensitively.
+	Name string
+
+	// Aliases are the alternative names of the parameter (e.g., the names
+	// of renamed parameters).
+	Aliases []string
+
+	// Value holds the value of the parameter. The value at the time of the
+	// registration is the default value, which CONFIG REWRITE does not add
+	// to the configuration file.
+	Value ConfigValue
+
+	// Immutable parameters can only be set by the configuration file.
+	Immutable bool
+
+	// OnChange, if set, is invoked after the value is changed by CONFIG SET,
+	// Set or Load to apply the new value. If it returns an error, the
+	// previous values of all the parameters changed together are restored.
+	OnChange func() error
+}
+
+// Config is a registry of typed configuration parameters declared by the
+// server and the applications. Config is a handler that serves CONFIG GET,
+// CONFIG SET, CONFIG RESETSTAT and CONFIG REWRITE and passes all the other
+// requests to the wrapped handler. Zero value of Config has no parameters.
+type Config struct {
+	// Handler serves the requests other than CONFIG.
+	Handler Handler
+
+	mu        sync.Mutex
+	params    map[string]*configParam
+	file      string
+	resetStat []func()
+}
+
+type configParam struct {
+	ConfigParam
+	def string
+}
+
+// Register adds the parameters to the registry. Register panics if a name
+// or an alias is already registered.
+func (cfg *Config) Register(params ...ConfigParam) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if cfg.params == nil {
+		cfg.params = map[string]*configParam{}
+	}
+
+	for _, p := range params {
+		cp := &configParam{ConfigParam: p, def: p.Value.String()}
+		for _, name := range append([]string{p.Name}, p.Aliases...) {
+			name = strings.ToLower(name)
+			if _, found := cfg.params[name]; found {
+				panic("radio: config parameter '" + name + "' registered twice")
+			}
+			cfg.params[name] = cp
+		}
+	}
+}
+
+// OnResetStat registers a function invoked by CONFIG RESETSTAT to reset the
+// statistics.
+func (cfg *Config) OnResetStat(fn func()) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.resetStat = append(cfg.resetStat, fn)
+}
+
+// Get returns the value of the parameter. ok is false if the parameter is
+// not registered.
+func (cfg *Config) Get(name string) (val string, ok bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	p, ok := cfg.params[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return p.Value.String(), true
+}
+
+// ConfigError is the error of setting a configuration parameter.
+type ConfigError struct {
+	// Name is the name of the parameter as given to Set.
+	Name string
+	Err  error
+}
+
+func (ce *ConfigError) Error() string {
+	return fmt.Sprintf("'%s': %v", ce.Name, ce.Err)
+}
+
+var (
+	errUnknownConfig   = errors.New("unknown parameter")
+	errImmutableConfig = errors.New("can't set immutable config")
+	errDuplicateConfig = errors.New("duplicate parameter")
+)
+
+// Set sets the parameters given as name and value pairs. The parameters are
+// set atomically: if any of the values is not valid or cannot be applied,
+// none of the parameters is changed. Returns a *ConfigError.
+func (cfg *Config) Set(pairs ...string) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.set(pairs, false)
+}
+
+// set sets the parameters given as name and value pairs. Immutable
+// parameters can only be set when loading the configuration file. cfg.mu
+// must be held.
+func (cfg *Config) set(pairs []string, loading bool) error {
+	var changed []*configParam
+	var old []string
+
+	restore := func(applied int) {
+		for i := len(changed) - 1; i >= 0; i-- {
+			changed[i].Value.Set(old[i])
+		}
+		for _, p := range changed[:applied] {
+			if p.OnChange != nil {
+				p.OnChange()
+			}
+		}
+	}
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, val := pairs[i], pairs[i+1]
+		p, found := cfg.params[strings.ToLower(name)]
+		if !found {
+			restore(0)
+			return &ConfigError{Name: name, Err: errUnknownConfig}
+		}
+		if p.Immutable && !loading {
+			restore(0)
+			return &ConfigError{Name: name, Err: errImmutableConfig}
+		}
+
+		for _, other := range changed {
+			if other == p {
+				restore(0)
+				return &ConfigError{Name: name, Err: errDuplicateConfig}
+			}
+		}
+
+		prev := p.Value.String()
+		if err := p.Value.Set(val); err != nil {
+			restore(0)
+			return &ConfigError{Name: name, Err: err}
+		}
+		changed = append(changed, p)
+		old = append(old, prev)
+	}
+
+	for i, p := range changed {
+		if p.OnChange == nil {
+			continue
+		}
+		if err := p.OnChange(); err != nil {
+			restore(i)
+			return &ConfigError{Name: pairs[2*i], Err: err}
+		}
+	}
+	return nil
+}
+
+// Load reads the redis.conf style configuration file and sets the
+// parameters. Every line of the file holds a parameter and its value
+// separated by spaces. Values can be quoted and lines starting with '#' are
+// comments. The include directive loads another file, relative to the
+// directory of the including file. The file is remembered for CONFIG
+// REWRITE.
+func (cfg *Config) Load(file string) error {
+	lines, err := readConfigFile(file, 0)
+	if err != nil {
+		return err
+	}
+
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for _, l := range lines {
+		if err := cfg.set(l.args, true); err != nil {
+			return fmt.Errorf("%s:%d: %v", l.file, l.num, err)
+		}
+	}
+
+	cfg.file = file
+	return nil
+}
+
+// configLine is a parameter set by a line of a configuration file.
+type configLine struct {
+	file string
+	num  int
+	args []string
+}
+
+// readConfigFile returns the parameters set by the configuration file and
+// the files it includes.
+func readConfigFile(file string, depth int) ([]configLine, error) {
+	if depth > 16 {
+		return nil, errors.New("too many nested includes in " + file)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []configLine
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for num := 1; sc.Scan(); num++ {
+		args, err := splitConfigLine(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, num, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s:%d: wrong number of arguments", file, num)
+		}
+
+		if strings.EqualFold(args[0], "include") {
+			path := args[1]
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(file), path)
+			}
+
+			included, err := readConfigFile(path, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, included...)
+			continue
+		}
+
+		lines = append(lines, configLine{file: file, num: num, args: args})
+	}
+	return lines, sc.Err()
+}
+
+var errNoConfigFile = errors.New("the server is running without a config file")
+
+// Rewrite rewrites the configuration file loaded using Load with the current
+// values of the parameters. The first line of every parameter is replaced
+// and the other lines of the same parameter are removed. Comments and the
+// lines of unknown parameters are kept as they are. The parameters without
+// a line that differ from their default values are appended.
+func (cfg *Config) Rewrite() error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if cfg.file == "" {
+		return errNoConfigFile
+	}
+
+	mode := os.FileMode(0644)
+	data, err := ioutil.ReadFile(cfg.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if fi, err := os.Stat(cfg.file); err == nil {
+		mode = fi.Mode()
+	}
+
+	var out bytes.Buffer
+	written := map[*configParam]bool{}
+	signed := false
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		signed = signed || line == rewriteSignature
+
+		args, err := splitConfigLine(line)
+		if err != nil || len(args) == 0 {
+			out.WriteString(line + "\n")
+			continue
+		}
+
+		p, found := cfg.params[strings.ToLower(args[0])]
+		if !found {
+			out.WriteString(line + "\n")
+			continue
+		}
+
+		if !written[p] {
+			written[p] = true
+			out.WriteString(p.Name + " " + quoteConfigArg(p.Value.String()) + "\n")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range cfg.sortedParams() {
+		val := p.Value.String()
+		if written[p] || val == p.def {
+			continue
+		}
+
+		if !signed {
+			out.WriteString(rewriteSignature + "\n")
+			signed = true
+		}
+		out.WriteString(p.Name + " " + quoteConfigArg(val) + "\n")
+	}
+
+	return writeFileAtomic(cfg.file, out.Bytes(), mode)
+}
+
+// writeFileAtomic replaces the file with the data by renaming a temporary
+// file so that the file is never partially written.
+func writeFileAtomic(file string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".config-rewrite-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// sortedParams returns the registered parameters sorted by name. cfg.mu
+// must be held.
+func (cfg *Config) sortedParams() []*configParam {
+	var params []*configParam
+	for name, p := range cfg.params {
+		if strings.EqualFold(name, p.Name) {
+			params = append(params, p)
+		}
+	}
+
+	sort.Slice(params, func(i, j int) bool {
+		return params[i].Name < params[j].Name
+	})
+	return params
+}
+
+// ServeRESP serves the CONFIG command and passes the other requests to the
+// wrapped handler.
+func (cfg *Config) ServeRESP(wr ResponseWriter, req *Request) {
+	if !strings.EqualFold(req.Command, "config") {
+		cfg.Handler.ServeRESP(wr, req)
+		return
+	}
+
+	if len(req.Args) == 0 {
+		wr.Write(ErrorStr("ERR wrong number of arguments for 'config' command"))
+		return
+	}
+
+	sub, args := strings.ToLower(req.Args[0]), req.Args[1:]
+	switch {
+	case sub == "get" && len(args) > 0:
+		cfg.serveGet(wr, args)
+
+	case sub == "set" && len(args) > 0 && len(args)%2 == 0:
+		err := cfg.Set(args...)
+		if err == nil {
+			wr.Write(SimpleStr("OK"))
+			return
+		}
+
+		ce := err.(*ConfigError)
+		if ce.Err == errUnknownConfig {
+			wr.Write(ErrorStr("ERR Unknown option or number of arguments for CONFIG SET - '" + ce.Name + "'"))
+		} else {
+			wr.Write(ErrorStr("ERR CONFIG SET failed (possibly related to argument '" + ce.Name + "') - " + ce.Err.Error()))
+		}
+
+	case sub == "resetstat" && len(args) == 0:
+		cfg.mu.Lock()
+		resetStat := cfg.resetStat
+		cfg.mu.Unlock()
+
+		for _, fn := range resetStat {
+			fn()
+		}
+		wr.Write(SimpleStr("OK"))
+
+	case sub == "rewrite" && len(args) == 0:
+		switch err := cfg.Rewrite(); err {
+		case nil:
+			wr.Write(SimpleStr("OK"))
+		case errNoConfigFile:
+			wr.Write(ErrorStr("ERR The server is running without a config file"))
+		default:
+			wr.Write(ErrorStr("ERR Rewriting config file: " + err.Error()))
+		}
+
+	case sub == "get" || sub == "set" || sub == "resetstat" || sub == "rewrite":
+		wr.Write(ErrorStr("ERR wrong number of arguments for 'config|" + sub + "' command"))
+
+	default:
+		wr.Write(ErrorStr("ERR unknown subcommand '" + req.Args[0] + "'. Try CONFIG HELP."))
+	}
+}
+
+// serveGet replies with the names and the values of the parameters matching
+// any of the glob-style patterns. Aliases are only matched exactly.
+func (cfg *Config) serveGet(wr ResponseWriter, patterns []string) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	matches := map[string]string{}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if p, found := cfg.params[pattern]; found {
+			matches[pattern] = p.Value.String()
+			continue
+		}
+
+		for _, p := range cfg.sortedParams() {
+			if MatchPattern(pattern, strings.ToLower(p.Name)) {
+				matches[p.Name] = p.Value.String()
+			}
+		}
+	}
+
+	names := make([]string, 0, len(matches))
+	for name := range matches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	arr := &Array{Items: []Value{}}
+	for _, name := range names {
+		arr.Items = append(arr.Items,
+			&BulkStr{Value: []byte(name)},
+			&BulkStr{Value: []byte(matches[name])},
+		)
+	}
+	wr.Write(arr)
+}
+
+// splitConfigLine splits a line of a configuration file into arguments in
+// the same way as Redis. Arguments are separated by spaces and can be
+// quoted: double quoted arguments support the escape sequences \n, \r, \t,
+// \b, \a and \xHH and single quoted arguments support \'. Comments have no
+// arguments.
+func splitConfigLine(line string) ([]string, error) {
+	if trimmed := strings.TrimSpace(line); trimmed == "" || trimmed[0] == '#' {
+		return nil, nil
+	}
+
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isConfigSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		var dq, sq bool
+		for done := false; !done; i++ {
+			if i == len(line) {
+				if dq || sq {
+					return nil, errors.New("unbalanced quotes")
+				}
+				break
+			}
+
+			c := line[i]
+			switch {
+			case dq && c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+				b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+				arg = append(arg, byte(b))
+				i += 3
+
+			case dq && c == '\\' && i+1 < len(line):
+				i++
+				switch line[i] {
+				case 'n':
+					arg = append(arg, '\n')
+				case 'r':
+					arg = append(arg, '\r')
+				case 't':
+					arg = append(arg, '\t')
+				case 'b':
+					arg = append(arg, '\b')
+				case 'a':
+					arg = append(arg, '\a')
+				default:
+					arg = append(arg, line[i])
+				}
+
+			case sq && c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+				arg = append(arg, '\'')
+				i++
+
+			case (dq && c == '"') || (sq && c == '\''):
+				if i+1 < len(line) && !isConfigSpace(line[i+1]) {
+					return nil, errors.New("closing quote must be followed by a space")
+				}
+				done = true
+
+			case dq || sq:
+				arg = append(arg, c)
+
+			case isConfigSpace(c):
+				done = true
+
+			case c == '"':
+				dq = true
+
+			case c == '\'':
+				sq = true
+
+			default:
+				arg = append(arg, c)
+			}
+		}
+
+		args = append(args, string(arg))
+	}
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// quoteConfigArg quotes the argument for the configuration file if it is
+// empty or contains spaces, quotes or non-printable characters.
+func quoteConfigArg(arg string) string {
+	plain := arg != ""
+	for i := 0; i < len(arg) && plain; i++ {
+		c := arg[i]
+		plain = c > ' ' && c < 0x7f && c != '"' && c != '\'' && c != '\\'
+	}
+	if plain {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\a':
+			b.WriteString("\\a")
+		case '\b':
+			b.WriteString("\\b")
+		default:
+			if c < ' ' || c >= 0x7f {
+				fmt.Fprintf(&b, "\\x%02x", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,300 @@
+package radio_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+)
+
+func newConfig() *radio.Config {
+	cfg := &radio.Config{
+		Handler: radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+			wr.Write(radio.SimpleStr("PASSED"))
+		}),
+	}
+
+	cfg.Register(
+		radio.ConfigParam{Name: "maxclients", Value: radio.NewIntConfig(10000, 1, 100000)},
+		radio.ConfigParam{Name: "maxmemory", Value: radio.NewMemoryConfig(0, 0, 1<<40)},
+		radio.ConfigParam{Name: "appendonly", Value: radio.NewBoolConfig(false)},
+		radio.ConfigParam{Name: "maxmemory-policy", Value: radio.NewEnumConfig("noeviction", "noeviction", "allkeys-lru")},
+		radio.ConfigParam{Name: "timeout", Value: radio.NewDurationConfig(0, time.Second, 0, time.Hour)},
+		radio.ConfigParam{Name: "hash-max-listpack-entries", Aliases: []string{"hash-max-ziplist-entries"}, Value: radio.NewIntConfig(128, 0, 1<<20)},
+		radio.ConfigParam{Name: "dir", Value: radio.NewStringConfig("./"), Immutable: true},
+	)
+	return cfg
+}
+
+func TestConfig(suite *testing.T) {
+	suite.Parallel()
+
+	type step struct {
+		args  string
+		reply radio.Value
+	}
+
+	pairs := func(items ...string) *radio.Array {
+		arr := &radio.Array{Items: []radio.Value{}}
+		for _, itm := range items {
+			arr.Items = append(arr.Items, &radio.BulkStr{Value: []byte(itm)})
+		}
+		return arr
+	}
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "GET",
+			steps: []step{
+				{args: "GET maxclients", reply: pairs("maxclients", "10000")},
+				{args: "GET MAXCLIENTS", reply: pairs("maxclients", "10000")},
+				{args: "GET maxmemory*", reply: pairs("maxmemory", "0", "maxmemory-policy", "noeviction")},
+				{args: "GET timeout appendonly maxmemory", reply: pairs("appendonly", "no", "maxmemory", "0", "timeout", "0")},
+				{args: "GET hash-max-ziplist-entries", reply: pairs("hash-max-ziplist-entries", "128")},
+				{args: "GET hash-*", reply: pairs("hash-max-listpack-entries", "128")},
+				{args: "GET foo", reply: pairs()},
+				{args: "GET", reply: radio.ErrorStr("ERR wrong number of arguments for 'config|get' command")},
+			},
+		},
+		{
+			title: "SET",
+			steps: []step{
+				{args: "SET maxmemory 100mb maxmemory-policy ALLKEYS-LRU", reply: radio.SimpleStr("OK")},
+				{args: "GET maxmemory*", reply: pairs("maxmemory", "104857600", "maxmemory-policy", "allkeys-lru")},
+				{args: "SET maxmemory 1k appendonly yes timeout 1m", reply: radio.SimpleStr("OK")},
+				{args: "GET maxmemory appendonly timeout", reply: pairs("appendonly", "yes", "maxmemory", "1000", "timeout", "60")},
+				{args: "SET timeout 1500ms", reply: radio.SimpleStr("OK")},
+				{args: "GET timeout", reply: pairs("timeout", "1.5s")},
+				{args: "SET hash-max-ziplist-entries 64", reply: radio.SimpleStr("OK")},
+				{args: "GET hash-max-listpack-entries", reply: pairs("hash-max-listpack-entries", "64")},
+			},
+		},
+		{
+			title: "Invalid",
+			steps: []step{
+				{args: "SET maxclients 10 maxmemory 1zb", reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'maxmemory') - argument must be a memory value")},
+				{args: "GET maxclients", reply: pairs("maxclients", "10000")},
+				{args: "SET maxclients 0", reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'maxclients') - argument must be between 1 and 100000 inclusive")},
+				{args: "SET maxclients ten", reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'maxclients') - argument couldn't be parsed into an integer")},
+				{args: "SET appendonly true", reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'appendonly') - argument must be 'yes' or 'no'")},
+				{args: "SET maxmemory-policy lru", reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'maxmemory-policy') - argument(s) must be one of the following: noeviction, allkeys-lru")},
+				{args: "SET timeout 2h", reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'timeout') - argument must be between 0 and 3600 inclusive")},
+				{args: "SET dir /tmp", reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'dir') - can't set immutable config")},
+				{args: "SET maxclients 10 maxclients 20", reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'maxclients') - duplicate parameter")},
+				{args: "SET maxclients 10 foo bar", reply: radio.ErrorStr("ERR Unknown option or number of arguments for CONFIG SET - 'foo'")},
+				{args: "GET maxclients", reply: pairs("maxclients", "10000")},
+				{args: "SET maxclients", reply: radio.ErrorStr("ERR wrong number of arguments for 'config|set' command")},
+				{args: "RESETSTAT now", reply: radio.ErrorStr("ERR wrong number of arguments for 'config|resetstat' command")},
+				{args: "REWRITE", reply: radio.ErrorStr("ERR The server is running without a config file")},
+				{args: "FOO", reply: radio.ErrorStr("ERR unknown subcommand 'FOO'. Try CONFIG HELP.")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			cfg := newConfig()
+			for _, st := range cs.steps {
+				rec := radiotest.NewRecorder()
+				cfg.ServeRESP(rec, &radio.Request{Command: "CONFIG", Args: strings.Split(st.args, " ")})
+				if got := rec.Last(); !reflect.DeepEqual(st.reply, got) {
+					t.Errorf("CONFIG %s: expecting '%#v', got '%#v'", st.args, st.reply, got)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_Handler(t *testing.T) {
+	cfg := newConfig()
+
+	resets := 0
+	cfg.OnResetStat(func() { resets++ })
+
+	rec := radiotest.NewRecorder()
+	cfg.ServeRESP(rec, &radio.Request{Command: "CONFIG", Args: []string{"RESETSTAT"}})
+	cfg.ServeRESP(rec, &radio.Request{Command: "GET", Args: []string{"k"}})
+
+	expected := []radio.Value{radio.SimpleStr("OK"), radio.SimpleStr("PASSED")}
+	if vals := rec.Values(); !reflect.DeepEqual(expected, vals) {
+		t.Errorf("expecting '%#v', got '%#v'", expected, vals)
+	}
+	if resets != 1 {
+		t.Errorf("expecting statistics to be reset once, got %d", resets)
+	}
+}
+
+func TestConfig_OnChange(t *testing.T) {
+	cfg := &radio.Config{}
+
+	var applied []string
+	size := radio.NewIntConfig(1, 0, 100)
+	limit := radio.NewIntConfig(10, 0, 100)
+	cfg.Register(
+		radio.ConfigParam{Name: "size", Value: size, OnChange: func() error {
+			applied = append(applied, "size="+size.String())
+			return nil
+		}},
+		radio.ConfigParam{Name: "limit", Value: limit, OnChange: func() error {
+			if limit.Get() < size.Get() {
+				return errors.New("limit must not be less than size")
+			}
+			applied = append(applied, "limit="+limit.String())
+			return nil
+		}},
+	)
+
+	if err := cfg.Set("size", "5", "limit", "20"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the failed change restores and re-applies the previous values.
+	err := cfg.Set("size", "50", "limit", "30")
+	if err == nil || err.Error() != "'limit': limit must not be less than size" {
+		t.Errorf("expecting error about limit, got %v", err)
+	}
+
+	expected := []string{"size=5", "limit=20", "size=50", "size=5"}
+	if !reflect.DeepEqual(expected, applied) {
+		t.Errorf("expecting '%v', got '%v'", expected, applied)
+	}
+	if v, _ := cfg.Get("size"); v != "5" {
+		t.Errorf("expecting size to be restored to 5, got %s", v)
+	}
+	if v, _ := cfg.Get("limit"); v != "20" {
+		t.Errorf("expecting limit to be restored to 20, got %s", v)
+	}
+}
+
+func TestConfig_LoadAndRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "radio-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "radio.conf")
+	write := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("radio.conf", strings.Join([]string{
+		"# memory",
+		"maxmemory 1gb",
+		"",
+		"  # clients",
+		"maxclients 100",
+		"hash-max-ziplist-entries 10",
+		"maxclients 200",
+		`dir "/var/lib/radio data"`,
+		"include extra.conf",
+		"",
+	}, "\n"))
+	write("extra.conf", "appendonly 'yes'\n")
+
+	cfg := newConfig()
+	if err := cfg.Load(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, expected := range map[string]string{
+		"maxmemory":                 "1073741824",
+		"maxclients":                "200",
+		"hash-max-listpack-entries": "10",
+		"dir":                       "/var/lib/radio data",
+		"appendonly":                "yes",
+	} {
+		if v, _ := cfg.Get(name); v != expected {
+			t.Errorf("%s: expecting '%s', got '%s'", name, expected, v)
+		}
+	}
+
+	if err := cfg.Set("maxclients", "300", "timeout", "30500ms", "maxmemory-policy", "allkeys-lru"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rec := radiotest.NewRecorder()
+	cfg.ServeRESP(rec, &radio.Request{Command: "CONFIG", Args: []string{"REWRITE"}})
+	if v := rec.Last(); v != radio.SimpleStr("OK") {
+		t.Fatalf("expecting OK, got '%#v'", v)
+	}
+
+	expected := strings.Join([]string{
+		"# memory",
+		"maxmemory 1073741824",
+		"",
+		"  # clients",
+		"maxclients 300",
+		"hash-max-listpack-entries 10",
+		`dir "/var/lib/radio data"`,
+		"include extra.conf",
+		"# Generated by CONFIG REWRITE",
+		"appendonly yes",
+		"maxmemory-policy allkeys-lru",
+		"timeout 30.5s",
+		"",
+	}, "\n")
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("expecting rewritten file:\n%s\ngot:\n%s", expected, data)
+	}
+
+	// rewriting again keeps the file as it is.
+	if err := cfg.Rewrite(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(file); string(data) != expected {
+		t.Errorf("expecting rewritten file:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestConfig_LoadErrors(suite *testing.T) {
+	suite.Parallel()
+
+	dir, err := ioutil.TempDir("", "radio-config")
+	if err != nil {
+		suite.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		data string
+		err  string
+	}{
+		{data: "maxclients 10\nmaxmemory lots\n", err: "radio.conf:2: 'maxmemory': argument must be a memory value"},
+		{data: "foo bar\n", err: "radio.conf:1: 'foo': unknown parameter"},
+		{data: "maxclients 10 20\n", err: "radio.conf:1: wrong number of arguments"},
+		{data: "dir \"/tmp\n", err: "radio.conf:1: unbalanced quotes"},
+		{data: "dir \"/tmp\"x\n", err: "radio.conf:1: closing quote must be followed by a space"},
+		{data: "include missing.conf\n", err: "missing.conf: no such file or directory"},
+	}
+
+	for _, cs := range cases {
+		file := filepath.Join(dir, "radio.conf")
+		if err := ioutil.WriteFile(file, []byte(cs.data), 0600); err != nil {
+			suite.Fatal(err)
+		}
+
+		err := newConfig().Load(file)
+		if err == nil || !strings.HasSuffix(err.Error(), cs.err) {
+			suite.Errorf("%q: expecting error '%s', got %v", cs.data, cs.err, err)
+		}
+	}
+}
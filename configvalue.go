@@ -0,0 +1,283 @@
+package radio
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConfigValue is the value of a configuration parameter. Implementations
+// must be safe for concurrent use as the values are usually read while
+// serving requests and changed using CONFIG SET.
+type ConfigValue interface {
+	// String returns the value as replied by CONFIG GET and written by
+	// CONFIG REWRITE.
+	String() string
+
+	// Set validates and sets the value. The error describes why the value
+	// is not valid.
+	Set(s string) error
+}
+
+// IntConfig is an integer value in the range [Min, Max].
+type IntConfig struct {
+	v        int64
+	Min, Max int64
+}
+
+// NewIntConfig returns an IntConfig with the value and the range.
+func NewIntConfig(val, min, max int64) *IntConfig {
+	return &IntConfig{v: val, Min: min, Max: max}
+}
+
+// Get returns the value.
+func (ic *IntConfig) Get() int64 {
+	return atomic.LoadInt64(&ic.v)
+}
+
+// Set parses and sets the value.
+func (ic *IntConfig) Set(s string) error {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < ic.Min || n > ic.Max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", ic.Min, ic.Max)
+	}
+
+	atomic.StoreInt64(&ic.v, n)
+	return nil
+}
+
+func (ic *IntConfig) String() string {
+	return strconv.FormatInt(ic.Get(), 10)
+}
+
+// MemoryConfig is a size in bytes in the range [Min, Max]. Sizes can be set
+// with the units of redis.conf: k (1000), kb (1024), m, mb, g and gb.
+type MemoryConfig struct {
+	v        int64
+	Min, Max int64
+}
+
+// NewMemoryConfig returns a MemoryConfig with the size in bytes and the
+// range.
+func NewMemoryConfig(val, min, max int64) *MemoryConfig {
+	return &MemoryConfig{v: val, Min: min, Max: max}
+}
+
+// Get returns the size in bytes.
+func (mc *MemoryConfig) Get() int64 {
+	return atomic.LoadInt64(&mc.v)
+}
+
+// Set parses and sets the size.
+func (mc *MemoryConfig) Set(s string) error {
+	n, ok := parseMemory(s)
+	if !ok {
+		return errors.New("argument must be a memory value")
+	}
+	if n < mc.Min || n > mc.Max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", mc.Min, mc.Max)
+	}
+
+	atomic.StoreInt64(&mc.v, n)
+	return nil
+}
+
+func (mc *MemoryConfig) String() string {
+	return strconv.FormatInt(mc.Get(), 10)
+}
+
+// parseMemory parses a size with an optional unit.
+func parseMemory(s string) (int64, bool) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+		{"g", 1000 * 1000 * 1000}, {"m", 1000 * 1000}, {"k", 1000}, {"b", 1},
+	}
+
+	lower := strings.ToLower(s)
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, mul = lower[:len(lower)-len(u.suffix)], u.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/mul {
+		return 0, false
+	}
+	return n * mul, true
+}
+
+// BoolConfig is a boolean value set using yes or no.
+type BoolConfig struct {
+	v int32
+}
+
+// NewBoolConfig returns a BoolConfig with the value.
+func NewBoolConfig(val bool) *BoolConfig {
+	bc := &BoolConfig{}
+	if val {
+		bc.v = 1
+	}
+	return bc
+}
+
+// Get returns the value.
+func (bc *BoolConfig) Get() bool {
+	return atomic.LoadInt32(&bc.v) == 1
+}
+
+// Set parses and sets the value.
+func (bc *BoolConfig) Set(s string) error {
+	switch strings.ToLower(s) {
+	case "yes":
+		atomic.StoreInt32(&bc.v, 1)
+	case "no":
+		atomic.StoreInt32(&bc.v, 0)
+	default:
+		return errors.New("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+func (bc *BoolConfig) String() string {
+	if bc.Get() {
+		return "yes"
+	}
+	return "no"
+}
+
+// EnumConfig is one of a fixed set of names. Names are set case
+// insensitively.
+type EnumConfig struct {
+	v     int32
+	Names []string
+}
+
+// NewEnumConfig returns an EnumConfig with the value which must be one of
+// the names.
+func NewEnumConfig(val string, names ...string) *EnumConfig {
+	ec := &EnumConfig{Names: names}
+	if err := ec.Set(val); err != nil {
+		panic("radio: invalid enum config value '" + val + "'")
+	}
+	return ec
+}
+
+// Get returns the name.
+func (ec *EnumConfig) Get() string {
+	return ec.Names[atomic.LoadInt32(&ec.v)]
+}
+
+// Set sets the value if it is one of the names.
+func (ec *EnumConfig) Set(s string) error {
+	for i, name := range ec.Names {
+		if strings.EqualFold(name, s) {
+			atomic.StoreInt32(&ec.v, int32(i))
+			return nil
+		}
+	}
+	return errors.New("argument(s) must be one of the following: " + strings.Join(ec.Names, ", "))
+}
+
+func (ec *EnumConfig) String() string {
+	return ec.Get()
+}
+
+// DurationConfig is a duration in the range [Min, Max]. Durations are
+// integers in Unit (e.g., seconds for the timeout of redis.conf) and can
+// also be set in the format of time.ParseDuration (e.g., "1m30s"). Durations
+// that are not a multiple of Unit are formatted like time.Duration.
+type DurationConfig struct {
+	v        int64
+	Unit     time.Duration
+	Min, Max time.Duration
+}
+
+// NewDurationConfig returns a DurationConfig with the value, the unit and
+// the range.
+func NewDurationConfig(val, unit, min, max time.Duration) *DurationConfig {
+	return &DurationConfig{v: int64(val), Unit: unit, Min: min, Max: max}
+}
+
+// Get returns the duration.
+func (dc *DurationConfig) Get() time.Duration {
+	return time.Duration(atomic.LoadInt64(&dc.v))
+}
+
+// Set parses and sets the duration.
+func (dc *DurationConfig) Set(s string) error {
+	var d time.Duration
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		d = time.Duration(n) * dc.Unit
+		if d/dc.Unit != time.Duration(n) {
+			return errors.New("argument must be a duration")
+		}
+	} else if d, err = time.ParseDuration(s); err != nil {
+		return errors.New("argument must be a duration")
+	}
+
+	if d < dc.Min || d > dc.Max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", dc.Min/dc.Unit, dc.Max/dc.Unit)
+	}
+
+	atomic.StoreInt64(&dc.v, int64(d))
+	return nil
+}
+
+func (dc *DurationConfig) String() string {
+	d := dc.Get()
+	if d%dc.Unit != 0 {
+		return d.String()
+	}
+	return strconv.FormatInt(int64(d/dc.Unit), 10)
+}
+
+// StringConfig is a string value. Validate, if set, checks the values
+// before they are set.
+type StringConfig struct {
+	mu       sync.RWMutex
+	v        string
+	Validate func(s string) error
+}
+
+// NewStringConfig returns a StringConfig with the value.
+func NewStringConfig(val string) *StringConfig {
+	return &StringConfig{v: val}
+}
+
+// Get returns the value.
+func (sc *StringConfig) Get() string {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.v
+}
+
+// Set validates and sets the value.
+func (sc *StringConfig) Set(s string) error {
+	if sc.Validate != nil {
+		if err := sc.Validate(s); err != nil {
+			return err
+		}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.v = s
+	return nil
+}
+
+func (sc *StringConfig) String() string {
+	return sc.Get()
+}
@@ -0,0 +1,84 @@
+package radio
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Dial connects to the RESP server at the given TCP address.
+func Dial(addr string) (*Conn, error) {
+	con, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(con), nil
+}
+
+// NewConn initializes a client connection using the given stream. Replies
+// are parsed using a client-mode Reader.
+func NewConn(rwc io.ReadWriteCloser) *Conn {
+	return &Conn{
+		rwc: rwc,
+		rd:  NewReader(rwc, false),
+		bw:  bufio.NewWriter(rwc),
+	}
+}
+
+// Conn represents a client connection to a RESP server. Commands can be
+// executed one at a time using Do or pipelined using Send, Flush and Receive.
+// Conn is not safe for concurrent use.
+type Conn struct {
+	rwc io.ReadWriteCloser
+	rd  *Reader
+	bw  *bufio.Writer
+}
+
+// Do sends the command to the server and returns the reply. Error replies
+// from the server are returned as ErrorStr values and not as errors.
+func (conn *Conn) Do(cmd string, args ...string) (Value, error) {
+	if err := conn.Send(cmd, args...); err != nil {
+		return nil, err
+	}
+
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	return conn.Receive()
+}
+
+// Send writes the command to the output buffer of the connection.
+func (conn *Conn) Send(cmd string, args ...string) error {
+	_, err := conn.bw.WriteString((&Request{Command: cmd, Args: args}).Serialize())
+	return err
+}
+
+// Flush writes all the buffered commands to the server.
+func (conn *Conn) Flush() error {
+	return conn.bw.Flush()
+}
+
+// Receive reads the next reply from the server.
+func (conn *Conn) Receive() (Value, error) {
+	return conn.rd.Read()
+}
+
+// SyscallConn returns the raw connection of the underlying stream. An error
+// is returned if the stream does not provide one (i.e., does not implement
+// syscall.Conn).
+func (conn *Conn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := conn.rwc.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("stream does not provide a raw connection")
+	}
+	return sc.SyscallConn()
+}
+
+// Close closes the underlying connection.
+func (conn *Conn) Close() error {
+	return conn.rwc.Close()
+}
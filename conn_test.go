@@ -0,0 +1,63 @@
+package radio_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/spy16/radio"
+)
+
+func TestConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	echo := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		if req.Command == "FAIL" {
+			wr.Write(radio.ErrorStr("ERR failed"))
+			return
+		}
+
+		arr := &radio.Array{Items: []radio.Value{}}
+		for _, arg := range req.Args {
+			arr.Items = append(arr.Items, &radio.BulkStr{Value: []byte(arg)})
+		}
+		wr.Write(arr)
+	})
+	go radio.ListenAndServe(context.Background(), l, echo)
+
+	conn, err := radio.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	v, err := conn.Do("ECHO", "hello", "")
+	expected := &radio.Array{
+		Items: []radio.Value{
+			&radio.BulkStr{Value: []byte("hello")},
+			&radio.BulkStr{Value: []byte("")},
+		},
+	}
+	if err != nil || !reflect.DeepEqual(expected, v) {
+		t.Errorf("expecting '%v', got '%v' (err=%v)", expected, v, err)
+	}
+
+	conn.Send("FAIL")
+	conn.Send("ECHO", "world")
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	if v, err := conn.Receive(); err != nil || v != radio.ErrorStr("ERR failed") {
+		t.Errorf("expecting error reply, got '%v' (err=%v)", v, err)
+	}
+
+	if v, err := conn.Receive(); err != nil || len(v.(*radio.Array).Items) != 1 {
+		t.Errorf("expecting array reply, got '%v' (err=%v)", v, err)
+	}
+}
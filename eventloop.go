@@ -0,0 +1,25 @@
+package radio
+
+import "errors"
+
+// ErrEventLoopUnsupported is returned by ListenAndServeEventLoop on platforms
+// where the event-loop server is not available.
+var ErrEventLoopUnsupported = errors.New("event loop server is not supported on this platform")
+
+// EventLoopOptions control the event-loop server. Zero values are replaced
+// with defaults.
+type EventLoopOptions struct {
+	// Loops is the number of event loops connections are distributed to.
+	// Each loop runs on its own goroutine. Defaults to the number of CPUs.
+	Loops int
+
+	// ReadBufferSize is the size of the read buffer shared by all the
+	// connections of a loop. Defaults to 64 KB.
+	ReadBufferSize int
+
+	// OutputBufferLimit is the size of the pending output of a connection
+	// above which its requests are not read until the output is written.
+	// This keeps clients that pipeline requests without reading the replies
+	// from growing the output without limit. Defaults to 1 MB.
+	OutputBufferLimit int
+}
@@ -0,0 +1,532 @@
+package radio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	epollRead  = syscall.EPOLLIN | syscall.EPOLLRDHUP
+	epollWrite = epollRead | syscall.EPOLLOUT
+
+	// epollTimeout is the maximum time in milliseconds an event loop waits
+	// for events before checking whether it must shut down.
+	epollTimeout = 100
+)
+
+var errConnClosed = errors.New("connection closed")
+
+// ListenAndServeEventLoop starts a RESP server on the given listener that
+// uses a small number of epoll based event loops instead of a goroutine per
+// connection. Connections that are idle hold no buffers, which makes this
+// suitable for servers with very large number of mostly idle clients.
+//
+// Requests are dispatched to the handler from the event loop goroutines and
+// the handler must not block for long as that stalls all the connections of
+// the loop. Values written to the response writer after ServeRESP returns
+// (e.g., push messages) are written immediately. All the connections are
+// closed when ListenAndServeEventLoop returns.
+func ListenAndServeEventLoop(ctx context.Context, l net.Listener, handler Handler, opts EventLoopOptions) error {
+	srv := &Server{Handler: handler}
+	return srv.ServeEventLoop(ctx, l, opts)
+}
+
+// ServeEventLoop is like Serve but serves the connections using event loops
+// as described in ListenAndServeEventLoop. Requests are executed on the
+// executors if enabled, Concurrency and MaxPipeline are not used.
+func (srv *Server) ServeEventLoop(ctx context.Context, l net.Listener, opts EventLoopOptions) error {
+	if opts.Loops <= 0 {
+		opts.Loops = runtime.NumCPU()
+	}
+	if opts.ReadBufferSize <= 0 {
+		opts.ReadBufferSize = 64 * 1024
+	}
+	if opts.OutputBufferLimit <= 0 {
+		opts.OutputBufferLimit = 1024 * 1024
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	handler, stats := srv.start(ctx, l)
+
+	loops := make([]*eventLoop, opts.Loops)
+	for i := range loops {
+		lp, err := newEventLoop(handler, stats, opts)
+		if err != nil {
+			return err
+		}
+		loops[i] = lp
+
+		wg.Add(1)
+		go func(lp *eventLoop) {
+			defer wg.Done()
+			lp.run(ctx)
+		}(loops[i])
+	}
+
+	for next := 0; ; next++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+		}
+
+		con, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		if !stats.admit(con) {
+			continue
+		}
+
+		if err := loops[next%len(loops)].add(ctx, con); err != nil {
+			con.Close()
+			return err
+		}
+	}
+}
+
+type eventLoop struct {
+	epfd    int
+	handler Handler
+	stats   *serverStats
+	buf     []byte
+	limit   int
+
+	// wake is a pipe used to wake the loop up to resume the connections
+	// of clients that were unblocked.
+	wake [2]int
+
+	mu      sync.Mutex
+	conns   map[int]*elConn
+	resumed []*elConn
+}
+
+func newEventLoop(handler Handler, stats *serverStats, opts EventLoopOptions) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	lp := &eventLoop{
+		epfd:    epfd,
+		handler: handler,
+		stats:   stats,
+		buf:     make([]byte, opts.ReadBufferSize),
+		limit:   opts.OutputBufferLimit,
+		conns:   map[int]*elConn{},
+	}
+
+	if err := syscall.Pipe2(lp.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+
+	ev := &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(lp.wake[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, lp.wake[0], ev); err != nil {
+		syscall.Close(lp.wake[0])
+		syscall.Close(lp.wake[1])
+		syscall.Close(epfd)
+		return nil, err
+	}
+
+	return lp, nil
+}
+
+// add detaches the file descriptor of the connection from the Go runtime
+// and registers it with the event loop.
+func (lp *eventLoop) add(ctx context.Context, con net.Conn) error {
+	sc, ok := con.(syscall.Conn)
+	if !ok {
+		return errors.New("event loop requires connections with file descriptors")
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	fd := -1
+	ctlErr := rc.Control(func(sysfd uintptr) {
+		fd, err = syscall.Dup(int(sysfd))
+	})
+	if ctlErr != nil {
+		return ctlErr
+	} else if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := &elConn{
+		fd:     fd,
+		epfd:   lp.epfd,
+		client: newClient(ctx, con),
+		cancel: cancel,
+		limit:  lp.limit,
+		events: epollRead,
+	}
+	con.Close()
+
+	syscall.CloseOnExec(fd)
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		cancel()
+		return err
+	}
+	lp.stats.connect()
+
+	lp.mu.Lock()
+	lp.conns[fd] = c
+	lp.mu.Unlock()
+
+	ev := &syscall.EpollEvent{Events: epollRead, Fd: int32(fd)}
+	if err := syscall.EpollCtl(lp.epfd, syscall.EPOLL_CTL_ADD, fd, ev); err != nil {
+		lp.close(c)
+		return err
+	}
+	return nil
+}
+
+func (lp *eventLoop) run(ctx context.Context) {
+	defer func() {
+		syscall.Close(lp.wake[0])
+		syscall.Close(lp.wake[1])
+		syscall.Close(lp.epfd)
+	}()
+
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		select {
+		case <-ctx.Done():
+			lp.mu.Lock()
+			conns := lp.conns
+			lp.conns = map[int]*elConn{}
+			lp.mu.Unlock()
+
+			for _, c := range conns {
+				lp.close(c)
+			}
+			return
+
+		default:
+		}
+
+		// poll without blocking first and yield to other goroutines before
+		// blocking in the syscall, which ties up an OS thread.
+		n, err := syscall.EpollWait(lp.epfd, events, 0)
+		if err == nil && n == 0 {
+			runtime.Gosched()
+			n, err = syscall.EpollWait(lp.epfd, events, epollTimeout)
+		}
+
+		if err != nil && err != syscall.EINTR {
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			if int(events[i].Fd) == lp.wake[0] {
+				lp.resume()
+				continue
+			}
+
+			lp.mu.Lock()
+			c, found := lp.conns[int(events[i].Fd)]
+			lp.mu.Unlock()
+			if !found {
+				continue
+			}
+
+			if events[i].Events&syscall.EPOLLOUT != 0 {
+				c.mu.Lock()
+				err := c.flush()
+				c.mu.Unlock()
+				if err != nil {
+					lp.close(c)
+					continue
+				}
+
+				// serve the requests left buffered by the output limit.
+				if c.parser != nil && c.parser.Buffered() > 0 {
+					lp.dispatch(c)
+
+					c.mu.Lock()
+					closed := c.closed
+					c.mu.Unlock()
+					if closed {
+						continue
+					}
+				}
+			}
+
+			if events[i].Events&(epollRead|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				lp.read(c)
+			}
+		}
+	}
+}
+
+// read reads the available data from the connection and serves the
+// complete requests.
+func (lp *eventLoop) read(c *elConn) {
+	n, err := syscall.Read(c.fd, lp.buf)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return
+	} else if err != nil || n == 0 {
+		lp.close(c)
+		return
+	}
+
+	if c.parser == nil {
+		c.parser = NewParser(true)
+	}
+	c.parser.Feed(lp.buf[:n])
+
+	lp.dispatch(c)
+}
+
+// dispatch serves the complete requests buffered for the connection and
+// writes the replies.
+func (lp *eventLoop) dispatch(c *elConn) {
+	for {
+		c.setDispatching(true)
+		keep, throttled := lp.serve(c)
+		c.setDispatching(false)
+
+		c.mu.Lock()
+		err := c.flush()
+		full := c.full()
+		c.mu.Unlock()
+
+		if !keep || err != nil {
+			lp.close(c)
+			return
+		}
+
+		// the requests left buffered because of the output limit are
+		// served once the output is written, right away or when the
+		// connection becomes writable.
+		if !throttled || full {
+			break
+		}
+	}
+
+	if c.parser != nil && c.parser.Buffered() == 0 {
+		// release the buffer of the idle connection.
+		c.parser = nil
+	}
+}
+
+// serve dispatches the complete requests of the connection and reports
+// whether the connection must be kept open. Dispatching stops when the
+// client is blocked and is resumed once it is unblocked. It also stops when
+// the pending output exceeds the limit, in which case throttled is true.
+func (lp *eventLoop) serve(c *elConn) (keep, throttled bool) {
+	if c.parser == nil || c.client.Blocked() {
+		return true, false
+	}
+
+	for {
+		c.mu.Lock()
+		full := c.full()
+		c.mu.Unlock()
+		if full {
+			return true, true
+		}
+
+		val, err := c.parser.Next()
+		if err == ErrIncomplete {
+			return true, false
+		} else if err != nil {
+			c.Write(ErrorStr("ERR " + err.Error()))
+			return false, false
+		}
+
+		req, err := NewRequest(val)
+		if err != nil {
+			c.Write(ErrorStr("ERR " + err.Error()))
+			return false, false
+		}
+
+		if req == nil {
+			continue
+		}
+		req.Client = c.client
+
+		lp.handler.ServeRESP(c, req)
+
+		if unblocked := c.client.unblocked(); unblocked != nil {
+			atomic.AddInt64(&lp.stats.blocked, 1)
+			go func() {
+				<-unblocked
+				atomic.AddInt64(&lp.stats.blocked, -1)
+
+				lp.mu.Lock()
+				lp.resumed = append(lp.resumed, c)
+				lp.mu.Unlock()
+				syscall.Write(lp.wake[1], []byte{0})
+			}()
+			return true, false
+		}
+	}
+}
+
+// resume dispatches the requests buffered for the unblocked clients.
+func (lp *eventLoop) resume() {
+	var buf [64]byte
+	for {
+		if n, _ := syscall.Read(lp.wake[0], buf[:]); n <= 0 {
+			break
+		}
+	}
+
+	lp.mu.Lock()
+	resumed := lp.resumed
+	lp.resumed = nil
+	lp.mu.Unlock()
+
+	for _, c := range resumed {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+
+		if !closed {
+			lp.dispatch(c)
+		}
+	}
+}
+
+func (lp *eventLoop) close(c *elConn) {
+	lp.mu.Lock()
+	if lp.conns[c.fd] == c {
+		delete(lp.conns, c.fd)
+	}
+	lp.mu.Unlock()
+
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		syscall.EpollCtl(c.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+		syscall.Close(c.fd)
+		c.out = nil
+		lp.stats.disconnect()
+	}
+	c.mu.Unlock()
+
+	c.cancel()
+	c.client.close()
+}
+
+// elConn is a connection managed by an event loop. elConn implements the
+// ResponseWriter passed to the handler. Writes made while the event loop is
+// dispatching requests are buffered and written once all the available
+// requests are served. Other writes are written immediately.
+type elConn struct {
+	fd     int
+	epfd   int
+	client *Client
+	cancel context.CancelFunc
+	parser *Parser
+	limit  int
+
+	mu          sync.Mutex
+	out         []byte
+	events      uint32
+	dispatching bool
+	closed      bool
+}
+
+func (c *elConn) Write(v Value) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, errConnClosed
+	}
+
+	s := v.Serialize()
+	c.out = append(c.out, s...)
+	if c.dispatching {
+		return len(s), nil
+	}
+
+	if err := c.flush(); err != nil {
+		// let the event loop notice the failure and close the connection.
+		syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+		return 0, err
+	}
+	return len(s), nil
+}
+
+func (c *elConn) setDispatching(dispatching bool) {
+	c.mu.Lock()
+	c.dispatching = dispatching
+	c.mu.Unlock()
+}
+
+// flush writes as much of the pending output as possible without blocking.
+// If the socket is not writable, the connection is registered for write
+// readiness and the rest is written from the event loop. c.mu must be held.
+func (c *elConn) flush() error {
+	if c.closed {
+		return errConnClosed
+	}
+
+	for len(c.out) > 0 {
+		n, err := syscall.Write(c.fd, c.out)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.EAGAIN {
+			return c.poll()
+		} else if err != nil {
+			return err
+		} else if n == 0 {
+			return io.ErrShortWrite
+		}
+
+		c.out = c.out[n:]
+	}
+
+	// release the buffer of the idle connection.
+	c.out = nil
+	return c.poll()
+}
+
+// full returns true if the pending output exceeds the limit. c.mu must be
+// held.
+func (c *elConn) full() bool {
+	return len(c.out) >= c.limit
+}
+
+// poll registers the connection for write readiness while output is
+// pending and for read readiness unless the pending output exceeds the
+// limit, in which case no more requests are read until the client reads
+// the replies. c.mu must be held.
+func (c *elConn) poll() error {
+	events := uint32(epollRead)
+	if c.full() {
+		events = syscall.EPOLLOUT
+	} else if len(c.out) > 0 {
+		events = epollWrite
+	}
+
+	if events == c.events {
+		return nil
+	}
+	c.events = events
+
+	ev := &syscall.EpollEvent{Events: events, Fd: int32(c.fd)}
+	return syscall.EpollCtl(c.epfd, syscall.EPOLL_CTL_MOD, c.fd, ev)
+}
@@ -0,0 +1,264 @@
+package radio_test
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+func TestListenAndServeEventLoop(t *testing.T) {
+	disconnected := make(chan struct{}, 1)
+	pushed := make(chan struct{})
+
+	handler := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		switch req.Command {
+		case "PING":
+			wr.Write(radio.SimpleStr("PONG"))
+
+		case "ECHO":
+			wr.Write(&radio.BulkStr{Value: []byte(req.Args[0])})
+
+		case "PUSH":
+			wr.Write(radio.SimpleStr("OK"))
+			go func() {
+				<-pushed
+				wr.Write(radio.SimpleStr("pushed"))
+			}()
+
+		case "WATCH":
+			wr.Write(radio.SimpleStr("OK"))
+			go func() {
+				<-req.Client.Context().Done()
+				disconnected <- struct{}{}
+			}()
+		}
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- radio.ListenAndServeEventLoop(ctx, l, handler, radio.EventLoopOptions{Loops: 2, ReadBufferSize: 512})
+	}()
+
+	conn, err := radio.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if v, err := conn.Do("PING"); err != nil || v != radio.SimpleStr("PONG") {
+		t.Errorf("expecting 'PONG', got '%v' (err=%v)", v, err)
+	}
+
+	// pipelined large values exceed both the read buffer and the socket
+	// buffers.
+	large := strings.Repeat("x", 256*1024)
+	for i := 0; i < 20; i++ {
+		conn.Send("ECHO", large+strconv.Itoa(i))
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		v, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+
+		if bs, ok := v.(*radio.BulkStr); !ok || bs.String() != large+strconv.Itoa(i) {
+			t.Fatalf("unexpected reply for request %d", i)
+		}
+	}
+
+	if v, err := conn.Do("PUSH"); err != nil || v != radio.SimpleStr("OK") {
+		t.Errorf("expecting 'OK', got '%v' (err=%v)", v, err)
+	}
+	close(pushed)
+	if v, err := conn.Receive(); err != nil || v != radio.SimpleStr("pushed") {
+		t.Errorf("expecting 'pushed', got '%v' (err=%v)", v, err)
+	}
+
+	other, err := radio.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	other.Do("WATCH")
+	other.Close()
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Errorf("expecting client context to be cancelled on disconnect")
+	}
+
+	cancel()
+	l.Close()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatalf("expecting server to exit")
+	}
+
+	if _, err := conn.Do("PING"); err == nil {
+		t.Errorf("expecting connections to be closed on shutdown")
+	}
+}
+
+func TestListenAndServeEventLoop_OutputBufferLimit(t *testing.T) {
+	value := &radio.BulkStr{Value: make([]byte, 64*1024)}
+	var served int64
+	handler := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		atomic.AddInt64(&served, 1)
+		wr.Write(value)
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go radio.ListenAndServeEventLoop(ctx, l, handler, radio.EventLoopOptions{Loops: 1, OutputBufferLimit: 64 * 1024})
+
+	conn, err := radio.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// the requests fit in the socket buffers while the replies do not.
+	const requests = 1000
+	for i := 0; i < requests; i++ {
+		conn.Send("GET", "k")
+	}
+	conn.Flush()
+
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt64(&served); n >= requests/2 {
+		t.Errorf("expecting requests not to be read while replies are pending, %d served", n)
+	}
+
+	for i := 0; i < requests; i++ {
+		if v, err := conn.Receive(); err != nil || len(v.(*radio.BulkStr).Value) != len(value.Value) {
+			t.Fatalf("expecting reply %d, got error '%v'", i, err)
+		}
+	}
+}
+
+func BenchmarkServe_IdleConns(b *testing.B) {
+	const conns = 2000
+
+	for name, serve := range servers() {
+		serve := serve
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				addr, stop := serve(b)
+
+				before := heapAndStack()
+				cons := make([]*radio.Conn, 0, conns)
+				for j := 0; j < conns; j++ {
+					conn, err := radio.Dial(addr)
+					if err != nil {
+						b.Fatalf("failed to dial: %v", err)
+					}
+
+					// make sure the connection is set up on the server.
+					if _, err := conn.Do("PING"); err != nil {
+						b.Fatalf("not expecting error, got '%v'", err)
+					}
+					cons = append(cons, conn)
+				}
+				after := heapAndStack()
+
+				// includes the memory used by the client side of the
+				// connections which is the same for both servers.
+				b.ReportMetric(float64(after-before)/conns, "bytes/conn")
+
+				for _, conn := range cons {
+					conn.Close()
+				}
+				stop()
+			}
+		})
+	}
+}
+
+func BenchmarkServe_PING(b *testing.B) {
+	for name, serve := range servers() {
+		serve := serve
+		b.Run(name, func(b *testing.B) {
+			addr, stop := serve(b)
+			defer stop()
+
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				conn, err := radio.Dial(addr)
+				if err != nil {
+					b.Fatalf("failed to dial: %v", err)
+				}
+				defer conn.Close()
+
+				for pb.Next() {
+					if _, err := conn.Do("PING"); err != nil {
+						b.Fatalf("not expecting error, got '%v'", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+type serveFunc func(b *testing.B) (addr string, stop func())
+
+func servers() map[string]serveFunc {
+	ping := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		wr.Write(radio.SimpleStr("PONG"))
+	})
+
+	start := func(b *testing.B, serve func(ctx context.Context, l net.Listener) error) (string, func()) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			b.Fatalf("failed to listen: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go serve(ctx, l)
+		return l.Addr().String(), func() {
+			cancel()
+			l.Close()
+		}
+	}
+
+	return map[string]serveFunc{
+		"clientLoop": func(b *testing.B) (string, func()) {
+			return start(b, func(ctx context.Context, l net.Listener) error {
+				return radio.ListenAndServe(ctx, l, ping)
+			})
+		},
+		"eventLoop": func(b *testing.B) (string, func()) {
+			return start(b, func(ctx context.Context, l net.Listener) error {
+				return radio.ListenAndServeEventLoop(ctx, l, ping, radio.EventLoopOptions{})
+			})
+		},
+	}
+}
+
+func heapAndStack() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapInuse + ms.StackInuse
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package radio
+
+import (
+	"context"
+	"net"
+)
+
+// ListenAndServeEventLoop is only supported on Linux and returns
+// ErrEventLoopUnsupported on other platforms.
+func ListenAndServeEventLoop(ctx context.Context, l net.Listener, handler Handler, opts EventLoopOptions) error {
+	return ErrEventLoopUnsupported
+}
+
+// ServeEventLoop is only supported on Linux and returns
+// ErrEventLoopUnsupported on other platforms.
+func (srv *Server) ServeEventLoop(ctx context.Context, l net.Listener, opts EventLoopOptions) error {
+	return ErrEventLoopUnsupported
+}
@@ -0,0 +1,179 @@
+package radio
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// executor runs requests one after the other on a single goroutine.
+type executor struct {
+	jobs chan *job
+}
+
+// job is either a request to be served by the executor or, if release is
+// set, a request to park the executor until release is closed so that the
+// request can be served while holding multiple executors.
+type job struct {
+	req   *Request
+	reply *pendingReply
+
+	parked  chan struct{}
+	release chan struct{}
+}
+
+func newExecutor(ctx context.Context, handler Handler) *executor {
+	ex := &executor{jobs: make(chan *job, 64)}
+	go ex.run(ctx, handler)
+	return ex
+}
+
+func (ex *executor) run(ctx context.Context, handler Handler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case j := <-ex.jobs:
+			if j.release == nil {
+				handler.ServeRESP(j.reply, j.req)
+				close(j.reply.done)
+				continue
+			}
+
+			close(j.parked)
+			select {
+			case <-ctx.Done():
+				return
+			case <-j.release:
+			}
+		}
+	}
+}
+
+// executorHandler serves the requests on executors while the replies are
+// written to the connection by the calling goroutine. Requests are assigned
+// to executors by hashing their keys so that requests on the same key are
+// serialized while requests on different keys run in parallel. Requests
+// with keys on multiple executors, without keys or with keys that cannot be
+// determined hold all the involved executors. Executors are always acquired
+// in the same order to avoid deadlocks.
+type executorHandler struct {
+	ctx      context.Context
+	handler  Handler
+	shards   []*executor
+	keySpecs KeySpecs
+	bypass   func(req *Request) bool
+}
+
+func newExecutorHandler(ctx context.Context, handler Handler, shards int, specs KeySpecs, bypass func(req *Request) bool) *executorHandler {
+	eh := &executorHandler{
+		ctx:      ctx,
+		handler:  handler,
+		keySpecs: specs,
+		bypass:   bypass,
+	}
+
+	for i := 0; i < shards; i++ {
+		eh.shards = append(eh.shards, newExecutor(ctx, handler))
+	}
+	return eh
+}
+
+func (eh *executorHandler) ServeRESP(wr ResponseWriter, req *Request) {
+	if eh.bypass != nil && eh.bypass(req) {
+		eh.handler.ServeRESP(wr, req)
+		return
+	}
+
+	reply := &pendingReply{out: wr, done: make(chan struct{})}
+
+	shards := eh.shardsOf(req)
+	if len(shards) == 1 {
+		j := &job{req: req, reply: reply}
+		if !eh.submit(eh.shards[shards[0]], j) {
+			return
+		}
+	} else {
+		release := make(chan struct{})
+		for _, shard := range shards {
+			j := &job{parked: make(chan struct{}), release: release}
+			if !eh.submit(eh.shards[shard], j) {
+				close(release)
+				return
+			}
+
+			select {
+			case <-eh.ctx.Done():
+				close(release)
+				return
+			case <-j.parked:
+			}
+		}
+
+		eh.handler.ServeRESP(reply, req)
+		close(release)
+		close(reply.done)
+	}
+
+	select {
+	case <-eh.ctx.Done():
+		return
+
+	case <-reply.done:
+		reply.flush()
+	}
+}
+
+// Serial reports whether the wrapped handler requires the request to be
+// executed serially.
+func (eh *executorHandler) Serial(req *Request) bool {
+	if sh, ok := eh.handler.(SerialHandler); ok {
+		return sh.Serial(req)
+	}
+	return false
+}
+
+func (eh *executorHandler) submit(ex *executor, j *job) bool {
+	select {
+	case <-eh.ctx.Done():
+		return false
+
+	case ex.jobs <- j:
+		return true
+	}
+}
+
+// shardsOf returns the sorted indices of the executors the request must be
+// executed on.
+func (eh *executorHandler) shardsOf(req *Request) []int {
+	if len(eh.shards) == 1 {
+		return []int{0}
+	}
+
+	keys, ok := eh.keySpecs.Keys(req)
+	if !ok || len(keys) == 0 || MovableKeys[strings.ToLower(req.Command)] {
+		all := make([]int, len(eh.shards))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	seen := map[int]bool{}
+	var shards []int
+	for _, key := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+
+		shard := int(h.Sum32() % uint32(len(eh.shards)))
+		if !seen[shard] {
+			seen[shard] = true
+			shards = append(shards, shard)
+		}
+	}
+
+	sort.Ints(shards)
+	return shards
+}
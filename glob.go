@@ -0,0 +1,103 @@
+package radio
+
+// MatchPattern reports whether the string matches the glob-style pattern in
+// the same way as Redis does for KEYS, SCAN MATCH and PSUBSCRIBE. Supported
+// patterns are '*' (any sequence), '?' (any byte), '[abc]', '[^abc]' and
+// '[a-z]' (byte classes) and '\' to escape the next byte.
+func MatchPattern(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(str); i++ {
+				if MatchPattern(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+
+			rest, ok := matchClass(pattern[1:], str[0])
+			if !ok {
+				return false
+			}
+			str = str[1:]
+			pattern = rest
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(str) == 0
+}
+
+// matchClass matches the byte against the class at the start of the pattern
+// (after the opening '[') and returns the rest of the pattern after the
+// class. An unterminated class extends till the end of the pattern.
+func matchClass(pattern string, c byte) (string, bool) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] == c {
+				match = true
+			}
+			pattern = pattern[2:]
+
+		case len(pattern) > 2 && pattern[1] == '-':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				match = true
+			}
+			pattern = pattern[3:]
+
+		default:
+			if pattern[0] == c {
+				match = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // skip ']'
+	}
+
+	return pattern, match != not
+}
@@ -0,0 +1,50 @@
+package radio_test
+
+import (
+	"testing"
+
+	"github.com/spy16/radio"
+)
+
+func TestMatchPattern(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{pattern: "*", str: "", match: true},
+		{pattern: "*", str: "anything", match: true},
+		{pattern: "h?llo", str: "hello", match: true},
+		{pattern: "h?llo", str: "hllo", match: false},
+		{pattern: "h*llo", str: "hllo", match: true},
+		{pattern: "h*llo", str: "heeeello", match: true},
+		{pattern: "h*llo", str: "hellos", match: false},
+		{pattern: "h[ae]llo", str: "hallo", match: true},
+		{pattern: "h[ae]llo", str: "hillo", match: false},
+		{pattern: "h[^e]llo", str: "hallo", match: true},
+		{pattern: "h[^e]llo", str: "hello", match: false},
+		{pattern: "h[a-b]llo", str: "hbllo", match: true},
+		{pattern: "h[b-a]llo", str: "hbllo", match: true},
+		{pattern: "h[a-b]llo", str: "hcllo", match: false},
+		{pattern: `h\*llo`, str: "h*llo", match: true},
+		{pattern: `h\*llo`, str: "hello", match: false},
+		{pattern: `[\]]`, str: "]", match: true},
+		{pattern: "__keyspace@0__:*", str: "__keyspace@0__:foo", match: true},
+		{pattern: "__keyspace@*__:foo", str: "__keyevent@0__:foo", match: false},
+		{pattern: "a**b", str: "axxb", match: true},
+		{pattern: "[abc", str: "a", match: true},
+		{pattern: "", str: "", match: true},
+		{pattern: "", str: "a", match: false},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.pattern+"/"+cs.str, func(t *testing.T) {
+			if match := radio.MatchPattern(cs.pattern, cs.str); match != cs.match {
+				t.Errorf("expecting match=%t, got %t", cs.match, match)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package radio
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Hello is a handler that serves HELLO [protover] by switching the RESP
+// protocol of the client (see Client.SetProtocol) and passes all the other
+// requests to the wrapped handler. HELLO replies with the properties of the
+// server as a map for RESP3 clients and as an array of the keys and the
+// values for RESP2 clients. The AUTH and SETNAME options are not supported.
+type Hello struct {
+	// Handler serves the requests other than HELLO.
+	Handler Handler
+}
+
+// ServeRESP serves HELLO and passes the other requests to the handler.
+func (h *Hello) ServeRESP(wr ResponseWriter, req *Request) {
+	if !strings.EqualFold(req.Command, "hello") {
+		h.Handler.ServeRESP(wr, req)
+		return
+	}
+
+	if len(req.Args) > 1 {
+		wr.Write(ErrorStr("ERR Syntax error in HELLO option '" + req.Args[1] + "'"))
+		return
+	}
+
+	if len(req.Args) == 1 {
+		proto, err := strconv.ParseInt(req.Args[0], 10, 64)
+		if err != nil {
+			wr.Write(ErrorStr("ERR Protocol version is not an integer or out of range"))
+			return
+		}
+		if proto != 2 && proto != 3 {
+			wr.Write(ErrorStr("NOPROTO unsupported protocol version"))
+			return
+		}
+		req.Client.SetProtocol(int(proto))
+	}
+
+	var id uint64
+	if req.Client != nil {
+		id = req.Client.ID
+	}
+
+	items := []Value{
+		&BulkStr{Value: []byte("server")}, &BulkStr{Value: []byte("redis")},
+		&BulkStr{Value: []byte("version")}, &BulkStr{Value: []byte(redisVersion)},
+		&BulkStr{Value: []byte("proto")}, Integer(req.Client.Protocol()),
+		&BulkStr{Value: []byte("id")}, Integer(id),
+		&BulkStr{Value: []byte("mode")}, &BulkStr{Value: []byte("standalone")},
+		&BulkStr{Value: []byte("role")}, &BulkStr{Value: []byte("master")},
+		&BulkStr{Value: []byte("modules")}, &Array{Items: []Value{}},
+	}
+
+	if req.Client.Protocol() >= 3 {
+		wr.Write(&Map{Items: items})
+		return
+	}
+	wr.Write(&Array{Items: items})
+}
@@ -0,0 +1,82 @@
+package radio_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+)
+
+func TestHello(t *testing.T) {
+	hello := &radio.Hello{
+		Handler: radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+			wr.Write(radio.Integer(req.Client.Protocol()))
+		}),
+	}
+
+	srv := radiotest.NewServer(hello)
+	defer srv.Close()
+
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	props := func(proto int) []radio.Value {
+		bulk := func(s string) radio.Value { return &radio.BulkStr{Value: []byte(s)} }
+		return []radio.Value{
+			bulk("server"), bulk("redis"),
+			bulk("version"), bulk("7.4.0"),
+			bulk("proto"), radio.Integer(proto),
+			bulk("id"), nil,
+			bulk("mode"), bulk("standalone"),
+			bulk("role"), bulk("master"),
+			bulk("modules"), &radio.Array{Items: []radio.Value{}},
+		}
+	}
+
+	steps := []struct {
+		args  []string
+		reply radio.Value
+	}{
+		{args: []string{"PROTO"}, reply: radio.Integer(2)},
+		{args: []string{"HELLO"}, reply: &radio.Array{Items: props(2)}},
+		{args: []string{"HELLO", "3"}, reply: &radio.Map{Items: props(3)}},
+		{args: []string{"PROTO"}, reply: radio.Integer(3)},
+		{args: []string{"hello"}, reply: &radio.Map{Items: props(3)}},
+		{args: []string{"HELLO", "4"}, reply: radio.ErrorStr("NOPROTO unsupported protocol version")},
+		{args: []string{"HELLO", "three"}, reply: radio.ErrorStr("ERR Protocol version is not an integer or out of range")},
+		{args: []string{"HELLO", "2", "SETNAME", "foo"}, reply: radio.ErrorStr("ERR Syntax error in HELLO option 'SETNAME'")},
+		{args: []string{"PROTO"}, reply: radio.Integer(3)},
+		{args: []string{"HELLO", "2"}, reply: &radio.Array{Items: props(2)}},
+		{args: []string{"PROTO"}, reply: radio.Integer(2)},
+	}
+
+	for _, st := range steps {
+		v, err := conn.Do(st.args[0], st.args[1:]...)
+		if err != nil {
+			t.Fatalf("%v: not expecting error, got '%v'", st.args, err)
+		}
+
+		// the client ID is only checked to be an integer.
+		var items []radio.Value
+		switch reply := v.(type) {
+		case *radio.Array:
+			items = reply.Items
+		case *radio.Map:
+			items = reply.Items
+		}
+		if len(items) == 14 {
+			if _, ok := items[7].(radio.Integer); !ok {
+				t.Errorf("%v: expecting the client ID, got '%v'", st.args, items[7])
+			}
+			items[7] = nil
+		}
+
+		if !reflect.DeepEqual(st.reply, v) {
+			t.Errorf("%v: expecting '%#v', got '%#v'", st.args, st.reply, v)
+		}
+	}
+}
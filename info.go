@@ -0,0 +1,123 @@
+package radio
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// InfoSection writes the fields of a section of the INFO reply.
+type InfoSection func(w *InfoWriter)
+
+// InfoWriter collects the fields of a section in the "key:value" format of
+// the INFO reply.
+type InfoWriter struct {
+	sb *strings.Builder
+}
+
+// Field writes a field with the value. Values of fields with multiple
+// properties are written as comma separated "name=value" pairs (e.g.,
+// "keys=1,expires=0").
+func (w *InfoWriter) Field(key, val string) {
+	w.sb.WriteString(key)
+	w.sb.WriteByte(':')
+	w.sb.WriteString(val)
+	w.sb.WriteString("\r\n")
+}
+
+// Fieldf writes a field with the value formatted using fmt.Sprintf.
+func (w *InfoWriter) Fieldf(key, format string, args ...interface{}) {
+	w.Field(key, fmt.Sprintf(format, args...))
+}
+
+// Info builds the reply of the INFO command from the sections registered by
+// the server (see Server.RegisterInfo) and the applications. Info is a
+// handler that serves INFO and passes all the other requests to the wrapped
+// handler. Zero value of Info has no sections.
+type Info struct {
+	// Handler serves the requests other than INFO.
+	Handler Handler
+
+	mu       sync.Mutex
+	sections []*infoSection
+}
+
+type infoSection struct {
+	name  string
+	title string
+	def   bool
+	fn    InfoSection
+}
+
+// Register adds a section. Sections are written in the order they are
+// registered. Default sections are included when INFO is invoked without
+// arguments while the others (e.g., commandstats) are only included when
+// requested by name or using "all" or "everything". Register panics if the
+// name is already registered.
+func (inf *Info) Register(name string, isDefault bool, fn InfoSection) {
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+
+	name = strings.ToLower(name)
+	for _, sec := range inf.sections {
+		if sec.name == name {
+			panic("radio: info section '" + name + "' registered twice")
+		}
+	}
+
+	inf.sections = append(inf.sections, &infoSection{
+		name:  name,
+		title: strings.ToUpper(name[:1]) + name[1:],
+		def:   isDefault,
+		fn:    fn,
+	})
+}
+
+// Build returns the INFO reply for the names of the sections, which are
+// matched case insensitively. "default" selects the default sections and
+// "all" and "everything" select all the sections. The default sections are
+// returned if no names are given. Unknown names are ignored.
+func (inf *Info) Build(names ...string) string {
+	inf.mu.Lock()
+	sections := inf.sections
+	inf.mu.Unlock()
+
+	all, def := false, len(names) == 0
+	selected := map[string]bool{}
+	for _, name := range names {
+		switch name = strings.ToLower(name); name {
+		case "all", "everything":
+			all = true
+		case "default":
+			def = true
+		default:
+			selected[name] = true
+		}
+	}
+
+	var sb strings.Builder
+	w := &InfoWriter{sb: &sb}
+	for _, sec := range sections {
+		if !all && !(def && sec.def) && !selected[sec.name] {
+			continue
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + sec.title + "\r\n")
+		sec.fn(w)
+	}
+	return sb.String()
+}
+
+// ServeRESP serves the INFO command and passes the other requests to the
+// wrapped handler.
+func (inf *Info) ServeRESP(wr ResponseWriter, req *Request) {
+	if !strings.EqualFold(req.Command, "info") {
+		inf.Handler.ServeRESP(wr, req)
+		return
+	}
+
+	wr.Write(&BulkStr{Value: []byte(inf.Build(req.Args...))})
+}
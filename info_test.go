@@ -0,0 +1,284 @@
+package radio_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+)
+
+func newInfo() *radio.Info {
+	info := &radio.Info{
+		Handler: radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+			wr.Write(radio.SimpleStr("PASSED"))
+		}),
+	}
+
+	info.Register("server", true, func(w *radio.InfoWriter) {
+		w.Field("redis_version", "7.4.0")
+		w.Field("tcp_port", "6379")
+	})
+	info.Register("commandstats", false, func(w *radio.InfoWriter) {
+		w.Fieldf("cmdstat_get", "calls=%d,usec=%d,usec_per_call=%.2f", 3, 4, 4.0/3)
+	})
+	info.Register("Keyspace", true, func(w *radio.InfoWriter) {})
+	return info
+}
+
+func TestInfo_Build(suite *testing.T) {
+	suite.Parallel()
+
+	const (
+		server       = "# Server\r\nredis_version:7.4.0\r\ntcp_port:6379\r\n"
+		commandstats = "# Commandstats\r\ncmdstat_get:calls=3,usec=4,usec_per_call=1.33\r\n"
+		keyspace     = "# Keyspace\r\n"
+	)
+
+	cases := []struct {
+		title    string
+		names    []string
+		expected string
+	}{
+		{
+			title:    "Default",
+			expected: server + "\r\n" + keyspace,
+		},
+		{
+			title:    "DefaultKeyword",
+			names:    []string{"default"},
+			expected: server + "\r\n" + keyspace,
+		},
+		{
+			title:    "All",
+			names:    []string{"all"},
+			expected: server + "\r\n" + commandstats + "\r\n" + keyspace,
+		},
+		{
+			title:    "Everything",
+			names:    []string{"EVERYTHING"},
+			expected: server + "\r\n" + commandstats + "\r\n" + keyspace,
+		},
+		{
+			title:    "Section",
+			names:    []string{"CommandStats"},
+			expected: commandstats,
+		},
+		{
+			title:    "MultipleSections",
+			names:    []string{"keyspace", "server", "keyspace"},
+			expected: server + "\r\n" + keyspace,
+		},
+		{
+			title:    "DefaultAndSection",
+			names:    []string{"default", "commandstats"},
+			expected: server + "\r\n" + commandstats + "\r\n" + keyspace,
+		},
+		{
+			title:    "Unknown",
+			names:    []string{"foo"},
+			expected: "",
+		},
+	}
+
+	info := newInfo()
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			if got := info.Build(cs.names...); got != cs.expected {
+				t.Errorf("expecting '%q', got '%q'", cs.expected, got)
+			}
+		})
+	}
+}
+
+func TestInfo_Register(t *testing.T) {
+	defer func() {
+		if v := recover(); v == nil {
+			t.Errorf("expecting panic for duplicate section")
+		}
+	}()
+
+	newInfo().Register("SERVER", true, func(w *radio.InfoWriter) {})
+}
+
+func TestInfo_Handler(t *testing.T) {
+	info := newInfo()
+
+	rec := radiotest.NewRecorder()
+	info.ServeRESP(rec, &radio.Request{Command: "INFO", Args: []string{"server"}})
+	info.ServeRESP(rec, &radio.Request{Command: "GET", Args: []string{"k"}})
+
+	expected := []radio.Value{
+		&radio.BulkStr{Value: []byte("# Server\r\nredis_version:7.4.0\r\ntcp_port:6379\r\n")},
+		radio.SimpleStr("PASSED"),
+	}
+	if vals := rec.Values(); !reflect.DeepEqual(expected, vals) {
+		t.Errorf("expecting '%#v', got '%#v'", expected, vals)
+	}
+}
+
+func TestServer_Info(t *testing.T) {
+	info := &radio.Info{
+		Handler: radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+			switch strings.ToLower(req.Command) {
+			case "get":
+				wr.Write(radio.SimpleStr("OK"))
+			case "set":
+				wr.Write(radio.ErrorStr("WRONGTYPE Operation against a key holding the wrong kind of value"))
+			default:
+				wr.Write(radio.ErrorStr("ERR unknown command '" + req.Command + "'"))
+			}
+		}),
+	}
+
+	srv := &radio.Server{Handler: info}
+	srv.RegisterInfo(info)
+
+	conn, stop := startServer(t, srv)
+	defer stop()
+
+	for _, cmd := range []string{"GET", "get", "SET", "FOO"} {
+		if _, err := conn.Do(cmd, "k"); err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+	}
+
+	// like Redis, the statistics of a command are updated after it is
+	// executed and unknown commands are not counted as processed.
+	fields := infoFields(t, conn, "everything")
+	expected := map[string]string{
+		"redis_mode":                 "standalone",
+		"connected_clients":          "1",
+		"blocked_clients":            "0",
+		"total_connections_received": "1",
+		"total_commands_processed":   "3",
+		"total_error_replies":        "2",
+		"errorstat_ERR":              "count=1",
+		"errorstat_WRONGTYPE":        "count=1",
+	}
+	for key, val := range expected {
+		if fields[key] != val {
+			t.Errorf("expecting %s to be '%s', got '%s'", key, val, fields[key])
+		}
+	}
+
+	if !strings.HasPrefix(fields["cmdstat_get"], "calls=2,") {
+		t.Errorf("expecting 2 calls of get, got '%s'", fields["cmdstat_get"])
+	}
+	if !strings.HasPrefix(fields["cmdstat_set"], "calls=1,") {
+		t.Errorf("expecting 1 call of set, got '%s'", fields["cmdstat_set"])
+	}
+	if _, found := fields["cmdstat_foo"]; found {
+		t.Errorf("not expecting statistics of unknown command")
+	}
+	if fields["tcp_port"] == "0" || fields["uptime_in_seconds"] == "" {
+		t.Errorf("expecting server fields, got '%v'", fields)
+	}
+}
+
+// infoFields returns the fields of the INFO reply for the sections.
+func infoFields(t *testing.T, conn *radio.Conn, sections ...string) map[string]string {
+	v, err := conn.Do("INFO", sections...)
+	if err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	bs, ok := v.(*radio.BulkStr)
+	if !ok {
+		t.Fatalf("expecting bulk string, got '%#v'", v)
+	}
+
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(bs.Value), "\r\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			t.Fatalf("malformed line '%s'", line)
+		}
+		fields[kv[0]] = kv[1]
+	}
+	return fields
+}
+
+func TestServer_InfoEventLoop(t *testing.T) {
+	lh := newListHandler()
+	info := &radio.Info{Handler: lh.blocker}
+	srv := &radio.Server{Handler: info}
+	srv.RegisterInfo(info)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.ServeEventLoop(ctx, l, radio.EventLoopOptions{Loops: 2})
+
+	dial := func() *radio.Conn {
+		conn, err := radio.Dial(l.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		return conn
+	}
+
+	blocked, conn := dial(), dial()
+	defer conn.Close()
+
+	blocked.Send("BLPOP", "list", "0")
+	blocked.Flush()
+	lh.waitBlocked(t, "list", 1)
+	if _, err := conn.Do("PING"); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	waitInfoFields(t, conn, map[string]string{
+		"connected_clients":          "2",
+		"blocked_clients":            "1",
+		"total_connections_received": "2",
+	})
+
+	blocked.Close()
+	waitInfoFields(t, conn, map[string]string{
+		"connected_clients": "1",
+		"blocked_clients":   "0",
+	})
+
+	fields := infoFields(t, conn, "latencystats")
+	if !regexp.MustCompile(`^p50=\d+\.\d{3},p99=\d+\.\d{3},p99\.9=\d+\.\d{3}$`).MatchString(fields["latency_percentiles_usec_ping"]) {
+		t.Errorf("expecting latency of ping, got '%v'", fields)
+	}
+}
+
+// waitInfoFields waits for the fields of the default INFO sections to have
+// the expected values.
+func waitInfoFields(t *testing.T, conn *radio.Conn, expected map[string]string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fields := infoFields(t, conn)
+
+		mismatch := ""
+		for key, val := range expected {
+			if fields[key] != val {
+				mismatch = key + " is '" + fields[key] + "', expecting '" + val + "'"
+			}
+		}
+		if mismatch == "" {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for INFO: %s", mismatch)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
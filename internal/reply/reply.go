@@ -0,0 +1,72 @@
+// Package reply provides helpers for recording and comparing the replies
+// written by handlers, shared by the packages that capture, mirror or
+// execute requests outside of a connection.
+package reply
+
+import (
+	"sync"
+
+	"github.com/spy16/radio"
+)
+
+// Recorder is a radio.ResponseWriter that records the values written for a
+// request and forwards them to Next if set. Push values are out-of-band
+// (e.g., invalidation messages written using the writer of an earlier
+// request) and are forwarded without being recorded. Recorder is safe for
+// concurrent use.
+type Recorder struct {
+	// Next, if set, receives all the values written to the recorder.
+	Next radio.ResponseWriter
+
+	mu      sync.Mutex
+	vals    []radio.Value
+	stopped bool
+}
+
+// Write records the value and forwards it to Next.
+func (rec *Recorder) Write(v radio.Value) (int, error) {
+	if _, push := v.(*radio.Push); !push {
+		rec.mu.Lock()
+		if !rec.stopped {
+			rec.vals = append(rec.vals, v)
+		}
+		rec.mu.Unlock()
+	}
+
+	if rec.Next == nil {
+		return 0, nil
+	}
+	return rec.Next.Write(v)
+}
+
+// Values returns the values recorded so far.
+func (rec *Recorder) Values() []radio.Value {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]radio.Value(nil), rec.vals...)
+}
+
+// Stop stops recording and returns the recorded values. Values written
+// afterwards are only forwarded to Next.
+func (rec *Recorder) Stop() []radio.Value {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.stopped = true
+	return rec.vals
+}
+
+// Equal returns true if both the lists of values have the same RESP
+// representation.
+func Equal(a, b []radio.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Serialize() != b[i].Serialize() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package reply_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/internal/reply"
+	"github.com/spy16/radio/radiotest"
+)
+
+func TestRecorder(t *testing.T) {
+	next := radiotest.NewRecorder()
+	rec := &reply.Recorder{Next: next}
+
+	push := &radio.Push{Items: []radio.Value{radio.SimpleStr("invalidate")}}
+	rec.Write(radio.SimpleStr("OK"))
+	rec.Write(push)
+	rec.Write(radio.Integer(1))
+
+	expected := []radio.Value{radio.SimpleStr("OK"), radio.Integer(1)}
+	if vals := rec.Stop(); !reflect.DeepEqual(expected, vals) {
+		t.Errorf("expecting '%#v', got '%#v'", expected, vals)
+	}
+
+	rec.Write(radio.Integer(2))
+	if vals := rec.Values(); !reflect.DeepEqual(expected, vals) {
+		t.Errorf("expecting values written after Stop to be ignored, got '%#v'", vals)
+	}
+
+	forwarded := []radio.Value{radio.SimpleStr("OK"), push, radio.Integer(1), radio.Integer(2)}
+	if vals := next.Values(); !reflect.DeepEqual(forwarded, vals) {
+		t.Errorf("expecting '%#v' to be forwarded, got '%#v'", forwarded, vals)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a := []radio.Value{radio.SimpleStr("OK"), &radio.BulkStr{Value: []byte("v")}}
+	b := []radio.Value{radio.SimpleStr("OK"), &radio.BulkStr{Value: []byte("v")}}
+	if !reply.Equal(a, b) {
+		t.Errorf("expecting '%v' and '%v' to be equal", a, b)
+	}
+	if reply.Equal(a, b[:1]) || reply.Equal(a, []radio.Value{radio.SimpleStr("OK"), radio.SimpleStr("v")}) {
+		t.Errorf("expecting different replies not to be equal")
+	}
+}
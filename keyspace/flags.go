@@ -0,0 +1,113 @@
+package keyspace
+
+import "fmt"
+
+// Flags selects the classes of keyspace events that are published. Flags
+// can be parsed from and formatted to the notify-keyspace-events flag
+// strings used by Redis.
+type Flags uint32
+
+// Event classes and the channels the events are published on. An event is
+// published only if its class is enabled and at least one of Keyspace and
+// Keyevent is enabled.
+const (
+	Keyspace Flags = 1 << iota // K: publish on __keyspace@<db>__:<key>
+	Keyevent                   // E: publish on __keyevent@<db>__:<event>
+	Generic                    // g: generic commands (e.g., DEL, EXPIRE, RENAME)
+	String                     // $: string commands
+	List                       // l: list commands
+	Set                        // s: set commands
+	Hash                       // h: hash commands
+	ZSet                       // z: sorted set commands
+	Expired                    // x: keys expiring
+	Evicted                    // e: keys evicted for maxmemory
+	Stream                     // t: stream commands
+	Module                     // d: module key type events
+	KeyMiss                    // m: key misses (not included in All)
+	New                        // n: new keys (not included in All)
+
+	// All is the A alias for g$lshzxetd.
+	All = Generic | String | List | Set | Hash | ZSet | Expired | Evicted | Stream | Module
+)
+
+var classChars = []struct {
+	flag Flags
+	char byte
+}{
+	{flag: Generic, char: 'g'},
+	{flag: String, char: '$'},
+	{flag: List, char: 'l'},
+	{flag: Set, char: 's'},
+	{flag: Hash, char: 'h'},
+	{flag: ZSet, char: 'z'},
+	{flag: Expired, char: 'x'},
+	{flag: Evicted, char: 'e'},
+	{flag: Stream, char: 't'},
+	{flag: Module, char: 'd'},
+}
+
+// ParseFlags parses a notify-keyspace-events flag string (e.g., "KEA" or
+// "Kx"). An empty string disables the notifications.
+func ParseFlags(s string) (Flags, error) {
+	var flags Flags
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 'A':
+			flags |= All
+		case 'K':
+			flags |= Keyspace
+		case 'E':
+			flags |= Keyevent
+		case 'm':
+			flags |= KeyMiss
+		case 'n':
+			flags |= New
+
+		default:
+			found := false
+			for _, cc := range classChars {
+				if cc.char == c {
+					flags |= cc.flag
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return 0, fmt.Errorf("keyspace: invalid event class character '%c'", c)
+			}
+		}
+	}
+
+	return flags, nil
+}
+
+// String returns the flags in the same canonical form as reported by Redis
+// for CONFIG GET notify-keyspace-events.
+func (f Flags) String() string {
+	var s []byte
+	if f&All == All {
+		s = append(s, 'A')
+	} else {
+		for _, cc := range classChars {
+			if f&cc.flag != 0 {
+				s = append(s, cc.char)
+			}
+		}
+	}
+
+	if f&Keyspace != 0 {
+		s = append(s, 'K')
+	}
+	if f&Keyevent != 0 {
+		s = append(s, 'E')
+	}
+	if f&KeyMiss != 0 {
+		s = append(s, 'm')
+	}
+	if f&New != 0 {
+		s = append(s, 'n')
+	}
+
+	return string(s)
+}
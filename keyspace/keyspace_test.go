@@ -0,0 +1,278 @@
+package keyspace_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+	"github.com/spy16/radio/radiotest"
+)
+
+func TestParseFlags(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		flags    string
+		expected keyspace.Flags
+		str      string
+		err      bool
+	}{
+		{flags: "", expected: 0, str: ""},
+		{flags: "KEA", expected: keyspace.Keyspace | keyspace.Keyevent | keyspace.All, str: "AKE"},
+		{flags: "Ex", expected: keyspace.Keyevent | keyspace.Expired, str: "xE"},
+		{flags: "$gK", expected: keyspace.Keyspace | keyspace.Generic | keyspace.String, str: "g$K"},
+		{flags: "g$lshzxetdKEmn", expected: keyspace.All | keyspace.Keyspace | keyspace.Keyevent | keyspace.KeyMiss | keyspace.New, str: "AKEmn"},
+		{flags: "Kq", err: true},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.flags, func(t *testing.T) {
+			flags, err := keyspace.ParseFlags(cs.flags)
+			if cs.err {
+				if err == nil {
+					t.Errorf("expecting error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expecting no error, got '%v'", err)
+			}
+
+			if flags != cs.expected {
+				t.Errorf("expecting flags %b, got %b", cs.expected, flags)
+			}
+
+			if s := flags.String(); s != cs.str {
+				t.Errorf("expecting '%s', got '%s'", cs.str, s)
+			}
+		})
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	n := &keyspace.Notifier{}
+	n.Handler = newStringsHandler(n)
+
+	srv := radiotest.NewServer(n)
+	defer srv.Close()
+
+	subscriber := dial(t, srv)
+	defer subscriber.Close()
+	client := dial(t, srv)
+	defer client.Close()
+
+	expect(t, client, radio.SimpleStr("OK"), "CONFIG", "SET", "notify-keyspace-events", "KE$")
+	expect(t, client, bulkArray("notify-keyspace-events", "$KE"), "CONFIG", "GET", "notify-keyspace-events")
+
+	subscriber.Send("SUBSCRIBE", "__keyspace@0__:foo")
+	subscriber.Send("PSUBSCRIBE", "__keyevent@0__:*")
+	subscriber.Flush()
+	receive(t, subscriber, subscription("subscribe", "__keyspace@0__:foo", 1))
+	receive(t, subscriber, subscription("psubscribe", "__keyevent@0__:*", 2))
+
+	expect(t, client, radio.SimpleStr("OK"), "SET", "foo", "1")
+	receive(t, subscriber, bulkArray("message", "__keyspace@0__:foo", "set"))
+	receive(t, subscriber, bulkArray("pmessage", "__keyevent@0__:*", "__keyevent@0__:set", "foo"))
+
+	// only the key event is subscribed for bar.
+	expect(t, client, radio.SimpleStr("OK"), "SET", "bar", "1")
+	receive(t, subscriber, bulkArray("pmessage", "__keyevent@0__:*", "__keyevent@0__:set", "bar"))
+
+	// generic events are not enabled.
+	expect(t, client, radio.Integer(1), "DEL", "foo")
+	expect(t, subscriber, bulkArray("pong", ""), "PING")
+
+	expect(t, subscriber, radio.ErrorStr("ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"), "GET", "foo")
+
+	subscriber.Send("UNSUBSCRIBE")
+	subscriber.Send("PUNSUBSCRIBE")
+	subscriber.Flush()
+	receive(t, subscriber, subscription("unsubscribe", "__keyspace@0__:foo", 1))
+	receive(t, subscriber, subscription("punsubscribe", "__keyevent@0__:*", 0))
+
+	expect(t, client, radio.SimpleStr("OK"), "SET", "foo", "2")
+	expect(t, subscriber, &radio.BulkStr{Value: []byte("2")}, "GET", "foo")
+
+	expect(t, client, radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - Invalid event class character. Use 'Ag$lshzxeKEtmdn'."),
+		"CONFIG", "SET", "notify-keyspace-events", "KEq")
+	if flags := n.Flags(); flags != keyspace.Keyspace|keyspace.Keyevent|keyspace.String {
+		t.Errorf("expecting flags to be unchanged after invalid CONFIG SET, got '%s'", flags)
+	}
+}
+
+func TestNotifier_Disconnect(t *testing.T) {
+	n := &keyspace.Notifier{}
+	n.Handler = newStringsHandler(n)
+	n.SetFlags(keyspace.Keyspace | keyspace.All)
+
+	srv := radiotest.NewServer(n)
+	defer srv.Close()
+
+	subscriber := dial(t, srv)
+	subscriber.Send("SUBSCRIBE", "__keyspace@0__:foo")
+	subscriber.Send("PSUBSCRIBE", "*")
+	subscriber.Flush()
+	receive(t, subscriber, subscription("subscribe", "__keyspace@0__:foo", 1))
+	receive(t, subscriber, subscription("psubscribe", "*", 2))
+
+	client := dial(t, srv)
+	defer client.Close()
+
+	expect(t, client, bulkArray("__keyspace@0__:foo"), "PUBSUB", "CHANNELS")
+	expect(t, client, &radio.Array{Items: []radio.Value{&radio.BulkStr{Value: []byte("__keyspace@0__:foo")}, radio.Integer(1)}},
+		"PUBSUB", "NUMSUB", "__keyspace@0__:foo")
+	expect(t, client, radio.Integer(1), "PUBSUB", "NUMPAT")
+
+	subscriber.Close()
+
+	// the subscriptions are removed once the server notices the disconnect.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		v, err := client.Do("PUBSUB", "NUMPAT")
+		if err != nil {
+			t.Fatalf("expecting no error, got '%v'", err)
+		}
+
+		if v == radio.Integer(0) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting subscriptions to be removed after disconnect, got %v patterns", v)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	expect(t, client, bulkArray(), "PUBSUB", "CHANNELS")
+}
+
+func TestNotifier_Publish(t *testing.T) {
+	var published [][2]string
+	n := &keyspace.Notifier{
+		Publish: func(channel, msg string) {
+			published = append(published, [2]string{channel, msg})
+		},
+	}
+	n.Handler = newStringsHandler(n)
+	n.SetFlags(keyspace.Keyspace | keyspace.Keyevent | keyspace.Generic)
+
+	n.Notify(keyspace.Generic, "del", "foo", 3)
+	n.Notify(keyspace.String, "set", "foo", 3)
+
+	expected := [][2]string{
+		{"__keyspace@3__:foo", "del"},
+		{"__keyevent@3__:del", "foo"},
+	}
+	if !reflect.DeepEqual(expected, published) {
+		t.Errorf("expecting %v, got %v", expected, published)
+	}
+
+	rec := radiotest.NewRecorder()
+	n.ServeRESP(rec, &radio.Request{Command: "SUBSCRIBE", Args: []string{"a"}})
+	if last := rec.Last(); last != radio.ErrorStr("ERR unknown command 'SUBSCRIBE'") {
+		t.Errorf("expecting SUBSCRIBE to be passed to the handler, got '%v'", last)
+	}
+}
+
+func TestNotifier_ConfigParam(t *testing.T) {
+	n := &keyspace.Notifier{}
+	cfg := &radio.Config{Handler: n}
+	cfg.Register(n.ConfigParam())
+
+	if err := cfg.Set("notify-keyspace-events", "KEA"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := cfg.Get("notify-keyspace-events"); v != "AKE" {
+		t.Errorf("expecting 'AKE', got '%s'", v)
+	}
+	if flags := n.Flags(); flags != keyspace.Keyspace|keyspace.Keyevent|keyspace.All {
+		t.Errorf("expecting all the events, got '%s'", flags)
+	}
+
+	err := cfg.Set("notify-keyspace-events", "KEq")
+	if err == nil || err.Error() != "'notify-keyspace-events': Invalid event class character. Use 'Ag$lshzxeKEtmdn'." {
+		t.Errorf("expecting invalid flags error, got %v", err)
+	}
+}
+
+// stringsHandler implements GET, SET and DEL and notifies the events.
+type stringsHandler struct {
+	n *keyspace.Notifier
+
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func newStringsHandler(n *keyspace.Notifier) *stringsHandler {
+	return &stringsHandler{n: n, data: map[string]string{}}
+}
+
+func (sh *stringsHandler) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	switch req.Command {
+	case "GET":
+		wr.Write(&radio.BulkStr{Value: []byte(sh.data[req.Args[0]])})
+
+	case "SET":
+		sh.data[req.Args[0]] = req.Args[1]
+		sh.n.Notify(keyspace.String, "set", req.Args[0], 0)
+		wr.Write(radio.SimpleStr("OK"))
+
+	case "DEL":
+		delete(sh.data, req.Args[0])
+		sh.n.Notify(keyspace.Generic, "del", req.Args[0], 0)
+		wr.Write(radio.Integer(1))
+
+	default:
+		wr.Write(radio.ErrorStr("ERR unknown command '" + req.Command + "'"))
+	}
+}
+
+func dial(t *testing.T, srv *radiotest.Server) *radio.Conn {
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	return conn
+}
+
+func expect(t *testing.T, conn *radio.Conn, expected radio.Value, cmd string, args ...string) {
+	t.Helper()
+
+	v, err := conn.Do(cmd, args...)
+	if err != nil || !reflect.DeepEqual(expected, v) {
+		t.Errorf("%s: expecting '%v', got '%v' (err=%v)", cmd, expected, v, err)
+	}
+}
+
+func receive(t *testing.T, conn *radio.Conn, expected radio.Value) {
+	t.Helper()
+
+	v, err := conn.Receive()
+	if err != nil || !reflect.DeepEqual(expected, v) {
+		t.Errorf("expecting '%v', got '%v' (err=%v)", expected, v, err)
+	}
+}
+
+func subscription(kind, name string, count int) *radio.Array {
+	return &radio.Array{Items: []radio.Value{
+		&radio.BulkStr{Value: []byte(kind)},
+		&radio.BulkStr{Value: []byte(name)},
+		radio.Integer(count),
+	}}
+}
+
+func bulkArray(items ...string) *radio.Array {
+	arr := &radio.Array{Items: []radio.Value{}}
+	for _, itm := range items {
+		arr.Items = append(arr.Items, &radio.BulkStr{Value: []byte(itm)})
+	}
+	return arr
+}
@@ -0,0 +1,383 @@
+// Package keyspace provides Redis compatible keyspace notifications. Handlers
+// report the events using Notifier.Notify and the events are published on the
+// __keyspace@<db>__:<key> and __keyevent@<db>__:<event> channels to the
+// clients subscribed using SUBSCRIBE or PSUBSCRIBE.
+package keyspace
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spy16/radio"
+)
+
+// ConfigName is the name of the configuration parameter holding the flags.
+const ConfigName = "notify-keyspace-events"
+
+// Notifier publishes keyspace events to the subscribed clients. Notifier is
+// a handler that serves the subscription commands (SUBSCRIBE, UNSUBSCRIBE,
+// PSUBSCRIBE, PUNSUBSCRIBE and PUBSUB), CONFIG SET and CONFIG GET of
+// notify-keyspace-events and passes all the other requests to the wrapped
+// handler. Zero value of Notifier has the notifications disabled.
+type Notifier struct {
+	// Handler serves the requests not served by the notifier.
+	Handler radio.Handler
+
+	// Publish, if set, is used to deliver the events instead of the built-in
+	// subscriptions (e.g., to publish them using a full pub/sub broker). The
+	// subscription commands are then passed to Handler.
+	Publish func(channel, message string)
+
+	flags uint32
+
+	mu       sync.RWMutex
+	channels map[string]map[*subscriber]struct{}
+	patterns map[string]map[*subscriber]struct{}
+}
+
+type subscriberKey struct{}
+
+// subscriber holds the subscriptions of a client. Messages are written to
+// the writer of the request that created the first subscription.
+type subscriber struct {
+	wr       radio.ResponseWriter
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// Flags returns the enabled event classes.
+func (n *Notifier) Flags() Flags {
+	return Flags(atomic.LoadUint32(&n.flags))
+}
+
+// SetFlags sets the enabled event classes.
+func (n *Notifier) SetFlags(flags Flags) {
+	atomic.StoreUint32(&n.flags, uint32(flags))
+}
+
+// Notify publishes the event of the class on the key in the database if the
+// class is enabled. Event is the name of the event (e.g., "set", "del" or
+// "expired").
+func (n *Notifier) Notify(class Flags, event, key string, db int) {
+	flags := n.Flags()
+	if flags&class == 0 {
+		return
+	}
+
+	prefix := "@" + strconv.Itoa(db) + "__:"
+	if flags&Keyspace != 0 {
+		n.publish("__keyspace"+prefix+key, event)
+	}
+	if flags&Keyevent != 0 {
+		n.publish("__keyevent"+prefix+event, key)
+	}
+}
+
+// ServeRESP serves the subscription and configuration commands and passes
+// the other requests to the wrapped handler. Clients with subscriptions can
+// only use the subscription commands, PING, QUIT and RESET.
+func (n *Notifier) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	cmd := strings.ToLower(req.Command)
+	switch cmd {
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+		if n.Publish == nil {
+			n.serveSubscription(wr, req, cmd)
+			return
+		}
+
+	case "pubsub":
+		if n.Publish == nil {
+			n.servePubSub(wr, req)
+			return
+		}
+
+	case "config":
+		if n.serveConfig(wr, req) {
+			return
+		}
+	}
+
+	if n.subscriptions(req.Client) > 0 {
+		switch cmd {
+		case "ping":
+			msg := ""
+			if len(req.Args) > 0 {
+				msg = req.Args[0]
+			}
+			wr.Write(message("pong", msg))
+			return
+
+		case "quit":
+
+		case "reset":
+			n.unsubscribeAll(req.Client)
+
+		default:
+			wr.Write(radio.ErrorStr("ERR Can't execute '" + cmd + "': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"))
+			return
+		}
+	}
+
+	n.Handler.ServeRESP(wr, req)
+}
+
+func (n *Notifier) serveSubscription(wr radio.ResponseWriter, req *radio.Request, cmd string) {
+	if req.Client == nil {
+		wr.Write(radio.ErrorStr("ERR " + cmd + " requires a client connection"))
+		return
+	}
+
+	subscribe := cmd == "subscribe" || cmd == "psubscribe"
+	pattern := cmd == "psubscribe" || cmd == "punsubscribe"
+	if subscribe && len(req.Args) == 0 {
+		wr.Write(radio.ErrorStr("ERR wrong number of arguments for '" + cmd + "' command"))
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub := n.subscriber(req.Client, wr)
+	subs, index := sub.channels, &n.channels
+	if pattern {
+		subs, index = sub.patterns, &n.patterns
+	}
+
+	names := req.Args
+	if !subscribe && len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+
+		if len(names) == 0 {
+			wr.Write(&radio.Array{Items: []radio.Value{
+				&radio.BulkStr{Value: []byte(cmd)},
+				&radio.BulkStr{},
+				radio.Integer(len(sub.channels) + len(sub.patterns)),
+			}})
+			return
+		}
+	}
+
+	for _, name := range names {
+		if subscribe {
+			subs[name] = struct{}{}
+			if *index == nil {
+				*index = map[string]map[*subscriber]struct{}{}
+			}
+			if (*index)[name] == nil {
+				(*index)[name] = map[*subscriber]struct{}{}
+			}
+			(*index)[name][sub] = struct{}{}
+		} else {
+			delete(subs, name)
+			delete((*index)[name], sub)
+			if len((*index)[name]) == 0 {
+				delete(*index, name)
+			}
+		}
+
+		wr.Write(&radio.Array{Items: []radio.Value{
+			&radio.BulkStr{Value: []byte(cmd)},
+			&radio.BulkStr{Value: []byte(name)},
+			radio.Integer(len(sub.channels) + len(sub.patterns)),
+		}})
+	}
+}
+
+// servePubSub serves PUBSUB CHANNELS, NUMSUB and NUMPAT.
+func (n *Notifier) servePubSub(wr radio.ResponseWriter, req *radio.Request) {
+	if len(req.Args) == 0 {
+		wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'pubsub' command"))
+		return
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	switch sub := strings.ToLower(req.Args[0]); {
+	case sub == "channels" && len(req.Args) <= 2:
+		var channels []string
+		for channel := range n.channels {
+			if len(req.Args) == 1 || radio.MatchPattern(req.Args[1], channel) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		wr.Write(message(channels...))
+
+	case sub == "numsub":
+		arr := &radio.Array{Items: []radio.Value{}}
+		for _, channel := range req.Args[1:] {
+			arr.Items = append(arr.Items,
+				&radio.BulkStr{Value: []byte(channel)},
+				radio.Integer(len(n.channels[channel])),
+			)
+		}
+		wr.Write(arr)
+
+	case sub == "numpat" && len(req.Args) == 1:
+		wr.Write(radio.Integer(len(n.patterns)))
+
+	default:
+		wr.Write(radio.ErrorStr("ERR unknown subcommand or wrong number of arguments for '" + req.Args[0] + "'. Try PUBSUB HELP."))
+	}
+}
+
+// serveConfig serves CONFIG SET and CONFIG GET of notify-keyspace-events
+// and returns false for all the other CONFIG requests.
+func (n *Notifier) serveConfig(wr radio.ResponseWriter, req *radio.Request) bool {
+	if len(req.Args) < 2 || !strings.EqualFold(req.Args[1], ConfigName) {
+		return false
+	}
+
+	switch {
+	case strings.EqualFold(req.Args[0], "set") && len(req.Args) == 3:
+		flags, err := ParseFlags(req.Args[2])
+		if err != nil {
+			wr.Write(radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument '" + ConfigName + "') - Invalid event class character. Use 'Ag$lshzxeKEtmdn'."))
+			return true
+		}
+
+		n.SetFlags(flags)
+		wr.Write(radio.SimpleStr("OK"))
+		return true
+
+	case strings.EqualFold(req.Args[0], "get") && len(req.Args) == 2:
+		wr.Write(message(ConfigName, n.Flags().String()))
+		return true
+	}
+
+	return false
+}
+
+// ConfigParam returns the notify-keyspace-events parameter of the notifier
+// to register with a radio.Config serving CONFIG in front of the notifier.
+func (n *Notifier) ConfigParam() radio.ConfigParam {
+	return radio.ConfigParam{Name: ConfigName, Value: flagsConfig{n: n}}
+}
+
+// flagsConfig is the value of the notify-keyspace-events parameter.
+type flagsConfig struct {
+	n *Notifier
+}
+
+func (fc flagsConfig) String() string {
+	return fc.n.Flags().String()
+}
+
+func (fc flagsConfig) Set(s string) error {
+	flags, err := ParseFlags(s)
+	if err != nil {
+		return errors.New("Invalid event class character. Use 'Ag$lshzxeKEtmdn'.")
+	}
+
+	fc.n.SetFlags(flags)
+	return nil
+}
+
+// subscriber returns the subscriber of the client creating it if required.
+// The subscriptions are removed when the server closes the connection of
+// the client. n.mu must be held.
+func (n *Notifier) subscriber(cl *radio.Client, wr radio.ResponseWriter) *subscriber {
+	if sub, ok := cl.Value(subscriberKey{}).(*subscriber); ok {
+		return sub
+	}
+
+	sub := &subscriber{
+		wr:       wr,
+		channels: map[string]struct{}{},
+		patterns: map[string]struct{}{},
+	}
+	cl.SetValue(subscriberKey{}, sub)
+
+	cl.OnClose(func() {
+		n.unsubscribeAll(cl)
+	})
+
+	return sub
+}
+
+func (n *Notifier) subscriptions(cl *radio.Client) int {
+	sub, ok := cl.Value(subscriberKey{}).(*subscriber)
+	if !ok {
+		return 0
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return len(sub.channels) + len(sub.patterns)
+}
+
+func (n *Notifier) unsubscribeAll(cl *radio.Client) {
+	sub, ok := cl.Value(subscriberKey{}).(*subscriber)
+	if !ok {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for name := range sub.channels {
+		delete(n.channels[name], sub)
+		if len(n.channels[name]) == 0 {
+			delete(n.channels, name)
+		}
+	}
+	for name := range sub.patterns {
+		delete(n.patterns[name], sub)
+		if len(n.patterns[name]) == 0 {
+			delete(n.patterns, name)
+		}
+	}
+
+	sub.channels = map[string]struct{}{}
+	sub.patterns = map[string]struct{}{}
+}
+
+// publish delivers the message to the subscribers of the channel and the
+// subscribers of the patterns matching the channel. The messages are written
+// without holding the lock so that slow clients do not block subscriptions.
+func (n *Notifier) publish(channel, msg string) {
+	if n.Publish != nil {
+		n.Publish(channel, msg)
+		return
+	}
+
+	type delivery struct {
+		wr  radio.ResponseWriter
+		msg *radio.Array
+	}
+
+	var deliveries []delivery
+	n.mu.RLock()
+	for sub := range n.channels[channel] {
+		deliveries = append(deliveries, delivery{wr: sub.wr, msg: message("message", channel, msg)})
+	}
+	for pattern, subs := range n.patterns {
+		if !radio.MatchPattern(pattern, channel) {
+			continue
+		}
+
+		for sub := range subs {
+			deliveries = append(deliveries, delivery{wr: sub.wr, msg: message("pmessage", pattern, channel, msg)})
+		}
+	}
+	n.mu.RUnlock()
+
+	for _, d := range deliveries {
+		d.wr.Write(d.msg)
+	}
+}
+
+func message(items ...string) *radio.Array {
+	arr := &radio.Array{Items: []radio.Value{}}
+	for _, itm := range items {
+		arr.Items = append(arr.Items, &radio.BulkStr{Value: []byte(itm)})
+	}
+	return arr
+}
@@ -0,0 +1,457 @@
+package radio
+
+import (
+	"strconv"
+	"strings"
+)
+
+// KeySpec describes the positions of the key arguments of a command in the
+// same way as the first key, last key and step values reported by the Redis
+// COMMAND command. Positions count the command name as 0 and a negative
+// Last is relative to the end of the arguments (i.e., -1 is the last one).
+// Zero value of KeySpec represents a command without keys.
+type KeySpec struct {
+	First int
+	Last  int
+	Step  int
+}
+
+// Keys returns the key arguments of the request as described by the spec.
+func (ks KeySpec) Keys(req *Request) []string {
+	if ks.First <= 0 || ks.First > len(req.Args) {
+		return nil
+	}
+
+	last := ks.Last
+	if last < 0 {
+		last = len(req.Args) + 1 + last
+	}
+	if last > len(req.Args) {
+		last = len(req.Args)
+	}
+
+	step := ks.Step
+	if step <= 0 {
+		step = 1
+	}
+
+	var keys []string
+	for i := ks.First; i <= last; i += step {
+		keys = append(keys, req.Args[i-1])
+	}
+
+	return keys
+}
+
+// KeySpecs maps lower case command names to their key specs.
+type KeySpecs map[string]KeySpec
+
+// Keys returns the key arguments of the request using the spec registered
+// for its command. ok is false if no spec is registered for the command.
+func (specs KeySpecs) Keys(req *Request) (keys []string, ok bool) {
+	ks, ok := specs[strings.ToLower(req.Command)]
+	if !ok {
+		return nil, false
+	}
+
+	return ks.Keys(req), true
+}
+
+// Resolve returns all the key arguments of the request. The keys of the
+// commands in MovableKeys are found by parsing the arguments like Redis
+// does (e.g., using the number of keys of ZUNIONSTORE or the STREAMS option
+// of XREAD) and ok is false if the arguments are malformed. The keys of the
+// other commands are found using Keys.
+func (specs KeySpecs) Resolve(req *Request) (keys []string, ok bool) {
+	name := strings.ToLower(req.Command)
+	if !MovableKeys[name] {
+		return specs.Keys(req)
+	}
+
+	resolve, found := movableResolvers[name]
+	if !found {
+		return nil, false
+	}
+	return resolve(req.Args)
+}
+
+// DefaultKeySpecs contains the key specs of the standard Redis commands.
+// Commands that declare the number of keys as an argument (e.g., EVAL or
+// ZUNIONSTORE) only report the keys at fixed positions. See MovableKeys.
+var DefaultKeySpecs = KeySpecs{
+	// generic
+	"del":         {1, -1, 1},
+	"unlink":      {1, -1, 1},
+	"exists":      {1, -1, 1},
+	"touch":       {1, -1, 1},
+	"type":        {1, 1, 1},
+	"rename":      {1, 2, 1},
+	"renamenx":    {1, 2, 1},
+	"copy":        {1, 2, 1},
+	"expire":      {1, 1, 1},
+	"pexpire":     {1, 1, 1},
+	"expireat":    {1, 1, 1},
+	"pexpireat":   {1, 1, 1},
+	"ttl":         {1, 1, 1},
+	"pttl":        {1, 1, 1},
+	"expiretime":  {1, 1, 1},
+	"pexpiretime": {1, 1, 1},
+	"persist":     {1, 1, 1},
+	"dump":        {1, 1, 1},
+	"restore":     {1, 1, 1},
+	"object":      {2, 2, 1},
+	"memory":      {2, 2, 1},
+
+	// strings
+	"get":         {1, 1, 1},
+	"set":         {1, 1, 1},
+	"setnx":       {1, 1, 1},
+	"setex":       {1, 1, 1},
+	"psetex":      {1, 1, 1},
+	"getset":      {1, 1, 1},
+	"getdel":      {1, 1, 1},
+	"getex":       {1, 1, 1},
+	"append":      {1, 1, 1},
+	"strlen":      {1, 1, 1},
+	"incr":        {1, 1, 1},
+	"decr":        {1, 1, 1},
+	"incrby":      {1, 1, 1},
+	"decrby":      {1, 1, 1},
+	"incrbyfloat": {1, 1, 1},
+	"getrange":    {1, 1, 1},
+	"setrange":    {1, 1, 1},
+	"mget":        {1, -1, 1},
+	"mset":        {1, -1, 2},
+	"msetnx":      {1, -1, 2},
+
+	// bitmaps & hyperloglog
+	"setbit":      {1, 1, 1},
+	"getbit":      {1, 1, 1},
+	"bitcount":    {1, 1, 1},
+	"bitpos":      {1, 1, 1},
+	"bitfield":    {1, 1, 1},
+	"bitfield_ro": {1, 1, 1},
+	"bitop":       {2, -1, 1},
+	"pfadd":       {1, 1, 1},
+	"pfcount":     {1, -1, 1},
+	"pfmerge":     {1, -1, 1},
+
+	// hashes
+	"hset":         {1, 1, 1},
+	"hsetnx":       {1, 1, 1},
+	"hmset":        {1, 1, 1},
+	"hget":         {1, 1, 1},
+	"hmget":        {1, 1, 1},
+	"hdel":         {1, 1, 1},
+	"hlen":         {1, 1, 1},
+	"hstrlen":      {1, 1, 1},
+	"hexists":      {1, 1, 1},
+	"hkeys":        {1, 1, 1},
+	"hvals":        {1, 1, 1},
+	"hgetall":      {1, 1, 1},
+	"hincrby":      {1, 1, 1},
+	"hincrbyfloat": {1, 1, 1},
+	"hrandfield":   {1, 1, 1},
+	"hscan":        {1, 1, 1},
+	"hexpire":      {1, 1, 1},
+	"hpexpire":     {1, 1, 1},
+	"httl":         {1, 1, 1},
+	"hpttl":        {1, 1, 1},
+	"hexpireat":    {1, 1, 1},
+	"hpexpireat":   {1, 1, 1},
+	"hexpiretime":  {1, 1, 1},
+	"hpexpiretime": {1, 1, 1},
+	"hpersist":     {1, 1, 1},
+
+	// lists
+	"lpush":      {1, 1, 1},
+	"rpush":      {1, 1, 1},
+	"lpushx":     {1, 1, 1},
+	"rpushx":     {1, 1, 1},
+	"lpop":       {1, 1, 1},
+	"rpop":       {1, 1, 1},
+	"llen":       {1, 1, 1},
+	"lindex":     {1, 1, 1},
+	"lset":       {1, 1, 1},
+	"lrange":     {1, 1, 1},
+	"ltrim":      {1, 1, 1},
+	"lrem":       {1, 1, 1},
+	"linsert":    {1, 1, 1},
+	"lpos":       {1, 1, 1},
+	"lmove":      {1, 2, 1},
+	"rpoplpush":  {1, 2, 1},
+	"blpop":      {1, -2, 1},
+	"brpop":      {1, -2, 1},
+	"blmove":     {1, 2, 1},
+	"brpoplpush": {1, 2, 1},
+
+	// sets
+	"sadd":        {1, 1, 1},
+	"srem":        {1, 1, 1},
+	"scard":       {1, 1, 1},
+	"sismember":   {1, 1, 1},
+	"smismember":  {1, 1, 1},
+	"smembers":    {1, 1, 1},
+	"spop":        {1, 1, 1},
+	"srandmember": {1, 1, 1},
+	"sscan":       {1, 1, 1},
+	"smove":       {1, 2, 1},
+	"sinter":      {1, -1, 1},
+	"sunion":      {1, -1, 1},
+	"sdiff":       {1, -1, 1},
+	"sinterstore": {1, -1, 1},
+	"sunionstore": {1, -1, 1},
+	"sdiffstore":  {1, -1, 1},
+	"sintercard":  {2, 2, 1},
+
+	// sorted sets
+	"zadd":             {1, 1, 1},
+	"zincrby":          {1, 1, 1},
+	"zrem":             {1, 1, 1},
+	"zcard":            {1, 1, 1},
+	"zcount":           {1, 1, 1},
+	"zlexcount":        {1, 1, 1},
+	"zscore":           {1, 1, 1},
+	"zmscore":          {1, 1, 1},
+	"zrank":            {1, 1, 1},
+	"zrevrank":         {1, 1, 1},
+	"zrange":           {1, 1, 1},
+	"zrevrange":        {1, 1, 1},
+	"zrangebyscore":    {1, 1, 1},
+	"zrevrangebyscore": {1, 1, 1},
+	"zrangebylex":      {1, 1, 1},
+	"zrevrangebylex":   {1, 1, 1},
+	"zrangestore":      {1, 2, 1},
+	"zremrangebyrank":  {1, 1, 1},
+	"zremrangebyscore": {1, 1, 1},
+	"zremrangebylex":   {1, 1, 1},
+	"zpopmin":          {1, 1, 1},
+	"zpopmax":          {1, 1, 1},
+	"zrandmember":      {1, 1, 1},
+	"zscan":            {1, 1, 1},
+	"zunionstore":      {1, 1, 1},
+	"zinterstore":      {1, 1, 1},
+	"zdiffstore":       {1, 1, 1},
+	"bzpopmin":         {1, -2, 1},
+	"bzpopmax":         {1, -2, 1},
+
+	// geo
+	"geoadd":         {1, 1, 1},
+	"geodist":        {1, 1, 1},
+	"geopos":         {1, 1, 1},
+	"geohash":        {1, 1, 1},
+	"geosearch":      {1, 1, 1},
+	"geosearchstore": {1, 2, 1},
+
+	// streams
+	"xadd":       {1, 1, 1},
+	"xlen":       {1, 1, 1},
+	"xdel":       {1, 1, 1},
+	"xtrim":      {1, 1, 1},
+	"xrange":     {1, 1, 1},
+	"xrevrange":  {1, 1, 1},
+	"xack":       {1, 1, 1},
+	"xpending":   {1, 1, 1},
+	"xclaim":     {1, 1, 1},
+	"xautoclaim": {1, 1, 1},
+	"xgroup":     {2, 2, 1},
+	"xinfo":      {2, 2, 1},
+
+	// keyless
+	"ping":     {},
+	"echo":     {},
+	"select":   {},
+	"dbsize":   {},
+	"flushdb":  {},
+	"flushall": {},
+	"scan":     {},
+	"keys":     {},
+	"info":     {},
+	"config":   {},
+	"client":   {},
+	"command":  {},
+	"cluster":  {},
+	"asking":   {},
+	"time":     {},
+}
+
+// MovableKeys contains the commands with keys that cannot be fully described
+// by a KeySpec (e.g., when the number of keys is an argument). This is the
+// same as the movablekeys flag reported by the Redis COMMAND command. Key
+// specs of these commands only report the keys at fixed positions.
+var MovableKeys = map[string]bool{
+	"zunionstore":       true,
+	"zinterstore":       true,
+	"zdiffstore":        true,
+	"zunion":            true,
+	"zinter":            true,
+	"zdiff":             true,
+	"zintercard":        true,
+	"sintercard":        true,
+	"lmpop":             true,
+	"blmpop":            true,
+	"zmpop":             true,
+	"bzmpop":            true,
+	"sort":              true,
+	"sort_ro":           true,
+	"eval":              true,
+	"evalsha":           true,
+	"fcall":             true,
+	"eval_ro":           true,
+	"evalsha_ro":        true,
+	"fcall_ro":          true,
+	"xread":             true,
+	"xreadgroup":        true,
+	"migrate":           true,
+	"georadius":         true,
+	"georadiusbymember": true,
+}
+
+// movableResolvers find the keys of the commands in MovableKeys from the
+// arguments of the requests.
+var movableResolvers = map[string]func(args []string) ([]string, bool){
+	"zunionstore":       numKeysResolver(1, 1),
+	"zinterstore":       numKeysResolver(1, 1),
+	"zdiffstore":        numKeysResolver(1, 1),
+	"zunion":            numKeysResolver(0, 0),
+	"zinter":            numKeysResolver(0, 0),
+	"zdiff":             numKeysResolver(0, 0),
+	"zintercard":        numKeysResolver(0, 0),
+	"sintercard":        numKeysResolver(0, 0),
+	"lmpop":             numKeysResolver(0, 0),
+	"zmpop":             numKeysResolver(0, 0),
+	"blmpop":            numKeysResolver(1, 0),
+	"bzmpop":            numKeysResolver(1, 0),
+	"eval":              numKeysResolver(1, 0),
+	"evalsha":           numKeysResolver(1, 0),
+	"eval_ro":           numKeysResolver(1, 0),
+	"evalsha_ro":        numKeysResolver(1, 0),
+	"fcall":             numKeysResolver(1, 0),
+	"fcall_ro":          numKeysResolver(1, 0),
+	"sort":              sortKeys,
+	"sort_ro":           sortKeys,
+	"xread":             streamsKeys,
+	"xreadgroup":        streamsKeys,
+	"migrate":           migrateKeys,
+	"georadius":         georadiusKeys(4),
+	"georadiusbymember": georadiusKeys(3),
+}
+
+// numKeysResolver resolves the keys of commands with the number of keys at
+// the position pos (0 being the first argument) followed by the keys. The
+// first leading arguments are keys too (e.g., the destination of
+// ZUNIONSTORE).
+func numKeysResolver(pos, leading int) func(args []string) ([]string, bool) {
+	return func(args []string) ([]string, bool) {
+		if pos >= len(args) {
+			return nil, false
+		}
+
+		n, err := strconv.Atoi(args[pos])
+		if err != nil || n < 0 || n > len(args)-pos-1 {
+			return nil, false
+		}
+
+		keys := append([]string{}, args[:leading]...)
+		return append(keys, args[pos+1:pos+1+n]...), true
+	}
+}
+
+// sortKeys resolves the key of SORT along with the destination of STORE.
+func sortKeys(args []string) ([]string, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+
+	keys := []string{args[0]}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BY", "GET":
+			i++
+		case "LIMIT":
+			i += 2
+		case "STORE":
+			if i+1 >= len(args) {
+				return nil, false
+			}
+			i++
+			keys = append(keys, args[i])
+		}
+	}
+	return keys, true
+}
+
+// streamsKeys resolves the keys of XREAD and XREADGROUP, which are the
+// first half of the arguments following STREAMS.
+func streamsKeys(args []string) ([]string, bool) {
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT", "BLOCK":
+			i++
+		case "GROUP":
+			i += 2
+		case "NOACK":
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, false
+			}
+			return rest[:len(rest)/2], true
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// migrateKeys resolves the key of MIGRATE or the keys following KEYS when
+// the key is empty.
+func migrateKeys(args []string) ([]string, bool) {
+	if len(args) < 5 {
+		return nil, false
+	}
+	if args[2] != "" {
+		return []string{args[2]}, true
+	}
+
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COPY", "REPLACE":
+		case "AUTH":
+			i++
+		case "AUTH2":
+			i += 2
+		case "KEYS":
+			return args[i+1:], true
+		default:
+			return nil, false
+		}
+	}
+	return nil, true
+}
+
+// georadiusKeys resolves the key of GEORADIUS and GEORADIUSBYMEMBER along
+// with the destination of STORE or STOREDIST, which are looked for after
+// the first n arguments.
+func georadiusKeys(n int) func(args []string) ([]string, bool) {
+	return func(args []string) ([]string, bool) {
+		if len(args) == 0 {
+			return nil, false
+		}
+
+		keys := []string{args[0]}
+		for i := n + 1; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "COUNT":
+				i++
+			case "STORE", "STOREDIST":
+				if i+1 >= len(args) {
+					return nil, false
+				}
+				i++
+				keys = append(keys, args[i])
+			}
+		}
+		return keys, true
+	}
+}
@@ -0,0 +1,182 @@
+package radio_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spy16/radio"
+)
+
+func TestKeySpecs_Keys(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		req   *radio.Request
+		keys  []string
+		ok    bool
+	}{
+		{
+			title: "SingleKey",
+			req:   &radio.Request{Command: "GET", Args: []string{"a"}},
+			keys:  []string{"a"},
+			ok:    true,
+		},
+		{
+			title: "AllArgs",
+			req:   &radio.Request{Command: "del", Args: []string{"a", "b", "c"}},
+			keys:  []string{"a", "b", "c"},
+			ok:    true,
+		},
+		{
+			title: "Step",
+			req:   &radio.Request{Command: "MSET", Args: []string{"a", "1", "b", "2"}},
+			keys:  []string{"a", "b"},
+			ok:    true,
+		},
+		{
+			title: "ExcludeLast",
+			req:   &radio.Request{Command: "BLPOP", Args: []string{"a", "b", "0"}},
+			keys:  []string{"a", "b"},
+			ok:    true,
+		},
+		{
+			title: "AfterSubcommand",
+			req:   &radio.Request{Command: "OBJECT", Args: []string{"FREQ", "a"}},
+			keys:  []string{"a"},
+			ok:    true,
+		},
+		{
+			title: "MissingArgs",
+			req:   &radio.Request{Command: "GET"},
+			keys:  nil,
+			ok:    true,
+		},
+		{
+			title: "Keyless",
+			req:   &radio.Request{Command: "PING"},
+			keys:  nil,
+			ok:    true,
+		},
+		{
+			title: "Unknown",
+			req:   &radio.Request{Command: "FOO", Args: []string{"a"}},
+			keys:  nil,
+			ok:    false,
+		},
+	}
+
+	for _, cs := range cases {
+		suite.Run(cs.title, func(t *testing.T) {
+			keys, ok := radio.DefaultKeySpecs.Keys(cs.req)
+			if ok != cs.ok {
+				t.Errorf("expecting ok to be '%t', got '%t'", cs.ok, ok)
+			}
+
+			if !reflect.DeepEqual(cs.keys, keys) {
+				t.Errorf("expecting keys %v, got %v", cs.keys, keys)
+			}
+		})
+	}
+}
+
+func TestKeySpecs_Resolve(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		req   *radio.Request
+		keys  []string
+		ok    bool
+	}{
+		{
+			title: "Fixed",
+			req:   &radio.Request{Command: "MGET", Args: []string{"a", "b"}},
+			keys:  []string{"a", "b"},
+			ok:    true,
+		},
+		{
+			title: "NumKeysWithDestination",
+			req:   &radio.Request{Command: "zunionstore", Args: []string{"d", "2", "a", "b", "WEIGHTS", "1", "2"}},
+			keys:  []string{"d", "a", "b"},
+			ok:    true,
+		},
+		{
+			title: "NumKeys",
+			req:   &radio.Request{Command: "ZINTER", Args: []string{"2", "a", "b", "WITHSCORES"}},
+			keys:  []string{"a", "b"},
+			ok:    true,
+		},
+		{
+			title: "NumKeysAfterTimeout",
+			req:   &radio.Request{Command: "BLMPOP", Args: []string{"0", "2", "a", "b", "LEFT"}},
+			keys:  []string{"a", "b"},
+			ok:    true,
+		},
+		{
+			title: "Eval",
+			req:   &radio.Request{Command: "EVAL", Args: []string{"return 1", "1", "a", "arg"}},
+			keys:  []string{"a"},
+			ok:    true,
+		},
+		{
+			title: "TooManyKeys",
+			req:   &radio.Request{Command: "EVAL", Args: []string{"return 1", "2", "a"}},
+			ok:    false,
+		},
+		{
+			title: "InvalidNumKeys",
+			req:   &radio.Request{Command: "ZUNION", Args: []string{"x", "a"}},
+			ok:    false,
+		},
+		{
+			title: "Streams",
+			req:   &radio.Request{Command: "XREAD", Args: []string{"COUNT", "1", "BLOCK", "0", "STREAMS", "a", "b", "0", "$"}},
+			keys:  []string{"a", "b"},
+			ok:    true,
+		},
+		{
+			title: "StreamsGroup",
+			req:   &radio.Request{Command: "XREADGROUP", Args: []string{"GROUP", "streams", "c", "NOACK", "STREAMS", "a", ">"}},
+			keys:  []string{"a"},
+			ok:    true,
+		},
+		{
+			title: "StreamsUnbalanced",
+			req:   &radio.Request{Command: "XREAD", Args: []string{"STREAMS", "a", "b", "0"}},
+			ok:    false,
+		},
+		{
+			title: "SortStore",
+			req:   &radio.Request{Command: "SORT", Args: []string{"a", "BY", "store", "LIMIT", "0", "1", "STORE", "d"}},
+			keys:  []string{"a", "d"},
+			ok:    true,
+		},
+		{
+			title: "Migrate",
+			req:   &radio.Request{Command: "MIGRATE", Args: []string{"host", "6379", "", "0", "1000", "REPLACE", "AUTH", "keys", "KEYS", "a", "b"}},
+			keys:  []string{"a", "b"},
+			ok:    true,
+		},
+		{
+			title: "GeoRadiusStore",
+			req:   &radio.Request{Command: "GEORADIUS", Args: []string{"a", "15", "37", "200", "km", "COUNT", "5", "STOREDIST", "d"}},
+			keys:  []string{"a", "d"},
+			ok:    true,
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			keys, ok := radio.DefaultKeySpecs.Resolve(cs.req)
+			if ok != cs.ok {
+				t.Errorf("expecting ok to be '%t', got '%t'", cs.ok, ok)
+			}
+
+			if cs.ok && !reflect.DeepEqual(cs.keys, keys) {
+				t.Errorf("expecting keys %v, got %v", cs.keys, keys)
+			}
+		})
+	}
+}
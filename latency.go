@@ -0,0 +1,112 @@
+package radio
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Durations are counted in buckets of nanoseconds in the manner of HDR
+// histograms: durations below 2^latencySubBits ns have buckets of their own
+// and every following power of two is split into 2^(latencySubBits-1)
+// buckets of the same width, which bounds the relative error to 1/64.
+// Durations of 2^latencyMaxBits ns (about 68s) or more are counted in the
+// last bucket.
+const (
+	latencySubBits  = 7
+	latencyMaxBits  = 36
+	latencyHalf     = 1 << (latencySubBits - 1)
+	latencyBuckets  = (latencyMaxBits - latencySubBits + 2) * latencyHalf
+	latencyMaxValue = 1<<latencyMaxBits - 1
+)
+
+// LatencyHistogram records the distribution of durations with a bounded
+// relative error in a fixed amount of memory. Recording is lock-free and
+// safe for concurrent use. Zero value of LatencyHistogram is empty.
+type LatencyHistogram struct {
+	counts [latencyBuckets]uint64
+}
+
+// Record adds the duration to the histogram.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	atomic.AddUint64(&h.counts[latencyBucket(d)], 1)
+}
+
+// Count returns the number of durations recorded.
+func (h *LatencyHistogram) Count() uint64 {
+	total := uint64(0)
+	for i := range h.counts {
+		total += atomic.LoadUint64(&h.counts[i])
+	}
+	return total
+}
+
+// Percentile returns the duration below or at which the percentage p (e.g.,
+// 99.9) of the recorded durations fall. Returns zero if the histogram is
+// empty.
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(p / 100 * float64(total)))
+	if target < 1 {
+		target = 1
+	}
+
+	seen := uint64(0)
+	for i := range h.counts {
+		seen += atomic.LoadUint64(&h.counts[i])
+		if seen >= target {
+			return latencyHighest(i)
+		}
+	}
+	return latencyMaxValue
+}
+
+// Reset removes all the recorded durations.
+func (h *LatencyHistogram) Reset() {
+	for i := range h.counts {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+}
+
+// snapshot returns a copy of the histogram.
+func (h *LatencyHistogram) snapshot() *LatencyHistogram {
+	cp := &LatencyHistogram{}
+	for i := range h.counts {
+		cp.counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return cp
+}
+
+// latencyBucket returns the index of the bucket of the duration.
+func latencyBucket(d time.Duration) int {
+	if d < 0 {
+		d = 0
+	}
+
+	v := uint64(d)
+	if v > latencyMaxValue {
+		v = latencyMaxValue
+	}
+	if v < 2*latencyHalf {
+		return int(v)
+	}
+
+	shift := uint(bits.Len64(v) - latencySubBits)
+	return int(shift)*latencyHalf + int(v>>shift)
+}
+
+// latencyHighest returns the highest duration counted in the bucket.
+func latencyHighest(i int) time.Duration {
+	if i < 2*latencyHalf {
+		return time.Duration(i)
+	}
+
+	shift := uint(i/latencyHalf - 1)
+	sub := uint64(i%latencyHalf + latencyHalf)
+	return time.Duration((sub+1)<<shift - 1)
+}
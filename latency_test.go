@@ -0,0 +1,76 @@
+package radio_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := &radio.LatencyHistogram{}
+	if p := h.Percentile(99); p != 0 {
+		t.Errorf("expecting zero percentile of empty histogram, got %s", p)
+	}
+
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	if n := h.Count(); n != 1000 {
+		t.Errorf("expecting 1000 durations, got %d", n)
+	}
+
+	// percentiles are the highest durations of the buckets, which are at
+	// most 1/64 greater than the recorded durations.
+	expected := map[float64]time.Duration{
+		0:    time.Microsecond,
+		50:   500 * time.Microsecond,
+		99:   990 * time.Microsecond,
+		99.9: 999 * time.Microsecond,
+		100:  1000 * time.Microsecond,
+	}
+	for p, d := range expected {
+		got := h.Percentile(p)
+		if got < d || got > d+d/64 {
+			t.Errorf("expecting p%v to be within 1/64 of %s, got %s", p, d, got)
+		}
+	}
+
+	h.Reset()
+	if n := h.Count(); n != 0 {
+		t.Errorf("expecting no durations after reset, got %d", n)
+	}
+
+	// short durations are recorded exactly and long ones are capped.
+	h.Record(5)
+	h.Record(-time.Second)
+	if p := h.Percentile(100); p != 5 {
+		t.Errorf("expecting 5ns, got %s", p)
+	}
+	h.Record(time.Hour)
+	if p := h.Percentile(100); p < time.Minute || p > 2*time.Minute {
+		t.Errorf("expecting capped duration, got %s", p)
+	}
+}
+
+func BenchmarkLatencyHistogram_Record(b *testing.B) {
+	b.Run("Serial", func(b *testing.B) {
+		h := &radio.LatencyHistogram{}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.Record(time.Duration(i))
+		}
+	})
+
+	b.Run("Parallel", func(b *testing.B) {
+		h := &radio.LatencyHistogram{}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			d := time.Duration(0)
+			for pb.Next() {
+				h.Record(d)
+				d += 100
+			}
+		})
+	})
+}
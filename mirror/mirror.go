@@ -0,0 +1,320 @@
+package mirror
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/internal/reply"
+)
+
+// Options control the sampling and delivery of mirrored requests. Zero
+// values are replaced with defaults.
+type Options struct {
+	// Ratio is the fraction of requests (between 0 and 1) that are mirrored.
+	// Defaults to 1 (i.e., all requests).
+	Ratio float64
+
+	// Commands, if set, restricts mirroring to these commands. Command
+	// names are matched case-insensitively.
+	Commands []string
+
+	// Workers is the number of connections used to send the mirrored
+	// requests without a client (e.g., requests not served by a server).
+	// The requests of every client are sent in order over a connection of
+	// their own, so that the state of the connection (e.g., SELECT, MULTI)
+	// on the secondary follows the primary. Defaults to 4.
+	Workers int
+
+	// QueueSize is the maximum number of requests of a client (or of all
+	// the requests without a client) waiting to be mirrored. Requests are
+	// dropped when the queue is full. Defaults to 1024.
+	QueueSize int
+
+	// OnMismatch is invoked from the mirroring goroutines when the replies
+	// of the primary and the secondary differ.
+	OnMismatch func(m Mismatch)
+
+	// Dial is used to connect to the secondary. Defaults to radio.Dial.
+	Dial func(addr string) (*radio.Conn, error)
+}
+
+// Mismatch records a request for which the primary and the secondary
+// replied differently.
+type Mismatch struct {
+	Time      time.Time
+	Command   string
+	Args      []string
+	Primary   []radio.Value
+	Secondary []radio.Value
+}
+
+// Stats holds the counters of the mirror. Skipped counts the sampled
+// requests not mirrored because the primary had not replied when it
+// returned (e.g., blocked commands).
+type Stats struct {
+	Mirrored   uint64
+	Matched    uint64
+	Mismatched uint64
+	Dropped    uint64
+	Skipped    uint64
+	Failed     uint64
+}
+
+// New initializes a mirror which serves all requests using the primary
+// handler and mirrors a sample of them to the RESP server at the address.
+// Close must be called to stop the mirroring goroutines.
+func New(primary radio.Handler, addr string, opts Options) *Mirror {
+	if opts.Ratio <= 0 {
+		opts.Ratio = 1
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.Dial == nil {
+		opts.Dial = radio.Dial
+	}
+
+	m := &Mirror{
+		primary: primary,
+		addr:    addr,
+		opts:    opts,
+		queue:   make(chan *sample, opts.QueueSize),
+		clients: map[uint64]chan *sample{},
+		done:    make(chan struct{}),
+	}
+
+	if len(opts.Commands) > 0 {
+		m.commands = map[string]bool{}
+		for _, cmd := range opts.Commands {
+			m.commands[strings.ToLower(cmd)] = true
+		}
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker(m.queue)
+	}
+	return m
+}
+
+// Mirror is a radio.Handler that serves requests using the primary handler
+// and asynchronously replays a sample of them against a secondary RESP
+// endpoint, comparing the replies. Mirroring never blocks or fails the
+// requests served by the primary. The replies are compared with the values
+// the primary wrote before returning, so requests replied to later (e.g.,
+// blocked commands or handlers replying asynchronously) are not mirrored.
+// Push values (e.g., invalidation messages) are not compared.
+type Mirror struct {
+	primary  radio.Handler
+	addr     string
+	opts     Options
+	commands map[string]bool
+	queue    chan *sample
+	wg       sync.WaitGroup
+	stats    Stats
+
+	// clients holds the queues of the clients, which are closed when the
+	// clients disconnect or the mirror is closed.
+	mu      sync.Mutex
+	clients map[uint64]chan *sample
+	closed  bool
+	done    chan struct{}
+}
+
+type sample struct {
+	req   *radio.Request
+	reply []radio.Value
+}
+
+// ServeRESP serves the request using the primary and queues it for mirroring
+// if it is sampled.
+func (m *Mirror) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	if !m.sampled(req) {
+		m.primary.ServeRESP(wr, req)
+		return
+	}
+
+	rec := &reply.Recorder{Next: wr}
+	m.primary.ServeRESP(rec, req)
+
+	// values written after the primary returns are not recorded, so that
+	// the replies are not read while being written.
+	s := &sample{
+		req:   &radio.Request{Command: req.Command, Args: req.Args},
+		reply: rec.Stop(),
+	}
+	if len(s.reply) == 0 {
+		atomic.AddUint64(&m.stats.Skipped, 1)
+		return
+	}
+
+	if !m.enqueue(req.Client, s) {
+		atomic.AddUint64(&m.stats.Dropped, 1)
+	}
+}
+
+// enqueue queues the sample on the queue of the client, starting a worker
+// with a connection of its own for new clients. Returns false if the queue
+// is full or the mirror is closed. Queues are only sent to and closed with
+// m.mu held.
+func (m *Mirror) enqueue(cl *radio.Client, s *sample) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false
+	}
+
+	queue := m.queue
+	if cl != nil {
+		var found bool
+		if queue, found = m.clients[cl.ID]; !found {
+			queue = make(chan *sample, m.opts.QueueSize)
+			m.clients[cl.ID] = queue
+
+			m.wg.Add(1)
+			go m.worker(queue)
+			go m.release(cl)
+		}
+	}
+
+	select {
+	case queue <- s:
+		return true
+	default:
+		return false
+	}
+}
+
+// release closes the queue of the client once the client disconnects so
+// that its worker exits after mirroring the queued requests.
+func (m *Mirror) release(cl *radio.Client) {
+	select {
+	case <-cl.Context().Done():
+	case <-m.done:
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if queue, found := m.clients[cl.ID]; found && !m.closed {
+		delete(m.clients, cl.ID)
+		close(queue)
+	}
+}
+
+// Stats returns a snapshot of the mirroring counters.
+func (m *Mirror) Stats() Stats {
+	return Stats{
+		Mirrored:   atomic.LoadUint64(&m.stats.Mirrored),
+		Matched:    atomic.LoadUint64(&m.stats.Matched),
+		Mismatched: atomic.LoadUint64(&m.stats.Mismatched),
+		Dropped:    atomic.LoadUint64(&m.stats.Dropped),
+		Skipped:    atomic.LoadUint64(&m.stats.Skipped),
+		Failed:     atomic.LoadUint64(&m.stats.Failed),
+	}
+}
+
+// Close stops accepting requests for mirroring and waits for the queued
+// requests to be mirrored. ServeRESP must not be called after Close.
+func (m *Mirror) Close() error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+		for _, queue := range m.clients {
+			close(queue)
+		}
+		close(m.done)
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	return nil
+}
+
+func (m *Mirror) sampled(req *radio.Request) bool {
+	if m.commands != nil && !m.commands[strings.ToLower(req.Command)] {
+		return false
+	}
+
+	return m.opts.Ratio >= 1 || rand.Float64() < m.opts.Ratio
+}
+
+func (m *Mirror) worker(queue <-chan *sample) {
+	defer m.wg.Done()
+
+	var conn *radio.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for s := range queue {
+		if conn == nil {
+			c, err := m.opts.Dial(m.addr)
+			if err != nil {
+				atomic.AddUint64(&m.stats.Failed, 1)
+				continue
+			}
+			conn = c
+		}
+
+		replies, err := m.replay(conn, s)
+		if err != nil {
+			atomic.AddUint64(&m.stats.Failed, 1)
+			conn.Close()
+			conn = nil
+			continue
+		}
+
+		atomic.AddUint64(&m.stats.Mirrored, 1)
+		if reply.Equal(s.reply, replies) {
+			atomic.AddUint64(&m.stats.Matched, 1)
+			continue
+		}
+
+		atomic.AddUint64(&m.stats.Mismatched, 1)
+		if m.opts.OnMismatch != nil {
+			m.opts.OnMismatch(Mismatch{
+				Time:      time.Now(),
+				Command:   s.req.Command,
+				Args:      s.req.Args,
+				Primary:   s.reply,
+				Secondary: replies,
+			})
+		}
+	}
+}
+
+// replay sends the request to the secondary and reads as many replies as
+// were written by the primary.
+func (m *Mirror) replay(conn *radio.Conn, s *sample) ([]radio.Value, error) {
+	if err := conn.Send(s.req.Command, s.req.Args...); err != nil {
+		return nil, err
+	}
+
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]radio.Value, 0, len(s.reply))
+	for range s.reply {
+		v, err := conn.Receive()
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, v)
+	}
+
+	return replies, nil
+}
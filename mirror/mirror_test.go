@@ -0,0 +1,225 @@
+package mirror_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/mirror"
+	"github.com/spy16/radio/radiotest"
+)
+
+func TestMirror_Compare(t *testing.T) {
+	secondary := startSecondary(t, 0)
+
+	var mu sync.Mutex
+	var mismatches []mirror.Mismatch
+	m := mirror.New(echoHandler(), secondary, mirror.Options{
+		OnMismatch: func(mm mirror.Mismatch) {
+			mu.Lock()
+			mismatches = append(mismatches, mm)
+			mu.Unlock()
+		},
+	})
+
+	rec := &recorder{}
+	m.ServeRESP(rec, &radio.Request{Command: "ECHO", Args: []string{"same"}})
+	m.ServeRESP(rec, &radio.Request{Command: "ECHO", Args: []string{"differ"}})
+	m.Close()
+
+	if len(rec.vals) != 2 || rec.vals[1] != radio.SimpleStr("differ") {
+		t.Errorf("expecting primary replies to be written to client, got %v", rec.vals)
+	}
+
+	stats := m.Stats()
+	if stats.Mirrored != 2 || stats.Matched != 1 || stats.Mismatched != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if len(mismatches) != 1 {
+		t.Fatalf("expecting 1 mismatch, got %d", len(mismatches))
+	}
+
+	mm := mismatches[0]
+	if mm.Command != "ECHO" || mm.Args[0] != "differ" ||
+		mm.Primary[0] != radio.SimpleStr("differ") || mm.Secondary[0] != radio.ErrorStr("ERR mismatch") {
+		t.Errorf("unexpected mismatch: %+v", mm)
+	}
+}
+
+func TestMirror_Sampling(t *testing.T) {
+	secondary := startSecondary(t, 0)
+
+	m := mirror.New(echoHandler(), secondary, mirror.Options{
+		Ratio:     0.5,
+		Commands:  []string{"echo"},
+		QueueSize: 2000,
+	})
+
+	for i := 0; i < 1000; i++ {
+		m.ServeRESP(&recorder{}, &radio.Request{Command: "ECHO", Args: []string{strconv.Itoa(i)}})
+		m.ServeRESP(&recorder{}, &radio.Request{Command: "PING"})
+	}
+	m.Close()
+
+	stats := m.Stats()
+	if stats.Mirrored < 400 || stats.Mirrored > 600 {
+		t.Errorf("expecting roughly half of ECHO requests to be mirrored, got %d", stats.Mirrored)
+	}
+}
+
+func TestMirror_NoLatency(t *testing.T) {
+	secondary := startSecondary(t, 200*time.Millisecond)
+
+	m := mirror.New(echoHandler(), secondary, mirror.Options{Workers: 1})
+	defer m.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		m.ServeRESP(&recorder{}, &radio.Request{Command: "ECHO", Args: []string{"same"}})
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("mirroring must not delay primary, took %s", elapsed)
+	}
+}
+
+func TestMirror_SecondaryDown(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	m := mirror.New(echoHandler(), addr, mirror.Options{QueueSize: 1})
+
+	rec := &recorder{}
+	for i := 0; i < 10; i++ {
+		m.ServeRESP(rec, &radio.Request{Command: "ECHO", Args: []string{"same"}})
+	}
+	m.Close()
+
+	if len(rec.vals) != 10 {
+		t.Errorf("expecting all requests to be served by primary, got %d replies", len(rec.vals))
+	}
+
+	stats := m.Stats()
+	if stats.Failed+stats.Dropped != 10 || stats.Mirrored != 0 {
+		t.Errorf("expecting mirroring to fail or drop silently, got %+v", stats)
+	}
+}
+
+func TestMirror_ClientOrder(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go radio.ListenAndServe(context.Background(), l, seqHandler())
+
+	m := mirror.New(seqHandler(), l.Addr().String(), mirror.Options{Workers: 4})
+
+	var wg sync.WaitGroup
+	for id := uint64(1); id <= 8; id++ {
+		wg.Add(1)
+		go func(cl *radio.Client) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				m.ServeRESP(&recorder{}, &radio.Request{Command: "SEQ", Client: cl})
+			}
+		}(&radio.Client{ID: id})
+	}
+	wg.Wait()
+	m.Close()
+
+	if stats := m.Stats(); stats.Mirrored != 400 || stats.Matched != 400 {
+		t.Errorf("expecting the requests of every client to be mirrored in order, got %+v", stats)
+	}
+}
+
+func TestMirror_LateReplies(t *testing.T) {
+	secondary := startSecondary(t, 0)
+
+	// the primary replies to ASYNC after returning, like a blocked command.
+	done := make(chan struct{})
+	primary := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		if req.Command != "ASYNC" {
+			echoHandler().ServeRESP(wr, req)
+			return
+		}
+
+		go func() {
+			defer close(done)
+			time.Sleep(10 * time.Millisecond)
+			wr.Write(radio.SimpleStr("LATE"))
+		}()
+	})
+
+	m := mirror.New(primary, secondary, mirror.Options{})
+	rec := radiotest.NewRecorder()
+	m.ServeRESP(rec, &radio.Request{Command: "ASYNC"})
+	m.ServeRESP(rec, &radio.Request{Command: "ECHO", Args: []string{"same"}})
+	<-done
+	m.Close()
+
+	if last := rec.Last(); last != radio.SimpleStr("LATE") {
+		t.Errorf("expecting the late reply to be written to the client, got '%v'", last)
+	}
+	if stats := m.Stats(); stats.Skipped != 1 || stats.Mirrored != 1 || stats.Matched != 1 {
+		t.Errorf("expecting the late reply not to be mirrored, got %+v", stats)
+	}
+}
+
+// seqHandler replies with the number of requests served for the client.
+func seqHandler() radio.Handler {
+	type seqKey struct{}
+
+	return radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		n, _ := req.Client.Value(seqKey{}).(int)
+		req.Client.SetValue(seqKey{}, n+1)
+		wr.Write(radio.Integer(n + 1))
+	})
+}
+
+// echoHandler replies with the first argument as a simple string.
+func echoHandler() radio.Handler {
+	return radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		if len(req.Args) == 0 {
+			wr.Write(radio.SimpleStr("PONG"))
+			return
+		}
+		wr.Write(radio.SimpleStr(req.Args[0]))
+	})
+}
+
+// startSecondary starts a secondary which echoes the first argument except
+// for 'differ' and delays every reply by the given duration.
+func startSecondary(t *testing.T, delay time.Duration) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	primary := echoHandler()
+	h := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		time.Sleep(delay)
+		if len(req.Args) > 0 && req.Args[0] == "differ" {
+			wr.Write(radio.ErrorStr("ERR mismatch"))
+			return
+		}
+		primary.ServeRESP(wr, req)
+	})
+
+	go radio.ListenAndServe(context.Background(), l, h)
+	return l.Addr().String()
+}
+
+type recorder struct {
+	vals []radio.Value
+}
+
+func (rec *recorder) Write(v radio.Value) (int, error) {
+	rec.vals = append(rec.vals, v)
+	return 0, nil
+}
@@ -6,38 +6,31 @@ import (
 	"io"
 	"net"
 	"reflect"
-	"time"
+	"sync/atomic"
 )
 
 // ListenAndServe starts a RESP server on the given listener. Parsed requests will
-// be passed to the given handler.
+// be passed to the given handler. See Server for more control over how the
+// requests are executed.
 func ListenAndServe(ctx context.Context, l net.Listener, handler Handler) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-
-		default:
-		}
+	srv := &Server{Handler: handler}
+	return srv.Serve(ctx, l)
+}
 
-		con, err := l.Accept()
-		if err != nil {
-			return err
-		}
+func clientLoop(ctx context.Context, rwc io.ReadWriteCloser, handler Handler, stats *serverStats) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		if tc, ok := con.(*net.TCPConn); ok {
-			tc.SetKeepAlive(true)
-			tc.SetKeepAlivePeriod(10 * time.Minute)
-		}
-		go clientLoop(ctx, con, handler)
-	}
-}
+	// the connection is counted by Serve when accepted.
+	defer stats.disconnect()
 
-func clientLoop(ctx context.Context, rwc io.ReadWriteCloser, handler Handler) {
 	rdr := NewReader(rwc, true)
 	rw := NewWriter(rwc)
+	cl := newClient(ctx, rwc)
+	defer cl.close()
 	defer rwc.Close()
 
+	var ahead *readResult
 	for {
 		select {
 		case <-ctx.Done():
@@ -45,7 +38,15 @@ func clientLoop(ctx context.Context, rwc io.ReadWriteCloser, handler Handler) {
 		default:
 		}
 
-		val, err := rdr.Read()
+		var val Value
+		var err error
+		if ahead != nil {
+			val, err = ahead.val, ahead.err
+			ahead = nil
+		} else {
+			val, err = rdr.Read()
+		}
+
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -55,7 +56,7 @@ func clientLoop(ctx context.Context, rwc io.ReadWriteCloser, handler Handler) {
 			return
 		}
 
-		req, err := newRequest(val)
+		req, err := NewRequest(val)
 		if err != nil {
 			rw.Write(ErrorStr("ERR " + err.Error()))
 			return
@@ -64,12 +65,50 @@ func clientLoop(ctx context.Context, rwc io.ReadWriteCloser, handler Handler) {
 		if req == nil {
 			continue
 		}
+		req.Client = cl
 
 		handler.ServeRESP(rw, req)
+
+		if unblocked := cl.unblocked(); unblocked != nil {
+			atomic.AddInt64(&stats.blocked, 1)
+			ahead = waitUnblocked(unblocked, rdr, cancel)
+			atomic.AddInt64(&stats.blocked, -1)
+		}
+	}
+}
+
+type readResult struct {
+	val Value
+	err error
+}
+
+// waitUnblocked waits for the blocked client to be unblocked. The next value
+// is read in the meantime to detect the client disconnecting, in which case
+// the client context is cancelled to unblock the client.
+func waitUnblocked(unblocked <-chan struct{}, rdr *Reader, cancel context.CancelFunc) *readResult {
+	results := make(chan *readResult, 1)
+	go func() {
+		val, err := rdr.Read()
+		results <- &readResult{val: val, err: err}
+	}()
+
+	select {
+	case <-unblocked:
+		return <-results
+
+	case res := <-results:
+		if res.err != nil {
+			cancel()
+		}
+		<-unblocked
+		return res
 	}
 }
 
-func newRequest(val Value) (*Request, error) {
+// NewRequest creates a request from a RESP value read from a client. Only
+// non-empty arrays of bulk strings make valid requests. Nil request is returned
+// for all other values without any error.
+func NewRequest(val Value) (*Request, error) {
 	arr, ok := val.(*Array)
 	if !ok {
 		return nil, nil
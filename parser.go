@@ -0,0 +1,265 @@
+package radio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrIncomplete is returned by Parser when the buffered data does not
+// contain a complete value yet.
+var ErrIncomplete = errors.New("incomplete value")
+
+// NewParser initializes a push-style RESP parser. See Parser for details.
+func NewParser(isServer bool) *Parser {
+	return &Parser{IsServer: isServer}
+}
+
+// Parser is a push-style (non-blocking) RESP parser. Data is fed to the
+// parser as it arrives using Feed and values are extracted using Next. Parser
+// follows the same server-mode and client-mode rules as Reader which makes it
+// suitable for event-loop based servers and custom transports that cannot
+// afford a blocking read per connection.
+type Parser struct {
+	// IsServer controls the RESP parsing mode. If set, only inline string
+	// and multi-bulk (array of bulk strings) will be enabled.
+	IsServer bool
+
+	buf   []byte
+	start int
+
+	// arr holds the top-level array being parsed. Items are parsed as they
+	// become available so that large arrays arriving in small chunks are
+	// not re-parsed from the beginning.
+	arr       *Array
+	remaining int
+	pos       int
+}
+
+// Feed appends the data to the internal buffer of the parser. The data is
+// copied and can be reused by the caller once Feed returns.
+func (p *Parser) Feed(data []byte) {
+	if p.start > 0 && p.start >= len(p.buf)-p.start {
+		// move the unread data to the front to reclaim space.
+		n := copy(p.buf, p.buf[p.start:])
+		p.buf = p.buf[:n]
+		p.pos -= p.start
+		p.start = 0
+	}
+
+	p.buf = append(p.buf, data...)
+}
+
+// Next returns the next complete value from the buffered data. ErrIncomplete
+// is returned if more data is required, in which case the buffered data is
+// retained and Next can be called again after feeding more data. Any other
+// error indicates a protocol error.
+func (p *Parser) Next() (Value, error) {
+	if p.arr == nil {
+		if p.start >= len(p.buf) {
+			return nil, ErrIncomplete
+		}
+
+		prefix := p.buf[p.start]
+		if prefix != '*' {
+			v, next, err := p.parse(p.start, false)
+			if err != nil {
+				return nil, err
+			}
+			p.start = next
+			return v, nil
+		}
+
+		size, next, err := p.readNumber(p.start + 1)
+		if err != nil {
+			if p.IsServer && (err == errInvalidNumber || err == errNoNumber) {
+				return nil, errors.New("Protocol error: invalid multibulk length")
+			}
+			return nil, err
+		}
+
+		if size < 0 {
+			p.start = next
+			return &Array{}, nil
+		}
+
+		p.arr = &Array{Items: []Value{}}
+		p.remaining = size
+		p.pos = next
+	}
+
+	for p.remaining > 0 {
+		item, next, err := p.parse(p.pos, true)
+		if err == ErrIncomplete {
+			return nil, err
+		} else if err != nil {
+			p.arr = nil
+			return nil, err
+		}
+
+		p.arr.Items = append(p.arr.Items, item)
+		p.remaining--
+		p.pos = next
+	}
+
+	arr := p.arr
+	p.arr = nil
+	p.start = p.pos
+	return arr, nil
+}
+
+// Buffered returns the number of bytes buffered but not yet returned as
+// values.
+func (p *Parser) Buffered() int {
+	return len(p.buf) - p.start
+}
+
+// Reset discards all the buffered data and any partially parsed value.
+func (p *Parser) Reset() {
+	p.buf = p.buf[:0]
+	p.start = 0
+	p.arr = nil
+	p.remaining = 0
+	p.pos = 0
+}
+
+// parse parses the value starting at pos and returns it along with the
+// position right after it.
+func (p *Parser) parse(pos int, inArray bool) (Value, int, error) {
+	if pos >= len(p.buf) {
+		return nil, pos, ErrIncomplete
+	}
+	prefix := p.buf[pos]
+
+	if p.IsServer {
+		if inArray && prefix != '$' {
+			return nil, pos, fmt.Errorf("Protocol error: expecting '$', got '%c'", prefix)
+		}
+
+		if prefix != '*' && prefix != '$' {
+			data, next, err := p.readLine(pos)
+			if err != nil {
+				return nil, pos, err
+			}
+
+			return &Array{Items: []Value{&BulkStr{Value: data}}}, next, nil
+		}
+	}
+
+	switch prefix {
+	case '+':
+		data, next, err := p.readLine(pos + 1)
+		return SimpleStr(data), next, err
+
+	case '-':
+		data, next, err := p.readLine(pos + 1)
+		return ErrorStr(data), next, err
+
+	case ':':
+		n, next, err := p.readNumber(pos + 1)
+		return Integer(n), next, err
+
+	case '$':
+		return p.parseBulkStr(pos + 1)
+
+	case '*':
+		return p.parseArray(pos + 1)
+
+	case '>':
+		v, next, err := p.parseArray(pos + 1)
+		if err != nil {
+			return nil, pos, err
+		}
+		return &Push{Items: v.(*Array).Items}, next, nil
+
+	case '%':
+		v, next, err := p.parseAggregate(pos+1, 2)
+		if err != nil {
+			return nil, pos, err
+		}
+		return &Map{Items: v.(*Array).Items}, next, nil
+	}
+
+	return nil, pos, fmt.Errorf("bad prefix '%c'", prefix)
+}
+
+func (p *Parser) parseBulkStr(pos int) (Value, int, error) {
+	size, next, err := p.readNumber(pos)
+	if err != nil {
+		if p.IsServer && (err == errInvalidNumber || err == errNoNumber) {
+			return nil, pos, errors.New("Protocol error: invalid bulk length")
+		}
+		return nil, pos, err
+	}
+
+	if size < 0 {
+		if p.IsServer {
+			return nil, pos, errors.New("Protocol error: invalid bulk length")
+		}
+
+		return &BulkStr{}, next, nil
+	}
+
+	if len(p.buf)-next < size+2 {
+		return nil, pos, ErrIncomplete
+	}
+
+	data := make([]byte, size)
+	copy(data, p.buf[next:next+size])
+	return &BulkStr{Value: data}, next + size + 2, nil
+}
+
+func (p *Parser) parseArray(pos int) (Value, int, error) {
+	return p.parseAggregate(pos, 1)
+}
+
+// parseAggregate parses an aggregate value of size elements of n items each
+// (e.g., 2 for the key and the value of the entries of maps).
+func (p *Parser) parseAggregate(pos, n int) (Value, int, error) {
+	size, next, err := p.readNumber(pos)
+	if err != nil {
+		return nil, pos, err
+	}
+
+	if size < 0 {
+		return &Array{}, next, nil
+	}
+
+	arr := &Array{Items: []Value{}}
+	for i := 0; i < size*n; i++ {
+		item, n, err := p.parse(next, true)
+		if err != nil {
+			return nil, pos, err
+		}
+
+		arr.Items = append(arr.Items, item)
+		next = n
+	}
+
+	return arr, next, nil
+}
+
+func (p *Parser) readLine(pos int) ([]byte, int, error) {
+	crlf := bytes.Index(p.buf[pos:], []byte("\r\n"))
+	if crlf < 0 {
+		return nil, pos, ErrIncomplete
+	}
+
+	data := make([]byte, crlf)
+	copy(data, p.buf[pos:pos+crlf])
+	return data, pos + crlf + 2, nil
+}
+
+func (p *Parser) readNumber(pos int) (int, int, error) {
+	data, next, err := p.readLine(pos)
+	if err != nil {
+		return 0, pos, err
+	}
+
+	if len(data) == 0 {
+		return 0, pos, errNoNumber
+	}
+
+	n, err := toInt(data)
+	return n, next, err
+}
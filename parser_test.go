@@ -0,0 +1,150 @@
+package radio_test
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spy16/radio"
+)
+
+func TestParser_Next(suite *testing.T) {
+	suite.Parallel()
+
+	// parser must follow the same rules as the reader except that it
+	// reports incomplete input instead of blocking for more data.
+	modes := map[string]struct {
+		cases    []readTestCase
+		isServer bool
+	}{
+		"ClientMode": {cases: clientModeCases, isServer: false},
+		"ServerMode": {cases: serverModeCases, isServer: true},
+	}
+
+	for name, mode := range modes {
+		for _, cs := range mode.cases {
+			cs, isServer := cs, mode.isServer
+			if cs.title == "" {
+				cs.title = cs.input
+			}
+
+			suite.Run(name+"/"+cs.title, func(t *testing.T) {
+				expectedErr := cs.err
+				if expectedErr == io.EOF {
+					expectedErr = radio.ErrIncomplete
+				}
+
+				p := radio.NewParser(isServer)
+				p.Feed([]byte(cs.input))
+				val, err := p.Next()
+
+				if !reflect.DeepEqual(expectedErr, err) {
+					t.Errorf("expecting error '%v', got '%v'", expectedErr, err)
+				}
+
+				if !reflect.DeepEqual(cs.val, val) {
+					t.Errorf("expecting RESP value '%s{%v}', got '%s{%v}'",
+						reflect.TypeOf(cs.val), cs.val, reflect.TypeOf(val), val)
+				}
+			})
+		}
+	}
+}
+
+func TestParser_Feed_ByteByByte(t *testing.T) {
+	table := []struct {
+		input    string
+		isServer bool
+	}{
+		{
+			input:    "*2\r\n$5\r\nhello\r\n$0\r\n\r\n*1\r\n$4\r\nPING\r\nPING\r\n$3\r\nfoo\r\n",
+			isServer: true,
+		},
+		{
+			input:    "+OK\r\n*3\r\n$5\r\nhello\r\n:10\r\n*1\r\n-ERR failed\r\n$-1\r\n*-1\r\n+\r\n:-5\r\n",
+			isServer: false,
+		},
+	}
+
+	for _, tt := range table {
+		var expected []radio.Value
+		rd := radio.NewReader(strings.NewReader(tt.input), tt.isServer)
+		for {
+			val, err := rd.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("not expecting error, got '%v'", err)
+			}
+			expected = append(expected, val)
+		}
+
+		var got []radio.Value
+		p := radio.NewParser(tt.isServer)
+		for i := 0; i < len(tt.input); i++ {
+			p.Feed([]byte{tt.input[i]})
+
+			for {
+				val, err := p.Next()
+				if err == radio.ErrIncomplete {
+					break
+				} else if err != nil {
+					t.Fatalf("not expecting error, got '%v'", err)
+				}
+				got = append(got, val)
+			}
+		}
+
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("expecting values %v, got %v", expected, got)
+		}
+
+		if p.Buffered() != 0 {
+			t.Errorf("expecting buffer to be drained, got %d bytes", p.Buffered())
+		}
+	}
+}
+
+func TestParser_Incomplete(t *testing.T) {
+	p := radio.NewParser(true)
+	p.Feed([]byte("*2\r\n$3\r\nGET\r\n$1\r"))
+
+	if _, err := p.Next(); err != radio.ErrIncomplete {
+		t.Fatalf("expecting error '%v', got '%v'", radio.ErrIncomplete, err)
+	}
+
+	if p.Buffered() != 16 {
+		t.Errorf("expecting incomplete input to be retained, got %d bytes", p.Buffered())
+	}
+
+	p.Feed([]byte("\na\r\n"))
+	val, err := p.Next()
+	if err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	req, _ := radio.NewRequest(val)
+	if req.Command != "GET" || !reflect.DeepEqual([]string{"a"}, req.Args) {
+		t.Errorf("expecting 'GET a', got '%s %v'", req.Command, req.Args)
+	}
+
+	p.Feed([]byte("*1\r\n"))
+	p.Reset()
+	if p.Buffered() != 0 {
+		t.Errorf("expecting empty buffer after reset, got %d bytes", p.Buffered())
+	}
+}
+
+func BenchmarkParser_Next(b *testing.B) {
+	data := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+	p := radio.NewParser(true)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.Feed(data)
+		if _, err := p.Next(); err != nil {
+			b.Fatalf("not expecting error, got '%v'", err)
+		}
+	}
+}
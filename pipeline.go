@@ -0,0 +1,176 @@
+package radio
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// pipelineLoop serves the requests of a connection concurrently using the
+// workers semaphore while writing the replies in the order of the requests.
+// At most depth requests are in-flight at any time.
+func pipelineLoop(ctx context.Context, rwc io.ReadWriteCloser, handler Handler, stats *serverStats, workers chan struct{}, depth int) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer rwc.Close()
+
+	// the connection is counted by Serve when accepted.
+	defer stats.disconnect()
+
+	rdr := NewReader(rwc, true)
+	out := &syncWriter{w: NewWriter(rwc)}
+	cl := newClient(ctx, rwc)
+	defer cl.close()
+	serial, _ := handler.(SerialHandler)
+
+	pending := make(chan *pendingReply, depth)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for pr := range pending {
+			<-pr.done
+			pr.flush()
+		}
+	}()
+	defer func() {
+		close(pending)
+		<-written
+	}()
+
+	var inflight sync.WaitGroup
+	var ahead *readResult
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		var val Value
+		var err error
+		if ahead != nil {
+			val, err = ahead.val, ahead.err
+			ahead = nil
+		} else {
+			val, err = rdr.Read()
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				pending <- failedReply(out, err)
+			}
+
+			// cancel the requests blocked by a Blocker since the client
+			// has gone away.
+			cancel()
+			return
+		}
+
+		req, err := NewRequest(val)
+		if err != nil {
+			pending <- failedReply(out, err)
+			return
+		}
+
+		if req == nil {
+			continue
+		}
+		req.Client = cl
+
+		// further requests are not served while the client is blocked.
+		if unblocked := cl.unblocked(); unblocked != nil {
+			ahead = waitUnblocked(unblocked, rdr, cancel)
+		}
+
+		pr := &pendingReply{out: out, done: make(chan struct{})}
+		pending <- pr
+
+		if serial != nil && serial.Serial(req) {
+			inflight.Wait()
+			handler.ServeRESP(pr, req)
+			if unblocked := cl.parked(req); unblocked != nil {
+				atomic.AddInt64(&stats.blocked, 1)
+				ahead = waitUnblocked(unblocked, rdr, cancel)
+				atomic.AddInt64(&stats.blocked, -1)
+			}
+			close(pr.done)
+			continue
+		}
+
+		workers <- struct{}{}
+		inflight.Add(1)
+		go func(pr *pendingReply, req *Request) {
+			defer func() {
+				close(pr.done)
+				inflight.Done()
+			}()
+
+			handler.ServeRESP(pr, req)
+			<-workers
+
+			// blocked requests do not hold a worker while waiting. Only the
+			// request that parked waits as the other requests of the client
+			// may be executing concurrently.
+			if unblocked := cl.parked(req); unblocked != nil {
+				atomic.AddInt64(&stats.blocked, 1)
+				<-unblocked
+				atomic.AddInt64(&stats.blocked, -1)
+			}
+		}(pr, req)
+	}
+}
+
+func failedReply(out *syncWriter, err error) *pendingReply {
+	pr := &pendingReply{out: out, done: make(chan struct{})}
+	pr.Write(ErrorStr("ERR " + err.Error()))
+	close(pr.done)
+	return pr
+}
+
+// pendingReply buffers the values written for a request until it is flushed
+// (e.g., after all the replies of the preceding requests are written). Values
+// written after the reply is flushed are written directly to out.
+type pendingReply struct {
+	out  ResponseWriter
+	done chan struct{}
+
+	mu      sync.Mutex
+	vals    []Value
+	flushed bool
+}
+
+func (pr *pendingReply) Write(v Value) (int, error) {
+	pr.mu.Lock()
+	if pr.flushed {
+		pr.mu.Unlock()
+		return pr.out.Write(v)
+	}
+	defer pr.mu.Unlock()
+
+	pr.vals = append(pr.vals, v)
+	return 0, nil
+}
+
+func (pr *pendingReply) flush() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	for _, v := range pr.vals {
+		pr.out.Write(v)
+	}
+	pr.vals = nil
+	pr.flushed = true
+}
+
+// syncWriter serializes the writes to the connection.
+type syncWriter struct {
+	mu sync.Mutex
+	w  *Writer
+}
+
+func (sw *syncWriter) Write(v Value) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Write(v)
+}
@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+const maxPending = 1024
+
+var errConnClosed = errors.New("backend connection closed")
+
+// backend represents a single upstream server along with its pool of
+// pipelined connections and health state.
+type backend struct {
+	addr string
+	opts *Options
+
+	next uint32
+
+	mu       sync.Mutex
+	conns    []*pipeConn
+	failures int
+	ejected  bool
+	closed   bool
+}
+
+func newBackend(addr string, opts *Options) *backend {
+	return &backend{
+		addr:  addr,
+		opts:  opts,
+		conns: make([]*pipeConn, opts.PoolSize),
+	}
+}
+
+// do sends the request over one of the pooled connections and returns the
+// reply exactly as received from the backend.
+func (b *backend) do(req *radio.Request) (radio.Raw, error) {
+	pc, err := b.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	return pc.do(req)
+}
+
+func (b *backend) conn() (*pipeConn, error) {
+	i := int(atomic.AddUint32(&b.next, 1) % uint32(len(b.conns)))
+
+	b.mu.Lock()
+	pc := b.conns[i]
+	b.mu.Unlock()
+	if pc != nil && !pc.broken() {
+		return pc, nil
+	}
+
+	// the lock is not held while dialing so that the requests and health
+	// checks of an unreachable backend do not queue behind the dials.
+	pc, err := dialPipe(b.addr, b.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		pc.close()
+		return nil, errConnClosed
+	}
+
+	if cur := b.conns[i]; cur != nil && !cur.broken() {
+		// another request replaced the connection in the meantime.
+		pc.close()
+		return cur, nil
+	}
+	b.conns[i] = pc
+	return pc, nil
+}
+
+// ping checks the health of the backend using a separate connection so that
+// a hung backend does not block the check.
+func (b *backend) ping() error {
+	con, err := net.DialTimeout("tcp", b.addr, b.opts.DialTimeout)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	con.SetDeadline(time.Now().Add(b.opts.DialTimeout))
+	if _, err := radio.NewWriter(con).Write(&radio.Request{Command: "PING"}); err != nil {
+		return err
+	}
+
+	v, err := radio.NewReader(con, false).Read()
+	if err != nil {
+		return err
+	}
+
+	if errStr, ok := v.(radio.ErrorStr); ok {
+		return errors.New(string(errStr))
+	}
+	return nil
+}
+
+func (b *backend) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.closeConns()
+}
+
+// closeConns closes the connections failing the requests waiting for their
+// replies. b.mu must be held.
+func (b *backend) closeConns() {
+	for i, pc := range b.conns {
+		if pc != nil {
+			pc.close()
+			b.conns[i] = nil
+		}
+	}
+}
+
+// pipeConn multiplexes requests from many clients over a single backend
+// connection. Requests are written in the order they are queued and the
+// replies are matched to the requests in the same order.
+type pipeConn struct {
+	con     net.Conn
+	rd      *radio.Reader
+	writers int32
+	pending chan *call
+	closed  chan struct{}
+	once    sync.Once
+
+	mu  sync.Mutex
+	bw  *bufio.Writer
+	err error
+}
+
+type call struct {
+	reply radio.Raw
+	err   error
+	done  chan struct{}
+}
+
+func dialPipe(addr string, timeout time.Duration) (*pipeConn, error) {
+	con, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &pipeConn{
+		con:     con,
+		rd:      radio.NewReader(con, false),
+		bw:      bufio.NewWriter(con),
+		pending: make(chan *call, maxPending),
+		closed:  make(chan struct{}),
+	}
+	go pc.readLoop()
+	return pc, nil
+}
+
+func (pc *pipeConn) do(req *radio.Request) (radio.Raw, error) {
+	c := &call{done: make(chan struct{})}
+
+	// the last writer among the concurrent ones flushes the buffer so that
+	// requests queued together are sent together.
+	atomic.AddInt32(&pc.writers, 1)
+	pc.mu.Lock()
+	err := pc.err
+	if err == nil {
+		if _, err = pc.bw.WriteString(req.Serialize()); err == nil {
+			select {
+			case pc.pending <- c:
+			case <-pc.closed:
+				err = errConnClosed
+			}
+		}
+	}
+
+	if atomic.AddInt32(&pc.writers, -1) == 0 && err == nil {
+		err = pc.bw.Flush()
+	}
+
+	if err != nil && pc.err == nil {
+		pc.err = err
+		pc.con.Close()
+	}
+	pc.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	<-c.done
+	return c.reply, c.err
+}
+
+func (pc *pipeConn) readLoop() {
+	for {
+		var c *call
+		select {
+		case c = <-pc.pending:
+		case <-pc.closed:
+			pc.fail(errConnClosed)
+			return
+		}
+
+		raw, err := pc.rd.ReadRaw()
+		if err != nil {
+			c.err = err
+			close(c.done)
+			pc.fail(err)
+			return
+		}
+
+		c.reply = raw
+		close(c.done)
+	}
+}
+
+// fail marks the connection as broken and fails all the pending calls. No
+// call can be queued after the error is set.
+func (pc *pipeConn) fail(err error) {
+	pc.once.Do(func() { close(pc.closed) })
+
+	pc.mu.Lock()
+	if pc.err == nil {
+		pc.err = err
+	}
+	pc.mu.Unlock()
+	pc.con.Close()
+
+	for {
+		select {
+		case c := <-pc.pending:
+			c.err = err
+			close(c.done)
+
+		default:
+			return
+		}
+	}
+}
+
+func (pc *pipeConn) broken() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.err != nil
+}
+
+func (pc *pipeConn) close() {
+	pc.once.Do(func() { close(pc.closed) })
+	pc.con.Close()
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"crypto/md5"
+	"sort"
+	"strconv"
+)
+
+const (
+	ketamaDigests = 40 // each md5 digest gives 4 points on the continuum.
+)
+
+// ketama implements the ketama consistent hashing continuum as used by
+// libketama and twemproxy so that keys are distributed identically.
+type ketama struct {
+	points []ketamaPoint
+}
+
+type ketamaPoint struct {
+	hash uint32
+	node int
+}
+
+// newKetama builds the continuum for the named nodes. Lookups return the
+// index of the node in the given slice.
+func newKetama(names []string) *ketama {
+	k := &ketama{}
+	for node, name := range names {
+		for i := 0; i < ketamaDigests; i++ {
+			digest := md5.Sum([]byte(name + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				k.points = append(k.points, ketamaPoint{
+					hash: ketamaHash(digest[j*4 : j*4+4]),
+					node: node,
+				})
+			}
+		}
+	}
+
+	sort.Slice(k.points, func(i, j int) bool {
+		return k.points[i].hash < k.points[j].hash
+	})
+	return k
+}
+
+// get returns the index of the node owning the key or -1 if there are no
+// nodes in the continuum.
+func (k *ketama) get(key string) int {
+	if len(k.points) == 0 {
+		return -1
+	}
+
+	digest := md5.Sum([]byte(key))
+	hash := ketamaHash(digest[:4])
+	i := sort.Search(len(k.points), func(i int) bool {
+		return k.points[i].hash >= hash
+	})
+
+	if i == len(k.points) {
+		i = 0
+	}
+	return k.points[i].node
+}
+
+func ketamaHash(b []byte) uint32 {
+	return uint32(b[3])<<24 | uint32(b[2])<<16 | uint32(b[1])<<8 | uint32(b[0])
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKetama(t *testing.T) {
+	nodes := []string{"10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"}
+	ring := newKetama(nodes)
+
+	counts := make([]int, len(nodes))
+	owners := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		owners[key] = ring.get(key)
+		counts[owners[key]]++
+	}
+
+	for i, count := range counts {
+		if count < 500 {
+			t.Errorf("expecting keys to be spread evenly, node %d got %d of 3000", i, count)
+		}
+	}
+
+	// removing a node must only move the keys owned by that node.
+	reduced := newKetama([]string{nodes[0], nodes[2]})
+	for key, owner := range owners {
+		if owner == 1 {
+			continue
+		}
+
+		if actual := nodes[[]int{0, 2}[reduced.get(key)]]; actual != nodes[owner] {
+			t.Errorf("expecting key '%s' to stay on '%s', moved to '%s'", key, nodes[owner], actual)
+		}
+	}
+
+	if newKetama(nil).get("key") != -1 {
+		t.Errorf("expecting -1 from empty ring")
+	}
+}
@@ -0,0 +1,378 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+// Options control the behaviour of the proxy. Zero values are replaced with
+// defaults.
+type Options struct {
+	// PoolSize is the number of pipelined connections maintained with each
+	// backend. Defaults to 4.
+	PoolSize int
+
+	// DialTimeout is the timeout for connecting to backends and for health
+	// checks. Defaults to 1 second.
+	DialTimeout time.Duration
+
+	// HealthCheckInterval is the interval between health checks of the
+	// backends. Defaults to 1 second.
+	HealthCheckInterval time.Duration
+
+	// FailureLimit is the number of consecutive failures after which a
+	// backend is ejected from the hash ring. The connections of an ejected
+	// backend are closed, failing the requests waiting for its replies.
+	// Ejected backends are added back once a health check succeeds.
+	// Defaults to 3.
+	FailureLimit int
+
+	// KeySpecs is used to find the keys of requests. Defaults to
+	// radio.DefaultKeySpecs.
+	KeySpecs radio.KeySpecs
+}
+
+// New initializes a proxy for the backends at given addresses and starts
+// checking their health in the background. Close must be called to release
+// the connections.
+func New(addrs []string, opts Options) *Proxy {
+	opts.setDefaults()
+
+	p := &Proxy{
+		opts: opts,
+		done: make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		p.backends = append(p.backends, newBackend(addr, &p.opts))
+	}
+	p.rebuild()
+
+	go p.healthLoop()
+	return p
+}
+
+// Proxy is a radio.Handler which forwards requests to backends chosen by
+// ketama consistent hashing of the keys of the request. Requests with keys
+// on different backends are rejected. Requests from
+// all the clients are pipelined over a pool of connections per backend and
+// the replies are relayed verbatim. MGET, MSET, DEL, UNLINK, EXISTS and
+// TOUCH are split across backends and their replies are merged.
+type Proxy struct {
+	opts     Options
+	backends []*backend
+	done     chan struct{}
+
+	mu   sync.RWMutex
+	ring *ketama
+	live []*backend
+}
+
+// ServeRESP forwards the request to the backends.
+func (p *Proxy) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	cmd := strings.ToLower(req.Command)
+	switch cmd {
+	case "ping":
+		if len(req.Args) > 0 {
+			wr.Write(&radio.BulkStr{Value: []byte(req.Args[0])})
+			return
+		}
+		wr.Write(radio.SimpleStr("PONG"))
+		return
+
+	case "quit":
+		wr.Write(radio.SimpleStr("OK"))
+		return
+	}
+
+	keys, ok := p.opts.KeySpecs.Resolve(req)
+	if !ok && radio.MovableKeys[cmd] {
+		wr.Write(radio.ErrorStr(fmt.Sprintf("ERR keys of command '%s' could not be determined by proxy", cmd)))
+		return
+	}
+
+	if len(keys) == 0 {
+		wr.Write(radio.ErrorStr(fmt.Sprintf("ERR command '%s' is not supported by proxy", cmd)))
+		return
+	}
+
+	switch cmd {
+	case "mget":
+		p.mget(wr, req)
+
+	case "mset":
+		p.mset(wr, req)
+
+	case "del", "unlink", "exists", "touch":
+		p.count(wr, req)
+
+	default:
+		b := p.pick(keys[0])
+		for _, key := range keys[1:] {
+			if p.pick(key) != b {
+				wr.Write(radio.ErrorStr("ERR keys in request don't map to the same backend"))
+				return
+			}
+		}
+		wr.Write(p.forward(b, req))
+	}
+}
+
+// Close stops the health checks and closes all the backend connections.
+func (p *Proxy) Close() error {
+	close(p.done)
+	for _, b := range p.backends {
+		b.close()
+	}
+	return nil
+}
+
+func (p *Proxy) mget(wr radio.ResponseWriter, req *radio.Request) {
+	groups, order := p.split(req.Args, 1)
+	replies := p.fanout(req.Command, groups, order)
+
+	result := &radio.Array{Items: make([]radio.Value, len(req.Args))}
+	for _, b := range order {
+		arr, ok := replies[b].(*radio.Array)
+		if !ok || len(arr.Items) != len(groups[b].positions) {
+			wr.Write(errorReply(replies[b]))
+			return
+		}
+
+		for i, pos := range groups[b].positions {
+			result.Items[pos] = arr.Items[i]
+		}
+	}
+
+	wr.Write(result)
+}
+
+func (p *Proxy) mset(wr radio.ResponseWriter, req *radio.Request) {
+	if len(req.Args) == 0 || len(req.Args)%2 != 0 {
+		wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'mset' command"))
+		return
+	}
+
+	groups, order := p.split(req.Args, 2)
+	replies := p.fanout(req.Command, groups, order)
+	for _, b := range order {
+		if _, ok := replies[b].(radio.SimpleStr); !ok {
+			wr.Write(errorReply(replies[b]))
+			return
+		}
+	}
+
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func (p *Proxy) count(wr radio.ResponseWriter, req *radio.Request) {
+	groups, order := p.split(req.Args, 1)
+	replies := p.fanout(req.Command, groups, order)
+
+	var total radio.Integer
+	for _, b := range order {
+		n, ok := replies[b].(radio.Integer)
+		if !ok {
+			wr.Write(errorReply(replies[b]))
+			return
+		}
+		total += n
+	}
+
+	wr.Write(total)
+}
+
+// subRequest holds the arguments of a multi-key request that map to a single
+// backend along with their positions in the original request.
+type subRequest struct {
+	args      []string
+	positions []int
+}
+
+// split groups the arguments of a multi-key request by backend. Each group
+// of step arguments starts with a key.
+func (p *Proxy) split(args []string, step int) (map[*backend]*subRequest, []*backend) {
+	groups := map[*backend]*subRequest{}
+	var order []*backend
+
+	for i := 0; i+step <= len(args); i += step {
+		b := p.pick(args[i])
+		sub, found := groups[b]
+		if !found {
+			sub = &subRequest{}
+			groups[b] = sub
+			order = append(order, b)
+		}
+
+		sub.args = append(sub.args, args[i:i+step]...)
+		sub.positions = append(sub.positions, i/step)
+	}
+
+	return groups, order
+}
+
+// fanout sends the sub-requests to their backends concurrently and returns
+// the parsed replies.
+func (p *Proxy) fanout(cmd string, groups map[*backend]*subRequest, order []*backend) map[*backend]radio.Value {
+	replies := make([]radio.Value, len(order))
+
+	var wg sync.WaitGroup
+	for i, b := range order {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+
+			raw := p.forward(b, &radio.Request{Command: cmd, Args: groups[b].args})
+			if v, ok := raw.(radio.Raw); ok {
+				parsed, err := radio.NewReader(bytes.NewReader(v), false).Read()
+				if err != nil {
+					replies[i] = radio.ErrorStr("ERR invalid reply from backend: " + err.Error())
+					return
+				}
+				replies[i] = parsed
+				return
+			}
+			replies[i] = raw
+		}(i, b)
+	}
+	wg.Wait()
+
+	result := map[*backend]radio.Value{}
+	for i, b := range order {
+		result[b] = replies[i]
+	}
+	return result
+}
+
+// forward sends the request to the backend and returns the raw reply or an
+// error reply if the backend could not be reached.
+func (p *Proxy) forward(b *backend, req *radio.Request) radio.Value {
+	if b == nil {
+		return radio.ErrorStr("ERR no backend available")
+	}
+
+	raw, err := b.do(req)
+	if err != nil {
+		p.recordFailure(b)
+		return radio.ErrorStr(fmt.Sprintf("ERR backend '%s' failed: %v", b.addr, err))
+	}
+
+	return raw
+}
+
+func (p *Proxy) pick(key string) *backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	i := p.ring.get(key)
+	if i < 0 {
+		return nil
+	}
+	return p.live[i]
+}
+
+func (p *Proxy) healthLoop() {
+	ticker := time.NewTicker(p.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+
+		case <-ticker.C:
+			for _, b := range p.backends {
+				if err := b.ping(); err != nil {
+					p.recordFailure(b)
+				} else {
+					p.recordSuccess(b)
+				}
+			}
+		}
+	}
+}
+
+func (p *Proxy) recordFailure(b *backend) {
+	b.mu.Lock()
+	b.failures++
+	eject := !b.ejected && b.failures >= p.opts.FailureLimit
+	if eject {
+		// the requests waiting on a hung backend fail instead of waiting
+		// for replies that may never arrive.
+		b.ejected = true
+		b.closeConns()
+	}
+	b.mu.Unlock()
+
+	if eject {
+		p.rebuild()
+	}
+}
+
+func (p *Proxy) recordSuccess(b *backend) {
+	b.mu.Lock()
+	b.failures = 0
+	restore := b.ejected
+	b.ejected = false
+	b.mu.Unlock()
+
+	if restore {
+		p.rebuild()
+	}
+}
+
+// rebuild recreates the hash ring using the backends that are not ejected.
+func (p *Proxy) rebuild() {
+	var live []*backend
+	var names []string
+	for _, b := range p.backends {
+		b.mu.Lock()
+		ejected := b.ejected
+		b.mu.Unlock()
+
+		if !ejected {
+			live = append(live, b)
+			names = append(names, b.addr)
+		}
+	}
+
+	ring := newKetama(names)
+
+	p.mu.Lock()
+	p.ring = ring
+	p.live = live
+	p.mu.Unlock()
+}
+
+func (opts *Options) setDefaults() {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 4
+	}
+
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 1 * time.Second
+	}
+
+	if opts.HealthCheckInterval <= 0 {
+		opts.HealthCheckInterval = 1 * time.Second
+	}
+
+	if opts.FailureLimit <= 0 {
+		opts.FailureLimit = 3
+	}
+
+	if opts.KeySpecs == nil {
+		opts.KeySpecs = radio.DefaultKeySpecs
+	}
+}
+
+func errorReply(v radio.Value) radio.Value {
+	if _, ok := v.(radio.ErrorStr); ok {
+		return v
+	}
+	return radio.ErrorStr("ERR unexpected reply from backend")
+}
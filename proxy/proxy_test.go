@@ -0,0 +1,441 @@
+package proxy_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/proxy"
+)
+
+func TestProxy_Routing(t *testing.T) {
+	backends := startBackends(t, 3)
+	defer backends.close()
+
+	p := proxy.New(backends.addrs(), proxy.Options{})
+	defer p.Close()
+	conn := serveProxy(t, p)
+	defer conn.Close()
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if v, err := conn.Do("SET", key, strconv.Itoa(i)); err != nil || v != radio.SimpleStr("OK") {
+			t.Fatalf("SET failed: %v (err=%v)", v, err)
+		}
+	}
+
+	total := 0
+	for _, b := range backends {
+		if b.size() == 0 {
+			t.Errorf("expecting keys to be distributed to all backends")
+		}
+		total += b.size()
+	}
+
+	if total != 100 {
+		t.Errorf("expecting each key to be stored exactly once, got %d keys", total)
+	}
+
+	for i := 0; i < 100; i++ {
+		v, err := conn.Do("GET", "key"+strconv.Itoa(i))
+		if err != nil || v.(*radio.BulkStr).String() != strconv.Itoa(i) {
+			t.Errorf("expecting '%d', got '%v' (err=%v)", i, v, err)
+		}
+	}
+}
+
+func TestProxy_FanOut(t *testing.T) {
+	backends := startBackends(t, 3)
+	defer backends.close()
+
+	p := proxy.New(backends.addrs(), proxy.Options{})
+	defer p.Close()
+	conn := serveProxy(t, p)
+	defer conn.Close()
+
+	args := []string{}
+	keys := []string{}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, "k"+strconv.Itoa(i))
+		args = append(args, "k"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+
+	if v, err := conn.Do("MSET", args...); err != nil || v != radio.SimpleStr("OK") {
+		t.Fatalf("expecting 'OK' for MSET, got '%v' (err=%v)", v, err)
+	}
+
+	v, err := conn.Do("MGET", append(keys, "missing")...)
+	if err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	expected := &radio.Array{}
+	for i := 0; i < 10; i++ {
+		expected.Items = append(expected.Items, &radio.BulkStr{Value: []byte("v" + strconv.Itoa(i))})
+	}
+	expected.Items = append(expected.Items, &radio.BulkStr{})
+
+	if !reflect.DeepEqual(expected, v) {
+		t.Errorf("expecting MGET reply '%v', got '%v'", expected, v)
+	}
+
+	if v, err := conn.Do("EXISTS", "k1", "k2", "missing"); err != nil || v != radio.Integer(2) {
+		t.Errorf("expecting 2 for EXISTS, got '%v' (err=%v)", v, err)
+	}
+
+	if v, err := conn.Do("DEL", keys...); err != nil || v != radio.Integer(10) {
+		t.Errorf("expecting 10 for DEL, got '%v' (err=%v)", v, err)
+	}
+}
+
+func TestProxy_MovableKeys(t *testing.T) {
+	backends := startBackends(t, 3)
+	defer backends.close()
+
+	p := proxy.New(backends.addrs(), proxy.Options{})
+	defer p.Close()
+
+	// find two keys stored on different backends.
+	owners := map[string]*testBackend{}
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		p.ServeRESP(&recorder{}, &radio.Request{Command: "SET", Args: []string{key, "v"}})
+		for _, b := range backends {
+			if b.has(key) {
+				owners[key] = b
+			}
+		}
+	}
+
+	a, b := "key0", ""
+	for key, owner := range owners {
+		if owner != owners[a] {
+			b = key
+		}
+	}
+	if b == "" {
+		t.Fatalf("expecting keys to be distributed to multiple backends")
+	}
+
+	rec := &recorder{}
+	p.ServeRESP(rec, &radio.Request{Command: "ZUNIONSTORE", Args: []string{a, "2", a, b}})
+	p.ServeRESP(rec, &radio.Request{Command: "SINTERCARD", Args: []string{"2", a, b}})
+	p.ServeRESP(rec, &radio.Request{Command: "ZUNIONSTORE", Args: []string{a, "1", a}})
+	p.ServeRESP(rec, &radio.Request{Command: "ZUNIONSTORE", Args: []string{a, "two", a, b}})
+
+	expected := []radio.Value{
+		radio.ErrorStr("ERR keys in request don't map to the same backend"),
+		radio.ErrorStr("ERR keys in request don't map to the same backend"),
+		radio.ErrorStr("ERR unknown command"),
+		radio.ErrorStr("ERR keys of command 'zunionstore' could not be determined by proxy"),
+	}
+	if len(rec.vals) != len(expected) {
+		t.Fatalf("expecting %d replies, got %v", len(expected), rec.vals)
+	}
+	for i := range expected {
+		if got := rec.vals[i].Serialize(); got != expected[i].Serialize() {
+			t.Errorf("reply %d: expecting '%s', got '%s'", i, expected[i].Serialize(), got)
+		}
+	}
+}
+
+func TestProxy_Concurrent(t *testing.T) {
+	backends := startBackends(t, 2)
+	defer backends.close()
+
+	p := proxy.New(backends.addrs(), proxy.Options{PoolSize: 1})
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for c := 0; c < 10; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+
+			for i := 0; i < 50; i++ {
+				key := strconv.Itoa(c) + ":" + strconv.Itoa(i)
+				rec := &recorder{}
+				p.ServeRESP(rec, &radio.Request{Command: "SET", Args: []string{key, key}})
+				p.ServeRESP(rec, &radio.Request{Command: "GET", Args: []string{key}})
+
+				if got := rec.vals[1].Serialize(); got != (&radio.BulkStr{Value: []byte(key)}).Serialize() {
+					t.Errorf("expecting '%s', got '%s'", key, got)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+func TestProxy_Ejection(t *testing.T) {
+	backends := startBackends(t, 3)
+	defer backends.close()
+
+	p := proxy.New(backends.addrs(), proxy.Options{
+		HealthCheckInterval: 5 * time.Millisecond,
+		DialTimeout:         100 * time.Millisecond,
+		FailureLimit:        2,
+	})
+	defer p.Close()
+
+	backends[1].stop()
+
+	// wait for the failed backend to be ejected after which all the keys
+	// must be served by the remaining backends.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		failed := 0
+		for i := 0; i < 30; i++ {
+			rec := &recorder{}
+			p.ServeRESP(rec, &radio.Request{Command: "SET", Args: []string{"key" + strconv.Itoa(i), "v"}})
+			if _, isErr := rec.vals[0].(radio.ErrorStr); isErr {
+				failed++
+			}
+		}
+
+		if failed == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("backend was not ejected, %d requests failed", failed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if backends[0].size()+backends[2].size() != 30 {
+		t.Errorf("expecting all keys to be served by live backends")
+	}
+}
+
+func TestProxy_HungBackend(t *testing.T) {
+	// the backend accepts connections but never replies.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		var cons []net.Conn
+		defer func() {
+			for _, con := range cons {
+				con.Close()
+			}
+		}()
+
+		for {
+			con, err := l.Accept()
+			if err != nil {
+				return
+			}
+			cons = append(cons, con)
+		}
+	}()
+
+	p := proxy.New([]string{l.Addr().String()}, proxy.Options{
+		HealthCheckInterval: 5 * time.Millisecond,
+		DialTimeout:         20 * time.Millisecond,
+		FailureLimit:        2,
+	})
+	defer p.Close()
+
+	replied := make(chan radio.Value, 1)
+	go func() {
+		rec := &recorder{}
+		p.ServeRESP(rec, &radio.Request{Command: "GET", Args: []string{"a"}})
+		replied <- rec.vals[0]
+	}()
+
+	select {
+	case v := <-replied:
+		if _, isErr := v.(radio.ErrorStr); !isErr {
+			t.Errorf("expecting error reply, got '%v'", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expecting request to fail once the hung backend is ejected")
+	}
+}
+
+func TestProxy_Unsupported(t *testing.T) {
+	p := proxy.New(nil, proxy.Options{})
+	defer p.Close()
+
+	rec := &recorder{}
+	p.ServeRESP(rec, &radio.Request{Command: "PING"})
+	p.ServeRESP(rec, &radio.Request{Command: "FLUSHALL"})
+	p.ServeRESP(rec, &radio.Request{Command: "GET", Args: []string{"a"}})
+
+	expected := []radio.Value{
+		radio.SimpleStr("PONG"),
+		radio.ErrorStr("ERR command 'flushall' is not supported by proxy"),
+		radio.ErrorStr("ERR no backend available"),
+	}
+	if !reflect.DeepEqual(expected, rec.vals) {
+		t.Errorf("expecting replies %v, got %v", expected, rec.vals)
+	}
+}
+
+type testBackend struct {
+	addr   string
+	cancel context.CancelFunc
+	l      net.Listener
+
+	mu    sync.Mutex
+	cons  []net.Conn
+	store map[string]string
+}
+
+func (tb *testBackend) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	switch req.Command {
+	case "PING":
+		wr.Write(radio.SimpleStr("PONG"))
+
+	case "SET":
+		tb.store[req.Args[0]] = req.Args[1]
+		wr.Write(radio.SimpleStr("OK"))
+
+	case "MSET":
+		for i := 0; i < len(req.Args); i += 2 {
+			tb.store[req.Args[i]] = req.Args[i+1]
+		}
+		wr.Write(radio.SimpleStr("OK"))
+
+	case "GET":
+		wr.Write(tb.get(req.Args[0]))
+
+	case "MGET":
+		arr := &radio.Array{Items: []radio.Value{}}
+		for _, key := range req.Args {
+			arr.Items = append(arr.Items, tb.get(key))
+		}
+		wr.Write(arr)
+
+	case "DEL", "EXISTS":
+		count := 0
+		for _, key := range req.Args {
+			if _, found := tb.store[key]; found {
+				count++
+				if req.Command == "DEL" {
+					delete(tb.store, key)
+				}
+			}
+		}
+		wr.Write(radio.Integer(count))
+
+	default:
+		wr.Write(radio.ErrorStr("ERR unknown command"))
+	}
+}
+
+func (tb *testBackend) get(key string) radio.Value {
+	val, found := tb.store[key]
+	if !found {
+		return &radio.BulkStr{}
+	}
+	return &radio.BulkStr{Value: []byte(val)}
+}
+
+func (tb *testBackend) has(key string) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	_, found := tb.store[key]
+	return found
+}
+
+func (tb *testBackend) size() int {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return len(tb.store)
+}
+
+func (tb *testBackend) Accept() (net.Conn, error) {
+	con, err := tb.l.Accept()
+	if err == nil {
+		tb.mu.Lock()
+		tb.cons = append(tb.cons, con)
+		tb.mu.Unlock()
+	}
+	return con, err
+}
+
+func (tb *testBackend) Close() error   { return tb.l.Close() }
+func (tb *testBackend) Addr() net.Addr { return tb.l.Addr() }
+
+func (tb *testBackend) stop() {
+	tb.cancel()
+	tb.l.Close()
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for _, con := range tb.cons {
+		con.Close()
+	}
+}
+
+type testBackends []*testBackend
+
+func startBackends(t *testing.T, n int) testBackends {
+	var backends testBackends
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		tb := &testBackend{
+			addr:   l.Addr().String(),
+			cancel: cancel,
+			l:      l,
+			store:  map[string]string{},
+		}
+		go radio.ListenAndServe(ctx, tb, tb)
+		backends = append(backends, tb)
+	}
+	return backends
+}
+
+func (backends testBackends) addrs() []string {
+	var addrs []string
+	for _, b := range backends {
+		addrs = append(addrs, b.addr)
+	}
+	return addrs
+}
+
+func (backends testBackends) close() {
+	for _, b := range backends {
+		b.stop()
+	}
+}
+
+func serveProxy(t *testing.T, p *proxy.Proxy) *radio.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	go radio.ListenAndServe(context.Background(), l, p)
+	conn, err := radio.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	return conn
+}
+
+type recorder struct {
+	vals []radio.Value
+}
+
+func (rec *recorder) Write(v radio.Value) (int, error) {
+	rec.vals = append(rec.vals, v)
+	return 0, nil
+}
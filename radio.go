@@ -14,6 +14,28 @@ type ResponseWriter interface {
 type Request struct {
 	Command string
 	Args    []string
+
+	// Client is the client that sent the request. This is set by the server
+	// and is nil when not available.
+	Client *Client
+
+	// parked is closed once the request parked by a Blocker is served.
+	parked chan struct{}
+}
+
+// Serialize returns the RESP representation of the request as an array of
+// bulk strings. This allows a request to be written to a server using Writer.
+func (req *Request) Serialize() string {
+	arr := &Array{
+		Items: make([]Value, 0, len(req.Args)+1),
+	}
+
+	arr.Items = append(arr.Items, &BulkStr{Value: []byte(req.Command)})
+	for _, arg := range req.Args {
+		arr.Items = append(arr.Items, &BulkStr{Value: []byte(arg)})
+	}
+
+	return arr.Serialize()
 }
 
 // HandlerFunc implements Handler interface using a function type.
@@ -0,0 +1,286 @@
+package radiotest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+// Expect returns a step of a FakeServer script expecting the command with
+// exactly the given arguments. Command names are matched case-insensitively.
+func Expect(cmd string, args ...string) *Step {
+	return &Step{Command: cmd, Args: args}
+}
+
+// Step is a single exchange in the script of a FakeServer: a request the
+// server expects and what it does in response.
+type Step struct {
+	Command string
+	Args    []string
+
+	replies    []radio.Value
+	raw        string
+	delay      time.Duration
+	partial    int
+	chunk      int
+	chunkDelay time.Duration
+	disconnect bool
+}
+
+// Reply sets the values to be written in response to the request.
+func (st *Step) Reply(vals ...radio.Value) *Step {
+	st.replies = append(st.replies, vals...)
+	return st
+}
+
+// Raw sets data to be written verbatim after the replies. This can be used
+// to send malformed responses.
+func (st *Step) Raw(data string) *Step {
+	st.raw = data
+	return st
+}
+
+// Delay makes the server wait before responding.
+func (st *Step) Delay(d time.Duration) *Step {
+	st.delay = d
+	return st
+}
+
+// Partial makes the server write only the first n bytes of the response and
+// then close the connection.
+func (st *Step) Partial(n int) *Step {
+	st.partial = n
+	return st
+}
+
+// Chunked makes the server write the response in chunks of n bytes waiting
+// for the given duration between chunks.
+func (st *Step) Chunked(n int, delay time.Duration) *Step {
+	st.chunk = n
+	st.chunkDelay = delay
+	return st
+}
+
+// Disconnect makes the server close the connection after the response (if
+// any) is written.
+func (st *Step) Disconnect() *Step {
+	st.disconnect = true
+	return st
+}
+
+func (st *Step) String() string {
+	return strings.TrimSpace(st.Command + " " + strings.Join(st.Args, " "))
+}
+
+func (st *Step) matches(req *radio.Request) bool {
+	if !strings.EqualFold(st.Command, req.Command) || len(st.Args) != len(req.Args) {
+		return false
+	}
+
+	for i := range st.Args {
+		if st.Args[i] != req.Args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (st *Step) response() string {
+	var sb strings.Builder
+	for _, v := range st.replies {
+		sb.WriteString(v.Serialize())
+	}
+	sb.WriteString(st.raw)
+
+	resp := sb.String()
+	if st.partial > 0 && st.partial < len(resp) {
+		resp = resp[:st.partial]
+	}
+	return resp
+}
+
+// NewFakeServer starts a server on a loopback address which serves the
+// requests by following the script. Steps are consumed in order across all
+// the connections so that a script can cover reconnects. Requests that do
+// not match the next step are reported as test errors and get an error
+// reply. Close must be called to shut the server down and check that all
+// the steps were consumed.
+func NewFakeServer(t testing.TB, steps ...*Step) *FakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("radiotest: failed to listen: %v", err)
+	}
+
+	fs := &FakeServer{
+		Addr:  l.Addr().String(),
+		t:     t,
+		l:     l,
+		steps: steps,
+		conns: map[net.Conn]struct{}{},
+	}
+
+	fs.wg.Add(1)
+	go fs.acceptLoop()
+	return fs
+}
+
+// FakeServer is a scripted RESP server for testing clients.
+type FakeServer struct {
+	// Addr is the address the server is listening on in the form host:port.
+	Addr string
+
+	t  testing.TB
+	l  net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	steps    []*Step
+	conns    map[net.Conn]struct{}
+	requests []*radio.Request
+	closed   bool
+}
+
+// Requests returns all the requests received so far.
+func (fs *FakeServer) Requests() []*radio.Request {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return append([]*radio.Request(nil), fs.requests...)
+}
+
+// Close stops the server, closes all connections and reports the steps that
+// were not consumed as test errors.
+func (fs *FakeServer) Close() {
+	fs.mu.Lock()
+	fs.closed = true
+	for con := range fs.conns {
+		con.Close()
+	}
+	remaining := fs.steps
+	fs.steps = nil
+	fs.mu.Unlock()
+
+	fs.l.Close()
+	fs.wg.Wait()
+
+	for _, st := range remaining {
+		fs.t.Errorf("radiotest: expected request '%s' was not received", st)
+	}
+}
+
+func (fs *FakeServer) acceptLoop() {
+	defer fs.wg.Done()
+
+	for {
+		con, err := fs.l.Accept()
+		if err != nil {
+			return
+		}
+
+		fs.mu.Lock()
+		if fs.closed {
+			fs.mu.Unlock()
+			con.Close()
+			return
+		}
+		fs.conns[con] = struct{}{}
+		fs.mu.Unlock()
+
+		fs.wg.Add(1)
+		go fs.serve(con)
+	}
+}
+
+func (fs *FakeServer) serve(con net.Conn) {
+	defer fs.wg.Done()
+	defer func() {
+		fs.mu.Lock()
+		delete(fs.conns, con)
+		fs.mu.Unlock()
+		con.Close()
+	}()
+
+	rd := radio.NewReader(con, true)
+	for {
+		val, err := rd.Read()
+		if err != nil {
+			return
+		}
+
+		req, err := radio.NewRequest(val)
+		if err != nil || req == nil {
+			continue
+		}
+
+		st, err := fs.next(req)
+		if err != nil {
+			fs.t.Errorf("radiotest: %v", err)
+			if _, err := io.WriteString(con, radio.ErrorStr("ERR "+err.Error()).Serialize()); err != nil {
+				return
+			}
+			continue
+		}
+
+		if !fs.respond(con, st) {
+			return
+		}
+	}
+}
+
+// next consumes the next step of the script if it matches the request.
+func (fs *FakeServer) next(req *radio.Request) (*Step, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.requests = append(fs.requests, req)
+	if len(fs.steps) == 0 {
+		return nil, fmt.Errorf("unexpected request '%s': script is complete", req.Command)
+	}
+
+	st := fs.steps[0]
+	if !st.matches(req) {
+		got := (&Step{Command: req.Command, Args: req.Args}).String()
+		return nil, fmt.Errorf("expecting request '%s', got '%s'", st, got)
+	}
+	fs.steps = fs.steps[1:]
+
+	return st, nil
+}
+
+// respond writes the response of the step and reports whether the
+// connection must be kept open.
+func (fs *FakeServer) respond(con net.Conn, st *Step) bool {
+	if st.delay > 0 {
+		time.Sleep(st.delay)
+	}
+
+	resp := st.response()
+	if st.chunk <= 0 {
+		if _, err := io.WriteString(con, resp); err != nil {
+			return false
+		}
+	} else {
+		for i := 0; i < len(resp); i += st.chunk {
+			end := i + st.chunk
+			if end > len(resp) {
+				end = len(resp)
+			}
+
+			if i > 0 {
+				time.Sleep(st.chunkDelay)
+			}
+
+			if _, err := io.WriteString(con, resp[i:end]); err != nil {
+				return false
+			}
+		}
+	}
+
+	return st.partial <= 0 && !st.disconnect
+}
@@ -0,0 +1,166 @@
+package radiotest_test
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+)
+
+func TestServer(t *testing.T) {
+	srv := radiotest.NewServer(radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		wr.Write(radio.SimpleStr("PONG"))
+	}))
+
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if v, err := conn.Do("PING"); err != nil || v != radio.SimpleStr("PONG") {
+		t.Errorf("expecting 'PONG', got '%v' (err=%v)", v, err)
+	}
+
+	srv.Close()
+	if _, err := conn.Do("PING"); err == nil {
+		t.Errorf("expecting error after server is closed")
+	}
+
+	if _, err := radio.Dial(srv.Addr); err == nil {
+		t.Errorf("expecting dial to fail after server is closed")
+	}
+}
+
+func TestResponseRecorder(t *testing.T) {
+	rec := radiotest.NewRecorder()
+	if rec.Last() != nil {
+		t.Errorf("expecting nil, got '%v'", rec.Last())
+	}
+
+	rec.Write(radio.SimpleStr("OK"))
+	rec.Write(radio.Integer(10))
+
+	expected := []radio.Value{radio.SimpleStr("OK"), radio.Integer(10)}
+	if !reflect.DeepEqual(expected, rec.Values()) {
+		t.Errorf("expecting %v, got %v", expected, rec.Values())
+	}
+
+	if rec.Last() != radio.Integer(10) {
+		t.Errorf("expecting 10, got '%v'", rec.Last())
+	}
+
+	rec.Reset()
+	if len(rec.Values()) != 0 {
+		t.Errorf("expecting no values after reset, got %v", rec.Values())
+	}
+}
+
+func TestFakeServer(t *testing.T) {
+	fs := radiotest.NewFakeServer(t,
+		radiotest.Expect("SET", "a", "1").Reply(radio.SimpleStr("OK")),
+		radiotest.Expect("get", "a").Reply(&radio.BulkStr{Value: []byte("1")}).Chunked(2, time.Millisecond),
+		radiotest.Expect("GET", "b").Delay(50*time.Millisecond).Reply(&radio.BulkStr{}),
+		radiotest.Expect("GET", "c").Reply(&radio.BulkStr{Value: []byte("hello")}).Partial(6),
+		radiotest.Expect("PING").Disconnect(),
+		radiotest.Expect("PING").Reply(radio.SimpleStr("PONG")),
+	)
+	defer fs.Close()
+
+	conn, err := radio.Dial(fs.Addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	if v, err := conn.Do("SET", "a", "1"); err != nil || v != radio.SimpleStr("OK") {
+		t.Errorf("expecting 'OK', got '%v' (err=%v)", v, err)
+	}
+
+	if v, err := conn.Do("GET", "a"); err != nil || v.(*radio.BulkStr).String() != "1" {
+		t.Errorf("expecting '1', got '%v' (err=%v)", v, err)
+	}
+
+	start := time.Now()
+	if _, err := conn.Do("GET", "b"); err != nil {
+		t.Errorf("not expecting error, got '%v'", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expecting reply to be delayed, took %s", elapsed)
+	}
+
+	if _, err := conn.Do("GET", "c"); err != io.ErrUnexpectedEOF && err != io.EOF {
+		t.Errorf("expecting EOF error for partial reply, got '%v'", err)
+	}
+	conn.Close()
+
+	conn, err = radio.Dial(fs.Addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("PING"); err == nil {
+		t.Errorf("expecting error on disconnect")
+	}
+
+	conn, err = radio.Dial(fs.Addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if v, err := conn.Do("PING"); err != nil || v != radio.SimpleStr("PONG") {
+		t.Errorf("expecting 'PONG', got '%v' (err=%v)", v, err)
+	}
+
+	if n := len(fs.Requests()); n != 6 {
+		t.Errorf("expecting 6 requests, got %d", n)
+	}
+}
+
+func TestFakeServer_Unexpected(t *testing.T) {
+	ft := &fakeT{TB: t}
+	fs := radiotest.NewFakeServer(ft,
+		radiotest.Expect("GET", "a").Reply(&radio.BulkStr{}),
+		radiotest.Expect("GET", "b").Reply(&radio.BulkStr{}),
+	)
+
+	conn, err := radio.Dial(fs.Addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	v, err := conn.Do("GET", "x")
+	if _, isErr := v.(radio.ErrorStr); err != nil || !isErr {
+		t.Errorf("expecting error reply, got '%v' (err=%v)", v, err)
+	}
+	fs.Close()
+
+	expected := []string{
+		"radiotest: expecting request 'GET a', got 'GET x'",
+		"radiotest: expected request 'GET a' was not received",
+		"radiotest: expected request 'GET b' was not received",
+	}
+	if !reflect.DeepEqual(expected, ft.errors) {
+		t.Errorf("expecting errors %q, got %q", expected, ft.errors)
+	}
+}
+
+type fakeT struct {
+	testing.TB
+
+	mu     sync.Mutex
+	errors []string
+}
+
+func (ft *fakeT) Errorf(format string, args ...interface{}) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.errors = append(ft.errors, fmt.Sprintf(format, args...))
+}
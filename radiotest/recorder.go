@@ -0,0 +1,55 @@
+package radiotest
+
+import (
+	"sync"
+
+	"github.com/spy16/radio"
+)
+
+// NewRecorder returns an initialized ResponseRecorder.
+func NewRecorder() *ResponseRecorder {
+	return &ResponseRecorder{}
+}
+
+// ResponseRecorder is a radio.ResponseWriter that records the values written
+// to it for inspection in tests. ResponseRecorder is safe for concurrent use.
+type ResponseRecorder struct {
+	mu   sync.Mutex
+	vals []radio.Value
+}
+
+// Write records the value.
+func (rr *ResponseRecorder) Write(v radio.Value) (int, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.vals = append(rr.vals, v)
+	return len(v.Serialize()), nil
+}
+
+// Values returns all the values written so far.
+func (rr *ResponseRecorder) Values() []radio.Value {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	return append([]radio.Value(nil), rr.vals...)
+}
+
+// Last returns the last value written or nil if nothing was written.
+func (rr *ResponseRecorder) Last() radio.Value {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if len(rr.vals) == 0 {
+		return nil
+	}
+	return rr.vals[len(rr.vals)-1]
+}
+
+// Reset discards all the recorded values.
+func (rr *ResponseRecorder) Reset() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.vals = nil
+}
@@ -0,0 +1,104 @@
+// Package radiotest provides utilities for testing RESP servers, handlers
+// and clients built using radio.
+package radiotest
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/spy16/radio"
+)
+
+// NewServer starts and returns a new server serving requests using the
+// handler on a loopback address. Close must be called to shut it down.
+func NewServer(handler radio.Handler) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("radiotest: failed to listen: " + err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &Server{
+		Addr:   l.Addr().String(),
+		l:      l,
+		cancel: cancel,
+		conns:  map[net.Conn]struct{}{},
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(srv.done)
+		radio.ListenAndServe(ctx, &trackingListener{srv: srv}, handler)
+	}()
+	return srv
+}
+
+// Server is a RESP server listening on a loopback address for use in tests.
+type Server struct {
+	// Addr is the address the server is listening on in the form host:port.
+	Addr string
+
+	l      net.Listener
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// Dial connects to the server.
+func (srv *Server) Dial() (*radio.Conn, error) {
+	return radio.Dial(srv.Addr)
+}
+
+// CloseClientConnections closes all the connections accepted by the server
+// so far. The server continues to accept new connections.
+func (srv *Server) CloseClientConnections() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for con := range srv.conns {
+		con.Close()
+		delete(srv.conns, con)
+	}
+}
+
+// Close stops the server and closes all client connections.
+func (srv *Server) Close() {
+	srv.mu.Lock()
+	srv.closed = true
+	srv.mu.Unlock()
+
+	srv.cancel()
+	srv.l.Close()
+	<-srv.done
+	srv.CloseClientConnections()
+}
+
+// trackingListener records the accepted connections so that they can be
+// closed when the server shuts down.
+type trackingListener struct {
+	srv *Server
+}
+
+func (tl *trackingListener) Accept() (net.Conn, error) {
+	con, err := tl.srv.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tl.srv.mu.Lock()
+	defer tl.srv.mu.Unlock()
+
+	if tl.srv.closed {
+		con.Close()
+	} else {
+		tl.srv.conns[con] = struct{}{}
+	}
+	return con, nil
+}
+
+func (tl *trackingListener) Close() error   { return tl.srv.l.Close() }
+func (tl *trackingListener) Addr() net.Addr { return tl.srv.l.Addr() }
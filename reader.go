@@ -56,6 +56,12 @@ type Reader struct {
 	buf     []byte
 	sz      int
 	inArray bool
+
+	// raw holds the bytes of the value being read by ReadRaw that were
+	// already discarded from the buffer.
+	capture  bool
+	raw      []byte
+	rawStart int
 }
 
 // Read reads next RESP value from the stream.
@@ -115,11 +121,44 @@ func (rd *Reader) Read() (Value, error) {
 			return nil, err
 		}
 		return v, nil
+
+	case '>':
+		v, err := rd.readArray()
+		if err != nil {
+			return nil, err
+		}
+		return &Push{Items: v.Items}, nil
+
+	case '%':
+		v, err := rd.readAggregate(2)
+		if err != nil {
+			return nil, err
+		}
+		return &Map{Items: v.Items}, nil
 	}
 
 	return nil, fmt.Errorf("bad prefix '%c'", prefix)
 }
 
+// ReadRaw reads the next RESP value from the stream and returns it exactly
+// as it was received. This allows relaying values without re-encoding them.
+func (rd *Reader) ReadRaw() (Raw, error) {
+	rd.capture = true
+	rd.raw = nil
+	rd.rawStart = rd.start
+	defer func() {
+		rd.capture = false
+		rd.raw = nil
+	}()
+
+	if _, err := rd.Read(); err != nil {
+		return nil, err
+	}
+
+	raw := append(rd.raw, rd.buf[rd.rawStart:rd.start]...)
+	return Raw(raw), nil
+}
+
 // Size returns the current buffer size and the minimum buffer size
 // reader is configured with.
 func (rd *Reader) Size() (minSize int, currentSize int) {
@@ -208,6 +247,12 @@ func (rd *Reader) readBulkStr() (*BulkStr, error) {
 }
 
 func (rd *Reader) readArray() (*Array, error) {
+	return rd.readAggregate(1)
+}
+
+// readAggregate reads an aggregate value of size elements of n items each
+// (e.g., 2 for the key and the value of the entries of maps).
+func (rd *Reader) readAggregate(n int) (*Array, error) {
 	rd.inArray = true
 	defer func() {
 		rd.inArray = false
@@ -231,7 +276,7 @@ func (rd *Reader) readArray() (*Array, error) {
 	arr := &Array{}
 	arr.Items = []Value{}
 
-	for i := 0; i < size; i++ {
+	for i := 0; i < size*n; i++ {
 		item, err := rd.Read()
 		if err != nil {
 			return nil, err
@@ -244,14 +289,15 @@ func (rd *Reader) readArray() (*Array, error) {
 }
 
 func (rd *Reader) readExactly(n int) ([]byte, error) {
-	for rd.end-rd.start < n {
+	for rd.end-rd.start < n+2 {
 		if _, err := rd.buffer(true); err != nil {
 			return nil, err
 		}
 	}
 
-	data := rd.buf[rd.start : rd.start+n]
-	rd.start += n + 1
+	data := make([]byte, n)
+	copy(data, rd.buf[rd.start:rd.start+n])
+	rd.start += n
 	return data, nil
 }
 
@@ -269,10 +315,6 @@ func (rd *Reader) readTillCRLF() ([]byte, error) {
 		}
 	}
 
-	if crlf == 0 {
-		return []byte(""), nil
-	}
-
 	data := make([]byte, crlf)
 	copy(data, rd.buf[rd.start:rd.start+crlf])
 	rd.start += crlf + 2
@@ -297,9 +339,20 @@ func (rd *Reader) buffer(force bool) (int, error) {
 		return 0, nil // buffer already has some data.
 	}
 
+	if rd.capture && (rd.start >= rd.end || rd.end == len(rd.buf)) {
+		// data before start is about to be discarded.
+		rd.raw = append(rd.raw, rd.buf[rd.rawStart:rd.start]...)
+		rd.rawStart = 0
+	}
+
 	if rd.end > 0 && rd.start >= rd.end {
 		rd.start = 0
 		rd.end = 0
+	} else if rd.end == len(rd.buf) && rd.start > 0 {
+		// move the unread data to the front to make room instead of
+		// growing the buffer.
+		rd.end = copy(rd.buf, rd.buf[rd.start:rd.end])
+		rd.start = 0
 	} else if rd.end == len(rd.buf) {
 		if rd.FixedBuffer {
 			return 0, ErrBufferFull
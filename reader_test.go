@@ -82,218 +82,323 @@ func TestNewReader(suite *testing.T) {
 
 func TestReader_Read_ClientMode(suite *testing.T) {
 	suite.Parallel()
+	runAllCases(suite, clientModeCases, false)
+}
 
-	cases := []readTestCase{
-		{
-			title: "NoInput",
-			input: "",
-			val:   nil,
-			err:   io.EOF,
-		},
-		{
-			title: "BadPrefix",
-			input: "?helo",
-			val:   nil,
-			err:   errors.New("bad prefix '?'"),
-		},
-		{
-			title: "SimpleStr",
-			input: "+hello\r\n",
-			val:   radio.SimpleStr("hello"),
-			err:   nil,
-		},
-		{
-			title: "SimpleStr-Empty",
-			input: "+\r\n",
-			val:   radio.SimpleStr(""),
-			err:   nil,
-		},
-		{
-			title: "SimpleStr-NoCRLF",
-			input: "+hello",
-			val:   nil,
-			err:   io.EOF,
-		},
-		{
-			title: "SimpleStr-MultiValue",
-			input: "+hello\r\n+world\r\n",
-			val:   radio.SimpleStr("hello"),
-			err:   nil,
-		},
-		{
-			title: "ErrorStr",
-			input: "-ERR failed\r\n",
-			val:   radio.ErrorStr("ERR failed"),
-			err:   nil,
-		},
-		{
-			title: "ErrorStr-NoValue",
-			input: "-\r\n",
-			val:   radio.ErrorStr(""),
-			err:   nil,
-		},
-		{
-			title: "ErrorStr-NoCRLF",
-			input: "-ERR failed",
-			val:   nil,
-			err:   io.EOF,
-		},
-		{
-			title: "Integer",
-			input: ":100\r\n",
-			val:   radio.Integer(100),
-			err:   nil,
-		},
-		{
-			title: "Integer-NoValue",
-			input: ":\r\n",
-			val:   nil,
-			err:   errors.New("no number"),
-		},
-		{
-			title: "Integer-NoCRLF",
-			input: ":100",
-			val:   nil,
-			err:   io.EOF,
-		},
-		{
-			title: "Integer-BadFormat",
-			input: ":10.5\r\n",
-			val:   nil,
-			err:   errors.New("invalid number format"),
-		},
-		{
-			title: "Integer-EOF",
-			input: ":",
-			val:   nil,
-			err:   io.EOF,
+func TestReader_Read_ServerMode(suite *testing.T) {
+	suite.Parallel()
+	runAllCases(suite, serverModeCases, true)
+}
+
+var clientModeCases = []readTestCase{
+	{
+		title: "NoInput",
+		input: "",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "BadPrefix",
+		input: "?helo",
+		val:   nil,
+		err:   errors.New("bad prefix '?'"),
+	},
+	{
+		title: "SimpleStr",
+		input: "+hello\r\n",
+		val:   radio.SimpleStr("hello"),
+		err:   nil,
+	},
+	{
+		title: "SimpleStr-Empty",
+		input: "+\r\n",
+		val:   radio.SimpleStr(""),
+		err:   nil,
+	},
+	{
+		title: "SimpleStr-NoCRLF",
+		input: "+hello",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "SimpleStr-MultiValue",
+		input: "+hello\r\n+world\r\n",
+		val:   radio.SimpleStr("hello"),
+		err:   nil,
+	},
+	{
+		title: "ErrorStr",
+		input: "-ERR failed\r\n",
+		val:   radio.ErrorStr("ERR failed"),
+		err:   nil,
+	},
+	{
+		title: "ErrorStr-NoValue",
+		input: "-\r\n",
+		val:   radio.ErrorStr(""),
+		err:   nil,
+	},
+	{
+		title: "ErrorStr-NoCRLF",
+		input: "-ERR failed",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "Integer",
+		input: ":100\r\n",
+		val:   radio.Integer(100),
+		err:   nil,
+	},
+	{
+		title: "Integer-NoValue",
+		input: ":\r\n",
+		val:   nil,
+		err:   errors.New("no number"),
+	},
+	{
+		title: "Integer-NoCRLF",
+		input: ":100",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "Integer-BadFormat",
+		input: ":10.5\r\n",
+		val:   nil,
+		err:   errors.New("invalid number format"),
+	},
+	{
+		title: "Integer-EOF",
+		input: ":",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "BulkStr",
+		input: "$5\r\nhello\r\n",
+		val: &radio.BulkStr{
+			Value: []byte("hello"),
 		},
-		{
-			title: "BulkStr",
-			input: "$5\r\nhello\r\n",
-			val: &radio.BulkStr{
-				Value: []byte("hello"),
+		err: nil,
+	},
+	{
+		title: "BulkStr-NoSize",
+		input: "$\r\n",
+		val:   nil,
+		err:   errors.New("no number"),
+	},
+	{
+		title: "BulkStr-NegativeSize",
+		input: "$-1\r\n",
+		val:   &radio.BulkStr{},
+		err:   nil,
+	},
+	{
+		title: "BulkStr-NoData",
+		input: "$10\r\nhel\r\n",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "Array",
+		input: "*1\r\n+hello\r\n",
+		val: &radio.Array{
+			Items: []radio.Value{
+				radio.SimpleStr("hello"),
 			},
-			err: nil,
 		},
-		{
-			title: "BulkStr-NoSize",
-			input: "$\r\n",
-			val:   nil,
-			err:   errors.New("no number"),
-		},
-		{
-			title: "BulkStr-NegativeSize",
-			input: "$-1\r\n",
-			val:   &radio.BulkStr{},
-			err:   nil,
+		err: nil,
+	},
+	{
+		title: "Array-NoSize",
+		input: "*\r\n",
+		val:   nil,
+		err:   errors.New("no number"),
+	},
+	{
+		title: "Array-NegativeSize",
+		input: "*-1\r\n",
+		val:   &radio.Array{},
+		err:   nil,
+	},
+	{
+		title: "Array-InsufficientData",
+		input: "*2\r\n+hello\r\n",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "Array-InvalidSize",
+		input: "*2.5\r\n",
+		val:   nil,
+		err:   errors.New("invalid number format"),
+	},
+	{
+		title: "Push",
+		input: ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n",
+		val: &radio.Push{
+			Items: []radio.Value{
+				&radio.BulkStr{Value: []byte("invalidate")},
+				&radio.Array{Items: []radio.Value{&radio.BulkStr{Value: []byte("foo")}}},
+			},
 		},
-		{
-			title: "BulkStr-NoData",
-			input: "$10\r\nhel\r\n",
-			val:   nil,
-			err:   io.EOF,
+		err: nil,
+	},
+	{
+		title: "Push-InsufficientData",
+		input: ">2\r\n+hello\r\n",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "Map",
+		input: "%2\r\n+proto\r\n:3\r\n+modules\r\n*0\r\n",
+		val: &radio.Map{
+			Items: []radio.Value{
+				radio.SimpleStr("proto"),
+				radio.Integer(3),
+				radio.SimpleStr("modules"),
+				&radio.Array{Items: []radio.Value{}},
+			},
 		},
-		{
-			title: "Array",
-			input: "*1\r\n+hello\r\n",
-			val: &radio.Array{
-				Items: []radio.Value{
-					radio.SimpleStr("hello"),
+		err: nil,
+	},
+	{
+		title: "Map-InsufficientData",
+		input: "%1\r\n+proto\r\n",
+		val:   nil,
+		err:   io.EOF,
+	},
+}
+
+var serverModeCases = []readTestCase{
+	{
+		title: "InlineStr",
+		input: "hello\r\n",
+		val: &radio.Array{
+			Items: []radio.Value{
+				&radio.BulkStr{
+					Value: []byte("hello"),
 				},
 			},
-			err: nil,
-		},
-		{
-			title: "Array-NoSize",
-			input: "*\r\n",
-			val:   nil,
-			err:   errors.New("no number"),
 		},
-		{
-			title: "Array-NegativeSize",
-			input: "*-1\r\n",
-			val:   &radio.Array{},
-			err:   nil,
-		},
-		{
-			title: "Array-InsufficientData",
-			input: "*2\r\n+hello\r\n",
-			val:   nil,
-			err:   io.EOF,
+		err: nil,
+	},
+	{
+		title: "InlineStr-EOF",
+		input: "hello",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "MultiBulk-NullValue",
+		input: "*-1\r\n",
+		val:   &radio.Array{},
+		err:   nil,
+	},
+	{
+		title: "MultiBulk-EOF",
+		input: "*1\r\n",
+		val:   nil,
+		err:   io.EOF,
+	},
+	{
+		title: "SimpleStrInArray",
+		input: "*1\r\n+hello\r\n",
+		val:   nil,
+		err:   errors.New("Protocol error: expecting '$', got '+'"),
+	},
+	{
+		title: "MultiBulk-InvalidSize",
+		input: "*1.4\r\n",
+		val:   nil,
+		err:   errors.New("Protocol error: invalid multibulk length"),
+	},
+	{
+		title: "MultiBulk-InvalidBulkSize",
+		input: "*1\r\n$1.5\r\n",
+		val:   nil,
+		err:   errors.New("Protocol error: invalid bulk length"),
+	},
+	{
+		title: "MultiBulk-NegativeBulkSize",
+		input: "*1\r\n$-1\r\n",
+		val:   nil,
+		err:   errors.New("Protocol error: invalid bulk length"),
+	},
+}
+
+func TestReader_Read_Pipelined(t *testing.T) {
+	input := "*2\r\n$5\r\nhello\r\n$0\r\n\r\n*1\r\n$4\r\nPING\r\n"
+	rd := radio.NewReaderSize(strings.NewReader(input), true, 4)
+
+	expected := []radio.Value{
+		&radio.Array{
+			Items: []radio.Value{
+				&radio.BulkStr{Value: []byte("hello")},
+				&radio.BulkStr{Value: []byte("")},
+			},
 		},
-		{
-			title: "Array-InvalidSize",
-			input: "*2.5\r\n",
-			val:   nil,
-			err:   errors.New("invalid number format"),
+		&radio.Array{
+			Items: []radio.Value{
+				&radio.BulkStr{Value: []byte("PING")},
+			},
 		},
 	}
 
-	runAllCases(suite, cases, false)
+	for _, exp := range expected {
+		val, err := rd.Read()
+		if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+
+		if !reflect.DeepEqual(exp, val) {
+			t.Errorf("expecting RESP value '%v', got '%v'", exp, val)
+		}
+	}
+
+	if _, err := rd.Read(); err != io.EOF {
+		t.Errorf("expecting error '%v', got '%v'", io.EOF, err)
+	}
 }
 
-func TestReader_Read_ServerMode(suite *testing.T) {
-	suite.Parallel()
+func TestReader_Read_EmptyLines(t *testing.T) {
+	rd := radio.NewReader(strings.NewReader("+\r\n-\r\n+OK\r\n"), false)
 
-	cases := []readTestCase{
-		{
-			title: "InlineStr",
-			input: "hello\r\n",
-			val: &radio.Array{
-				Items: []radio.Value{
-					&radio.BulkStr{
-						Value: []byte("hello"),
-					},
-				},
-			},
-			err: nil,
-		},
-		{
-			title: "InlineStr-EOF",
-			input: "hello",
-			val:   nil,
-			err:   io.EOF,
-		},
-		{
-			title: "MultiBulk-NullValue",
-			input: "*-1\r\n",
-			val:   &radio.Array{},
-			err:   nil,
-		},
-		{
-			title: "MultiBulk-EOF",
-			input: "*1\r\n",
-			val:   nil,
-			err:   io.EOF,
-		},
-		{
-			title: "SimpleStrInArray",
-			input: "*1\r\n+hello\r\n",
-			val:   nil,
-			err:   errors.New("Protocol error: expecting '$', got '+'"),
-		},
-		{
-			title: "MultiBulk-InvalidSize",
-			input: "*1.4\r\n",
-			val:   nil,
-			err:   errors.New("Protocol error: invalid multibulk length"),
-		},
-		{
-			title: "MultiBulk-InvalidBulkSize",
-			input: "*1\r\n$1.5\r\n",
-			val:   nil,
-			err:   errors.New("Protocol error: invalid bulk length"),
-		},
-		{
-			title: "MultiBulk-NegativeBulkSize",
-			input: "*1\r\n$-1\r\n",
-			val:   nil,
-			err:   errors.New("Protocol error: invalid bulk length"),
-		},
+	expected := []radio.Value{radio.SimpleStr(""), radio.ErrorStr(""), radio.SimpleStr("OK")}
+	for _, exp := range expected {
+		val, err := rd.Read()
+		if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+
+		if !reflect.DeepEqual(exp, val) {
+			t.Errorf("expecting RESP value '%v', got '%v'", exp, val)
+		}
 	}
+}
 
-	runAllCases(suite, cases, true)
+func TestReader_ReadRaw(t *testing.T) {
+	values := []string{
+		"+OK\r\n",
+		"*3\r\n$5\r\nhello\r\n:10\r\n*1\r\n-ERR failed\r\n",
+		"$-1\r\n",
+		"$11\r\nhello world\r\n",
+	}
+
+	rd := radio.NewReaderSize(strings.NewReader(strings.Join(values, "")), false, 4)
+	for _, expected := range values {
+		raw, err := rd.ReadRaw()
+		if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+
+		if string(raw) != expected {
+			t.Errorf("expecting raw value '%q', got '%q'", expected, raw)
+		}
+	}
 }
 
 func runAllCases(suite *testing.T, cases []readTestCase, serverMode bool) {
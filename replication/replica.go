@@ -0,0 +1,562 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+const (
+	defaultAckInterval = 1 * time.Second
+	defaultRetryDelay  = 1 * time.Second
+	eofMarkLen         = 40
+)
+
+// Replication link states as reported by ROLE.
+const (
+	StateNone       = "none"
+	StateConnect    = "connect"
+	StateConnecting = "connecting"
+	StateSync       = "sync"
+	StateConnected  = "connected"
+)
+
+// ErrNotReplica is returned when stopping a replica that is not replicating.
+var ErrNotReplica = errors.New("not replicating from any master")
+
+// Replica follows a RESP master using the PSYNC protocol and feeds the
+// replicated command stream into the local Handler. Replica itself is a
+// Handler which handles REPLICAOF, SLAVEOF and ROLE commands and passes all
+// other requests to the local Handler.
+//
+// Refer https://redis.io/topics/replication for the protocol details.
+type Replica struct {
+	// Handler is the local handler that receives the commands streamed by
+	// the master and all requests that are not handled by the replica.
+	Handler radio.Handler
+
+	// Load, if set, is invoked with the initial payload sent by master
+	// during a full synchronization. Load should replace the local dataset
+	// with the payload. If not set, the payload is discarded.
+	Load func(payload io.Reader) error
+
+	// User and Password are used to authenticate with master when Password
+	// is set.
+	User     string
+	Password string
+
+	// ListeningPort is announced to the master using REPLCONF.
+	ListeningPort int
+
+	// AckInterval is the interval at which the replication offset is
+	// acknowledged to master. Defaults to 1 second.
+	AckInterval time.Duration
+
+	// RetryDelay is the delay before reconnecting to master after the link
+	// is lost. Defaults to 1 second.
+	RetryDelay time.Duration
+
+	// Dial is used to connect to master. Defaults to net.Dial over tcp.
+	Dial func(addr string) (net.Conn, error)
+
+	mu     sync.Mutex
+	master string
+	state  string
+	replID string
+	offset int64
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ServeRESP handles replication commands and dispatches all other requests
+// to the local handler.
+func (rep *Replica) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	switch strings.ToUpper(req.Command) {
+	case "REPLICAOF", "SLAVEOF":
+		rep.serveReplicaOf(wr, req)
+
+	case "ROLE":
+		wr.Write(rep.role())
+
+	default:
+		rep.Handler.ServeRESP(wr, req)
+	}
+}
+
+// ReplicaOf starts replicating from the master at the given address in the
+// background, stopping any existing replication first. Replication continues
+// until Stop is called or the context is cancelled.
+func (rep *Replica) ReplicaOf(ctx context.Context, addr string) {
+	rep.Stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	rep.mu.Lock()
+	rep.master = addr
+	rep.state = StateConnect
+	rep.cancel = cancel
+	rep.done = done
+	rep.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		rep.replicate(ctx, addr)
+	}()
+}
+
+// Stop stops the replication and waits for the link to be closed. The local
+// dataset and the replication offset are retained.
+func (rep *Replica) Stop() error {
+	rep.mu.Lock()
+	cancel, done := rep.cancel, rep.done
+	rep.cancel, rep.done = nil, nil
+	rep.master = ""
+	rep.state = StateNone
+	rep.mu.Unlock()
+
+	if cancel == nil {
+		return ErrNotReplica
+	}
+
+	cancel()
+	<-done
+	return nil
+}
+
+// Offset returns the replication ID and the offset processed so far.
+func (rep *Replica) Offset() (replID string, offset int64) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	return rep.replID, rep.offset
+}
+
+// State returns the current state of the link with master.
+func (rep *Replica) State() string {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	if rep.state == "" {
+		return StateNone
+	}
+	return rep.state
+}
+
+// Sync connects to the master at the given address, performs the handshake
+// and processes the replication stream until the link fails or the context
+// is cancelled.
+func (rep *Replica) Sync(ctx context.Context, addr string) error {
+	dial := rep.Dial
+	if dial == nil {
+		dial = func(addr string) (net.Conn, error) {
+			return net.Dial("tcp", addr)
+		}
+	}
+
+	rep.setState(StateConnecting)
+	con, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		con.Close()
+	}()
+
+	lnk := &link{
+		con: con,
+		br:  bufio.NewReader(con),
+		wr:  radio.NewWriter(con),
+	}
+
+	if err := rep.handshake(lnk); err != nil {
+		return err
+	}
+
+	rep.setState(StateConnected)
+
+	ackDone := make(chan struct{})
+	defer close(ackDone)
+	go rep.ackLoop(lnk, ackDone)
+
+	return rep.stream(lnk)
+}
+
+func (rep *Replica) replicate(ctx context.Context, addr string) {
+	for {
+		rep.Sync(ctx, addr)
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-time.After(durationOr(rep.RetryDelay, defaultRetryDelay)):
+			rep.setState(StateConnect)
+		}
+	}
+}
+
+func (rep *Replica) handshake(lnk *link) error {
+	reply, err := lnk.do("PING")
+	if err != nil {
+		return err
+	}
+	if !isOK(reply, "+PONG") && !strings.HasPrefix(reply, "-NOAUTH") {
+		return replyErr(reply)
+	}
+
+	if rep.Password != "" {
+		args := []string{rep.Password}
+		if rep.User != "" {
+			args = []string{rep.User, rep.Password}
+		}
+
+		if reply, err := lnk.do("AUTH", args...); err != nil {
+			return err
+		} else if !isOK(reply, "+OK") {
+			return replyErr(reply)
+		}
+	}
+
+	// errors for REPLCONF are ignored since older masters may not support
+	// all the options.
+	if rep.ListeningPort > 0 {
+		if _, err := lnk.do("REPLCONF", "listening-port", strconv.Itoa(rep.ListeningPort)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := lnk.do("REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	rep.setState(StateSync)
+	replID, offset := rep.Offset()
+	psyncID, psyncOffset := "?", "-1"
+	if replID != "" {
+		psyncID, psyncOffset = replID, strconv.FormatInt(offset+1, 10)
+	}
+
+	reply, err = lnk.do("PSYNC", psyncID, psyncOffset)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FULLRESYNC offset '%s'", fields[2])
+		}
+
+		if err := rep.loadPayload(lnk.br); err != nil {
+			return err
+		}
+
+		rep.mu.Lock()
+		rep.replID = fields[1]
+		rep.offset = offset
+		rep.mu.Unlock()
+
+	case len(fields) >= 1 && fields[0] == "+CONTINUE":
+		if len(fields) > 1 {
+			rep.mu.Lock()
+			rep.replID = fields[1]
+			rep.mu.Unlock()
+		}
+
+	default:
+		return replyErr(reply)
+	}
+
+	return nil
+}
+
+func (rep *Replica) loadPayload(br *bufio.Reader) error {
+	line, err := readLine(br)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(line, "$") {
+		return fmt.Errorf("unexpected payload header '%s'", line)
+	}
+
+	var payload io.Reader
+	if strings.HasPrefix(line, "$EOF:") {
+		mark := []byte(line[5:])
+		if len(mark) != eofMarkLen {
+			return fmt.Errorf("invalid EOF mark '%s'", mark)
+		}
+		payload = &eofMarkReader{br: br, mark: mark}
+	} else {
+		size, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || size < 0 {
+			return fmt.Errorf("invalid payload size '%s'", line[1:])
+		}
+		payload = io.LimitReader(br, size)
+	}
+
+	if rep.Load != nil {
+		if err := rep.Load(payload); err != nil {
+			return err
+		}
+	}
+
+	// drain the remaining payload so that the stream is positioned at the
+	// first replicated command.
+	_, err = io.Copy(ioutil.Discard, payload)
+	return err
+}
+
+func (rep *Replica) stream(lnk *link) error {
+	rd := radio.NewReader(lnk.br, false)
+	discard := discardWriter{}
+
+	for {
+		val, err := rd.Read()
+		if err != nil {
+			return err
+		}
+
+		req, err := radio.NewRequest(val)
+		if err != nil {
+			return err
+		}
+
+		size := int64(len(val.Serialize()))
+		if req == nil {
+			rep.advance(size)
+			continue
+		}
+
+		switch strings.ToUpper(req.Command) {
+		case "PING":
+			// master pings are only used to keep the link alive.
+
+		case "REPLCONF":
+			if len(req.Args) > 0 && strings.EqualFold(req.Args[0], "GETACK") {
+				if err := rep.ack(lnk); err != nil {
+					return err
+				}
+			}
+
+		default:
+			rep.Handler.ServeRESP(discard, req)
+		}
+
+		rep.advance(size)
+	}
+}
+
+func (rep *Replica) ackLoop(lnk *link, done chan struct{}) {
+	ticker := time.NewTicker(durationOr(rep.AckInterval, defaultAckInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+			if err := rep.ack(lnk); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (rep *Replica) ack(lnk *link) error {
+	_, offset := rep.Offset()
+	return lnk.send("REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+}
+
+func (rep *Replica) advance(n int64) {
+	rep.mu.Lock()
+	rep.offset += n
+	rep.mu.Unlock()
+}
+
+func (rep *Replica) setState(state string) {
+	rep.mu.Lock()
+	if rep.state != StateNone {
+		rep.state = state
+	}
+	rep.mu.Unlock()
+}
+
+func (rep *Replica) serveReplicaOf(wr radio.ResponseWriter, req *radio.Request) {
+	if len(req.Args) != 2 {
+		wr.Write(radio.ErrorStr(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(req.Command))))
+		return
+	}
+
+	if strings.EqualFold(req.Args[0], "no") && strings.EqualFold(req.Args[1], "one") {
+		rep.Stop()
+		wr.Write(radio.SimpleStr("OK"))
+		return
+	}
+
+	if _, err := strconv.ParseUint(req.Args[1], 10, 16); err != nil {
+		wr.Write(radio.ErrorStr("ERR Invalid master port"))
+		return
+	}
+
+	addr := net.JoinHostPort(req.Args[0], req.Args[1])
+	rep.mu.Lock()
+	already := rep.master == addr
+	rep.mu.Unlock()
+
+	if already {
+		wr.Write(radio.SimpleStr("OK Already connected to specified master"))
+		return
+	}
+
+	rep.ReplicaOf(context.Background(), addr)
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func (rep *Replica) role() radio.Value {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	if rep.master == "" {
+		return &radio.Array{
+			Items: []radio.Value{
+				&radio.BulkStr{Value: []byte("master")},
+				radio.Integer(rep.offset),
+				&radio.Array{Items: []radio.Value{}},
+			},
+		}
+	}
+
+	host, port, _ := net.SplitHostPort(rep.master)
+	portNum, _ := strconv.Atoi(port)
+	return &radio.Array{
+		Items: []radio.Value{
+			&radio.BulkStr{Value: []byte("slave")},
+			&radio.BulkStr{Value: []byte(host)},
+			radio.Integer(portNum),
+			&radio.BulkStr{Value: []byte(rep.state)},
+			radio.Integer(rep.offset),
+		},
+	}
+}
+
+// link represents the connection with master. Writes are serialized since
+// acknowledgements are sent from a separate goroutine.
+type link struct {
+	mu  sync.Mutex
+	con net.Conn
+	br  *bufio.Reader
+	wr  *radio.Writer
+}
+
+func (lnk *link) send(cmd string, args ...string) error {
+	lnk.mu.Lock()
+	defer lnk.mu.Unlock()
+
+	_, err := lnk.wr.Write(&radio.Request{Command: cmd, Args: args})
+	return err
+}
+
+func (lnk *link) do(cmd string, args ...string) (string, error) {
+	if err := lnk.send(cmd, args...); err != nil {
+		return "", err
+	}
+
+	return readLine(lnk.br)
+}
+
+// readLine reads the next non-empty line from master. Master sends empty
+// lines to keep the link alive while it prepares the payload.
+func readLine(br *bufio.Reader) (string, error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return line, nil
+		}
+	}
+}
+
+// eofMarkReader reads a diskless payload which is terminated by a random
+// 40 byte mark instead of being prefixed with its size.
+type eofMarkReader struct {
+	br   *bufio.Reader
+	mark []byte
+	tail []byte
+	done bool
+}
+
+func (er *eofMarkReader) Read(p []byte) (int, error) {
+	for !er.done && len(er.tail) < len(p)+len(er.mark) {
+		b, err := er.br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		er.tail = append(er.tail, b)
+		if bytes.HasSuffix(er.tail, er.mark) {
+			er.tail = er.tail[:len(er.tail)-len(er.mark)]
+			er.done = true
+		}
+	}
+
+	n := len(er.tail)
+	if !er.done {
+		n -= len(er.mark)
+	}
+	if n == 0 && er.done {
+		return 0, io.EOF
+	}
+
+	n = copy(p, er.tail[:n])
+	er.tail = er.tail[n:]
+	return n, nil
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(v radio.Value) (int, error) {
+	return 0, nil
+}
+
+func isOK(reply string, expected string) bool {
+	return strings.EqualFold(reply, expected)
+}
+
+func replyErr(reply string) error {
+	if strings.HasPrefix(reply, "-") {
+		return errors.New(reply[1:])
+	}
+	return fmt.Errorf("unexpected reply '%s'", reply)
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,346 @@
+package replication_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/replication"
+)
+
+func TestReplica_FullSync(t *testing.T) {
+	master := startMaster(t, "secret")
+	defer master.close()
+
+	local := &recorder{}
+	loaded := make(chan string, 1)
+	rep := &replication.Replica{
+		Handler:       local,
+		Password:      "secret",
+		ListeningPort: 7000,
+		AckInterval:   10 * time.Millisecond,
+		Load: func(payload io.Reader) error {
+			data, err := ioutil.ReadAll(payload)
+			loaded <- string(data)
+			return err
+		},
+	}
+
+	rep.ReplicaOf(context.Background(), master.addr)
+	defer rep.Stop()
+
+	wr := master.waitReplica(t)
+	if payload := <-loaded; payload != "snapshot" {
+		t.Errorf("expecting payload 'snapshot', got '%s'", payload)
+	}
+
+	if master.listeningPort() != "7000" {
+		t.Errorf("expecting listening-port '7000', got '%s'", master.listeningPort())
+	}
+
+	cmds := []*radio.Request{
+		{Command: "SET", Args: []string{"hello", "world"}},
+		{Command: "PING"},
+		{Command: "DEL", Args: []string{"hello"}},
+	}
+
+	var size int64
+	for _, cmd := range cmds {
+		wr.Write(cmd)
+		size += int64(len(cmd.Serialize()))
+	}
+
+	waitFor(t, func() bool { return master.ackedOffset() == 100+size })
+
+	expected := []string{"SET hello world", "DEL hello"}
+	if actual := local.commands(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expecting commands %v, got %v", expected, actual)
+	}
+
+	replID, offset := rep.Offset()
+	if replID != "abc" || offset != 100+size {
+		t.Errorf("expecting offset 'abc:%d', got '%s:%d'", 100+size, replID, offset)
+	}
+
+	if rep.State() != replication.StateConnected {
+		t.Errorf("expecting state '%s', got '%s'", replication.StateConnected, rep.State())
+	}
+}
+
+func TestReplica_PartialSync(t *testing.T) {
+	master := startMaster(t, "")
+	defer master.close()
+
+	rep := &replication.Replica{
+		Handler:     &recorder{},
+		AckInterval: 10 * time.Millisecond,
+		RetryDelay:  10 * time.Millisecond,
+	}
+	rep.ReplicaOf(context.Background(), master.addr)
+	defer rep.Stop()
+
+	wr := master.waitReplica(t)
+	cmd := &radio.Request{Command: "SET", Args: []string{"a", "1"}}
+	wr.Write(cmd)
+	expected := 100 + int64(len(cmd.Serialize()))
+	waitFor(t, func() bool { return master.ackedOffset() == expected })
+
+	master.dropConnections()
+	master.waitReplica(t)
+
+	if psync := master.lastPSync(); psync != fmt.Sprintf("abc %d", expected+1) {
+		t.Errorf("expecting PSYNC 'abc %d', got '%s'", expected+1, psync)
+	}
+}
+
+func TestReplica_GetAck(t *testing.T) {
+	master := startMaster(t, "")
+	defer master.close()
+
+	rep := &replication.Replica{
+		Handler:     &recorder{},
+		AckInterval: time.Hour,
+	}
+	rep.ReplicaOf(context.Background(), master.addr)
+	defer rep.Stop()
+
+	wr := master.waitReplica(t)
+	wr.Write(&radio.Request{Command: "REPLCONF", Args: []string{"GETACK", "*"}})
+	waitFor(t, func() bool { return master.ackedOffset() == 100 })
+}
+
+func TestReplica_ServeRESP(t *testing.T) {
+	master := startMaster(t, "")
+	defer master.close()
+
+	local := &recorder{}
+	rep := &replication.Replica{Handler: local}
+	host, port, _ := net.SplitHostPort(master.addr)
+
+	var out writer
+	rep.ServeRESP(&out, &radio.Request{Command: "replicaof", Args: []string{host, port}})
+	master.waitReplica(t)
+
+	rep.ServeRESP(&out, &radio.Request{Command: "ROLE"})
+	rep.ServeRESP(&out, &radio.Request{Command: "REPLICAOF", Args: []string{"no", "one"}})
+	rep.ServeRESP(&out, &radio.Request{Command: "GET", Args: []string{"a"}})
+
+	if len(out.vals) != 4 {
+		t.Fatalf("expecting 4 replies, got %d", len(out.vals))
+	}
+
+	if out.vals[0] != radio.SimpleStr("OK") || out.vals[2] != radio.SimpleStr("OK") {
+		t.Errorf("expecting OK replies, got '%v' and '%v'", out.vals[0], out.vals[2])
+	}
+
+	role := out.vals[1].(*radio.Array)
+	if role.Items[0].(*radio.BulkStr).String() != "slave" {
+		t.Errorf("expecting role 'slave', got '%v'", role.Items[0])
+	}
+
+	if rep.State() != replication.StateNone {
+		t.Errorf("expecting state '%s', got '%s'", replication.StateNone, rep.State())
+	}
+
+	if cmds := local.commands(); !reflect.DeepEqual(cmds, []string{"GET a"}) {
+		t.Errorf("expecting local handler to receive 'GET a', got %v", cmds)
+	}
+}
+
+// fakeMaster is a minimal master that performs the PSYNC handshake and lets
+// the test stream commands to connected replicas.
+type fakeMaster struct {
+	addr     string
+	password string
+	cancel   context.CancelFunc
+	replicas chan radio.ResponseWriter
+
+	mu    sync.Mutex
+	cons  []net.Conn
+	port  string
+	psync string
+	acked int64
+}
+
+func startMaster(t *testing.T, password string) *fakeMaster {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	master := &fakeMaster{
+		addr:     l.Addr().String(),
+		password: password,
+		cancel:   cancel,
+		replicas: make(chan radio.ResponseWriter, 1),
+	}
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go radio.ListenAndServe(ctx, &trackingListener{Listener: l, master: master}, master)
+	return master
+}
+
+func (fm *fakeMaster) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	switch strings.ToUpper(req.Command) {
+	case "PING":
+		if fm.password != "" {
+			wr.Write(radio.ErrorStr("NOAUTH Authentication required."))
+			return
+		}
+		wr.Write(radio.SimpleStr("PONG"))
+
+	case "AUTH":
+		if len(req.Args) != 1 || req.Args[0] != fm.password {
+			wr.Write(radio.ErrorStr("WRONGPASS invalid password"))
+			return
+		}
+		wr.Write(radio.SimpleStr("OK"))
+
+	case "REPLCONF":
+		fm.mu.Lock()
+		defer fm.mu.Unlock()
+
+		switch strings.ToLower(req.Args[0]) {
+		case "ack":
+			fmt.Sscan(req.Args[1], &fm.acked)
+			return
+
+		case "listening-port":
+			fm.port = req.Args[1]
+		}
+		wr.Write(radio.SimpleStr("OK"))
+
+	case "PSYNC":
+		fm.mu.Lock()
+		fm.psync = strings.Join(req.Args, " ")
+		fm.mu.Unlock()
+
+		if req.Args[0] == "abc" {
+			wr.Write(radio.SimpleStr("CONTINUE"))
+		} else {
+			wr.Write(radio.SimpleStr("FULLRESYNC abc 100"))
+			wr.Write(raw("\n$8\r\nsnapshot"))
+		}
+		fm.replicas <- wr
+
+	default:
+		wr.Write(radio.ErrorStr("ERR unknown command"))
+	}
+}
+
+func (fm *fakeMaster) waitReplica(t *testing.T) radio.ResponseWriter {
+	select {
+	case wr := <-fm.replicas:
+		return wr
+
+	case <-time.After(2 * time.Second):
+		t.Fatalf("replica did not complete the handshake")
+		return nil
+	}
+}
+
+func (fm *fakeMaster) dropConnections() {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	for _, con := range fm.cons {
+		con.Close()
+	}
+	fm.cons = nil
+}
+
+func (fm *fakeMaster) ackedOffset() int64 {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.acked
+}
+
+func (fm *fakeMaster) listeningPort() string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.port
+}
+
+func (fm *fakeMaster) lastPSync() string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.psync
+}
+
+func (fm *fakeMaster) close() {
+	fm.cancel()
+	fm.dropConnections()
+}
+
+type trackingListener struct {
+	net.Listener
+	master *fakeMaster
+}
+
+func (tl *trackingListener) Accept() (net.Conn, error) {
+	con, err := tl.Listener.Accept()
+	if err == nil {
+		tl.master.mu.Lock()
+		tl.master.cons = append(tl.master.cons, con)
+		tl.master.mu.Unlock()
+	}
+	return con, err
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (rec *recorder) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.cmds = append(rec.cmds, strings.Join(append([]string{req.Command}, req.Args...), " "))
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func (rec *recorder) commands() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string(nil), rec.cmds...)
+}
+
+type writer struct {
+	vals []radio.Value
+}
+
+func (wr *writer) Write(v radio.Value) (int, error) {
+	wr.vals = append(wr.vals, v)
+	return 0, nil
+}
+
+// raw allows writing bytes that are not a valid RESP value such as the
+// payload which is not terminated with CRLF.
+type raw string
+
+func (r raw) Serialize() string {
+	return string(r)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met before timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
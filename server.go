@@ -0,0 +1,146 @@
+package radio
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SerialHandler can be implemented by handlers to mark requests that must not
+// run concurrently with other requests of the same connection when the server
+// executes pipelined requests concurrently. A serial request starts only
+// after all the preceding requests of the connection complete and no later
+// request starts before it completes. This is required for commands that
+// change the state of the connection (e.g., SELECT, MULTI).
+type SerialHandler interface {
+	Handler
+
+	// Serial returns true if the request must be executed serially.
+	Serial(req *Request) bool
+}
+
+// Server is a RESP server. Zero value of optional fields result in the same
+// behaviour as ListenAndServe, i.e., requests of every connection are read
+// and executed one after the other on a goroutine per connection.
+type Server struct {
+	// Handler serves the requests.
+	Handler Handler
+
+	// Concurrency, if greater than zero, enables concurrent execution of
+	// pipelined requests of a connection. Replies are still written in the
+	// order of the requests. Concurrency is the maximum number of requests
+	// executing at any time across all the connections.
+	Concurrency int
+
+	// MaxPipeline is the maximum number of requests of a connection that
+	// can be in-flight (i.e., read but not replied to) when requests are
+	// executed concurrently. Defaults to 128.
+	MaxPipeline int
+
+	// SingleExecutor, if set, executes the requests of all the connections
+	// one after the other on a single goroutine while reading, parsing and
+	// writing replies still happen on the connection goroutines. This gives
+	// the same command-level atomicity as Redis and allows handlers to keep
+	// state without locking. Handlers must not block on the executor as that
+	// stalls all the clients.
+	SingleExecutor bool
+
+	// Shards, if greater than zero, executes the requests on this many
+	// executor goroutines chosen by hashing the keys of the requests. The
+	// requests on the same key are executed one after the other while the
+	// requests on different keys run in parallel. Requests with keys on
+	// multiple executors hold all of them while executing. Requests without
+	// keys or with keys that cannot be determined (see MovableKeys) hold all
+	// the executors. SingleExecutor is the same as setting Shards to 1.
+	Shards int
+
+	// KeySpecs is used to find the keys of the requests when Shards is set.
+	// Defaults to DefaultKeySpecs.
+	KeySpecs KeySpecs
+
+	// OffExecutor, if set, is used to pick the requests that are executed on
+	// the connection goroutine instead of the executors. Such requests can
+	// run concurrently with the executors and must synchronize access to any
+	// shared state themselves.
+	OffExecutor func(req *Request) bool
+
+	statsOnce   sync.Once
+	serverStats *serverStats
+}
+
+// Serve accepts connections on the listener and serves the requests using
+// the handler until the context is cancelled or the listener fails. Open
+// connections stop being served once Serve returns.
+func (srv *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	handler, stats := srv.start(ctx, l)
+
+	var workers chan struct{}
+	if srv.Concurrency > 0 {
+		workers = make(chan struct{}, srv.Concurrency)
+	}
+
+	depth := srv.MaxPipeline
+	if depth <= 0 {
+		depth = 128
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		default:
+		}
+
+		con, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		if !stats.admit(con) {
+			continue
+		}
+		stats.connect()
+
+		if tc, ok := con.(*net.TCPConn); ok {
+			tc.SetKeepAlive(true)
+			tc.SetKeepAlivePeriod(10 * time.Minute)
+		}
+
+		if workers == nil {
+			go clientLoop(ctx, con, handler, stats)
+		} else {
+			go pipelineLoop(ctx, con, handler, stats, workers, depth)
+		}
+	}
+}
+
+// start records the start of serving on the listener and returns the
+// handler that tracks the statistics and runs the requests on the executors.
+func (srv *Server) start(ctx context.Context, l net.Listener) (Handler, *serverStats) {
+	shards := srv.Shards
+	if shards <= 0 && srv.SingleExecutor {
+		shards = 1
+	}
+
+	stats := srv.stats()
+	atomic.StoreInt64(&stats.startedAt, time.Now().UnixNano())
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		atomic.StoreInt64(&stats.port, int64(addr.Port))
+	}
+
+	var handler Handler = &statsHandler{handler: srv.Handler, stats: stats}
+	if shards > 0 {
+		specs := srv.KeySpecs
+		if specs == nil {
+			specs = DefaultKeySpecs
+		}
+		handler = newExecutorHandler(ctx, handler, shards, specs, srv.OffExecutor)
+	}
+	return handler, stats
+}
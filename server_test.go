@@ -0,0 +1,468 @@
+package radio_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+func TestServer_Concurrency(t *testing.T) {
+	handler := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		n, _ := strconv.Atoi(req.Args[0])
+
+		// later requests complete earlier to make sure replies are
+		// re-ordered.
+		time.Sleep(time.Duration(100-n) * time.Millisecond / 2)
+		wr.Write(radio.Integer(n))
+	})
+
+	conn, stop := startServer(t, &radio.Server{Handler: handler, Concurrency: 200})
+	defer stop()
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		conn.Send("SLOW", strconv.Itoa(i))
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		v, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+
+		if v != radio.Integer(i) {
+			t.Errorf("expecting reply %d, got '%v'", i, v)
+		}
+	}
+
+	// slowest request takes 50ms and all of them together take 2.5s when
+	// executed sequentially.
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("expecting requests to run concurrently, took %s", elapsed)
+	}
+}
+
+func TestServer_Serial(t *testing.T) {
+	handler := &serialHandler{}
+	conn, stop := startServer(t, &radio.Server{Handler: handler, Concurrency: 10})
+	defer stop()
+
+	for i := 0; i < 20; i++ {
+		cmd := "READ"
+		if i%5 == 0 {
+			cmd = "WRITE"
+		}
+		conn.Send(cmd)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		v, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+
+		if v != radio.SimpleStr("OK") {
+			t.Errorf("expecting 'OK', got '%v'", v)
+		}
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.maxReads < 2 {
+		t.Errorf("expecting reads to run concurrently, got max %d", handler.maxReads)
+	}
+}
+
+func TestServer_Sequential(t *testing.T) {
+	var running, maxRunning int32
+	handler := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		wr.Write(radio.SimpleStr("OK"))
+	})
+
+	conn, stop := startServer(t, &radio.Server{Handler: handler})
+	defer stop()
+
+	for i := 0; i < 10; i++ {
+		conn.Send("CMD")
+	}
+	conn.Flush()
+	for i := 0; i < 10; i++ {
+		conn.Receive()
+	}
+
+	if n := atomic.LoadInt32(&maxRunning); n != 1 {
+		t.Errorf("expecting requests to run sequentially by default, got %d concurrent", n)
+	}
+}
+
+// serialHandler runs READ concurrently and makes sure no READ runs while
+// a WRITE is running.
+type serialHandler struct {
+	mu       sync.Mutex
+	reads    int
+	writing  bool
+	maxReads int
+}
+
+func (sh *serialHandler) Serial(req *radio.Request) bool {
+	return req.Command == "WRITE"
+}
+
+func (sh *serialHandler) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	sh.mu.Lock()
+	if sh.writing || (req.Command == "WRITE" && sh.reads > 0) {
+		sh.mu.Unlock()
+		wr.Write(radio.ErrorStr("ERR serial request overlapped"))
+		return
+	}
+
+	if req.Command == "WRITE" {
+		sh.writing = true
+	} else {
+		sh.reads++
+		if sh.reads > sh.maxReads {
+			sh.maxReads = sh.reads
+		}
+	}
+	sh.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	sh.mu.Lock()
+	if req.Command == "WRITE" {
+		sh.writing = false
+	} else {
+		sh.reads--
+	}
+	sh.mu.Unlock()
+
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func TestClient_OnClose(suite *testing.T) {
+	suite.Parallel()
+
+	servers := map[string]func(srv *radio.Server, ctx context.Context, l net.Listener) error{
+		"ClientLoop": (*radio.Server).Serve,
+		"Concurrent": func(srv *radio.Server, ctx context.Context, l net.Listener) error {
+			srv.Concurrency = 4
+			return srv.Serve(ctx, l)
+		},
+		"EventLoop": func(srv *radio.Server, ctx context.Context, l net.Listener) error {
+			return srv.ServeEventLoop(ctx, l, radio.EventLoopOptions{Loops: 2})
+		},
+	}
+
+	for name, serve := range servers {
+		serve := serve
+		suite.Run(name, func(t *testing.T) {
+			closed := make(chan *radio.Client, 2)
+			var client *radio.Client
+			handler := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+				client = req.Client
+				req.Client.OnClose(func() { closed <- req.Client })
+				wr.Write(radio.SimpleStr("OK"))
+			})
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go serve(&radio.Server{Handler: handler}, ctx, l)
+
+			conn, err := radio.Dial(l.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			if _, err := conn.Do("PING"); err != nil {
+				t.Fatalf("not expecting error, got '%v'", err)
+			}
+			conn.Close()
+
+			// functions registered after the connection is closed are
+			// called as well.
+			select {
+			case cl := <-closed:
+				cl.OnClose(func() { closed <- cl })
+			case <-time.After(2 * time.Second):
+				t.Fatalf("expecting OnClose function to be called")
+			}
+
+			select {
+			case cl := <-closed:
+				if cl != client {
+					t.Errorf("expecting the client of the closed connection")
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("expecting OnClose function registered after close to be called")
+			}
+		})
+	}
+}
+
+func startServer(t *testing.T, srv *radio.Server) (*radio.Conn, func()) {
+	addr, stop := serve(t, srv)
+
+	conn, err := radio.Dial(addr)
+	if err != nil {
+		stop()
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		stop()
+	}
+}
+
+func serve(t *testing.T, srv *radio.Server) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go srv.Serve(ctx, l)
+
+	return l.Addr().String(), func() {
+		cancel()
+		l.Close()
+	}
+}
+
+func TestServer_SingleExecutor(t *testing.T) {
+	// counters is accessed without locking which is only safe when all the
+	// requests run on the executor.
+	counters := map[string]int{}
+	handler := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		switch req.Command {
+		case "INCR":
+			counters[req.Args[0]]++
+			wr.Write(radio.Integer(counters[req.Args[0]]))
+
+		case "SLOW":
+			time.Sleep(200 * time.Millisecond)
+			wr.Write(radio.SimpleStr("OK"))
+		}
+	})
+
+	srv := &radio.Server{
+		Handler:        handler,
+		SingleExecutor: true,
+		OffExecutor: func(req *radio.Request) bool {
+			return req.Command == "SLOW"
+		},
+	}
+	addr, stop := serve(t, srv)
+	defer stop()
+
+	slow, err := radio.Dial(addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer slow.Close()
+	slow.Send("SLOW")
+	slow.Flush()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for c := 0; c < 10; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, err := radio.Dial(addr)
+			if err != nil {
+				t.Errorf("failed to dial: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			for i := 0; i < 100; i++ {
+				conn.Do("INCR", "counter")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("expecting requests off the executor to not block it, took %s", elapsed)
+	}
+
+	conn, err := radio.Dial(addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if v, err := conn.Do("INCR", "counter"); err != nil || v != radio.Integer(1001) {
+		t.Errorf("expecting 1001, got '%v' (err=%v)", v, err)
+	}
+
+	if v, err := slow.Receive(); err != nil || v != radio.SimpleStr("OK") {
+		t.Errorf("expecting 'OK', got '%v' (err=%v)", v, err)
+	}
+}
+
+func TestServer_Shards(t *testing.T) {
+	handler := &exclusiveHandler{running: map[string]int{}}
+	addr, stop := serve(t, &radio.Server{Handler: handler, Shards: 4})
+	defer stop()
+
+	requests := [][]string{
+		{"GET", "a"}, {"GET", "b"}, {"GET", "c"}, {"GET", "d"},
+		{"MSET", "a", "1", "c", "2"}, {"GET", "e"}, {"FLUSHALL"},
+	}
+
+	var wg sync.WaitGroup
+	for c := 0; c < 8; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+
+			conn, err := radio.Dial(addr)
+			if err != nil {
+				t.Errorf("failed to dial: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			for i := 0; i < 20; i++ {
+				req := requests[(c+i)%len(requests)]
+				v, err := conn.Do(req[0], req[1:]...)
+				if err != nil || v != radio.SimpleStr("OK") {
+					t.Errorf("expecting 'OK' for %v, got '%v' (err=%v)", req, v, err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.maxRunning < 2 {
+		t.Errorf("expecting requests on different keys to run in parallel")
+	}
+}
+
+// exclusiveHandler fails requests that run concurrently with another request
+// on one of their keys or with a request without keys.
+type exclusiveHandler struct {
+	mu         sync.Mutex
+	running    map[string]int
+	total      int
+	maxRunning int
+}
+
+func (eh *exclusiveHandler) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	keys, _ := radio.DefaultKeySpecs.Keys(req)
+	if len(keys) == 0 {
+		// requests without keys conflict with every other request.
+		keys = []string{""}
+	}
+
+	eh.mu.Lock()
+	conflict := eh.running[""] > 0 || (keys[0] == "" && eh.total > 0)
+	for _, key := range keys {
+		conflict = conflict || eh.running[key] > 0
+	}
+
+	if conflict {
+		eh.mu.Unlock()
+		wr.Write(radio.ErrorStr("ERR conflicting requests running"))
+		return
+	}
+
+	for _, key := range keys {
+		eh.running[key]++
+	}
+	eh.total++
+	if eh.total > eh.maxRunning {
+		eh.maxRunning = eh.total
+	}
+	eh.mu.Unlock()
+
+	time.Sleep(2 * time.Millisecond)
+
+	eh.mu.Lock()
+	for _, key := range keys {
+		eh.running[key]--
+	}
+	eh.total--
+	eh.mu.Unlock()
+
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func BenchmarkServer_Execution(b *testing.B) {
+	newHandler := func(mu sync.Locker) radio.Handler {
+		counters := map[string]int{}
+		return radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+			mu.Lock()
+			counters[req.Args[0]]++
+			n := counters[req.Args[0]]
+			mu.Unlock()
+
+			wr.Write(radio.Integer(n))
+		})
+	}
+
+	table := map[string]*radio.Server{
+		"GlobalMutex":    {Handler: newHandler(&sync.Mutex{})},
+		"SingleExecutor": {Handler: newHandler(noLock{}), SingleExecutor: true},
+	}
+
+	for name, srv := range table {
+		srv := srv
+		b.Run(name, func(b *testing.B) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("failed to listen: %v", err)
+			}
+			defer l.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go srv.Serve(ctx, l)
+
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				conn, err := radio.Dial(l.Addr().String())
+				if err != nil {
+					b.Fatalf("failed to dial: %v", err)
+				}
+				defer conn.Close()
+
+				for pb.Next() {
+					if _, err := conn.Do("INCR", "counter"); err != nil {
+						b.Fatalf("not expecting error, got '%v'", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
@@ -0,0 +1,518 @@
+package radio
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// redisVersion is reported as redis_version by INFO as clients and tools
+// use it to decide the commands and replies supported by the server.
+const redisVersion = "7.4.0"
+
+// defaultPercentiles are the latency percentiles reported by INFO
+// latencystats by default.
+const defaultPercentiles = "50 99 99.9"
+
+// defaultMaxClients is the default of maxclients like Redis.
+const defaultMaxClients = 10000
+
+// rejectedErrors are the prefixes of the errors replied by Redis when a
+// command is rejected before being executed (e.g., due to the number of
+// arguments or the memory limit). Rejected calls are counted separately and
+// are not included in the calls and the latency of the commands.
+var rejectedErrors = []string{
+	"ERR wrong number of arguments", "ERR unknown subcommand", "OOM ",
+	"NOAUTH ", "NOPERM ", "READONLY ", "MISCONF ", "LOADING ", "BUSY ",
+	"MASTERDOWN ", "NOREPLICAS ", "CLUSTERDOWN ", "CROSSSLOT ", "MOVED ",
+	"ASK ", "TRYAGAIN ",
+}
+
+// outcomes of the requests decided by the first error replied.
+const (
+	outcomeExecuted int32 = iota
+	outcomeFailed
+	outcomeRejected
+	outcomeUnknown
+)
+
+// maxErrorTypes limits the number of error prefixes tracked for INFO
+// errorstats like Redis, so that handlers replying with arbitrary errors
+// cannot grow the statistics without bound.
+const maxErrorTypes = 128
+
+// serverStats are the statistics tracked by the server while serving the
+// connections and executing the requests.
+type serverStats struct {
+	// 64-bit fields are accessed atomically and must be 64-bit aligned.
+	startedAt    int64 // unix nanoseconds
+	port         int64
+	connected    int64
+	blocked      int64
+	maxClients   int64 // 0 if the number of clients is not limited
+	connections  uint64
+	commands     uint64
+	errorReplies uint64
+
+	runID string
+
+	// latencyTracking is 1 if the latency of the commands is recorded and
+	// percentiles holds the []float64 percentiles reported by INFO.
+	latencyTracking int32
+	percentiles     atomic.Value
+
+	mu       sync.RWMutex
+	cmds     map[string]*commandStats // keyed by the lowercase names
+	cmdList  []*commandStats
+	errors   map[string]*uint64
+	errorIDs []string
+}
+
+// commandStats are the statistics of a command.
+type commandStats struct {
+	calls    uint64
+	nsec     uint64
+	rejected uint64
+	failed   uint64
+	latency  LatencyHistogram
+	name     string
+}
+
+// CommandStats are the statistics of a command recorded by the server.
+type CommandStats struct {
+	// Name is the name of the command in lower case.
+	Name string
+
+	// Calls is the number of times the command was executed and Duration
+	// the total time spent executing it.
+	Calls    uint64
+	Duration time.Duration
+
+	// RejectedCalls is the number of calls rejected before executing the
+	// command (e.g., due to a wrong number of arguments), which are not
+	// included in Calls. FailedCalls is the number of calls that replied
+	// with an error.
+	RejectedCalls uint64
+	FailedCalls   uint64
+
+	// Latency is the distribution of the durations of the calls. It is
+	// empty if latency tracking is disabled.
+	Latency *LatencyHistogram
+}
+
+func newServerStats() *serverStats {
+	id := make([]byte, 20)
+	rand.Read(id)
+
+	ss := &serverStats{
+		runID:           hex.EncodeToString(id),
+		latencyTracking: 1,
+		cmds:            map[string]*commandStats{},
+		errors:          map[string]*uint64{},
+	}
+	ss.percentiles.Store(mustParsePercentiles(defaultPercentiles))
+	return ss
+}
+
+func (ss *serverStats) connect() {
+	atomic.AddUint64(&ss.connections, 1)
+	atomic.AddInt64(&ss.connected, 1)
+}
+
+func (ss *serverStats) disconnect() {
+	atomic.AddInt64(&ss.connected, -1)
+}
+
+// admit reports whether the accepted connection can be served without
+// exceeding maxclients. Connections beyond the limit are replied with an
+// error and closed like Redis.
+func (ss *serverStats) admit(con net.Conn) bool {
+	max := atomic.LoadInt64(&ss.maxClients)
+	if max <= 0 || atomic.LoadInt64(&ss.connected) < max {
+		return true
+	}
+
+	con.Write([]byte("-ERR max number of clients reached\r\n"))
+	con.Close()
+	return false
+}
+
+// command returns the statistics of the command creating them if required.
+// Names are matched case insensitively without allocating for the names
+// seen before.
+func (ss *serverStats) command(name string) *commandStats {
+	// the name is lowered into a buffer on the stack as the lookup with
+	// a converted byte slice does not allocate.
+	var buf [32]byte
+	var cs *commandStats
+	if len(name) <= len(buf) {
+		lower := buf[:len(name)]
+		for i := 0; i < len(name); i++ {
+			c := name[i]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			lower[i] = c
+		}
+
+		ss.mu.RLock()
+		cs = ss.cmds[string(lower)]
+		ss.mu.RUnlock()
+		if cs != nil {
+			return cs
+		}
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	lower := strings.ToLower(name)
+	if cs = ss.cmds[lower]; cs == nil {
+		cs = &commandStats{name: lower}
+		ss.cmds[lower] = cs
+
+		// the list is replaced instead of sorted in place as INFO reads
+		// it without holding the lock.
+		cmdList := append(append([]*commandStats{}, ss.cmdList...), cs)
+		sort.Slice(cmdList, func(i, j int) bool {
+			return cmdList[i].name < cmdList[j].name
+		})
+		ss.cmdList = cmdList
+	}
+	return cs
+}
+
+// recordError counts an error reply by its prefix (e.g., "WRONGTYPE").
+func (ss *serverStats) recordError(msg string) {
+	atomic.AddUint64(&ss.errorReplies, 1)
+
+	prefix := msg
+	if i := strings.IndexByte(msg, ' '); i >= 0 {
+		prefix = msg[:i]
+	}
+
+	ss.mu.RLock()
+	count := ss.errors[prefix]
+	ss.mu.RUnlock()
+
+	if count == nil {
+		ss.mu.Lock()
+		if count = ss.errors[prefix]; count == nil {
+			if len(ss.errors) >= maxErrorTypes {
+				ss.mu.Unlock()
+				return
+			}
+			count = new(uint64)
+			ss.errors[prefix] = count
+			ss.errorIDs = append(ss.errorIDs, prefix)
+			sort.Strings(ss.errorIDs)
+		}
+		ss.mu.Unlock()
+	}
+	atomic.AddUint64(count, 1)
+}
+
+// statsHandler records the statistics of the requests served by the wrapped
+// handler.
+type statsHandler struct {
+	handler Handler
+	stats   *serverStats
+}
+
+func (sh *statsHandler) ServeRESP(wr ResponseWriter, req *Request) {
+	sw := &statsWriter{ResponseWriter: wr, stats: sh.stats}
+
+	start := time.Now()
+	sh.handler.ServeRESP(sw, req)
+	elapsed := time.Since(start)
+
+	// like Redis, unknown commands are only counted as errors and rejected
+	// commands are not counted as processed.
+	outcome := atomic.LoadInt32(&sw.outcome)
+	if outcome == outcomeUnknown {
+		return
+	}
+
+	cs := sh.stats.command(req.Command)
+	if outcome == outcomeRejected {
+		atomic.AddUint64(&cs.rejected, 1)
+		return
+	}
+
+	atomic.AddUint64(&sh.stats.commands, 1)
+	atomic.AddUint64(&cs.calls, 1)
+	atomic.AddUint64(&cs.nsec, uint64(elapsed))
+	if outcome == outcomeFailed {
+		atomic.AddUint64(&cs.failed, 1)
+	}
+	if atomic.LoadInt32(&sh.stats.latencyTracking) == 1 {
+		cs.latency.Record(elapsed)
+	}
+}
+
+// Serial reports whether the wrapped handler requires the request to be
+// executed serially.
+func (sh *statsHandler) Serial(req *Request) bool {
+	if serial, ok := sh.handler.(SerialHandler); ok {
+		return serial.Serial(req)
+	}
+	return false
+}
+
+// statsWriter counts the error replies written for a request and decides
+// the outcome of the request from the first one.
+type statsWriter struct {
+	ResponseWriter
+	stats   *serverStats
+	outcome int32
+}
+
+func (sw *statsWriter) Write(v Value) (int, error) {
+	if es, ok := v.(ErrorStr); ok {
+		sw.stats.recordError(string(es))
+		atomic.CompareAndSwapInt32(&sw.outcome, outcomeExecuted, errorOutcome(string(es)))
+	}
+	return sw.ResponseWriter.Write(v)
+}
+
+func errorOutcome(msg string) int32 {
+	if strings.HasPrefix(msg, "ERR unknown command") {
+		return outcomeUnknown
+	}
+
+	for _, prefix := range rejectedErrors {
+		if strings.HasPrefix(msg, prefix) {
+			return outcomeRejected
+		}
+	}
+	return outcomeFailed
+}
+
+// reset clears the statistics reset by CONFIG RESETSTAT.
+func (ss *serverStats) reset() {
+	atomic.StoreUint64(&ss.connections, 0)
+	atomic.StoreUint64(&ss.commands, 0)
+	atomic.StoreUint64(&ss.errorReplies, 0)
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for _, cs := range ss.cmdList {
+		atomic.StoreUint64(&cs.calls, 0)
+		atomic.StoreUint64(&cs.nsec, 0)
+		atomic.StoreUint64(&cs.rejected, 0)
+		atomic.StoreUint64(&cs.failed, 0)
+		cs.latency.Reset()
+	}
+	ss.errors = map[string]*uint64{}
+	ss.errorIDs = nil
+}
+
+// commandStats returns the statistics of the commands with any calls. The
+// latency histograms are only copied if withLatency is set.
+func (ss *serverStats) commandStats(withLatency bool) []CommandStats {
+	ss.mu.RLock()
+	cmds := ss.cmdList
+	ss.mu.RUnlock()
+
+	var stats []CommandStats
+	for _, cs := range cmds {
+		st := CommandStats{
+			Name:          cs.name,
+			Calls:         atomic.LoadUint64(&cs.calls),
+			Duration:      time.Duration(atomic.LoadUint64(&cs.nsec)),
+			RejectedCalls: atomic.LoadUint64(&cs.rejected),
+			FailedCalls:   atomic.LoadUint64(&cs.failed),
+		}
+		if withLatency {
+			st.Latency = cs.latency.snapshot()
+		}
+		if st.Calls > 0 || st.RejectedCalls > 0 {
+			stats = append(stats, st)
+		}
+	}
+	return stats
+}
+
+// parsePercentiles parses the space separated percentiles of the
+// latency-tracking-info-percentiles parameter.
+func parsePercentiles(s string) ([]float64, error) {
+	var percentiles []float64
+	for _, f := range strings.Fields(s) {
+		p, err := strconv.ParseFloat(f, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, errors.New("argument(s) must be percentiles between 0 and 100")
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+func mustParsePercentiles(s string) []float64 {
+	percentiles, err := parsePercentiles(s)
+	if err != nil {
+		panic("radio: " + err.Error())
+	}
+	return percentiles
+}
+
+// stats returns the statistics of the server creating them if required.
+func (srv *Server) stats() *serverStats {
+	srv.statsOnce.Do(func() {
+		srv.serverStats = newServerStats()
+	})
+	return srv.serverStats
+}
+
+// CommandStats returns the statistics of the commands executed by the server
+// sorted by name. Commands without any calls since the statistics were last
+// reset are not included.
+func (srv *Server) CommandStats() []CommandStats {
+	return srv.stats().commandStats(true)
+}
+
+// ResetStats resets the statistics of the server like CONFIG RESETSTAT.
+func (srv *Server) ResetStats() {
+	srv.stats().reset()
+}
+
+// RegisterConfig registers the maxclients, latency-tracking and
+// latency-tracking-info-percentiles parameters with the configuration and
+// resets the statistics of the server on CONFIG RESETSTAT. The number of
+// clients is limited to maxclients (10000 by default) once registered and
+// is not limited otherwise.
+func (srv *Server) RegisterConfig(cfg *Config) {
+	ss := srv.stats()
+
+	maxClients := NewIntConfig(defaultMaxClients, 1, math.MaxInt64)
+	atomic.StoreInt64(&ss.maxClients, maxClients.Get())
+
+	tracking := NewBoolConfig(true)
+	percentiles := NewStringConfig(defaultPercentiles)
+	percentiles.Validate = func(s string) error {
+		_, err := parsePercentiles(s)
+		return err
+	}
+
+	cfg.Register(
+		ConfigParam{
+			Name:  "maxclients",
+			Value: maxClients,
+			OnChange: func() error {
+				atomic.StoreInt64(&ss.maxClients, maxClients.Get())
+				return nil
+			},
+		},
+		ConfigParam{
+			Name:  "latency-tracking",
+			Value: tracking,
+			OnChange: func() error {
+				enabled := int32(0)
+				if tracking.Get() {
+					enabled = 1
+				}
+				atomic.StoreInt32(&ss.latencyTracking, enabled)
+				return nil
+			},
+		},
+		ConfigParam{
+			Name:  "latency-tracking-info-percentiles",
+			Value: percentiles,
+			OnChange: func() error {
+				ss.percentiles.Store(mustParsePercentiles(percentiles.Get()))
+				return nil
+			},
+		},
+	)
+	cfg.OnResetStat(ss.reset)
+}
+
+// RegisterInfo registers the sections of INFO with the statistics tracked by
+// the server: server, clients, stats, commandstats, errorstats and
+// latencystats. Other sections (e.g., keyspace) are registered by the
+// handlers maintaining the data.
+func (srv *Server) RegisterInfo(info *Info) {
+	ss := srv.stats()
+
+	info.Register("server", true, func(w *InfoWriter) {
+		now := time.Now()
+		uptime := int64(0)
+		if startedAt := atomic.LoadInt64(&ss.startedAt); startedAt > 0 {
+			uptime = int64(now.Sub(time.Unix(0, startedAt)) / time.Second)
+		}
+
+		w.Field("redis_version", redisVersion)
+		w.Field("redis_mode", "standalone")
+		w.Field("os", runtime.GOOS+" "+runtime.GOARCH)
+		w.Field("arch_bits", strconv.Itoa(strconv.IntSize))
+		w.Field("go_version", runtime.Version())
+		w.Field("process_id", strconv.Itoa(os.Getpid()))
+		w.Field("run_id", ss.runID)
+		w.Field("tcp_port", strconv.FormatInt(atomic.LoadInt64(&ss.port), 10))
+		w.Field("server_time_usec", strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10))
+		w.Field("uptime_in_seconds", strconv.FormatInt(uptime, 10))
+		w.Field("uptime_in_days", strconv.FormatInt(uptime/(24*60*60), 10))
+	})
+
+	info.Register("clients", true, func(w *InfoWriter) {
+		w.Field("connected_clients", strconv.FormatInt(atomic.LoadInt64(&ss.connected), 10))
+		w.Field("blocked_clients", strconv.FormatInt(atomic.LoadInt64(&ss.blocked), 10))
+	})
+
+	info.Register("stats", true, func(w *InfoWriter) {
+		w.Field("total_connections_received", strconv.FormatUint(atomic.LoadUint64(&ss.connections), 10))
+		w.Field("total_commands_processed", strconv.FormatUint(atomic.LoadUint64(&ss.commands), 10))
+		w.Field("total_error_replies", strconv.FormatUint(atomic.LoadUint64(&ss.errorReplies), 10))
+	})
+
+	info.Register("commandstats", false, func(w *InfoWriter) {
+		for _, st := range ss.commandStats(false) {
+			usec := float64(st.Duration) / float64(time.Microsecond)
+			perCall := 0.0
+			if st.Calls > 0 {
+				perCall = usec / float64(st.Calls)
+			}
+			w.Fieldf("cmdstat_"+st.Name, "calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+				st.Calls, int64(usec), perCall, st.RejectedCalls, st.FailedCalls)
+		}
+	})
+
+	info.Register("errorstats", true, func(w *InfoWriter) {
+		ss.mu.RLock()
+		defer ss.mu.RUnlock()
+
+		for _, prefix := range ss.errorIDs {
+			w.Field("errorstat_"+prefix, "count="+strconv.FormatUint(atomic.LoadUint64(ss.errors[prefix]), 10))
+		}
+	})
+
+	info.Register("latencystats", false, func(w *InfoWriter) {
+		percentiles := ss.percentiles.Load().([]float64)
+		for _, st := range ss.commandStats(true) {
+			if st.Latency.Count() == 0 {
+				continue
+			}
+
+			var sb strings.Builder
+			for i, p := range percentiles {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				usec := float64(st.Latency.Percentile(p)) / float64(time.Microsecond)
+				fmt.Fprintf(&sb, "p%s=%.3f", strconv.FormatFloat(p, 'f', -1, 64), usec)
+			}
+			w.Field("latency_percentiles_usec_"+st.Name, sb.String())
+		}
+	})
+}
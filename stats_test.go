@@ -0,0 +1,240 @@
+package radio_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+func newStatsServer() (*radio.Server, *radio.Config) {
+	handler := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		switch strings.ToLower(req.Command) {
+		case "set":
+			wr.Write(radio.ErrorStr("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		case "del":
+			wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'del' command"))
+		case "slow":
+			time.Sleep(2 * time.Millisecond)
+			wr.Write(radio.SimpleStr("OK"))
+		default:
+			wr.Write(radio.SimpleStr("OK"))
+		}
+	})
+
+	info := &radio.Info{Handler: handler}
+	cfg := &radio.Config{Handler: info}
+	srv := &radio.Server{Handler: cfg}
+	srv.RegisterInfo(info)
+	srv.RegisterConfig(cfg)
+	return srv, cfg
+}
+
+func TestServer_CommandStats(t *testing.T) {
+	srv, _ := newStatsServer()
+	conn, stop := startServer(t, srv)
+	defer stop()
+
+	for _, cmd := range []string{"GET", "get", "GeT", "SET", "DEL", "SLOW"} {
+		if _, err := conn.Do(cmd); err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+	}
+
+	stats := map[string]radio.CommandStats{}
+	var names []string
+	for _, st := range srv.CommandStats() {
+		stats[st.Name] = st
+		names = append(names, st.Name)
+	}
+
+	if expected := []string{"del", "get", "set", "slow"}; !reflect.DeepEqual(expected, names) {
+		t.Fatalf("expecting statistics of %v, got %v", expected, names)
+	}
+	if del := stats["del"]; del.Calls != 0 || del.RejectedCalls != 1 || del.Latency.Count() != 0 {
+		t.Errorf("expecting del to be rejected once, got %+v", del)
+	}
+	if get := stats["get"]; get.Calls != 3 || get.FailedCalls != 0 || get.Latency.Count() != 3 {
+		t.Errorf("expecting 3 calls of get, got %+v", get)
+	}
+	if set := stats["set"]; set.Calls != 1 || set.FailedCalls != 1 {
+		t.Errorf("expecting set to fail once, got %+v", set)
+	}
+	if slow := stats["slow"]; slow.Duration < 2*time.Millisecond || slow.Latency.Percentile(50) < 2*time.Millisecond {
+		t.Errorf("expecting slow to take at least 2ms, got %s and p50 %s", slow.Duration, slow.Latency.Percentile(50))
+	}
+
+	fields := infoFields(t, conn, "commandstats", "latencystats")
+	if cs := fields["cmdstat_del"]; cs != "calls=0,usec=0,usec_per_call=0.00,rejected_calls=1,failed_calls=0" {
+		t.Errorf("unexpected statistics of del '%s'", cs)
+	}
+	if cs := fields["cmdstat_set"]; !strings.HasPrefix(cs, "calls=1,") || !strings.HasSuffix(cs, ",rejected_calls=0,failed_calls=1") {
+		t.Errorf("unexpected statistics of set '%s'", cs)
+	}
+
+	percentiles := regexp.MustCompile(`^p50=\d+\.\d{3},p99=\d+\.\d{3},p99\.9=\d+\.\d{3}$`)
+	if ls := fields["latency_percentiles_usec_slow"]; !percentiles.MatchString(ls) {
+		t.Errorf("unexpected latency of slow '%s'", ls)
+	}
+	if _, found := fields["latency_percentiles_usec_del"]; found {
+		t.Errorf("not expecting latency of rejected commands")
+	}
+}
+
+func TestServer_CommandStatsConfig(t *testing.T) {
+	srv, _ := newStatsServer()
+	conn, stop := startServer(t, srv)
+	defer stop()
+
+	steps := []struct {
+		cmd   []string
+		reply radio.Value
+	}{
+		{cmd: []string{"GET"}, reply: radio.SimpleStr("OK")},
+		{cmd: []string{"CONFIG", "SET", "latency-tracking-info-percentiles", "50 101"}, reply: radio.ErrorStr("ERR CONFIG SET failed (possibly related to argument 'latency-tracking-info-percentiles') - argument(s) must be percentiles between 0 and 100")},
+		{cmd: []string{"CONFIG", "SET", "latency-tracking-info-percentiles", "90 99.99"}, reply: radio.SimpleStr("OK")},
+		{cmd: []string{"CONFIG", "SET", "latency-tracking", "no"}, reply: radio.SimpleStr("OK")},
+		{cmd: []string{"GET"}, reply: radio.SimpleStr("OK")},
+	}
+	for _, st := range steps {
+		v, err := conn.Do(st.cmd[0], st.cmd[1:]...)
+		if err != nil {
+			t.Fatalf("not expecting error, got '%v'", err)
+		}
+		if !reflect.DeepEqual(st.reply, v) {
+			t.Errorf("%v: expecting '%#v', got '%#v'", st.cmd, st.reply, v)
+		}
+	}
+
+	fields := infoFields(t, conn, "commandstats", "latencystats")
+	if !strings.HasPrefix(fields["cmdstat_get"], "calls=2,") {
+		t.Errorf("expecting 2 calls of get, got '%s'", fields["cmdstat_get"])
+	}
+	if ls := fields["latency_percentiles_usec_get"]; !regexp.MustCompile(`^p90=\d+\.\d{3},p99\.99=\d+\.\d{3}$`).MatchString(ls) {
+		t.Errorf("unexpected latency of get '%s'", ls)
+	}
+	for _, st := range srv.CommandStats() {
+		if st.Name == "get" && st.Latency.Count() != 1 {
+			t.Errorf("expecting latency of 1 call of get, got %d", st.Latency.Count())
+		}
+	}
+
+	if v, err := conn.Do("CONFIG", "RESETSTAT"); err != nil || v != radio.SimpleStr("OK") {
+		t.Fatalf("expecting 'OK', got '%v' (err=%v)", v, err)
+	}
+
+	// the statistics of CONFIG RESETSTAT itself are recorded after the
+	// reset.
+	fields = infoFields(t, conn, "stats", "commandstats", "errorstats")
+	if len(fields) != 4 || fields["total_commands_processed"] != "1" || fields["total_error_replies"] != "0" ||
+		!strings.HasPrefix(fields["cmdstat_config"], "calls=1,") {
+		t.Errorf("expecting statistics to be reset, got '%v'", fields)
+	}
+}
+
+func TestServer_MaxClients(suite *testing.T) {
+	suite.Parallel()
+
+	serves := map[string]func(srv *radio.Server, ctx context.Context, l net.Listener) error{
+		"Serve": (*radio.Server).Serve,
+		"EventLoop": func(srv *radio.Server, ctx context.Context, l net.Listener) error {
+			return srv.ServeEventLoop(ctx, l, radio.EventLoopOptions{Loops: 1})
+		},
+	}
+
+	for title, serve := range serves {
+		serve := serve
+		suite.Run(title, func(t *testing.T) {
+			t.Parallel()
+
+			srv, _ := newStatsServer()
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go serve(srv, ctx, l)
+
+			dial := func() *radio.Conn {
+				conn, err := radio.Dial(l.Addr().String())
+				if err != nil {
+					t.Fatalf("failed to dial: %v", err)
+				}
+				return conn
+			}
+
+			conn := dial()
+			defer conn.Close()
+			if v, err := conn.Do("CONFIG", "SET", "maxclients", "2"); err != nil || v != radio.SimpleStr("OK") {
+				t.Fatalf("expecting 'OK', got '%v' (err=%v)", v, err)
+			}
+
+			second := dial()
+			if v, err := second.Do("PING"); err != nil || v != radio.SimpleStr("OK") {
+				t.Fatalf("expecting 'OK', got '%v' (err=%v)", v, err)
+			}
+
+			rejected := dial()
+			defer rejected.Close()
+			if v, err := rejected.Do("PING"); err != nil || v != radio.ErrorStr("ERR max number of clients reached") {
+				t.Fatalf("expecting the client to be rejected, got '%v' (err=%v)", v, err)
+			}
+
+			second.Close()
+			waitInfoFields(t, conn, map[string]string{"connected_clients": "1"})
+
+			third := dial()
+			defer third.Close()
+			if v, err := third.Do("PING"); err != nil || v != radio.SimpleStr("OK") {
+				t.Fatalf("expecting 'OK', got '%v' (err=%v)", v, err)
+			}
+			if v, err := conn.Do("CONFIG", "GET", "maxclients"); err != nil || len(v.(*radio.Array).Items) != 2 {
+				t.Errorf("expecting maxclients, got '%v' (err=%v)", v, err)
+			}
+		})
+	}
+}
+
+func BenchmarkServer_CommandStats(b *testing.B) {
+	for _, tracking := range []string{"yes", "no"} {
+		tracking := tracking
+		b.Run("LatencyTracking="+tracking, func(b *testing.B) {
+			srv, cfg := newStatsServer()
+			if err := cfg.Set("latency-tracking", tracking); err != nil {
+				b.Fatalf("not expecting error, got '%v'", err)
+			}
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("failed to listen: %v", err)
+			}
+			defer l.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go srv.Serve(ctx, l)
+
+			b.ReportAllocs()
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				conn, err := radio.Dial(l.Addr().String())
+				if err != nil {
+					b.Fatalf("failed to dial: %v", err)
+				}
+				defer conn.Close()
+
+				for pb.Next() {
+					if _, err := conn.Do("GET", "k"); err != nil {
+						b.Fatalf("not expecting error, got '%v'", err)
+					}
+				}
+			})
+		})
+	}
+}
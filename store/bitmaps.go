@@ -0,0 +1,625 @@
+package store
+
+import (
+	"math/bits"
+	"strings"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+var (
+	errBitOffset     = radio.ErrorStr("ERR bit offset is not an integer or out of range")
+	errBitValue      = radio.ErrorStr("ERR bit is not an integer or out of range")
+	errBitfieldType  = radio.ErrorStr("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	errOverflowType  = radio.ErrorStr("ERR Invalid OVERFLOW type specified")
+	errBitfieldRO    = radio.ErrorStr("ERR BITFIELD_RO only supports the GET subcommand")
+	errBitopNotArity = radio.ErrorStr("ERR BITOP NOT must be called with a single source key.")
+)
+
+func init() {
+	register("setbit", 4, flagWrite|flagDenyOOM, setbitCommand)
+	register("getbit", 3, flagReadOnly, getbitCommand)
+	register("bitcount", -2, flagReadOnly, bitcountCommand)
+	register("bitpos", -3, flagReadOnly, bitposCommand)
+	register("bitop", -4, flagWrite|flagDenyOOM, bitopCommand)
+	register("bitfield", -2, flagWrite|flagDenyOOM, bitfieldCommand)
+	register("bitfield_ro", -2, flagReadOnly, bitfieldCommand)
+}
+
+// parseBitOffset parses the offset of a bit. If hash is set, offsets
+// prefixed with '#' are multiplied by width.
+func parseBitOffset(s string, hash bool, width int) (int64, bool) {
+	multiply := false
+	if hash && strings.HasPrefix(s, "#") {
+		s, multiply = s[1:], true
+	}
+
+	n, ok := parseInt(s)
+	if !ok || n < 0 || n>>3 >= maxStringSize {
+		return 0, false
+	}
+
+	if multiply {
+		n *= int64(width)
+		if n>>3 >= maxStringSize {
+			return 0, false
+		}
+	}
+	return n, true
+}
+
+// bitAt returns the bit at the offset where the bits beyond the end of the
+// value are zero. Bit zero is the most significant bit of the first byte.
+func bitAt(val []byte, offset int64) int {
+	n := offset >> 3
+	if n >= int64(len(val)) {
+		return 0
+	}
+	return int(val[n]>>(7-uint(offset&7))) & 1
+}
+
+func setBit(val []byte, offset int64, bit int) {
+	mask := byte(1) << (7 - uint(offset&7))
+	if bit == 0 {
+		val[offset>>3] &^= mask
+	} else {
+		val[offset>>3] |= mask
+	}
+}
+
+// grow returns a copy of the value of at least size bytes. The value is
+// copied as the bytes may still be referenced by replies that are not yet
+// written.
+func grow(val []byte, size int64) []byte {
+	if size < int64(len(val)) {
+		size = int64(len(val))
+	}
+
+	updated := make([]byte, size)
+	copy(updated, val)
+	return updated
+}
+
+func setbitCommand(c *call) {
+	offset, ok := parseBitOffset(c.args[1], false, 0)
+	if !ok {
+		c.reply(errBitOffset)
+		return
+	}
+
+	bit := c.args[2]
+	if bit != "0" && bit != "1" {
+		c.reply(errBitValue)
+		return
+	}
+
+	key := c.args[0]
+	e, val, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	old := bitAt(val, offset)
+	updated := grow(val, offset>>3+1)
+	setBit(updated, offset, int(bit[0]-'0'))
+	if e == nil {
+		c.add(key, updated)
+	} else {
+		e.val = updated
+	}
+
+	c.touch(key)
+	c.notify(keyspace.String, "setbit", key)
+	c.reply(radio.Integer(old))
+}
+
+func getbitCommand(c *call) {
+	offset, ok := parseBitOffset(c.args[1], false, 0)
+	if !ok {
+		c.reply(errBitOffset)
+		return
+	}
+
+	_, val, ok := c.lookupString(c.args[0])
+	if !ok {
+		return
+	}
+	c.reply(radio.Integer(bitAt(val, offset)))
+}
+
+// parseBitRange parses the optional start and end offsets with the unit
+// (BYTE or BIT) of the range starting at args[0]. end defaults to -1.
+func parseBitRange(args []string) (start, end int64, isBit bool, err radio.Value) {
+	end = -1
+	if len(args) == 0 {
+		return 0, end, false, nil
+	}
+
+	var ok bool
+	if start, ok = parseInt(args[0]); !ok {
+		return 0, 0, false, errNotInteger
+	}
+
+	if len(args) > 1 {
+		if end, ok = parseInt(args[1]); !ok {
+			return 0, 0, false, errNotInteger
+		}
+	}
+
+	if len(args) > 2 {
+		switch strings.ToLower(args[2]) {
+		case "byte":
+		case "bit":
+			isBit = true
+		default:
+			return 0, 0, false, errSyntax
+		}
+	}
+	return start, end, isBit, nil
+}
+
+func bitcountCommand(c *call) {
+	var start, end int64
+	var isBit bool
+	switch len(c.args) {
+	case 1:
+		end = -1
+	case 3, 4:
+		var err radio.Value
+		if start, end, isBit, err = parseBitRange(c.args[1:]); err != nil {
+			c.reply(err)
+			return
+		}
+	default:
+		c.reply(errSyntax)
+		return
+	}
+
+	_, val, ok := c.lookupString(c.args[0])
+	if !ok {
+		return
+	}
+
+	total := int64(len(val))
+	if isBit {
+		total *= 8
+	}
+
+	if start < 0 && end < 0 && start > end {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	start, end, ok = clampRange(start, end, total)
+	if !ok {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	if !isBit {
+		c.reply(radio.Integer(popcount(val[start : end+1])))
+		return
+	}
+
+	// the bits before start in the first byte and after end in the last
+	// byte are masked.
+	first, last := start>>3, end>>3
+	firstMask := byte(0xff) >> uint(start&7)
+	lastMask := byte(0xff) << (7 - uint(end&7))
+	if first == last {
+		c.reply(radio.Integer(bits.OnesCount8(val[first] & firstMask & lastMask)))
+		return
+	}
+
+	n := bits.OnesCount8(val[first]&firstMask) + popcount(val[first+1:last]) + bits.OnesCount8(val[last]&lastMask)
+	c.reply(radio.Integer(n))
+}
+
+func popcount(val []byte) int {
+	n := 0
+	for _, b := range val {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
+
+func bitposCommand(c *call) {
+	bit := c.args[1]
+	if bit != "0" && bit != "1" {
+		c.reply(radio.ErrorStr("ERR The bit argument must be 1 or 0."))
+		return
+	}
+	want := int(bit[0] - '0')
+
+	if len(c.args) > 5 {
+		c.reply(errSyntax)
+		return
+	}
+
+	start, end, isBit, err := parseBitRange(c.args[2:])
+	if err != nil {
+		c.reply(err)
+		return
+	}
+	endGiven := len(c.args) > 3
+
+	e, val, ok := c.lookupString(c.args[0])
+	if !ok {
+		return
+	}
+
+	// a missing key is an infinite sequence of zeros.
+	if e == nil {
+		if want == 1 {
+			c.reply(radio.Integer(-1))
+		} else {
+			c.reply(radio.Integer(0))
+		}
+		return
+	}
+
+	total := int64(len(val))
+	if isBit {
+		total *= 8
+	}
+
+	start, end, ok = clampRange(start, end, total)
+	if !ok {
+		c.reply(radio.Integer(-1))
+		return
+	}
+
+	if !isBit {
+		start, end = start*8, end*8+7
+	}
+
+	pos := bitpos(val, start, end, want)
+	if pos == -1 && want == 0 && !endGiven {
+		// the value is padded with zeros unless the range is explicit.
+		pos = end + 1
+	}
+	c.reply(radio.Integer(pos))
+}
+
+// bitpos returns the offset of the first bit between the inclusive start
+// and end offsets set to want or -1 if there is none.
+func bitpos(val []byte, start, end int64, want int) int64 {
+	skip := byte(0)
+	if want == 0 {
+		skip = 0xff
+	}
+
+	for i := start; i <= end; {
+		if i&7 == 0 && i+7 <= end && val[i>>3] == skip {
+			i += 8
+			continue
+		}
+
+		if bitAt(val, i) == want {
+			return i
+		}
+		i++
+	}
+
+	return -1
+}
+
+func bitopCommand(c *call) {
+	op, dst, keys := strings.ToLower(c.args[0]), c.args[1], c.args[2:]
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(keys) != 1 {
+			c.reply(errBitopNotArity)
+			return
+		}
+	default:
+		c.reply(errSyntax)
+		return
+	}
+
+	vals := make([][]byte, len(keys))
+	size := 0
+	for i, key := range keys {
+		_, val, ok := c.lookupString(key)
+		if !ok {
+			return
+		}
+
+		vals[i] = val
+		if len(val) > size {
+			size = len(val)
+		}
+	}
+
+	if size == 0 {
+		if c.delete(dst) {
+			c.notify(keyspace.Generic, "del", dst)
+		}
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	// the shorter values are padded with zeros.
+	res := make([]byte, size)
+	for i := range res {
+		var b byte
+		for j, val := range vals {
+			var v byte
+			if i < len(val) {
+				v = val[i]
+			}
+
+			switch {
+			case j == 0:
+				b = v
+			case op == "and":
+				b &= v
+			case op == "or":
+				b |= v
+			case op == "xor":
+				b ^= v
+			}
+		}
+
+		if op == "not" {
+			b = ^b
+		}
+		res[i] = b
+	}
+
+	c.set(dst, res, false)
+	c.touch(dst)
+	c.notify(keyspace.String, "set", dst)
+	c.reply(radio.Integer(size))
+}
+
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfieldOp is a GET, SET or INCRBY operation of BITFIELD.
+type bitfieldOp struct {
+	op       string
+	offset   int64
+	width    int
+	signed   bool
+	value    int64
+	overflow int
+}
+
+// parseBitfieldType parses the type of a field (e.g., i8 or u16).
+func parseBitfieldType(s string) (signed bool, width int, ok bool) {
+	if len(s) < 2 {
+		return false, 0, false
+	}
+
+	switch s[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return false, 0, false
+	}
+
+	n, ok := parseInt(s[1:])
+	if !ok || n < 1 || (signed && n > 64) || (!signed && n > 63) {
+		return false, 0, false
+	}
+	return signed, int(n), true
+}
+
+func (c *call) parseBitfield(readOnly bool) ([]bitfieldOp, radio.Value) {
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 1; i < len(c.args); i++ {
+		op, remaining := strings.ToLower(c.args[i]), len(c.args)-i-1
+		switch {
+		case op == "get" && remaining >= 2:
+		case (op == "set" || op == "incrby") && remaining >= 3:
+		case op == "overflow" && remaining >= 1:
+			i++
+			switch strings.ToLower(c.args[i]) {
+			case "wrap":
+				overflow = overflowWrap
+			case "sat":
+				overflow = overflowSat
+			case "fail":
+				overflow = overflowFail
+			default:
+				return nil, errOverflowType
+			}
+			continue
+
+		default:
+			return nil, errSyntax
+		}
+
+		signed, width, ok := parseBitfieldType(c.args[i+1])
+		if !ok {
+			return nil, errBitfieldType
+		}
+
+		offset, ok := parseBitOffset(c.args[i+2], true, width)
+		if !ok {
+			return nil, errBitOffset
+		}
+
+		bop := bitfieldOp{op: op, offset: offset, width: width, signed: signed, overflow: overflow}
+		if op != "get" {
+			if bop.value, ok = parseInt(c.args[i+3]); !ok {
+				return nil, errNotInteger
+			}
+			if readOnly {
+				return nil, errBitfieldRO
+			}
+			i++
+		}
+
+		ops = append(ops, bop)
+		i += 2
+	}
+
+	return ops, nil
+}
+
+func bitfieldCommand(c *call) {
+	ops, err := c.parseBitfield(c.name == "bitfield_ro")
+	if err != nil {
+		c.reply(err)
+		return
+	}
+
+	key := c.args[0]
+	e, val, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	// the value is grown once to fit all the fields written.
+	size := int64(-1)
+	for _, op := range ops {
+		if end := (op.offset + int64(op.width) - 1) >> 3; op.op != "get" && end >= size {
+			size = end + 1
+		}
+	}
+
+	if size >= 0 {
+		val = grow(val, size)
+		if e == nil {
+			c.add(key, val)
+		} else {
+			e.val = val
+		}
+	}
+
+	arr := &radio.Array{Items: make([]radio.Value, 0, len(ops))}
+	for _, op := range ops {
+		old := getField(val, op.offset, op.width)
+		if op.signed {
+			old = signExtend(old, op.width)
+		}
+
+		if op.op == "get" {
+			arr.Items = append(arr.Items, radio.Integer(int64(old)))
+			continue
+		}
+
+		value, incr := op.value, int64(0)
+		if op.op == "incrby" {
+			value, incr = int64(old), op.value
+		}
+
+		var overflowed bool
+		var res int64
+		if op.signed {
+			overflowed, res = signedOverflow(value, incr, op.width, op.overflow)
+		} else {
+			var ures uint64
+			overflowed, ures = unsignedOverflow(uint64(value), incr, op.width, op.overflow)
+			res = int64(ures)
+		}
+
+		switch {
+		case overflowed && op.overflow == overflowFail:
+			arr.Items = append(arr.Items, nullBulk)
+			continue
+		case !overflowed:
+			res = value + incr
+		}
+
+		setField(val, op.offset, op.width, uint64(res))
+		if op.op == "incrby" {
+			arr.Items = append(arr.Items, radio.Integer(res))
+		} else {
+			arr.Items = append(arr.Items, radio.Integer(int64(old)))
+		}
+	}
+
+	if size >= 0 {
+		c.touch(key)
+		c.notify(keyspace.String, "setbit", key)
+	}
+	c.reply(arr)
+}
+
+// getField returns the unsigned integer of width bits at the offset.
+func getField(val []byte, offset int64, width int) uint64 {
+	var v uint64
+	for i := int64(0); i < int64(width); i++ {
+		v = v<<1 | uint64(bitAt(val, offset+i))
+	}
+	return v
+}
+
+func setField(val []byte, offset int64, width int, v uint64) {
+	for i := 0; i < width; i++ {
+		setBit(val, offset+int64(i), int(v>>uint(width-1-i)&1))
+	}
+}
+
+func signExtend(v uint64, width int) uint64 {
+	if width < 64 && v&(1<<uint(width-1)) != 0 {
+		v |= ^uint64(0) << uint(width)
+	}
+	return v
+}
+
+// unsignedOverflow reports whether value+incr overflows an unsigned integer
+// of width bits and returns the value to use instead according to the
+// overflow behaviour.
+func unsignedOverflow(value uint64, incr int64, width int, overflow int) (bool, uint64) {
+	max := uint64(1)<<uint(width) - 1
+	maxIncr := int64(max - value)
+	minIncr := -int64(value)
+
+	switch {
+	case value > max || (incr > 0 && incr > maxIncr):
+		if overflow == overflowSat {
+			return true, max
+		}
+	case incr < 0 && incr < minIncr:
+		if overflow == overflowSat {
+			return true, 0
+		}
+	default:
+		return false, 0
+	}
+
+	return true, (value + uint64(incr)) & max
+}
+
+// signedOverflow reports whether value+incr overflows a signed integer of
+// width bits and returns the value to use instead according to the overflow
+// behaviour.
+func signedOverflow(value, incr int64, width int, overflow int) (bool, int64) {
+	max := int64(1)<<uint(width-1) - 1
+	if width == 64 {
+		max = 1<<63 - 1
+	}
+	min := -max - 1
+
+	// maxIncr and minIncr may overflow but they are used only after the
+	// range of value is checked.
+	maxIncr := int64(uint64(max) - uint64(value))
+	minIncr := min - value
+
+	switch {
+	case value > max || (width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr):
+		if overflow == overflowSat {
+			return true, max
+		}
+	case value < min || (width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr):
+		if overflow == overflowSat {
+			return true, min
+		}
+	default:
+		return false, 0
+	}
+
+	// the sum wraps around keeping the sign bit.
+	return true, int64(signExtend((uint64(value)+uint64(incr))&(^uint64(0)>>uint(64-width)), width))
+}
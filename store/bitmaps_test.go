@@ -0,0 +1,130 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/spy16/radio"
+)
+
+func TestBitmaps(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "SETBIT",
+			steps: []step{
+				{cmd: "SETBIT k 7 1", reply: radio.Integer(0)},
+				{cmd: "GET k", reply: bulk("\x01")},
+				{cmd: "SETBIT k 7 0", reply: radio.Integer(1)},
+				{cmd: "SETBIT k 9 1", reply: radio.Integer(0)},
+				{cmd: "GET k", reply: bulk("\x00\x40")},
+				{cmd: "GETBIT k 9", reply: radio.Integer(1)},
+				{cmd: "GETBIT k 100", reply: radio.Integer(0)},
+				{cmd: "GETBIT x 0", reply: radio.Integer(0)},
+				{cmd: "SETBIT k 2 x", reply: radio.ErrorStr("ERR bit is not an integer or out of range")},
+				{cmd: "SETBIT k -1 1", reply: radio.ErrorStr("ERR bit offset is not an integer or out of range")},
+				{cmd: "SETBIT k 4294967296 1", reply: radio.ErrorStr("ERR bit offset is not an integer or out of range")},
+				{cmd: "LPUSH l a", reply: radio.Integer(1)},
+				{cmd: "SETBIT l 0 1", reply: wrongType},
+			},
+		},
+		{
+			title: "BITCOUNT",
+			steps: []step{
+				{cmd: "SET k foobar", reply: okReply},
+				{cmd: "BITCOUNT k", reply: radio.Integer(26)},
+				{cmd: "BITCOUNT k 0 0", reply: radio.Integer(4)},
+				{cmd: "BITCOUNT k 1 1 BYTE", reply: radio.Integer(6)},
+				{cmd: "BITCOUNT k -2 -1", reply: radio.Integer(7)},
+				{cmd: "BITCOUNT k 5 30 BIT", reply: radio.Integer(17)},
+				{cmd: "BITCOUNT k 2 3 BIT", reply: radio.Integer(1)},
+				{cmd: "BITCOUNT k -1 -2", reply: radio.Integer(0)},
+				{cmd: "BITCOUNT x", reply: radio.Integer(0)},
+				{cmd: "BITCOUNT k 1", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "BITCOUNT k 1 2 FOO", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "BITCOUNT k a 2", reply: radio.ErrorStr("ERR value is not an integer or out of range")},
+			},
+		},
+		{
+			title: "BITPOS",
+			steps: []step{
+				{cmd: "SET k \xff\xf0\x00", reply: okReply},
+				{cmd: "BITPOS k 0", reply: radio.Integer(12)},
+				{cmd: "SET k \x00\xff\xf0", reply: okReply},
+				{cmd: "BITPOS k 1 0", reply: radio.Integer(8)},
+				{cmd: "BITPOS k 1 2", reply: radio.Integer(16)},
+				{cmd: "BITPOS k 1 2 -1 BYTE", reply: radio.Integer(16)},
+				{cmd: "BITPOS k 1 7 15 BIT", reply: radio.Integer(8)},
+				{cmd: "BITPOS k 1 7 -3 BIT", reply: radio.Integer(8)},
+				{cmd: "SET k \xff\xff\xff", reply: okReply},
+				{cmd: "BITPOS k 0", reply: radio.Integer(24)},
+				{cmd: "BITPOS k 0 0 -1", reply: radio.Integer(-1)},
+				{cmd: "BITPOS k 1 2 1", reply: radio.Integer(-1)},
+				{cmd: "BITPOS x 0", reply: radio.Integer(0)},
+				{cmd: "BITPOS x 1", reply: radio.Integer(-1)},
+				{cmd: "BITPOS k 2", reply: radio.ErrorStr("ERR The bit argument must be 1 or 0.")},
+				{cmd: "BITPOS k 1 0 1 BIT 1", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "BITOP",
+			steps: []step{
+				{cmd: "SET a foobar", reply: okReply},
+				{cmd: "SET b abcdef", reply: okReply},
+				{cmd: "BITOP AND d a b", reply: radio.Integer(6)},
+				{cmd: "GET d", reply: bulk("`bc`ab")},
+				{cmd: "BITOP OR d a b", reply: radio.Integer(6)},
+				{cmd: "GET d", reply: bulk("goofev")},
+				{cmd: "SET c \x0f", reply: okReply},
+				{cmd: "BITOP XOR d c a", reply: radio.Integer(6)},
+				{cmd: "GET d", reply: bulk("ioobar")},
+				{cmd: "BITOP AND d c a", reply: radio.Integer(6)},
+				{cmd: "GET d", reply: bulk("\x06\x00\x00\x00\x00\x00")},
+				{cmd: "BITOP NOT d c", reply: radio.Integer(1)},
+				{cmd: "GET d", reply: bulk("\xf0")},
+				{cmd: "BITOP NOT d x", reply: radio.Integer(0)},
+				{cmd: "EXISTS d", reply: radio.Integer(0)},
+				{cmd: "BITOP NOT d a b", reply: radio.ErrorStr("ERR BITOP NOT must be called with a single source key.")},
+				{cmd: "BITOP FOO d a", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "BITFIELD",
+			steps: []step{
+				{cmd: "BITFIELD k INCRBY i5 100 1 GET u4 0", reply: ints(1, 0)},
+				{cmd: "STRLEN k", reply: radio.Integer(14)},
+				{cmd: "BITFIELD k SET i8 #1 -100 GET i8 #1 GET u8 8", reply: ints(0, -100, 156)},
+				{cmd: "BITFIELD k SET u8 0 255 INCRBY u8 0 10", reply: ints(0, 9)},
+				{cmd: "BITFIELD k OVERFLOW SAT INCRBY u8 0 1000 INCRBY u8 0 -1000", reply: ints(255, 0)},
+				{cmd: "BITFIELD k SET i8 0 127 INCRBY i8 0 1", reply: ints(0, -128)},
+				{cmd: "BITFIELD k OVERFLOW SAT INCRBY i8 0 -1 INCRBY i8 0 -1000 SET i8 0 1000", reply: ints(-128, -128, -128)},
+				{cmd: "BITFIELD k GET i8 0", reply: ints(127)},
+				{cmd: "BITFIELD k OVERFLOW FAIL INCRBY i8 0 1 INCRBY u2 0 -1", reply: &radio.Array{Items: []radio.Value{nullBulk, radio.Integer(0)}}},
+				{cmd: "BITFIELD w SET i64 0 -1 GET u63 0 GET i64 0", reply: ints(0, 9223372036854775807, -1)},
+				{cmd: "BITFIELD w INCRBY i64 0 -9223372036854775807 INCRBY i64 0 -1", reply: ints(-9223372036854775808, 9223372036854775807)},
+				{cmd: "BITFIELD_RO k GET u4 0", reply: ints(3)},
+				{cmd: "BITFIELD_RO x GET u4 0", reply: ints(0)},
+				{cmd: "BITFIELD x GET u4 0", reply: ints(0)},
+				{cmd: "EXISTS x", reply: radio.Integer(0)},
+				{cmd: "BITFIELD_RO k SET u8 0 1", reply: radio.ErrorStr("ERR BITFIELD_RO only supports the GET subcommand")},
+				{cmd: "BITFIELD k GET u64 0", reply: radio.ErrorStr("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")},
+				{cmd: "BITFIELD k GET i8 -1", reply: radio.ErrorStr("ERR bit offset is not an integer or out of range")},
+				{cmd: "BITFIELD k SET i8 0 x", reply: radio.ErrorStr("ERR value is not an integer or out of range")},
+				{cmd: "BITFIELD k OVERFLOW FOO", reply: radio.ErrorStr("ERR Invalid OVERFLOW type specified")},
+				{cmd: "BITFIELD k GET i8", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(nil)
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
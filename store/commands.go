@@ -0,0 +1,212 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+)
+
+var (
+	replyOK  = radio.SimpleStr("OK")
+	nullBulk = &radio.BulkStr{}
+
+	errWrongType  = radio.ErrorStr("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errSyntax     = radio.ErrorStr("ERR syntax error")
+	errNotInteger = radio.ErrorStr("ERR value is not an integer or out of range")
+	errNotFloat   = radio.ErrorStr("ERR value is not a valid float")
+	errNoSuchKey  = radio.ErrorStr("ERR no such key")
+	errDBIndex    = radio.ErrorStr("ERR DB index is out of range")
+)
+
+const (
+	flagWrite = 1 << iota
+	flagReadOnly
+
+	// flagDenyOOM marks the commands that may use more memory, which are
+	// rejected when the memory used exceeds the limit.
+	flagDenyOOM
+)
+
+// command describes a command of the store. arity is the number of
+// arguments including the command name as reported by COMMAND, i.e., a
+// negative arity is the minimum number of arguments.
+type command struct {
+	arity int
+	flags int
+	fn    func(c *call)
+}
+
+var commands = map[string]*command{}
+
+func register(name string, arity, flags int, fn func(c *call)) {
+	commands[name] = &command{arity: arity, flags: flags, fn: fn}
+}
+
+// typeOf returns the name of the type of the value as reported by TYPE.
+func typeOf(val interface{}) string {
+	switch val.(type) {
+	case []byte:
+		return "string"
+	case *hash:
+		return "hash"
+	case *list:
+		return "list"
+	case *set:
+		return "set"
+	case *zset:
+		return "zset"
+	case *stream:
+		return "stream"
+	}
+
+	return "none"
+}
+
+// encodingOf returns the encoding of the value as reported by OBJECT
+// ENCODING.
+func encodingOf(val interface{}) string {
+	switch v := val.(type) {
+	case []byte:
+		if _, ok := parseInt(string(v)); ok {
+			return "int"
+		}
+		if len(v) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case *hash:
+		return v.encoding()
+	case *list:
+		return v.encoding()
+	case *set:
+		return v.encoding()
+	case *zset:
+		return v.encoding()
+	case *stream:
+		return "stream"
+	}
+
+	return ""
+}
+
+func errArity(name string) radio.ErrorStr {
+	return radio.ErrorStr("ERR wrong number of arguments for '" + name + "' command")
+}
+
+func unknownCommand(req *radio.Request) radio.ErrorStr {
+	var args strings.Builder
+	for _, arg := range req.Args {
+		args.WriteString("'" + arg + "' ")
+	}
+
+	return radio.ErrorStr("ERR unknown command '" + req.Command + "', with args beginning with: " + args.String())
+}
+
+// parseInt parses the integer in the same way as Redis, i.e., the string
+// must be the canonical representation of the integer.
+func parseInt(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseFloat parses the floating point number rejecting NaN and surrounding
+// spaces.
+func parseFloat(s string) (float64, bool) {
+	if len(s) == 0 || strings.TrimSpace(s) != s {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// formatFloat formats the floating point number in the human friendly form
+// used by Redis for INCRBYFLOAT.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// formatDouble formats the floating point number in the same way as Redis
+// replies with doubles: the shortest representation that parses back to the
+// same number, using the exponent form for very large and very small
+// magnitudes and inf/-inf for the infinities.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == 0 && math.Signbit(f):
+		return "-0"
+	case f == 0:
+		return "0"
+	}
+
+	// the shortest digits d.ddd and the exponent of the number.
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	sign := ""
+	if s[0] == '-' {
+		sign, s = "-", s[1:]
+	}
+
+	i := strings.IndexByte(s, 'e')
+	digits := strings.Replace(s[:i], ".", "", 1)
+	exp, _ := strconv.Atoi(s[i+1:])
+
+	// the number is digits * 10^k.
+	k := exp - len(digits) + 1
+	absExp := exp
+	if absExp < 0 {
+		absExp = -absExp
+	}
+
+	switch {
+	case k >= 0 && absExp < len(digits)+7:
+		return sign + digits + strings.Repeat("0", k)
+
+	case k < 0 && (k > -7 || absExp < 4):
+		point := len(digits) + k
+		if point <= 0 {
+			return sign + "0." + strings.Repeat("0", -point) + digits
+		}
+		return sign + digits[:point] + "." + digits[point:]
+	}
+
+	mantissa := digits[:1]
+	if len(digits) > 1 {
+		mantissa += "." + digits[1:]
+	}
+
+	expSign := "+"
+	if exp < 0 {
+		expSign = "-"
+	}
+	return sign + mantissa + "e" + expSign + strconv.Itoa(absExp)
+}
+
+func bulk(b []byte) *radio.BulkStr {
+	if b == nil {
+		b = []byte{}
+	}
+	return &radio.BulkStr{Value: b}
+}
+
+func bulkStr(s string) *radio.BulkStr {
+	return &radio.BulkStr{Value: []byte(s)}
+}
+
+func bulkArray(strs []string) *radio.Array {
+	arr := &radio.Array{Items: make([]radio.Value, len(strs))}
+	for i, s := range strs {
+		arr.Items[i] = bulkStr(s)
+	}
+	return arr
+}
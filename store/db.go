@@ -0,0 +1,151 @@
+package store
+
+import "sort"
+
+// db is a single database of the keyspace. Keys are additionally kept in the
+// order they were created so that SCAN can resume from a cursor even when
+// keys are added or deleted between the calls.
+type db struct {
+	id      int
+	keys    map[string]*entry
+	expires map[string]*entry
+
+	// volatile contains the hashes with fields with expiry.
+	volatile map[string]*entry
+
+	order []*entry
+	holes int
+	seq   uint64
+
+	// used is the estimated memory used by the keys in bytes.
+	used int64
+}
+
+// entry is a key of the database. val holds the value of the key whose
+// type decides the type of the key (e.g., []byte for strings).
+type entry struct {
+	key      string
+	val      interface{}
+	expireAt int64 // unix time in milliseconds, zero if the key is persistent
+	seq      uint64
+	deleted  bool
+
+	// size is the estimated memory used by the entry in bytes.
+	size int64
+
+	// accessed is the time of the last access in unix milliseconds. counter
+	// is the logarithmic access frequency counter, decremented for every
+	// minute elapsed since decayedAt (in unix minutes).
+	accessed  int64
+	counter   uint8
+	decayedAt int64
+}
+
+func newDB(id int) *db {
+	return &db{
+		id:       id,
+		keys:     map[string]*entry{},
+		expires:  map[string]*entry{},
+		volatile: map[string]*entry{},
+	}
+}
+
+// get returns the entry of the key without checking the expiry.
+func (d *db) get(key string) *entry {
+	return d.keys[key]
+}
+
+// add adds a new key with the value. The key must not exist.
+func (d *db) add(key string, val interface{}) *entry {
+	d.seq++
+	e := &entry{key: key, val: val, seq: d.seq}
+	d.keys[key] = e
+	d.order = append(d.order, e)
+	d.resize(e)
+	return e
+}
+
+// delete removes the key and returns the removed entry or nil if the key
+// does not exist.
+func (d *db) delete(key string) *entry {
+	e, found := d.keys[key]
+	if !found {
+		return nil
+	}
+
+	delete(d.keys, key)
+	delete(d.expires, key)
+	delete(d.volatile, key)
+	e.deleted = true
+	d.used -= e.size
+
+	d.holes++
+	if d.holes > 64 && d.holes > len(d.order)/2 {
+		d.compact()
+	}
+	return e
+}
+
+// setExpire sets the expiry of the entry in unix milliseconds. Zero makes
+// the entry persistent.
+func (d *db) setExpire(e *entry, at int64) {
+	e.expireAt = at
+	if at == 0 {
+		delete(d.expires, e.key)
+	} else {
+		d.expires[e.key] = e
+	}
+}
+
+func (d *db) flush() {
+	for _, e := range d.order {
+		e.deleted = true
+	}
+
+	d.keys = map[string]*entry{}
+	d.expires = map[string]*entry{}
+	d.volatile = map[string]*entry{}
+	d.order = nil
+	d.holes = 0
+	d.used = 0
+}
+
+// scan invokes fn for the entries starting at the cursor in the order they
+// were created until fn returns false. Returns the cursor to continue from
+// or zero if all the entries are visited. Cursors are the sequence numbers
+// of the entries so that the entries existing for the whole duration of a
+// full iteration are always visited.
+func (d *db) scan(cursor uint64, fn func(e *entry) bool) uint64 {
+	i := sort.Search(len(d.order), func(i int) bool {
+		return d.order[i].seq >= cursor
+	})
+
+	for ; i < len(d.order); i++ {
+		e := d.order[i]
+		if e.deleted {
+			continue
+		}
+
+		if !fn(e) {
+			if i+1 < len(d.order) {
+				return d.order[i+1].seq
+			}
+			return 0
+		}
+	}
+
+	return 0
+}
+
+// compact removes the deleted entries from the creation order.
+func (d *db) compact() {
+	order := make([]*entry, 0, len(d.keys))
+	for _, e := range d.order {
+		if !e.deleted {
+			order = append(order, e)
+		}
+	}
+
+	d.order = order
+	d.holes = 0
+}
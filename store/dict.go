@@ -0,0 +1,106 @@
+package store
+
+import "sort"
+
+// field is an element of a hash, a set or a sorted set. value and expireAt
+// are only used by hashes and score by sorted sets.
+type field struct {
+	name     string
+	value    string
+	expireAt int64 // unix time in milliseconds, zero if the field is persistent
+	score    float64
+
+	seq     uint64
+	deleted bool
+}
+
+// dict is the hashtable encoding of hashes, sets and sorted sets. Like db,
+// fields are kept in the order they were added so that scans using cursors
+// visit every field existing for the whole duration of the iteration.
+type dict struct {
+	fields map[string]*field
+	order  []*field
+	holes  int
+	seq    uint64
+}
+
+func newDict(size int) *dict {
+	return &dict{
+		fields: make(map[string]*field, size),
+		order:  make([]*field, 0, size),
+	}
+}
+
+func (d *dict) len() int {
+	return len(d.fields)
+}
+
+func (d *dict) get(name string) *field {
+	return d.fields[name]
+}
+
+// add adds a new field. The field must not exist.
+func (d *dict) add(name, value string) *field {
+	d.seq++
+	f := &field{name: name, value: value, seq: d.seq}
+	d.fields[name] = f
+	d.order = append(d.order, f)
+	return f
+}
+
+func (d *dict) remove(name string) bool {
+	f, found := d.fields[name]
+	if !found {
+		return false
+	}
+
+	delete(d.fields, name)
+	f.deleted = true
+
+	d.holes++
+	if d.holes > 64 && d.holes > len(d.order)/2 {
+		order := make([]*field, 0, len(d.fields))
+		for _, f := range d.order {
+			if !f.deleted {
+				order = append(order, f)
+			}
+		}
+		d.order = order
+		d.holes = 0
+	}
+	return true
+}
+
+// random returns a random field or nil if the dict is empty.
+func (d *dict) random() *field {
+	// map iteration starts at a random position.
+	for _, f := range d.fields {
+		return f
+	}
+	return nil
+}
+
+// scan invokes fn for the fields starting at the cursor until fn returns
+// false. Returns the cursor to continue from or zero if all the fields are
+// visited. See db.scan.
+func (d *dict) scan(cursor uint64, fn func(f *field) bool) uint64 {
+	i := sort.Search(len(d.order), func(i int) bool {
+		return d.order[i].seq >= cursor
+	})
+
+	for ; i < len(d.order); i++ {
+		f := d.order[i]
+		if f.deleted {
+			continue
+		}
+
+		if !fn(f) {
+			if i+1 < len(d.order) {
+				return d.order[i+1].seq
+			}
+			return 0
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,88 @@
+package store
+
+import "time"
+
+const (
+	// expireSamples is the number of keys with expiry sampled at a time.
+	expireSamples = 20
+
+	// expireBudget is the maximum duration of an active expiry cycle.
+	expireBudget = 25 * time.Millisecond
+)
+
+// expireCycle periodically removes the expired keys that are not accessed.
+func (s *Store) expireCycle() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.opts.ExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.activeExpire()
+		}
+	}
+}
+
+// activeExpire samples keys with expiry from every database and removes
+// the expired ones. Sampling of a database is repeated while more than a
+// quarter of the sampled keys are expired and the time budget allows.
+func (s *Store) activeExpire() {
+	start := time.Now()
+	for i := range s.dbs {
+		for time.Since(start) < expireBudget {
+			if expired := s.expireSample(i); expired <= expireSamples/4 {
+				break
+			}
+		}
+	}
+}
+
+// expireSample removes the expired keys among a sample of the keys with
+// expiry of the database and the expired fields among a sample of the
+// hashes with fields with expiry. Returns the number of keys removed.
+func (s *Store) expireSample(i int) int {
+	c := &call{s: s}
+
+	s.mu.Lock()
+	d := s.dbs[i]
+	c.db = d
+	c.now = s.now()
+
+	// map iteration starts at a random position.
+	sampled, expired := 0, 0
+	for _, e := range d.expires {
+		if sampled >= expireSamples {
+			break
+		}
+		sampled++
+
+		if e.expireAt <= c.now {
+			c.expired(d, e)
+			expired++
+		}
+	}
+
+	// hashes with expired fields are counted as expired keys.
+	sampled = 0
+	for _, e := range d.volatile {
+		if sampled >= expireSamples {
+			break
+		}
+		sampled++
+
+		h := e.val.(*hash)
+		n := h.len()
+		c.expireFields(e)
+		if h.len() < n {
+			expired++
+		}
+	}
+
+	s.finish(c, false)
+
+	return expired
+}
@@ -0,0 +1,106 @@
+package store_test
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+	"github.com/spy16/radio/store"
+)
+
+func TestActiveExpiry(t *testing.T) {
+	var mu sync.Mutex
+	var expired []string
+	notifier := &keyspace.Notifier{
+		Publish: func(channel, msg string) {
+			mu.Lock()
+			defer mu.Unlock()
+			expired = append(expired, msg)
+		},
+	}
+	notifier.SetFlags(keyspace.Keyevent | keyspace.Expired)
+
+	s := store.New(store.Options{
+		ExpiryInterval: 5 * time.Millisecond,
+		Notifier:       notifier,
+	})
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		s.Do(0, "SET", "tmp:"+strconv.Itoa(i), "v", "PX", "10")
+	}
+	s.Do(0, "SET", "persistent", "v")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Do(0, "DBSIZE") != radio.Integer(1) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting expired keys to be removed without access, got %v keys", s.Do(0, "DBSIZE"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 100 {
+		t.Errorf("expecting 100 expired events, got %d", len(expired))
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	var events [][2]string
+	notifier := &keyspace.Notifier{
+		Publish: func(channel, msg string) {
+			events = append(events, [2]string{channel, msg})
+		},
+	}
+	notifier.SetFlags(keyspace.Keyspace | keyspace.All | keyspace.New)
+
+	s := store.New(store.Options{ExpiryInterval: -1, Notifier: notifier})
+	defer s.Close()
+
+	s.Do(0, "SET", "a", "1", "EX", "10")
+	s.Do(0, "INCR", "a")
+	s.Do(0, "RENAME", "a", "b")
+	s.Do(0, "DEL", "b", "c")
+	s.Do(1, "APPEND", "x", "y")
+
+	expected := [][2]string{
+		{"__keyspace@0__:a", "new"},
+		{"__keyspace@0__:a", "set"},
+		{"__keyspace@0__:a", "expire"},
+		{"__keyspace@0__:a", "incrby"},
+		{"__keyspace@0__:a", "rename_from"},
+		{"__keyspace@0__:b", "rename_to"},
+		{"__keyspace@0__:b", "del"},
+		{"__keyspace@1__:x", "new"},
+		{"__keyspace@1__:x", "append"},
+	}
+	if !reflect.DeepEqual(expected, events) {
+		t.Errorf("expecting events %v, got %v", expected, events)
+	}
+}
+
+func TestActiveExpiry_Fields(t *testing.T) {
+	s := store.New(store.Options{ExpiryInterval: 5 * time.Millisecond})
+	defer s.Close()
+
+	for i := 0; i < 50; i++ {
+		key := "h:" + strconv.Itoa(i)
+		s.Do(0, "HSET", key, "tmp", "v", "keep", "v")
+		s.Do(0, "HPEXPIRE", key, "10", "FIELDS", "1", "tmp")
+	}
+	s.Do(0, "HSET", "gone", "tmp", "v")
+	s.Do(0, "HPEXPIRE", "gone", "10", "FIELDS", "1", "tmp")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Do(0, "DBSIZE") != radio.Integer(50) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting hashes with all fields expired to be removed, got %v keys", s.Do(0, "DBSIZE"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
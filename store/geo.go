@@ -0,0 +1,461 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+)
+
+var errUnit = radio.ErrorStr("ERR unsupported unit provided. please use M, KM, FT, MI")
+
+func init() {
+	register("geoadd", -5, flagWrite|flagDenyOOM, geoaddCommand)
+	register("geodist", -4, flagReadOnly, geodistCommand)
+	register("geopos", -2, flagReadOnly, geoposCommand)
+	register("geohash", -2, flagReadOnly, geohashCommand)
+	register("geosearch", -7, flagReadOnly, geosearchCommand)
+	register("geosearchstore", -8, flagWrite|flagDenyOOM, geosearchCommand)
+}
+
+// parseCoord parses the longitude and the latitude.
+func parseCoord(longArg, latArg string) (long, lat float64, err radio.Value) {
+	long, ok1 := parseFloat(longArg)
+	lat, ok2 := parseFloat(latArg)
+	if !ok1 || !ok2 {
+		return 0, 0, errNotFloat
+	}
+
+	if !validCoord(long, lat) {
+		return 0, 0, radio.ErrorStr(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", long, lat))
+	}
+	return long, lat, nil
+}
+
+// parseUnit returns the number of meters in the unit.
+func parseUnit(s string) (float64, bool) {
+	switch strings.ToLower(s) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+// formatCoord formats the coordinate with up to 17 decimals dropping the
+// trailing zeros as Redis does.
+func formatCoord(f float64) string {
+	s := strings.TrimRight(strconv.FormatFloat(f, 'f', 17, 64), "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+func formatDistance(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
+
+// geoaddCommand adds the members with the geohash of the coordinates as the
+// score using ZADD.
+func geoaddCommand(c *call) {
+	key, args := c.args[0], c.args[1:]
+
+	var opts []string
+	var nx, xx bool
+opts:
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+		default:
+			break opts
+		}
+		opts, args = append(opts, args[0]), args[1:]
+	}
+
+	if len(args)%3 != 0 || (nx && xx) {
+		c.reply(errSyntax)
+		return
+	}
+
+	zargs := append([]string{key}, opts...)
+	for i := 0; i < len(args); i += 3 {
+		long, lat, err := parseCoord(args[i], args[i+1])
+		if err != nil {
+			c.reply(err)
+			return
+		}
+
+		score := strconv.FormatUint(geoEncode(long, lat), 10)
+		zargs = append(zargs, score, args[i+2])
+	}
+
+	c.args = zargs
+	zaddCommand(c)
+}
+
+func geodistCommand(c *call) {
+	conversion := 1.0
+	if len(c.args) == 4 {
+		var ok bool
+		if conversion, ok = parseUnit(c.args[3]); !ok {
+			c.reply(errUnit)
+			return
+		}
+	} else if len(c.args) > 4 {
+		c.reply(errSyntax)
+		return
+	}
+
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	} else if z == nil {
+		c.reply(nullBulk)
+		return
+	}
+
+	score1, ok1 := z.score(c.args[1])
+	score2, ok2 := z.score(c.args[2])
+	if !ok1 || !ok2 {
+		c.reply(nullBulk)
+		return
+	}
+
+	long1, lat1 := geoDecode(uint64(score1))
+	long2, lat2 := geoDecode(uint64(score2))
+	c.reply(bulkStr(formatDistance(geoDistance(long1, lat1, long2, lat2) / conversion)))
+}
+
+func geoposCommand(c *call) {
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	}
+
+	arr := &radio.Array{Items: make([]radio.Value, 0, len(c.args)-1)}
+	for _, member := range c.args[1:] {
+		var score float64
+		if z != nil {
+			score, ok = z.score(member)
+		}
+
+		if z == nil || !ok {
+			arr.Items = append(arr.Items, &radio.Array{})
+			continue
+		}
+
+		long, lat := geoDecode(uint64(score))
+		arr.Items = append(arr.Items, bulkArray([]string{formatCoord(long), formatCoord(lat)}))
+	}
+
+	c.reply(arr)
+}
+
+func geohashCommand(c *call) {
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	}
+
+	arr := &radio.Array{Items: make([]radio.Value, 0, len(c.args)-1)}
+	for _, member := range c.args[1:] {
+		var score float64
+		if z != nil {
+			score, ok = z.score(member)
+		}
+
+		if z == nil || !ok {
+			arr.Items = append(arr.Items, nullBulk)
+			continue
+		}
+		arr.Items = append(arr.Items, bulkStr(geohashString(uint64(score))))
+	}
+
+	c.reply(arr)
+}
+
+// geoSearch is a parsed GEOSEARCH or GEOSEARCHSTORE command.
+type geoSearch struct {
+	shape      geoShape
+	fromMember string
+	byMember   bool
+	desc, asc  bool
+	count      int64
+	any        bool
+	withDist   bool
+	withHash   bool
+	withCoord  bool
+	storeDist  bool
+}
+
+// parseGeoSearch parses the options of GEOSEARCH starting at args[0].
+func (c *call) parseGeoSearch(args []string, store bool) (*geoSearch, radio.Value) {
+	gs := &geoSearch{}
+	var fromLonLat, byRadius bool
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "withdist":
+			gs.withDist = true
+		case opt == "withhash":
+			gs.withHash = true
+		case opt == "withcoord":
+			gs.withCoord = true
+		case opt == "any":
+			gs.any = true
+		case opt == "asc":
+			gs.asc, gs.desc = true, false
+		case opt == "desc":
+			gs.asc, gs.desc = false, true
+
+		case opt == "count" && remaining >= 1:
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return nil, errNotInteger
+			} else if n <= 0 {
+				return nil, radio.ErrorStr("ERR COUNT must be > 0")
+			}
+			gs.count = n
+			i++
+
+		case opt == "storedist" && store:
+			gs.storeDist = true
+
+		case opt == "frommember" && remaining >= 1:
+			if gs.byMember || fromLonLat {
+				return nil, errSyntax
+			}
+			gs.byMember, gs.fromMember = true, args[i+1]
+			i++
+
+		case opt == "fromlonlat" && remaining >= 2:
+			if gs.byMember || fromLonLat {
+				return nil, errSyntax
+			}
+
+			var err radio.Value
+			if gs.shape.long, gs.shape.lat, err = parseCoord(args[i+1], args[i+2]); err != nil {
+				return nil, err
+			}
+			fromLonLat = true
+			i += 2
+
+		case opt == "byradius" && remaining >= 2:
+			if byRadius || gs.shape.byBox {
+				return nil, errSyntax
+			}
+
+			radius, ok := parseFloat(args[i+1])
+			if !ok {
+				return nil, radio.ErrorStr("ERR need numeric radius")
+			} else if radius < 0 {
+				return nil, radio.ErrorStr("ERR radius cannot be negative")
+			}
+
+			if gs.shape.conversion, ok = parseUnit(args[i+2]); !ok {
+				return nil, errUnit
+			}
+			gs.shape.radius, byRadius = radius, true
+			i += 2
+
+		case opt == "bybox" && remaining >= 3:
+			if byRadius || gs.shape.byBox {
+				return nil, errSyntax
+			}
+
+			width, ok := parseFloat(args[i+1])
+			if !ok {
+				return nil, radio.ErrorStr("ERR need numeric width")
+			}
+			height, ok := parseFloat(args[i+2])
+			if !ok {
+				return nil, radio.ErrorStr("ERR need numeric height")
+			}
+			if width < 0 || height < 0 {
+				return nil, radio.ErrorStr("ERR height or width cannot be negative")
+			}
+
+			if gs.shape.conversion, ok = parseUnit(args[i+3]); !ok {
+				return nil, errUnit
+			}
+			gs.shape.width, gs.shape.height, gs.shape.byBox = width, height, true
+			i += 3
+
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	switch {
+	case store && (gs.withDist || gs.withHash || gs.withCoord):
+		return nil, radio.ErrorStr("ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	case !gs.byMember && !fromLonLat:
+		return nil, radio.ErrorStr("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + c.name)
+	case !byRadius && !gs.shape.byBox:
+		return nil, radio.ErrorStr("ERR exactly one of BYRADIUS and BYBOX can be specified for " + c.name)
+	case gs.any && gs.count == 0:
+		return nil, radio.ErrorStr("ERR the ANY argument requires COUNT argument")
+	}
+
+	// the nearest members are returned with COUNT unless ANY is set.
+	if gs.count > 0 && !gs.asc && !gs.desc && !gs.any {
+		gs.asc = true
+	}
+	return gs, nil
+}
+
+// geoPoint is a member found by GEOSEARCH.
+type geoPoint struct {
+	scored
+	long, lat, dist float64
+}
+
+func geosearchCommand(c *call) {
+	store := c.name == "geosearchstore"
+	dst, src, args := "", c.args[0], c.args[1:]
+	if store {
+		dst, src, args = c.args[0], c.args[1], c.args[2:]
+	}
+
+	_, z, ok := c.lookupZset(src)
+	if !ok {
+		return
+	}
+
+	gs, err := c.parseGeoSearch(args, store)
+	if err != nil {
+		c.reply(err)
+		return
+	}
+
+	if z == nil {
+		if store {
+			c.storeZset(dst, nil)
+			c.reply(radio.Integer(0))
+		} else {
+			c.reply(&radio.Array{Items: []radio.Value{}})
+		}
+		return
+	}
+
+	if gs.byMember {
+		score, ok := z.score(gs.fromMember)
+		if !ok {
+			c.reply(radio.ErrorStr("ERR could not decode requested zset member"))
+			return
+		}
+		gs.shape.long, gs.shape.lat = geoDecode(uint64(score))
+	}
+
+	limit := 0
+	if gs.any {
+		limit = int(gs.count)
+	}
+	points := geoSearchPoints(z, &gs.shape, limit)
+
+	if gs.asc || gs.desc {
+		sort.SliceStable(points, func(i, j int) bool {
+			if gs.desc {
+				return points[i].dist > points[j].dist
+			}
+			return points[i].dist < points[j].dist
+		})
+	}
+
+	if gs.count > 0 && int64(len(points)) > gs.count {
+		points = points[:gs.count]
+	}
+
+	if store {
+		elems := make([]scored, len(points))
+		for i, p := range points {
+			elems[i] = p.scored
+			if gs.storeDist {
+				elems[i].score = p.dist / gs.shape.conversion
+			}
+		}
+
+		c.storeZset(dst, elems)
+		c.reply(radio.Integer(len(elems)))
+		return
+	}
+
+	c.reply(geoSearchReply(gs, points))
+}
+
+// geoSearchPoints returns the members within the shape by searching the
+// geohash areas covering the shape in the same order as Redis. The search
+// stops once limit members are found unless limit is zero.
+func geoSearchPoints(z *zset, shape *geoShape, limit int) []geoPoint {
+	var points []geoPoint
+	areas := shape.areas()
+	last := 0
+	for i, area := range areas {
+		if area.isZero() {
+			continue
+		}
+
+		// adjacent areas may be the same for huge shapes. Like Redis, only
+		// the neighbours are compared.
+		if last > 0 && area == areas[last] {
+			continue
+		}
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		last = i
+
+		min, max := float64(area.align52()), float64(geohash{bits: area.bits + 1, step: area.step}.align52())
+		from := z.countWhile(func(e scored) bool { return e.score < min })
+		z.each(from, false, func(e scored) bool {
+			if e.score >= max {
+				return false
+			}
+
+			long, lat := geoDecode(uint64(e.score))
+			if dist, ok := shape.distance(long, lat); ok {
+				points = append(points, geoPoint{scored: e, long: long, lat: lat, dist: dist})
+			}
+			return limit == 0 || len(points) < limit
+		})
+	}
+
+	return points
+}
+
+func geoSearchReply(gs *geoSearch, points []geoPoint) *radio.Array {
+	arr := &radio.Array{Items: make([]radio.Value, 0, len(points))}
+	for _, p := range points {
+		if !gs.withDist && !gs.withHash && !gs.withCoord {
+			arr.Items = append(arr.Items, bulkStr(p.member))
+			continue
+		}
+
+		item := &radio.Array{Items: []radio.Value{bulkStr(p.member)}}
+		if gs.withDist {
+			item.Items = append(item.Items, bulkStr(formatDistance(p.dist/gs.shape.conversion)))
+		}
+		if gs.withHash {
+			item.Items = append(item.Items, radio.Integer(int64(p.score)))
+		}
+		if gs.withCoord {
+			item.Items = append(item.Items, bulkArray([]string{formatCoord(p.long), formatCoord(p.lat)}))
+		}
+		arr.Items = append(arr.Items, item)
+	}
+
+	return arr
+}
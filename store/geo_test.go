@@ -0,0 +1,130 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/spy16/radio"
+)
+
+func TestGeo(suite *testing.T) {
+	suite.Parallel()
+
+	sicily := []step{
+		{cmd: "GEOADD Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania", reply: radio.Integer(2)},
+		{cmd: "GEOADD Sicily 12.758489 38.788135 edge1 17.241510 38.788135 edge2", reply: radio.Integer(2)},
+	}
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "GEOADD",
+			steps: []step{
+				{cmd: "GEOADD Sicily NX 13.361389 38.115556 Palermo", reply: radio.Integer(0)},
+				{cmd: "GEOADD Sicily XX CH 13.361389 38.115556 Palermo 1 1 Nowhere", reply: radio.Integer(0)},
+				{cmd: "GEOADD Sicily CH 13.361389 38.2 Palermo", reply: radio.Integer(1)},
+				{cmd: "ZSCORE Sicily Catania", reply: bulk("3479447370796909")},
+				{cmd: "TYPE Sicily", reply: radio.SimpleStr("zset")},
+				{cmd: "GEOADD Sicily 181 1 x", reply: radio.ErrorStr("ERR invalid longitude,latitude pair 181.000000,1.000000")},
+				{cmd: "GEOADD Sicily 1 86 x", reply: radio.ErrorStr("ERR invalid longitude,latitude pair 1.000000,86.000000")},
+				{cmd: "GEOADD Sicily a 1 x", reply: radio.ErrorStr("ERR value is not a valid float")},
+				{cmd: "GEOADD Sicily 1 1 x 2", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "GEOADD Sicily NX XX 1 1 x", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "GEODIST",
+			steps: []step{
+				{cmd: "GEODIST Sicily Palermo Catania", reply: bulk("166274.1516")},
+				{cmd: "GEODIST Sicily Palermo Catania km", reply: bulk("166.2742")},
+				{cmd: "GEODIST Sicily Palermo Catania MI", reply: bulk("103.3182")},
+				{cmd: "GEODIST Sicily Palermo Palermo", reply: bulk("0.0000")},
+				{cmd: "GEODIST Sicily Foo Bar", reply: nullBulk},
+				{cmd: "GEODIST x Foo Bar", reply: nullBulk},
+				{cmd: "GEODIST Sicily Palermo Catania yd", reply: radio.ErrorStr("ERR unsupported unit provided. please use M, KM, FT, MI")},
+				{cmd: "GEODIST Sicily Palermo Catania km km", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "GEOPOS",
+			steps: []step{
+				{cmd: "GEOPOS Sicily Palermo Catania NonExisting", reply: &radio.Array{Items: []radio.Value{
+					bulks("13.36138933897018433", "38.11555639549629859"),
+					bulks("15.08726745843887329", "37.50266842333162032"),
+					&radio.Array{},
+				}}},
+				{cmd: "GEOPOS x a", reply: &radio.Array{Items: []radio.Value{&radio.Array{}}}},
+				{cmd: "GEOHASH Sicily Palermo Catania NonExisting", reply: &radio.Array{Items: []radio.Value{
+					bulk("sqc8b49rny0"), bulk("sqdtr74hyu0"), nullBulk,
+				}}},
+				{cmd: "SET s a", reply: okReply},
+				{cmd: "GEOPOS s a", reply: wrongType},
+			},
+		},
+		{
+			title: "GEOSEARCH",
+			steps: []step{
+				{cmd: "GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km ASC", reply: bulks("Catania", "Palermo")},
+				{cmd: "GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 200 km DESC", reply: bulks("Palermo", "Catania")},
+				{cmd: "GEOSEARCH Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHCOORD WITHDIST WITHHASH", reply: &radio.Array{Items: []radio.Value{
+					geoResult("Catania", "56.4413", 3479447370796909, "15.08726745843887329", "37.50266842333162032"),
+					geoResult("Palermo", "190.4424", 3479099956230698, "13.36138933897018433", "38.11555639549629859"),
+					geoResult("edge2", "279.7403", 3481342659049484, "17.24151045083999634", "38.78813451624225195"),
+					geoResult("edge1", "279.7405", 3479273021651468, "12.7584877610206604", "38.78813451624225195"),
+				}}},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 170 km COUNT 1 WITHDIST", reply: &radio.Array{Items: []radio.Value{
+					bulks("Palermo", "0.0000"),
+				}}},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 170 km ASC COUNT 2 WITHDIST", reply: &radio.Array{Items: []radio.Value{
+					bulks("Palermo", "0.0000"), bulks("edge1", "91.4007"),
+				}}},
+				{cmd: "GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 500 km COUNT 2 ANY", reply: bulks("Palermo", "edge1")},
+				{cmd: "GEOSEARCH Sicily FROMLONLAT 0 0 BYRADIUS 10 km", reply: bulks()},
+				{cmd: "GEOSEARCH x FROMLONLAT 0 0 BYRADIUS 10 km", reply: bulks()},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Foo BYRADIUS 10 km", reply: radio.ErrorStr("ERR could not decode requested zset member")},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo FROMLONLAT 0 0 BYRADIUS 10 km", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "GEOSEARCH Sicily BYRADIUS 10 km ASC WITHDIST", reply: radio.ErrorStr("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo ASC WITHDIST WITHHASH", reply: radio.ErrorStr("ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 1 km ANY", reply: radio.ErrorStr("ERR the ANY argument requires COUNT argument")},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 1 km COUNT 0", reply: radio.ErrorStr("ERR COUNT must be > 0")},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS -1 km", reply: radio.ErrorStr("ERR radius cannot be negative")},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo BYBOX 1 -1 km", reply: radio.ErrorStr("ERR height or width cannot be negative")},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 1 yd", reply: radio.ErrorStr("ERR unsupported unit provided. please use M, KM, FT, MI")},
+				{cmd: "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 1 km STOREDIST", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "GEOSEARCHSTORE",
+			steps: []step{
+				{cmd: "GEOSEARCHSTORE k Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3", reply: radio.Integer(3)},
+				{cmd: "GEOSEARCH k FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHHASH", reply: &radio.Array{Items: []radio.Value{
+					&radio.Array{Items: []radio.Value{bulk("Catania"), radio.Integer(3479447370796909)}},
+					&radio.Array{Items: []radio.Value{bulk("Palermo"), radio.Integer(3479099956230698)}},
+					&radio.Array{Items: []radio.Value{bulk("edge2"), radio.Integer(3481342659049484)}},
+				}}},
+				{cmd: "GEOSEARCHSTORE k Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3 STOREDIST", reply: radio.Integer(3)},
+				{cmd: "ZRANGE k 0 -1 WITHSCORES", reply: bulks("Catania", "56.4412578701582", "Palermo", "190.4424298477578", "edge2", "279.7403417843143")},
+				{cmd: "GEOSEARCHSTORE k Sicily FROMLONLAT 0 0 BYRADIUS 1 km", reply: radio.Integer(0)},
+				{cmd: "EXISTS k", reply: radio.Integer(0)},
+				{cmd: "GEOSEARCHSTORE k Sicily FROMLONLAT 0 0 BYRADIUS 1 km WITHDIST", reply: radio.ErrorStr("ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(nil)
+			defer s.Close()
+			runSteps(t, s, sicily)
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func geoResult(member, dist string, hash int64, long, lat string) *radio.Array {
+	return &radio.Array{Items: []radio.Value{
+		bulk(member), bulk(dist), radio.Integer(hash), bulks(long, lat),
+	}}
+}
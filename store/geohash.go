@@ -0,0 +1,345 @@
+package store
+
+import "math"
+
+// Locations are stored in sorted sets with the 52 bits geohash of the
+// coordinates as the score in the same way as Redis. The geohash interleaves
+// the bits of the latitude (even bits) and the longitude (odd bits) offsets
+// within the ranges supported by EPSG:3785.
+const (
+	geoStepMax = 26
+
+	geoLongMin = -180.0
+	geoLongMax = 180.0
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+
+	// earthRadius is the radius of the earth in meters used by Redis for
+	// the haversine distance.
+	earthRadius = 6372797.560856
+
+	mercatorMax = 20037726.37
+)
+
+type geoRange struct {
+	min, max float64
+}
+
+var (
+	geoLongRange = geoRange{min: geoLongMin, max: geoLongMax}
+	geoLatRange  = geoRange{min: geoLatMin, max: geoLatMax}
+)
+
+// geohash is a geohash of step*2 bits.
+type geohash struct {
+	bits uint64
+	step uint
+}
+
+// geoArea is the area covered by a geohash.
+type geoArea struct {
+	long, lat geoRange
+}
+
+func validCoord(long, lat float64) bool {
+	return long >= geoLongMin && long <= geoLongMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+func geohashEncode(longRange, latRange geoRange, long, lat float64, step uint) geohash {
+	latOffset := (lat - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (long - longRange.min) / (longRange.max - longRange.min)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return geohash{bits: interleave(uint32(latOffset), uint32(longOffset)), step: step}
+}
+
+func geohashDecode(longRange, latRange geoRange, h geohash) geoArea {
+	sep := deinterleave(h.bits)
+	lat, long := float64(uint32(sep)), float64(uint32(sep>>32))
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+	scale := float64(uint64(1) << h.step)
+
+	return geoArea{
+		lat: geoRange{
+			min: latRange.min + (lat*1.0/scale)*latScale,
+			max: latRange.min + ((lat+1)*1.0/scale)*latScale,
+		},
+		long: geoRange{
+			min: longRange.min + (long*1.0/scale)*longScale,
+			max: longRange.min + ((long+1)*1.0/scale)*longScale,
+		},
+	}
+}
+
+// geoEncode returns the 52 bits geohash of the coordinates used as the
+// score of the members.
+func geoEncode(long, lat float64) uint64 {
+	return geohashEncode(geoLongRange, geoLatRange, long, lat, geoStepMax).align52()
+}
+
+// geoDecode returns the coordinates of the center of the area of the 52
+// bits geohash.
+func geoDecode(bits uint64) (long, lat float64) {
+	area := geohashDecode(geoLongRange, geoLatRange, geohash{bits: bits, step: geoStepMax})
+	long = math.Max(geoLongMin, math.Min(geoLongMax, (area.long.min+area.long.max)/2))
+	lat = math.Max(geoLatMin, math.Min(geoLatMax, (area.lat.min+area.lat.max)/2))
+	return long, lat
+}
+
+// geohashString returns the 11 characters geohash string of the 52 bits
+// geohash. Standard geohashes use -90,90 as the range of the latitude, so
+// the coordinates are re-encoded.
+func geohashString(bits uint64) string {
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	long, lat := geoDecode(bits)
+	h := geohashEncode(geoLongRange, geoRange{min: -90, max: 90}, long, lat, geoStepMax)
+
+	buf := make([]byte, 11)
+	for i := range buf {
+		// 52 bits make only 10 characters, the last one is always zero.
+		idx := uint64(0)
+		if i < 10 {
+			idx = h.bits >> uint(52-(i+1)*5) & 0x1f
+		}
+		buf[i] = alphabet[idx]
+	}
+	return string(buf)
+}
+
+func (h geohash) align52() uint64 {
+	return h.bits << (52 - h.step*2)
+}
+
+func (h geohash) isZero() bool {
+	return h.bits == 0 && h.step == 0
+}
+
+// move returns the geohash of the adjacent area in the direction of dx
+// (longitude) and dy (latitude).
+func (h geohash) move(dx, dy int) geohash {
+	x := h.bits & 0xaaaaaaaaaaaaaaaa
+	y := h.bits & 0x5555555555555555
+	shift := 64 - h.step*2
+
+	if dx != 0 {
+		zz := uint64(0x5555555555555555) >> shift
+		if dx > 0 {
+			x += zz + 1
+		} else {
+			x |= zz
+			x -= zz + 1
+		}
+		x &= uint64(0xaaaaaaaaaaaaaaaa) >> shift
+	}
+
+	if dy != 0 {
+		zz := uint64(0xaaaaaaaaaaaaaaaa) >> shift
+		if dy > 0 {
+			y += zz + 1
+		} else {
+			y |= zz
+			y -= zz + 1
+		}
+		y &= uint64(0x5555555555555555) >> shift
+	}
+
+	return geohash{bits: x | y, step: h.step}
+}
+
+func interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// deinterleave returns the even bits in the low 32 bits and the odd bits in
+// the high 32 bits.
+func deinterleave(v uint64) uint64 {
+	return squash(v) | squash(v>>1)<<32
+}
+
+func squash(x uint64) uint64 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return x
+}
+
+func degRad(deg float64) float64 {
+	return deg * (math.Pi / 180.0)
+}
+
+func radDeg(rad float64) float64 {
+	return rad / (math.Pi / 180.0)
+}
+
+// geoDistance returns the haversine distance in meters between the
+// coordinates.
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	long1r, long2r := degRad(long1), degRad(long2)
+	v := math.Sin((long2r - long1r) / 2)
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// geoShape is the area searched by GEOSEARCH: a circle or a box centered on
+// the coordinates. The dimensions are in the unit converted to meters with
+// conversion.
+type geoShape struct {
+	long, lat     float64
+	byBox         bool
+	radius        float64
+	width, height float64
+	conversion    float64
+}
+
+// distance returns the distance of the coordinates from the center of the
+// shape. ok is false if the coordinates are outside the shape.
+func (s *geoShape) distance(long, lat float64) (dist float64, ok bool) {
+	if !s.byBox {
+		dist = geoDistance(s.long, s.lat, long, lat)
+		return dist, dist <= s.radius*s.conversion
+	}
+
+	// the latitude distance is cheaper, so it is checked first.
+	if geoLatDistance(lat, s.lat) > s.height*s.conversion/2 {
+		return 0, false
+	}
+	if geoDistance(long, lat, s.long, lat) > s.width*s.conversion/2 {
+		return 0, false
+	}
+	return geoDistance(s.long, s.lat, long, lat), true
+}
+
+// boundingBox returns the minimum and maximum longitude and latitude of the
+// shape.
+func (s *geoShape) boundingBox() (minLong, minLat, maxLong, maxLat float64) {
+	height, width := s.conversion*s.radius, s.conversion*s.radius
+	if s.byBox {
+		height, width = s.conversion*(s.height/2), s.conversion*(s.width/2)
+	}
+
+	latDelta := radDeg(height / earthRadius)
+	longDeltaTop := radDeg(width / earthRadius / math.Cos(degRad(s.lat+latDelta)))
+	longDeltaBottom := radDeg(width / earthRadius / math.Cos(degRad(s.lat-latDelta)))
+
+	// the widest side of the box is nearer to the equator.
+	longDelta := longDeltaTop
+	if s.lat < 0 {
+		longDelta = longDeltaBottom
+	}
+	return s.long - longDelta, s.lat - latDelta, s.long + longDelta, s.lat + latDelta
+}
+
+// areas returns the geohash areas to search for the members within the
+// shape: the area of the center of the shape followed by its north, south,
+// east, west, north east, north west, south east and south west neighbours.
+// The neighbours which do not intersect with the shape are zero.
+func (s *geoShape) areas() [9]geohash {
+	minLong, minLat, maxLong, maxLat := s.boundingBox()
+
+	radius := s.radius
+	if s.byBox {
+		radius = math.Sqrt((s.width/2)*(s.width/2) + (s.height/2)*(s.height/2))
+	}
+	step := geoEstimateSteps(radius*s.conversion, s.lat)
+
+	neighbours := func(step uint) [9]geohash {
+		h := geohashEncode(geoLongRange, geoLatRange, s.long, s.lat, step)
+		return [9]geohash{
+			h, h.move(0, 1), h.move(0, -1), h.move(1, 0), h.move(-1, 0),
+			h.move(1, 1), h.move(-1, 1), h.move(1, -1), h.move(-1, -1),
+		}
+	}
+	areas := neighbours(step)
+
+	// the step may be too large when the shape is near the edge of the
+	// area, so that the neighbours do not cover the whole shape.
+	decode := func(h geohash) geoArea {
+		return geohashDecode(geoLongRange, geoLatRange, h)
+	}
+	north, south, east, west := decode(areas[1]), decode(areas[2]), decode(areas[3]), decode(areas[4])
+	if step > 1 && (north.lat.max < maxLat || south.lat.min > minLat || east.long.max < maxLong || west.long.min > minLong) {
+		step--
+		areas = neighbours(step)
+	}
+
+	// exclude the neighbours which cannot contain members within the shape.
+	if step >= 2 {
+		area := decode(areas[0])
+		zero := func(idx ...int) {
+			for _, i := range idx {
+				areas[i] = geohash{}
+			}
+		}
+
+		if area.lat.min < minLat {
+			zero(2, 7, 8)
+		}
+		if area.lat.max > maxLat {
+			zero(1, 5, 6)
+		}
+		if area.long.min < minLong {
+			zero(4, 8, 6)
+		}
+		if area.long.max > maxLong {
+			zero(3, 7, 5)
+		}
+	}
+
+	return areas
+}
+
+// geoEstimateSteps returns the geohash step of the areas large enough to
+// cover the range in meters around the latitude.
+func geoEstimateSteps(rangeMeters, lat float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2
+
+	// the areas are narrower towards the poles.
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+)
+
+type selectedKey struct{}
+
+// Handler serves the commands of the store. The database selected using
+// SELECT is kept per client and defaults to 0. Handler can be used as a
+// reference server or as a fake Redis server in tests.
+type Handler struct {
+	// Store is the store the commands are executed on.
+	Store *Store
+
+	// Handler, if set, serves the commands unknown to the store.
+	Handler radio.Handler
+}
+
+// ServeRESP executes the request on the database selected by the client.
+func (h *Handler) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	switch strings.ToLower(req.Command) {
+	case "ping":
+		if len(req.Args) > 1 {
+			wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'ping' command"))
+		} else if len(req.Args) == 1 {
+			wr.Write(bulkStr(req.Args[0]))
+		} else {
+			wr.Write(radio.SimpleStr("PONG"))
+		}
+		return
+
+	case "echo":
+		if len(req.Args) != 1 {
+			wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'echo' command"))
+		} else {
+			wr.Write(bulkStr(req.Args[0]))
+		}
+		return
+
+	case "select":
+		h.serveSelect(wr, req)
+		return
+	}
+
+	db, _ := req.Client.Value(selectedKey{}).(int)
+	if h.Store.exec(wr, req, db) {
+		return
+	}
+
+	if h.Handler != nil {
+		h.Handler.ServeRESP(wr, req)
+		return
+	}
+	wr.Write(unknownCommand(req))
+}
+
+// Serial reports whether the request changes the state of the connection
+// and must be executed serially.
+func (h *Handler) Serial(req *radio.Request) bool {
+	if strings.EqualFold(req.Command, "select") {
+		return true
+	}
+
+	if sh, ok := h.Handler.(radio.SerialHandler); ok {
+		return sh.Serial(req)
+	}
+	return false
+}
+
+func (h *Handler) serveSelect(wr radio.ResponseWriter, req *radio.Request) {
+	if len(req.Args) != 1 {
+		wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'select' command"))
+		return
+	}
+
+	db, err := strconv.Atoi(req.Args[0])
+	if err != nil {
+		wr.Write(errNotInteger)
+		return
+	}
+
+	if db < 0 || db >= len(h.Store.dbs) {
+		wr.Write(errDBIndex)
+		return
+	}
+
+	if req.Client == nil {
+		wr.Write(radio.ErrorStr("ERR SELECT requires a client connection"))
+		return
+	}
+
+	req.Client.SetValue(selectedKey{}, db)
+	wr.Write(replyOK)
+}
@@ -0,0 +1,90 @@
+package store_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+	"github.com/spy16/radio/store"
+	"github.com/spy16/radio/tracking"
+)
+
+func TestHandler(t *testing.T) {
+	s := store.New(store.Options{})
+	defer s.Close()
+
+	fallback := radio.HandlerFunc(func(wr radio.ResponseWriter, req *radio.Request) {
+		wr.Write(radio.SimpleStr("FALLBACK"))
+	})
+
+	srv := radiotest.NewServer(&store.Handler{Store: s, Handler: fallback})
+	defer srv.Close()
+
+	first, second := dial(t, srv), dial(t, srv)
+	defer first.Close()
+	defer second.Close()
+
+	expect(t, first, radio.SimpleStr("PONG"), "PING")
+	expect(t, first, bulk("hi"), "ECHO", "hi")
+	expect(t, first, okReply, "SET", "a", "0")
+	expect(t, first, okReply, "SELECT", "1")
+	expect(t, first, okReply, "SET", "a", "1")
+	expect(t, first, radio.ErrorStr("ERR DB index is out of range"), "SELECT", "16")
+	expect(t, first, radio.ErrorStr("ERR value is not an integer or out of range"), "SELECT", "a")
+
+	// the database is selected per client.
+	expect(t, second, bulk("0"), "GET", "a")
+	expect(t, first, bulk("1"), "GET", "a")
+	expect(t, first, radio.SimpleStr("FALLBACK"), "FOO")
+
+	if v := s.Do(1, "GET", "a"); !reflect.DeepEqual(bulk("1"), v) {
+		t.Errorf("expecting '1' in database 1, got '%v'", v)
+	}
+}
+
+func TestHandler_Tracking(t *testing.T) {
+	tracker := &tracking.Tracker{}
+	s := store.New(store.Options{Tracker: tracker})
+	defer s.Close()
+
+	tracker.Handler = &store.Handler{Store: s}
+
+	srv := radiotest.NewServer(&radio.Hello{Handler: tracker})
+	defer srv.Close()
+
+	cached, writer := dial(t, srv), dial(t, srv)
+	defer cached.Close()
+	defer writer.Close()
+
+	if v, err := cached.Do("HELLO", "3"); err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	} else if _, ok := v.(*radio.Map); !ok {
+		t.Fatalf("expecting HELLO to reply with a map, got '%#v'", v)
+	}
+	expect(t, cached, okReply, "CLIENT", "TRACKING", "on")
+	expect(t, cached, &radio.Array{Items: []radio.Value{nullBulk, nullBulk}}, "MGET", "a", "b")
+
+	expect(t, writer, okReply, "SET", "b", "1")
+	expected := &radio.Push{Items: []radio.Value{bulk("invalidate"), bulks("b")}}
+	if v, err := cached.Receive(); err != nil || !reflect.DeepEqual(expected, v) {
+		t.Errorf("expecting '%v', got '%v' (err=%v)", expected, v, err)
+	}
+}
+
+func dial(t *testing.T, srv *radiotest.Server) *radio.Conn {
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	return conn
+}
+
+func expect(t *testing.T, conn *radio.Conn, expected radio.Value, cmd string, args ...string) {
+	t.Helper()
+
+	v, err := conn.Do(cmd, args...)
+	if err != nil || !reflect.DeepEqual(expected, v) {
+		t.Errorf("%s %v: expecting '%v', got '%v' (err=%v)", cmd, args, expected, v, err)
+	}
+}
@@ -0,0 +1,166 @@
+package store
+
+import "math/rand"
+
+const (
+	// hashMaxListpackEntries and hashMaxListpackValue are the limits of the
+	// listpack encoding of hashes (hash-max-listpack-entries/value).
+	hashMaxListpackEntries = 128
+	hashMaxListpackValue   = 64
+)
+
+// hash is a hash value. Small hashes are kept in a slice of fields in the
+// order they were added (listpack encoding) and converted to a dict
+// (hashtable encoding) when they exceed the listpack limits. Like Redis,
+// hashes are never converted back.
+type hash struct {
+	pairs []field
+	dict  *dict
+
+	// minExpire is a lower bound of the expiry of the fields or zero if
+	// none of the fields has an expiry.
+	minExpire int64
+}
+
+func (h *hash) encoding() string {
+	if h.dict != nil {
+		return "hashtable"
+	}
+	return "listpack"
+}
+
+func (h *hash) len() int {
+	if h.dict != nil {
+		return h.dict.len()
+	}
+	return len(h.pairs)
+}
+
+// get returns the field or nil if it does not exist. The returned field must
+// not be retained across modifications of the hash.
+func (h *hash) get(name string) *field {
+	if h.dict != nil {
+		return h.dict.get(name)
+	}
+
+	for i := range h.pairs {
+		if h.pairs[i].name == name {
+			return &h.pairs[i]
+		}
+	}
+	return nil
+}
+
+// set sets the value of the field keeping its expiry and returns true if
+// the field is created.
+func (h *hash) set(name, value string) bool {
+	if h.dict == nil && (len(name) > hashMaxListpackValue || len(value) > hashMaxListpackValue) {
+		h.convert()
+	}
+
+	if f := h.get(name); f != nil {
+		f.value = value
+		return false
+	}
+
+	if h.dict == nil && len(h.pairs) >= hashMaxListpackEntries {
+		h.convert()
+	}
+
+	if h.dict != nil {
+		h.dict.add(name, value)
+	} else {
+		h.pairs = append(h.pairs, field{name: name, value: value})
+	}
+	return true
+}
+
+func (h *hash) remove(name string) bool {
+	if h.dict != nil {
+		return h.dict.remove(name)
+	}
+
+	for i := range h.pairs {
+		if h.pairs[i].name == name {
+			h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// setExpire sets the expiry of the field in unix milliseconds. Zero makes
+// the field persistent.
+func (h *hash) setExpire(f *field, at int64) {
+	f.expireAt = at
+	if at != 0 && (h.minExpire == 0 || at < h.minExpire) {
+		h.minExpire = at
+	}
+}
+
+// expire removes the fields expired at now and returns the number of
+// fields removed.
+func (h *hash) expire(now int64) int {
+	if h.minExpire == 0 || h.minExpire > now {
+		return 0
+	}
+
+	var expired []string
+	h.minExpire = 0
+	h.each(func(f *field) bool {
+		if f.expireAt != 0 && f.expireAt <= now {
+			expired = append(expired, f.name)
+		} else if f.expireAt != 0 && (h.minExpire == 0 || f.expireAt < h.minExpire) {
+			h.minExpire = f.expireAt
+		}
+		return true
+	})
+
+	for _, name := range expired {
+		h.remove(name)
+	}
+	return len(expired)
+}
+
+// each invokes fn for every field until fn returns false.
+func (h *hash) each(fn func(f *field) bool) {
+	h.scan(0, fn)
+}
+
+// scan invokes fn for the fields starting at the cursor until fn returns
+// false. Returns the cursor to continue from or zero if all the fields are
+// visited. Hashes using the listpack encoding are always visited fully.
+func (h *hash) scan(cursor uint64, fn func(f *field) bool) uint64 {
+	if h.dict != nil {
+		return h.dict.scan(cursor, fn)
+	}
+
+	for i := range h.pairs {
+		if !fn(&h.pairs[i]) {
+			break
+		}
+	}
+	return 0
+}
+
+// random returns a random field or nil if the hash is empty.
+func (h *hash) random() *field {
+	if h.dict != nil {
+		return h.dict.random()
+	}
+
+	if len(h.pairs) == 0 {
+		return nil
+	}
+	return &h.pairs[rand.Intn(len(h.pairs))]
+}
+
+func (h *hash) convert() {
+	d := newDict(len(h.pairs))
+	for _, p := range h.pairs {
+		d.add(p.name, p.value).expireAt = p.expireAt
+	}
+
+	h.dict = d
+	h.pairs = nil
+}
@@ -0,0 +1,672 @@
+package store
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+func init() {
+	register("hset", -4, flagWrite|flagDenyOOM, hsetCommand)
+	register("hmset", -4, flagWrite|flagDenyOOM, hsetCommand)
+	register("hsetnx", 4, flagWrite|flagDenyOOM, hsetnxCommand)
+	register("hget", 3, flagReadOnly, hgetCommand)
+	register("hmget", -3, flagReadOnly, hmgetCommand)
+	register("hdel", -3, flagWrite, hdelCommand)
+	register("hlen", 2, flagReadOnly, hlenCommand)
+	register("hstrlen", 3, flagReadOnly, hstrlenCommand)
+	register("hexists", 3, flagReadOnly, hexistsCommand)
+	register("hkeys", 2, flagReadOnly, hgetallCommand)
+	register("hvals", 2, flagReadOnly, hgetallCommand)
+	register("hgetall", 2, flagReadOnly, hgetallCommand)
+	register("hincrby", 4, flagWrite|flagDenyOOM, hincrbyCommand)
+	register("hincrbyfloat", 4, flagWrite|flagDenyOOM, hincrbyfloatCommand)
+	register("hrandfield", -2, flagReadOnly, hrandfieldCommand)
+	register("hscan", -3, flagReadOnly, hscanCommand)
+	register("hexpire", -6, flagWrite, hexpireCommand)
+	register("hpexpire", -6, flagWrite, hexpireCommand)
+	register("hexpireat", -6, flagWrite, hexpireCommand)
+	register("hpexpireat", -6, flagWrite, hexpireCommand)
+	register("httl", -5, flagReadOnly, httlCommand)
+	register("hpttl", -5, flagReadOnly, httlCommand)
+	register("hexpiretime", -5, flagReadOnly, httlCommand)
+	register("hpexpiretime", -5, flagReadOnly, httlCommand)
+	register("hpersist", -5, flagWrite, hpersistCommand)
+}
+
+// lookupHash returns the hash of the key after removing its expired fields.
+// ok is false if the key holds another type in which case the error is
+// already written.
+func (c *call) lookupHash(key string) (e *entry, h *hash, ok bool) {
+	e, ok = c.lookupType(key, "hash")
+	if e == nil || c.expireFields(e) {
+		return nil, nil, ok
+	}
+	return e, e.val.(*hash), true
+}
+
+// createHash returns the hash of the key creating it if required. ok is
+// false if the key holds another type in which case the error is already
+// written.
+func (c *call) createHash(key string) (e *entry, h *hash, ok bool) {
+	e, h, ok = c.lookupHash(key)
+	if ok && e == nil {
+		h = &hash{}
+		e = c.add(key, h)
+	}
+	return e, h, ok
+}
+
+// expireFields removes the expired fields of the hash and deletes the key
+// if all of its fields are expired. Returns true if the key is deleted.
+func (c *call) expireFields(e *entry) bool {
+	h := e.val.(*hash)
+	removed := h.expire(c.now)
+	if h.minExpire == 0 {
+		delete(c.db.volatile, e.key)
+	}
+
+	if removed == 0 {
+		return false
+	}
+
+	c.touch(e.key)
+	c.notify(keyspace.Hash, "hexpired", e.key)
+	if h.len() == 0 {
+		c.db.delete(e.key)
+		c.notify(keyspace.Generic, "del", e.key)
+		return true
+	}
+	return false
+}
+
+// watchFields registers the hash for the active expiry of its fields.
+func (d *db) watchFields(e *entry) {
+	if e.val.(*hash).minExpire != 0 {
+		d.volatile[e.key] = e
+	} else {
+		delete(d.volatile, e.key)
+	}
+}
+
+// removeIfEmpty deletes the key if the collection stored at it is empty.
+func (c *call) removeIfEmpty(key string, n int) {
+	if n == 0 {
+		c.db.delete(key)
+		c.notify(keyspace.Generic, "del", key)
+	}
+}
+
+func hsetCommand(c *call) {
+	if len(c.args)%2 != 1 {
+		c.reply(errArity(c.name))
+		return
+	}
+
+	key := c.args[0]
+	_, h, ok := c.createHash(key)
+	if !ok {
+		return
+	}
+
+	created := 0
+	for i := 1; i < len(c.args); i += 2 {
+		if h.minExpire != 0 {
+			if f := h.get(c.args[i]); f != nil {
+				f.expireAt = 0
+			}
+		}
+
+		if h.set(c.args[i], c.args[i+1]) {
+			created++
+		}
+	}
+
+	c.touch(key)
+	c.notify(keyspace.Hash, "hset", key)
+	if c.name == "hmset" {
+		c.reply(replyOK)
+	} else {
+		c.reply(radio.Integer(created))
+	}
+}
+
+func hsetnxCommand(c *call) {
+	key := c.args[0]
+	_, h, ok := c.createHash(key)
+	if !ok {
+		return
+	}
+
+	if h.get(c.args[1]) != nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	h.set(c.args[1], c.args[2])
+	c.touch(key)
+	c.notify(keyspace.Hash, "hset", key)
+	c.reply(radio.Integer(1))
+}
+
+func hgetCommand(c *call) {
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	if h == nil {
+		c.reply(nullBulk)
+		return
+	}
+
+	if f := h.get(c.args[1]); f != nil {
+		c.reply(bulkStr(f.value))
+	} else {
+		c.reply(nullBulk)
+	}
+}
+
+func hmgetCommand(c *call) {
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	arr := &radio.Array{Items: make([]radio.Value, len(c.args)-1)}
+	for i, name := range c.args[1:] {
+		arr.Items[i] = nullBulk
+		if h == nil {
+			continue
+		}
+
+		if f := h.get(name); f != nil {
+			arr.Items[i] = bulkStr(f.value)
+		}
+	}
+	c.reply(arr)
+}
+
+func hdelCommand(c *call) {
+	key := c.args[0]
+	_, h, ok := c.lookupHash(key)
+	if !ok {
+		return
+	}
+
+	if h == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	deleted := 0
+	for _, name := range c.args[1:] {
+		if h.remove(name) {
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		c.touch(key)
+		c.notify(keyspace.Hash, "hdel", key)
+		c.removeIfEmpty(key, h.len())
+	}
+	c.reply(radio.Integer(deleted))
+}
+
+func hlenCommand(c *call) {
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	if h == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+	c.reply(radio.Integer(h.len()))
+}
+
+func hstrlenCommand(c *call) {
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	n := 0
+	if h != nil {
+		if f := h.get(c.args[1]); f != nil {
+			n = len(f.value)
+		}
+	}
+	c.reply(radio.Integer(n))
+}
+
+func hexistsCommand(c *call) {
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	if h != nil && h.get(c.args[1]) != nil {
+		c.reply(radio.Integer(1))
+	} else {
+		c.reply(radio.Integer(0))
+	}
+}
+
+func hgetallCommand(c *call) {
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	arr := &radio.Array{Items: []radio.Value{}}
+	if h == nil {
+		c.reply(arr)
+		return
+	}
+
+	h.each(func(f *field) bool {
+		if c.name != "hvals" {
+			arr.Items = append(arr.Items, bulkStr(f.name))
+		}
+		if c.name != "hkeys" {
+			arr.Items = append(arr.Items, bulkStr(f.value))
+		}
+		return true
+	})
+	c.reply(arr)
+}
+
+func hincrbyCommand(c *call) {
+	by, ok := parseInt(c.args[2])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	key, name := c.args[0], c.args[1]
+	_, h, ok := c.createHash(key)
+	if !ok {
+		return
+	}
+
+	var cur int64
+	if f := h.get(name); f != nil {
+		if cur, ok = parseInt(f.value); !ok {
+			c.reply(radio.ErrorStr("ERR hash value is not an integer"))
+			return
+		}
+	}
+
+	if (by > 0 && cur > math.MaxInt64-by) || (by < 0 && cur < math.MinInt64-by) {
+		c.reply(radio.ErrorStr("ERR increment or decrement would overflow"))
+		return
+	}
+	cur += by
+
+	h.set(name, strconv.FormatInt(cur, 10))
+	c.touch(key)
+	c.notify(keyspace.Hash, "hincrby", key)
+	c.reply(radio.Integer(cur))
+}
+
+func hincrbyfloatCommand(c *call) {
+	by, ok := parseFloat(c.args[2])
+	if !ok {
+		c.reply(errNotFloat)
+		return
+	}
+	if math.IsInf(by, 0) {
+		c.reply(radio.ErrorStr("ERR value is NaN or Infinity"))
+		return
+	}
+
+	key, name := c.args[0], c.args[1]
+	_, h, ok := c.createHash(key)
+	if !ok {
+		return
+	}
+
+	var cur float64
+	if f := h.get(name); f != nil {
+		if cur, ok = parseFloat(f.value); !ok {
+			c.reply(radio.ErrorStr("ERR hash value is not a float"))
+			return
+		}
+	}
+
+	cur += by
+	if math.IsNaN(cur) || math.IsInf(cur, 0) {
+		c.reply(radio.ErrorStr("ERR increment would produce NaN or Infinity"))
+		return
+	}
+
+	s := formatFloat(cur)
+	h.set(name, s)
+	c.touch(key)
+	c.notify(keyspace.Hash, "hincrbyfloat", key)
+	c.reply(bulkStr(s))
+}
+
+func hrandfieldCommand(c *call) {
+	if len(c.args) > 3 || (len(c.args) == 3 && !strings.EqualFold(c.args[2], "withvalues")) {
+		c.reply(errSyntax)
+		return
+	}
+
+	var count int64
+	if len(c.args) > 1 {
+		n, ok := parseInt(c.args[1])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+		if n < -math.MaxInt64/2 {
+			c.reply(radio.ErrorStr("ERR value is out of range"))
+			return
+		}
+		count = n
+	}
+
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	if len(c.args) == 1 {
+		if h == nil {
+			c.reply(nullBulk)
+		} else {
+			c.reply(bulkStr(h.random().name))
+		}
+		return
+	}
+
+	arr := &radio.Array{Items: []radio.Value{}}
+	if h == nil {
+		c.reply(arr)
+		return
+	}
+
+	withValues := len(c.args) == 3
+	add := func(f *field) {
+		arr.Items = append(arr.Items, bulkStr(f.name))
+		if withValues {
+			arr.Items = append(arr.Items, bulkStr(f.value))
+		}
+	}
+
+	if count < 0 {
+		for i := int64(0); i < -count; i++ {
+			add(h.random())
+		}
+		c.reply(arr)
+		return
+	}
+
+	var fields []*field
+	h.each(func(f *field) bool {
+		fields = append(fields, f)
+		return true
+	})
+
+	if count < int64(len(fields)) {
+		for i := 0; i < int(count); i++ {
+			j := i + rand.Intn(len(fields)-i)
+			fields[i], fields[j] = fields[j], fields[i]
+		}
+		fields = fields[:count]
+	}
+
+	for _, f := range fields {
+		add(f)
+	}
+	c.reply(arr)
+}
+
+func hscanCommand(c *call) {
+	opts, ok := c.parseScan(c.args[1:])
+	if !ok {
+		return
+	}
+
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	items := []radio.Value{}
+	var next uint64
+	if h != nil {
+		visited := int64(0)
+		next = h.scan(opts.cursor, func(f *field) bool {
+			visited++
+			if opts.pattern == "" || radio.MatchPattern(opts.pattern, f.name) {
+				items = append(items, bulkStr(f.name))
+				if !opts.noValues {
+					items = append(items, bulkStr(f.value))
+				}
+			}
+			return h.dict == nil || visited < opts.count
+		})
+	}
+
+	c.reply(&radio.Array{Items: []radio.Value{
+		bulkStr(strconv.FormatUint(next, 10)),
+		&radio.Array{Items: items},
+	}})
+}
+
+// parseFields parses the FIELDS numfields field... arguments of the field
+// expiry commands.
+func (c *call) parseFields(args []string) ([]string, bool) {
+	if len(args) < 2 || !strings.EqualFold(args[0], "fields") {
+		c.reply(radio.ErrorStr("ERR Mandatory argument FIELDS is missing or not at the right position"))
+		return nil, false
+	}
+
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.reply(errNotInteger)
+		return nil, false
+	}
+	if n <= 0 {
+		c.reply(radio.ErrorStr("ERR Parameter `numFields` should be greater than 0"))
+		return nil, false
+	}
+	if n != int64(len(args)-2) {
+		c.reply(radio.ErrorStr("ERR The `numfields` parameter must match the number of arguments"))
+		return nil, false
+	}
+
+	return args[2:], true
+}
+
+// fieldResults returns an array of n integers initialized to v.
+func fieldResults(n int, v int) *radio.Array {
+	arr := &radio.Array{Items: make([]radio.Value, n)}
+	for i := range arr.Items {
+		arr.Items[i] = radio.Integer(v)
+	}
+	return arr
+}
+
+func hexpireCommand(c *call) {
+	key := c.args[0]
+	when, ok := parseInt(c.args[1])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	rest := c.args[2:]
+	cond := strings.ToLower(rest[0])
+	switch cond {
+	case "nx", "xx", "gt", "lt":
+		rest = rest[1:]
+	default:
+		cond = ""
+	}
+
+	names, ok := c.parseFields(rest)
+	if !ok {
+		return
+	}
+
+	if when < 0 {
+		c.reply(radio.ErrorStr("ERR invalid expire time, must be >= 0"))
+		return
+	}
+
+	invalid := radio.ErrorStr("ERR invalid expire time in '" + c.name + "' command")
+	if c.name == "hexpire" || c.name == "hexpireat" {
+		if when > math.MaxInt64/1000 {
+			c.reply(invalid)
+			return
+		}
+		when *= 1000
+	}
+
+	if c.name == "hexpire" || c.name == "hpexpire" {
+		if when > math.MaxInt64-c.now {
+			c.reply(invalid)
+			return
+		}
+		when += c.now
+	}
+
+	e, h, ok := c.lookupHash(key)
+	if !ok {
+		return
+	}
+
+	results := fieldResults(len(names), -2)
+	if h == nil {
+		c.reply(results)
+		return
+	}
+
+	updated, deleted := 0, 0
+	for i, name := range names {
+		f := h.get(name)
+		if f == nil {
+			continue
+		}
+
+		// a field without expiry has an infinite ttl.
+		cur := f.expireAt
+		if (cond == "nx" && cur != 0) || (cond == "xx" && cur == 0) ||
+			(cond == "gt" && (cur == 0 || when <= cur)) || (cond == "lt" && cur != 0 && when >= cur) {
+			results.Items[i] = radio.Integer(0)
+			continue
+		}
+
+		if when <= c.now {
+			h.remove(name)
+			deleted++
+			results.Items[i] = radio.Integer(2)
+			continue
+		}
+
+		h.setExpire(f, when)
+		updated++
+		results.Items[i] = radio.Integer(1)
+	}
+
+	if updated+deleted > 0 {
+		c.touch(key)
+	}
+	if updated > 0 {
+		c.notify(keyspace.Hash, "hexpire", key)
+	}
+	if deleted > 0 {
+		c.notify(keyspace.Hash, "hdel", key)
+		c.removeIfEmpty(key, h.len())
+	}
+
+	if h.len() > 0 {
+		c.db.watchFields(e)
+	}
+	c.reply(results)
+}
+
+func httlCommand(c *call) {
+	names, ok := c.parseFields(c.args[1:])
+	if !ok {
+		return
+	}
+
+	_, h, ok := c.lookupHash(c.args[0])
+	if !ok {
+		return
+	}
+
+	results := fieldResults(len(names), -2)
+	if h == nil {
+		c.reply(results)
+		return
+	}
+
+	for i, name := range names {
+		f := h.get(name)
+		switch {
+		case f == nil:
+		case f.expireAt == 0:
+			results.Items[i] = radio.Integer(-1)
+		case c.name == "httl":
+			results.Items[i] = radio.Integer((f.expireAt - c.now + 999) / 1000)
+		case c.name == "hpttl":
+			results.Items[i] = radio.Integer(f.expireAt - c.now)
+		case c.name == "hexpiretime":
+			results.Items[i] = radio.Integer(f.expireAt / 1000)
+		default:
+			results.Items[i] = radio.Integer(f.expireAt)
+		}
+	}
+	c.reply(results)
+}
+
+func hpersistCommand(c *call) {
+	key := c.args[0]
+	names, ok := c.parseFields(c.args[1:])
+	if !ok {
+		return
+	}
+
+	_, h, ok := c.lookupHash(key)
+	if !ok {
+		return
+	}
+
+	results := fieldResults(len(names), -2)
+	if h == nil {
+		c.reply(results)
+		return
+	}
+
+	persisted := 0
+	for i, name := range names {
+		f := h.get(name)
+		switch {
+		case f == nil:
+		case f.expireAt == 0:
+			results.Items[i] = radio.Integer(-1)
+		default:
+			f.expireAt = 0
+			persisted++
+			results.Items[i] = radio.Integer(1)
+		}
+	}
+
+	if persisted > 0 {
+		c.touch(key)
+		c.notify(keyspace.Hash, "hpersist", key)
+	}
+	c.reply(results)
+}
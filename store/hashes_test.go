@@ -0,0 +1,205 @@
+package store_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+func TestHashes(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "HSET",
+			steps: []step{
+				{cmd: "HSET h a 1 b 2", reply: radio.Integer(2)},
+				{cmd: "HSET h a 3 c 4", reply: radio.Integer(1)},
+				{cmd: "HSET h a", reply: radio.ErrorStr("ERR wrong number of arguments for 'hset' command")},
+				{cmd: "HMSET h d 5", reply: okReply},
+				{cmd: "HSETNX h a 9", reply: radio.Integer(0)},
+				{cmd: "HSETNX h e 6", reply: radio.Integer(1)},
+				{cmd: "HGET h a", reply: bulk("3")},
+				{cmd: "HGET h x", reply: nullBulk},
+				{cmd: "HGET x a", reply: nullBulk},
+				{cmd: "HMGET h a x b", reply: &radio.Array{Items: []radio.Value{bulk("3"), nullBulk, bulk("2")}}},
+				{cmd: "HLEN h", reply: radio.Integer(5)},
+				{cmd: "HSTRLEN h a", reply: radio.Integer(1)},
+				{cmd: "HEXISTS h e", reply: radio.Integer(1)},
+				{cmd: "HEXISTS h x", reply: radio.Integer(0)},
+				{cmd: "HKEYS h", reply: bulks("a", "b", "c", "d", "e")},
+				{cmd: "HVALS h", reply: bulks("3", "2", "4", "5", "6")},
+				{cmd: "HGETALL h", reply: bulks("a", "3", "b", "2", "c", "4", "d", "5", "e", "6")},
+				{cmd: "HGETALL x", reply: bulks()},
+				{cmd: "HDEL h a b x", reply: radio.Integer(2)},
+				{cmd: "HDEL h c d e", reply: radio.Integer(3)},
+				{cmd: "EXISTS h", reply: radio.Integer(0)},
+				{cmd: "SET s 1", reply: okReply},
+				{cmd: "HSET s a 1", reply: wrongType},
+				{cmd: "HGET s a", reply: wrongType},
+				{cmd: "TYPE h", reply: radio.SimpleStr("none")},
+				{cmd: "HSET h a 1", reply: radio.Integer(1)},
+				{cmd: "TYPE h", reply: radio.SimpleStr("hash")},
+			},
+		},
+		{
+			title: "HINCRBY",
+			steps: []step{
+				{cmd: "HINCRBY h a 5", reply: radio.Integer(5)},
+				{cmd: "HINCRBY h a -10", reply: radio.Integer(-5)},
+				{cmd: "HINCRBY h a x", reply: radio.ErrorStr("ERR value is not an integer or out of range")},
+				{cmd: "HSET h b x c 9223372036854775807", reply: radio.Integer(2)},
+				{cmd: "HINCRBY h b 1", reply: radio.ErrorStr("ERR hash value is not an integer")},
+				{cmd: "HINCRBY h c 1", reply: radio.ErrorStr("ERR increment or decrement would overflow")},
+				{cmd: "HINCRBYFLOAT h d 10.5", reply: bulk("10.5")},
+				{cmd: "HINCRBYFLOAT h d 0.1", reply: bulk("10.6")},
+				{cmd: "HINCRBYFLOAT h b 1", reply: radio.ErrorStr("ERR hash value is not a float")},
+				{cmd: "HINCRBYFLOAT h d x", reply: radio.ErrorStr("ERR value is not a valid float")},
+				{cmd: "HINCRBYFLOAT h d inf", reply: radio.ErrorStr("ERR value is NaN or Infinity")},
+			},
+		},
+		{
+			title: "HRANDFIELD",
+			steps: []step{
+				{cmd: "HRANDFIELD h", reply: nullBulk},
+				{cmd: "HRANDFIELD h 5", reply: bulks()},
+				{cmd: "HSET h a 1", reply: radio.Integer(1)},
+				{cmd: "HRANDFIELD h", reply: bulk("a")},
+				{cmd: "HRANDFIELD h 5 WITHVALUES", reply: bulks("a", "1")},
+				{cmd: "HRANDFIELD h -3", reply: bulks("a", "a", "a")},
+				{cmd: "HRANDFIELD h 0", reply: bulks()},
+				{cmd: "HRANDFIELD h 1 FOO", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "HSCAN",
+			steps: []step{
+				{cmd: "HSET h a1 1 a2 2 b1 3", reply: radio.Integer(3)},
+				{cmd: "HSCAN h 0", reply: scanReply("0", "a1", "1", "a2", "2", "b1", "3")},
+				{cmd: "HSCAN h 0 MATCH a* COUNT 1", reply: scanReply("0", "a1", "1", "a2", "2")},
+				{cmd: "HSCAN h 0 NOVALUES", reply: scanReply("0", "a1", "a2", "b1")},
+				{cmd: "HSCAN h 0 TYPE hash", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "HSCAN x 0", reply: scanReply("0")},
+			},
+		},
+		{
+			title: "HEXPIRE",
+			steps: []step{
+				{cmd: "HEXPIRE h 10 FIELDS 1 a", reply: ints(-2)},
+				{cmd: "HSET h a 1 b 2 c 3", reply: radio.Integer(3)},
+				{cmd: "HEXPIRE h 10 FIELDS 2 a x", reply: ints(1, -2)},
+				{cmd: "HEXPIRE h 20 NX FIELDS 2 a b", reply: ints(0, 1)},
+				{cmd: "HEXPIRE h 30 GT FIELDS 3 a b c", reply: ints(1, 1, 0)},
+				{cmd: "HPEXPIRE h 5000 LT FIELDS 2 a c", reply: ints(1, 1)},
+				{cmd: "HPEXPIREAT h 1000100000 XX FIELDS 1 b", reply: ints(1)},
+				{cmd: "HTTL h FIELDS 3 a b x", reply: ints(5, 100, -2)},
+				{cmd: "HPTTL h FIELDS 1 a", reply: ints(5000)},
+				{cmd: "HEXPIRETIME h FIELDS 1 b", reply: ints(1000100)},
+				{cmd: "HPEXPIRETIME h FIELDS 1 b", reply: ints(1000100000)},
+				{cmd: "HPERSIST h FIELDS 3 a b x", reply: ints(1, 1, -2)},
+				{cmd: "HPERSIST h FIELDS 1 a", reply: ints(-1)},
+				{cmd: "HTTL h FIELDS 1 a", reply: ints(-1)},
+				{cmd: "HEXPIREAT h 1 FIELDS 1 a", reply: ints(2)},
+				{cmd: "HSET h c 4", reply: radio.Integer(0)},
+				{cmd: "HTTL h FIELDS 1 c", reply: ints(-1)},
+				{cmd: "HGETALL h", reply: bulks("b", "2", "c", "4")},
+				{cmd: "HEXPIRE h 10 FIELDS 0 a", reply: radio.ErrorStr("ERR Parameter `numFields` should be greater than 0")},
+				{cmd: "HEXPIRE h 10 FIELDS 2 a", reply: radio.ErrorStr("ERR The `numfields` parameter must match the number of arguments")},
+				{cmd: "HEXPIRE h 10 FOO 1 a", reply: radio.ErrorStr("ERR Mandatory argument FIELDS is missing or not at the right position")},
+				{cmd: "HEXPIRE h -1 FIELDS 1 a", reply: radio.ErrorStr("ERR invalid expire time, must be >= 0")},
+				{cmd: "HEXPIRE h 9223372036854775807 FIELDS 1 a", reply: radio.ErrorStr("ERR invalid expire time in 'hexpire' command")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(newClock())
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestHashes_FieldExpiry(t *testing.T) {
+	clock := newClock()
+	s := newStore(clock)
+	defer s.Close()
+
+	runSteps(t, s, []step{
+		{cmd: "HSET h a 1 b 2", reply: radio.Integer(2)},
+		{cmd: "HPEXPIRE h 100 FIELDS 1 a", reply: ints(1)},
+		{cmd: "HPEXPIRE h 200 FIELDS 1 b", reply: ints(1)},
+	})
+
+	clock.Advance(100 * time.Millisecond)
+	runSteps(t, s, []step{
+		{cmd: "HGETALL h", reply: bulks("b", "2")},
+		{cmd: "HLEN h", reply: radio.Integer(1)},
+	})
+
+	clock.Advance(100 * time.Millisecond)
+	runSteps(t, s, []step{
+		{cmd: "HLEN h", reply: radio.Integer(0)},
+		{cmd: "EXISTS h", reply: radio.Integer(0)},
+	})
+}
+
+func TestHashes_Encoding(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	runSteps(t, s, []step{
+		{cmd: "HSET small a 1", reply: radio.Integer(1)},
+		{cmd: "OBJECT ENCODING small", reply: bulk("listpack")},
+		{cmd: "HSET long a " + strings.Repeat("x", 65), reply: radio.Integer(1)},
+		{cmd: "OBJECT ENCODING long", reply: bulk("hashtable")},
+	})
+
+	for i := 0; i < 129; i++ {
+		s.Do(0, "HSET", "big", "f"+strconv.Itoa(i), "v")
+	}
+
+	runSteps(t, s, []step{
+		{cmd: "OBJECT ENCODING big", reply: bulk("hashtable")},
+		{cmd: "HLEN big", reply: radio.Integer(129)},
+		{cmd: "HGET big f128", reply: bulk("v")},
+	})
+
+	seen := map[string]bool{}
+	cursor := "0"
+	for {
+		reply := s.Do(0, "HSCAN", "big", cursor, "COUNT", "10", "NOVALUES").(*radio.Array)
+		cursor = reply.Items[0].(*radio.BulkStr).String()
+		fields := reply.Items[1].(*radio.Array).Items
+		if len(fields) > 10 {
+			t.Errorf("expecting at most 10 fields per call, got %d", len(fields))
+		}
+		for _, f := range fields {
+			seen[f.(*radio.BulkStr).String()] = true
+		}
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if len(seen) != 129 {
+		t.Errorf("expecting HSCAN to return all the 129 fields, got %d", len(seen))
+	}
+}
+
+func ints(vals ...int) *radio.Array {
+	arr := &radio.Array{Items: []radio.Value{}}
+	for _, v := range vals {
+		arr.Items = append(arr.Items, radio.Integer(v))
+	}
+	return arr
+}
@@ -0,0 +1,378 @@
+package store
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are strings in the same format as Redis so that the values
+// can be moved between radio and Redis with GET/SET (or DUMP/RESTORE). The
+// value starts with a 16 bytes header:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// E is the encoding (dense or sparse), N/U are unused bytes and the last 8
+// bytes cache the cardinality in little endian. The most significant bit of
+// the cache is set when the cache is invalid.
+//
+// The dense encoding stores the 16384 registers of 6 bits each starting
+// from the least significant bits of the bytes. The sparse encoding is a
+// run length encoding of the registers made of the following opcodes:
+//
+//	00xxxxxx          ZERO: 1-64 registers set to zero.
+//	01xxxxxx yyyyyyyy XZERO: 1-16384 registers set to zero.
+//	1vvvvvxx          VAL: 1-4 registers set to 1-32.
+//
+// New HyperLogLogs use the sparse encoding which is converted to the dense
+// encoding once a register exceeds 32 or the value exceeds 3000 bytes.
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllBits        = 6
+	hllRegisterMax = 1<<hllBits - 1
+	hllHeaderSize  = 16
+	hllDenseSize   = hllHeaderSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseValMax      = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+	hllSparseMaxBytes    = 3000
+
+	hllAlphaInf = 0.721347520444481703680
+)
+
+var hllMagic = "HYLL"
+
+// newHLL returns an empty HyperLogLog in the sparse encoding.
+func newHLL() []byte {
+	b := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(b, hllMagic)
+	b[4] = hllSparse
+	return append(b, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+// validHLL reports whether the string has a valid HyperLogLog header. The
+// registers of sparse HyperLogLogs are validated when they are decoded.
+func validHLL(b []byte) bool {
+	if len(b) < hllHeaderSize || string(b[:4]) != hllMagic {
+		return false
+	}
+
+	switch b[4] {
+	case hllDense:
+		return len(b) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+// hllCached returns the cached cardinality of the HyperLogLog.
+func hllCached(b []byte) (uint64, bool) {
+	if b[15]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(b[8:16]), true
+}
+
+func hllSetCached(b []byte, card uint64) {
+	binary.LittleEndian.PutUint64(b[8:16], card)
+}
+
+func hllInvalidate(b []byte) {
+	b[15] |= 0x80
+}
+
+// hllPattern returns the register of the element and the number of leading
+// zeros (plus one) of the rest of its hash.
+func hllPattern(elem string) (int, uint8) {
+	h := murmurHash64A(elem, 0xadc83b19)
+	index := int(h & (hllRegisters - 1))
+	h >>= hllP
+	h |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(h) + 1)
+}
+
+// hllAdd adds the elements to the HyperLogLog and returns the updated copy
+// of the value. The value is returned as is if no register is updated.
+func hllAdd(b []byte, elems []string) ([]byte, bool, bool) {
+	if b[4] == hllDense {
+		var updated []byte
+		for _, elem := range elems {
+			index, count := hllPattern(elem)
+			if hllDenseGet(b[hllHeaderSize:], index) >= count {
+				continue
+			}
+
+			if updated == nil {
+				updated = append([]byte(nil), b...)
+				b = updated
+			}
+			hllDenseSet(b[hllHeaderSize:], index, count)
+		}
+
+		if updated == nil {
+			return b, false, true
+		}
+		hllInvalidate(updated)
+		return updated, true, true
+	}
+
+	regs := make([]uint8, hllRegisters)
+	if !hllMerge(b, regs) {
+		return nil, false, false
+	}
+
+	changed := false
+	for _, elem := range elems {
+		index, count := hllPattern(elem)
+		if regs[index] < count {
+			regs[index] = count
+			changed = true
+		}
+	}
+
+	if !changed {
+		return b, false, true
+	}
+	return hllEncode(regs, true), true, true
+}
+
+// hllMerge sets the registers to the maximum of the registers and the
+// registers of the HyperLogLog. Returns false if the sparse encoding is
+// corrupted.
+func hllMerge(b []byte, regs []uint8) bool {
+	if b[4] == hllDense {
+		for i := range regs {
+			if r := hllDenseGet(b[hllHeaderSize:], i); r > regs[i] {
+				regs[i] = r
+			}
+		}
+		return true
+	}
+
+	index := 0
+	for p := hllHeaderSize; p < len(b); p++ {
+		op := b[p]
+		switch op & 0xc0 {
+		case 0x00:
+			index += int(op&0x3f) + 1
+
+		case 0x40:
+			if p+1 == len(b) {
+				return false
+			}
+			p++
+			index += int(op&0x3f)<<8 | int(b[p]) + 1
+
+		default:
+			val, n := op>>2&0x1f+1, int(op&0x03)+1
+			if index+n > hllRegisters {
+				return false
+			}
+			for i := index; i < index+n; i++ {
+				if val > regs[i] {
+					regs[i] = val
+				}
+			}
+			index += n
+		}
+
+		if index > hllRegisters {
+			return false
+		}
+	}
+
+	return index == hllRegisters
+}
+
+// hllEncode returns a HyperLogLog with the registers. The sparse encoding
+// is used if sparse is set and the registers fit in it. The cached
+// cardinality is invalid.
+func hllEncode(regs []uint8, sparse bool) []byte {
+	var b []byte
+	if sparse {
+		b = hllSparseEncode(regs)
+	}
+
+	if b == nil {
+		b = make([]byte, hllDenseSize)
+		copy(b, hllMagic)
+		b[4] = hllDense
+		for i, r := range regs {
+			if r != 0 {
+				hllDenseSet(b[hllHeaderSize:], i, r)
+			}
+		}
+	}
+
+	hllInvalidate(b)
+	return b
+}
+
+// hllSparseEncode returns the registers in the sparse encoding or nil if a
+// register is too large for it or the value would exceed the maximum size.
+func hllSparseEncode(regs []uint8) []byte {
+	b := make([]byte, hllHeaderSize, 64)
+	copy(b, hllMagic)
+	b[4] = hllSparse
+
+	for i := 0; i < len(regs); {
+		val, n := regs[i], 1
+		for i+n < len(regs) && regs[i+n] == val {
+			n++
+		}
+		i += n
+
+		switch {
+		case val > hllSparseValMax:
+			return nil
+
+		case val == 0:
+			for n > 0 {
+				if n <= hllSparseZeroMaxLen {
+					b = append(b, byte(n-1))
+					break
+				}
+
+				l := n
+				if l > hllSparseXZeroMaxLen {
+					l = hllSparseXZeroMaxLen
+				}
+				b = append(b, 0x40|byte((l-1)>>8), byte(l-1))
+				n -= l
+			}
+
+		default:
+			for ; n > 0; n -= hllSparseValMaxLen {
+				l := n
+				if l > hllSparseValMaxLen {
+					l = hllSparseValMaxLen
+				}
+				b = append(b, 0x80|(val-1)<<2|byte(l-1))
+			}
+		}
+
+		if len(b) > hllSparseMaxBytes {
+			return nil
+		}
+	}
+
+	return b
+}
+
+func hllDenseGet(regs []byte, i int) uint8 {
+	pos := i * hllBits
+	n, shift := pos/8, uint(pos%8)
+
+	r := regs[n] >> shift
+	if n+1 < len(regs) {
+		r |= regs[n+1] << (8 - shift)
+	}
+	return r & hllRegisterMax
+}
+
+func hllDenseSet(regs []byte, i int, val uint8) {
+	pos := i * hllBits
+	n, shift := pos/8, uint(pos%8)
+
+	regs[n] &^= hllRegisterMax << shift
+	regs[n] |= val << shift
+	if n+1 < len(regs) {
+		regs[n+1] &^= hllRegisterMax >> (8 - shift)
+		regs[n+1] |= val >> (8 - shift)
+	}
+}
+
+// hllCount estimates the cardinality of the registers using the improved
+// estimator by Otmar Ertl as Redis does.
+func hllCount(regs []uint8) uint64 {
+	var histogram [64]int
+	for _, r := range regs {
+		histogram[r]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64 bits MurmurHash2 by Austin Appleby used by Redis
+// to hash the elements of HyperLogLogs.
+func murmurHash64A(key string, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(key))*m
+
+	n := len(key) / 8 * 8
+	for i := 0; i < n; i += 8 {
+		k := uint64(key[i]) | uint64(key[i+1])<<8 | uint64(key[i+2])<<16 | uint64(key[i+3])<<24 |
+			uint64(key[i+4])<<32 | uint64(key[i+5])<<40 | uint64(key[i+6])<<48 | uint64(key[i+7])<<56
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	if tail := key[n:]; len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
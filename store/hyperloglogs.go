@@ -0,0 +1,158 @@
+package store
+
+import (
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+var (
+	errNotHLL     = radio.ErrorStr("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	errCorruptHLL = radio.ErrorStr("INVALIDOBJ Corrupted HLL object detected")
+)
+
+func init() {
+	register("pfadd", -2, flagWrite|flagDenyOOM, pfaddCommand)
+	register("pfcount", -2, flagWrite, pfcountCommand)
+	register("pfmerge", -2, flagWrite|flagDenyOOM, pfmergeCommand)
+}
+
+// lookupHLL returns the value of the HyperLogLog key. ok is false if the
+// key holds another type or a string which is not a HyperLogLog in which
+// case the error is already written.
+func (c *call) lookupHLL(key string) (e *entry, val []byte, ok bool) {
+	if e, val, ok = c.lookupString(key); !ok {
+		return nil, nil, false
+	}
+
+	if e != nil && !validHLL(val) {
+		c.reply(errNotHLL)
+		return nil, nil, false
+	}
+	return e, val, true
+}
+
+func pfaddCommand(c *call) {
+	key := c.args[0]
+	e, val, ok := c.lookupHLL(key)
+	if !ok {
+		return
+	}
+
+	created := e == nil
+	if created {
+		val = newHLL()
+	}
+
+	updated, changed, ok := hllAdd(val, c.args[1:])
+	if !ok {
+		c.reply(errCorruptHLL)
+		return
+	}
+
+	if !created && !changed {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	if created {
+		c.add(key, updated)
+	} else {
+		e.val = updated
+	}
+
+	c.touch(key)
+	c.notify(keyspace.String, "pfadd", key)
+	c.reply(radio.Integer(1))
+}
+
+func pfcountCommand(c *call) {
+	if len(c.args) == 1 {
+		key := c.args[0]
+		e, val, ok := c.lookupHLL(key)
+		if !ok {
+			return
+		} else if e == nil {
+			c.reply(radio.Integer(0))
+			return
+		}
+
+		if card, ok := hllCached(val); ok {
+			c.reply(radio.Integer(card))
+			return
+		}
+
+		regs := make([]uint8, hllRegisters)
+		if !hllMerge(val, regs) {
+			c.reply(errCorruptHLL)
+			return
+		}
+
+		// the cardinality is cached in a copy of the value as the bytes
+		// may still be referenced by replies that are not yet written.
+		card := hllCount(regs)
+		val = append([]byte(nil), val...)
+		hllSetCached(val, card)
+		e.val = val
+
+		c.touch(key)
+		c.reply(radio.Integer(card))
+		return
+	}
+
+	regs := make([]uint8, hllRegisters)
+	for _, key := range c.args {
+		e, val, ok := c.lookupHLL(key)
+		if !ok {
+			return
+		} else if e == nil {
+			continue
+		}
+
+		if !hllMerge(val, regs) {
+			c.reply(errCorruptHLL)
+			return
+		}
+	}
+
+	c.reply(radio.Integer(hllCount(regs)))
+}
+
+func pfmergeCommand(c *call) {
+	dst := c.args[0]
+	regs := make([]uint8, hllRegisters)
+
+	// the destination is merged too and stays in the sparse encoding unless
+	// one of the inputs is dense.
+	sparse := true
+	var dstEntry *entry
+	for i, key := range c.args {
+		e, val, ok := c.lookupHLL(key)
+		if !ok {
+			return
+		} else if e == nil {
+			continue
+		}
+
+		if i == 0 {
+			dstEntry = e
+		}
+		if val[4] == hllDense {
+			sparse = false
+		}
+		if !hllMerge(val, regs) {
+			c.reply(errCorruptHLL)
+			return
+		}
+	}
+
+	merged := hllEncode(regs, sparse)
+	if dstEntry == nil {
+		c.add(dst, merged)
+	} else {
+		dstEntry.val = merged
+	}
+
+	c.touch(dst)
+	c.notify(keyspace.String, "pfadd", dst)
+	c.reply(replyOK)
+}
@@ -0,0 +1,130 @@
+package store_test
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/spy16/radio"
+)
+
+func TestHyperLogLogs(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "PFADD",
+			steps: []step{
+				{cmd: "PFADD h foo bar zap", reply: radio.Integer(1)},
+				{cmd: "PFADD h zap zap zap", reply: radio.Integer(0)},
+				{cmd: "PFADD h foo bar", reply: radio.Integer(0)},
+				{cmd: "PFCOUNT h", reply: radio.Integer(3)},
+				{cmd: "PFADD o 1 2 3", reply: radio.Integer(1)},
+				{cmd: "PFCOUNT h o", reply: radio.Integer(6)},
+				{cmd: "PFCOUNT x", reply: radio.Integer(0)},
+				{cmd: "PFADD e", reply: radio.Integer(1)},
+				{cmd: "PFADD e", reply: radio.Integer(0)},
+				{cmd: "GET e", reply: bulk("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff")},
+				{cmd: "PFCOUNT e", reply: radio.Integer(0)},
+				{cmd: "TYPE e", reply: radio.SimpleStr("string")},
+			},
+		},
+		{
+			title: "PFMERGE",
+			steps: []step{
+				{cmd: "PFADD a 1 2 3 4", reply: radio.Integer(1)},
+				{cmd: "PFADD b 3 4 5 6", reply: radio.Integer(1)},
+				{cmd: "PFMERGE c a b x", reply: okReply},
+				{cmd: "PFCOUNT c", reply: radio.Integer(6)},
+				{cmd: "PFMERGE a b", reply: okReply},
+				{cmd: "PFCOUNT a", reply: radio.Integer(6)},
+				{cmd: "PFMERGE d", reply: okReply},
+				{cmd: "PFCOUNT d", reply: radio.Integer(0)},
+			},
+		},
+		{
+			title: "Invalid",
+			steps: []step{
+				{cmd: "SET s foo", reply: okReply},
+				{cmd: "PFADD s a", reply: radio.ErrorStr("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+				{cmd: "PFCOUNT s", reply: radio.ErrorStr("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+				{cmd: "PFMERGE d s", reply: radio.ErrorStr("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+				{cmd: "SET s HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", reply: okReply},
+				{cmd: "PFCOUNT s", reply: radio.ErrorStr("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+				{cmd: "SET s HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xff\x01", reply: okReply},
+				{cmd: "PFCOUNT s", reply: radio.ErrorStr("INVALIDOBJ Corrupted HLL object detected")},
+				{cmd: "PFADD s a", reply: radio.ErrorStr("INVALIDOBJ Corrupted HLL object detected")},
+				{cmd: "LPUSH l a", reply: radio.Integer(1)},
+				{cmd: "PFADD l a", reply: wrongType},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(nil)
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestHyperLogLogs_Encoding(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	encoding := func(key string) byte {
+		v := s.Do(0, "GET", key).(*radio.BulkStr)
+		return v.Value[4]
+	}
+
+	args := []string{"h"}
+	for i := 0; i < 100000; i++ {
+		args = append(args, strconv.Itoa(i))
+		if len(args) <= 100 {
+			continue
+		}
+
+		s.Do(0, "PFADD", args...)
+		args = args[:1]
+
+		if i == 99 && encoding("h") != 1 {
+			t.Errorf("expecting sparse encoding for 100 elements, got %d", encoding("h"))
+		}
+	}
+
+	if enc := encoding("h"); enc != 0 {
+		t.Errorf("expecting dense encoding, got %d", enc)
+	}
+
+	v := s.Do(0, "GET", "h").(*radio.BulkStr)
+	if len(v.Value) != 12304 {
+		t.Errorf("expecting dense value of 12304 bytes, got %d", len(v.Value))
+	}
+
+	card := s.Do(0, "PFCOUNT", "h").(radio.Integer)
+	if err := math.Abs(float64(card)-100000) / 100000; err > 0.02 {
+		t.Errorf("expecting cardinality close to 100000, got %d", card)
+	}
+
+	// the cached cardinality is served until the next update and the value
+	// can be restored with SET.
+	s.Do(0, "SET", "copy", string(s.Do(0, "GET", "h").(*radio.BulkStr).Value))
+	if v := s.Do(0, "PFCOUNT", "copy"); v != card {
+		t.Errorf("expecting '%d', got '%v'", card, v)
+	}
+
+	// merging a sparse and a dense HyperLogLog results in a dense one.
+	s.Do(0, "PFADD", "sparse", "a", "b", "c")
+	s.Do(0, "PFMERGE", "sparse", "h")
+	if enc := encoding("sparse"); enc != 0 {
+		t.Errorf("expecting dense encoding, got %d", enc)
+	}
+	if v := s.Do(0, "PFCOUNT", "sparse", "h"); v != s.Do(0, "PFCOUNT", "sparse") {
+		t.Errorf("expecting PFCOUNT of the union to match, got '%v'", v)
+	}
+}
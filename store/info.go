@@ -0,0 +1,60 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spy16/radio"
+)
+
+// RegisterInfo registers the memory and keyspace sections of INFO. The
+// keyspace section lists the databases with keys along with the number of
+// keys with expiry and their average TTL in milliseconds, which is
+// estimated from a sample of the keys like Redis.
+func (s *Store) RegisterInfo(info *radio.Info) {
+	info.Register("memory", true, func(w *radio.InfoWriter) {
+		s.mu.Lock()
+		used, opts := s.usedMemory(), s.opts
+		s.mu.Unlock()
+
+		w.Field("used_memory", strconv.FormatInt(used, 10))
+		w.Field("maxmemory", strconv.FormatInt(opts.MaxMemory, 10))
+		w.Field("maxmemory_policy", string(opts.MaxMemoryPolicy))
+	})
+
+	info.Register("keyspace", true, func(w *radio.InfoWriter) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		now := s.now()
+		for _, d := range s.dbs {
+			if len(d.keys) == 0 {
+				continue
+			}
+
+			w.Field("db"+strconv.Itoa(d.id), fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", len(d.keys), len(d.expires), d.avgTTL(now)))
+		}
+	})
+}
+
+// avgTTL returns the average TTL in milliseconds of a sample of the keys
+// with expiry or zero if there are no such keys.
+func (d *db) avgTTL(now int64) int64 {
+	// map iteration starts at a random position.
+	sampled, total := int64(0), int64(0)
+	for _, e := range d.expires {
+		if sampled >= expireSamples {
+			break
+		}
+		sampled++
+
+		if ttl := e.expireAt - now; ttl > 0 {
+			total += ttl
+		}
+	}
+
+	if sampled == 0 {
+		return 0
+	}
+	return total / sampled
+}
@@ -0,0 +1,30 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/store"
+)
+
+func TestStore_RegisterInfo(t *testing.T) {
+	clock := newClock()
+	s := store.New(store.Options{ExpiryInterval: -1, Now: clock.Now, MaxMemory: 1 << 20, MaxMemoryPolicy: store.AllKeysLRU})
+	defer s.Close()
+
+	info := &radio.Info{}
+	s.RegisterInfo(info)
+
+	runSteps(t, s, []step{
+		{cmd: "SET a 1", reply: okReply},
+		{cmd: "SET b 1 PX 1000", reply: okReply},
+		{cmd: "SET c 1 PX 3000", reply: okReply},
+	})
+	s.Do(2, "SET", "d", "1")
+
+	expected := "# Memory\r\nused_memory:488\r\nmaxmemory:1048576\r\nmaxmemory_policy:allkeys-lru\r\n" +
+		"\r\n# Keyspace\r\ndb0:keys=3,expires=2,avg_ttl=2000\r\ndb2:keys=1,expires=0,avg_ttl=0\r\n"
+	if got := info.Build(); got != expected {
+		t.Errorf("expecting '%q', got '%q'", expected, got)
+	}
+}
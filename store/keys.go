@@ -0,0 +1,420 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+func init() {
+	register("del", -2, flagWrite, delCommand)
+	register("unlink", -2, flagWrite, delCommand)
+	register("exists", -2, flagReadOnly, existsCommand)
+	register("touch", -2, flagReadOnly, existsCommand)
+	register("type", 2, flagReadOnly, typeCommand)
+	register("rename", 3, flagWrite, renameCommand)
+	register("renamenx", 3, flagWrite, renameCommand)
+	register("expire", -3, flagWrite, expireCommand)
+	register("pexpire", -3, flagWrite, expireCommand)
+	register("expireat", -3, flagWrite, expireCommand)
+	register("pexpireat", -3, flagWrite, expireCommand)
+	register("ttl", 2, flagReadOnly, ttlCommand)
+	register("pttl", 2, flagReadOnly, ttlCommand)
+	register("expiretime", 2, flagReadOnly, ttlCommand)
+	register("pexpiretime", 2, flagReadOnly, ttlCommand)
+	register("persist", 2, flagWrite, persistCommand)
+	register("scan", -2, flagReadOnly, scanCommand)
+	register("keys", 2, flagReadOnly, keysCommand)
+	register("randomkey", 1, flagReadOnly, randomkeyCommand)
+	register("dbsize", 1, flagReadOnly, dbsizeCommand)
+	register("flushdb", -1, flagWrite, flushCommand)
+	register("flushall", -1, flagWrite, flushCommand)
+	register("object", -2, flagReadOnly, objectCommand)
+}
+
+func delCommand(c *call) {
+	deleted := 0
+	for _, key := range c.args {
+		if c.delete(key) {
+			c.notify(keyspace.Generic, "del", key)
+			deleted++
+		}
+	}
+	c.reply(radio.Integer(deleted))
+}
+
+func existsCommand(c *call) {
+	// unlike EXISTS, TOUCH records the access to the keys.
+	lookup := c.peek
+	if c.name == "touch" {
+		lookup = c.lookup
+	}
+
+	n := 0
+	for _, key := range c.args {
+		if lookup(key) != nil {
+			n++
+		}
+	}
+	c.reply(radio.Integer(n))
+}
+
+func typeCommand(c *call) {
+	e := c.peek(c.args[0])
+	if e == nil {
+		c.reply(radio.SimpleStr("none"))
+		return
+	}
+	c.reply(radio.SimpleStr(typeOf(e.val)))
+}
+
+func renameCommand(c *call) {
+	src, dst := c.args[0], c.args[1]
+	nx := c.name == "renamenx"
+
+	e := c.lookup(src)
+	if e == nil {
+		c.reply(errNoSuchKey)
+		return
+	}
+
+	if src == dst {
+		if nx {
+			c.reply(radio.Integer(0))
+		} else {
+			c.reply(replyOK)
+		}
+		return
+	}
+
+	if c.lookup(dst) != nil {
+		if nx {
+			c.reply(radio.Integer(0))
+			return
+		}
+		c.db.delete(dst)
+	}
+
+	c.db.delete(src)
+	renamed := c.db.add(dst, e.val)
+	c.db.setExpire(renamed, e.expireAt)
+	renamed.accessed, renamed.counter, renamed.decayedAt = e.accessed, e.counter, e.decayedAt
+	if _, ok := e.val.(*hash); ok {
+		c.db.watchFields(renamed)
+	}
+
+	c.touch(src)
+	c.touch(dst)
+	c.signal(dst)
+	c.notify(keyspace.Generic, "rename_from", src)
+	c.notify(keyspace.Generic, "rename_to", dst)
+
+	if nx {
+		c.reply(radio.Integer(1))
+	} else {
+		c.reply(replyOK)
+	}
+}
+
+func expireCommand(c *call) {
+	key := c.args[0]
+	when, ok := parseInt(c.args[1])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	var nx, xx, gt, lt bool
+	for _, opt := range c.args[2:] {
+		switch strings.ToLower(opt) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			c.reply(radio.ErrorStr("ERR Unsupported option " + opt))
+			return
+		}
+	}
+
+	if nx && (xx || gt || lt) {
+		c.reply(radio.ErrorStr("ERR NX and XX, GT or LT options at the same time are not compatible"))
+		return
+	}
+	if gt && lt {
+		c.reply(radio.ErrorStr("ERR GT and LT options at the same time are not compatible"))
+		return
+	}
+
+	invalid := radio.ErrorStr("ERR invalid expire time in '" + c.name + "' command")
+	if c.name == "expire" || c.name == "expireat" {
+		if when > math.MaxInt64/1000 || when < math.MinInt64/1000 {
+			c.reply(invalid)
+			return
+		}
+		when *= 1000
+	}
+
+	if c.name == "expire" || c.name == "pexpire" {
+		if when > math.MaxInt64-c.now {
+			c.reply(invalid)
+			return
+		}
+		when += c.now
+	}
+
+	e := c.lookup(key)
+	if e == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	// a key without expiry has an infinite ttl.
+	cur := e.expireAt
+	if (nx && cur != 0) || (xx && cur == 0) || (gt && (cur == 0 || when <= cur)) || (lt && cur != 0 && when >= cur) {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	if when <= c.now {
+		c.delete(key)
+		c.notify(keyspace.Generic, "del", key)
+		c.reply(radio.Integer(1))
+		return
+	}
+
+	c.db.setExpire(e, when)
+	c.touch(key)
+	c.notify(keyspace.Generic, "expire", key)
+	c.reply(radio.Integer(1))
+}
+
+func ttlCommand(c *call) {
+	e := c.peek(c.args[0])
+	if e == nil {
+		c.reply(radio.Integer(-2))
+		return
+	}
+
+	if e.expireAt == 0 {
+		c.reply(radio.Integer(-1))
+		return
+	}
+
+	switch c.name {
+	case "ttl":
+		c.reply(radio.Integer((e.expireAt - c.now + 500) / 1000))
+	case "pttl":
+		c.reply(radio.Integer(e.expireAt - c.now))
+	case "expiretime":
+		c.reply(radio.Integer(e.expireAt / 1000))
+	case "pexpiretime":
+		c.reply(radio.Integer(e.expireAt))
+	}
+}
+
+func persistCommand(c *call) {
+	e := c.lookup(c.args[0])
+	if e == nil || e.expireAt == 0 {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	c.db.setExpire(e, 0)
+	c.touch(c.args[0])
+	c.notify(keyspace.Generic, "persist", c.args[0])
+	c.reply(radio.Integer(1))
+}
+
+// scanOptions are the cursor and the options of the SCAN family of
+// commands.
+type scanOptions struct {
+	cursor   uint64
+	pattern  string
+	count    int64
+	typ      string
+	noValues bool
+}
+
+// parseScan parses the cursor and the options of the SCAN family of
+// commands. TYPE is only accepted by SCAN and NOVALUES by HSCAN.
+func (c *call) parseScan(args []string) (opts scanOptions, ok bool) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		c.reply(radio.ErrorStr("ERR invalid cursor"))
+		return opts, false
+	}
+
+	opts.cursor = cursor
+	opts.count = 10
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "novalues" && c.name == "hscan" {
+			opts.noValues = true
+			continue
+		}
+
+		if i+1 >= len(args) || (opt == "type" && c.name != "scan") {
+			c.reply(errSyntax)
+			return opts, false
+		}
+
+		switch opt {
+		case "match":
+			opts.pattern = args[i+1]
+
+		case "count":
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				c.reply(errNotInteger)
+				return opts, false
+			}
+			if n < 1 {
+				c.reply(errSyntax)
+				return opts, false
+			}
+			opts.count = n
+
+		case "type":
+			opts.typ = strings.ToLower(args[i+1])
+
+		default:
+			c.reply(errSyntax)
+			return opts, false
+		}
+		i++
+	}
+
+	return opts, true
+}
+
+func scanCommand(c *call) {
+	opts, ok := c.parseScan(c.args)
+	if !ok {
+		return
+	}
+
+	var keys []string
+	var expired []*entry
+	visited := int64(0)
+	next := c.db.scan(opts.cursor, func(e *entry) bool {
+		visited++
+		switch {
+		case e.expireAt != 0 && e.expireAt <= c.now:
+			expired = append(expired, e)
+		case opts.pattern != "" && !radio.MatchPattern(opts.pattern, e.key):
+		case opts.typ != "" && typeOf(e.val) != opts.typ:
+		default:
+			keys = append(keys, e.key)
+		}
+		return visited < opts.count
+	})
+
+	for _, e := range expired {
+		c.expired(c.db, e)
+	}
+
+	c.reply(&radio.Array{Items: []radio.Value{
+		bulkStr(strconv.FormatUint(next, 10)),
+		bulkArray(keys),
+	}})
+}
+
+func keysCommand(c *call) {
+	keys := []string{}
+	c.db.scan(0, func(e *entry) bool {
+		if (e.expireAt == 0 || e.expireAt > c.now) && radio.MatchPattern(c.args[0], e.key) {
+			keys = append(keys, e.key)
+		}
+		return true
+	})
+	c.reply(bulkArray(keys))
+}
+
+func randomkeyCommand(c *call) {
+	for tries := 0; tries < 100 && len(c.db.keys) > 0; tries++ {
+		// map iteration starts at a random position.
+		var e *entry
+		for _, e = range c.db.keys {
+			break
+		}
+
+		if c.lookup(e.key) != nil {
+			c.reply(bulkStr(e.key))
+			return
+		}
+	}
+
+	c.reply(nullBulk)
+}
+
+func dbsizeCommand(c *call) {
+	c.reply(radio.Integer(len(c.db.keys)))
+}
+
+func flushCommand(c *call) {
+	if len(c.args) > 1 {
+		c.reply(errSyntax)
+		return
+	}
+
+	if len(c.args) == 1 {
+		if mode := strings.ToLower(c.args[0]); mode != "sync" && mode != "async" {
+			c.reply(errSyntax)
+			return
+		}
+	}
+
+	if c.name == "flushall" {
+		for _, d := range c.s.dbs {
+			d.flush()
+		}
+	} else {
+		c.db.flush()
+	}
+
+	c.flushed = true
+	c.reply(replyOK)
+}
+
+func objectCommand(c *call) {
+	sub := strings.ToLower(c.args[0])
+	switch sub {
+	case "encoding", "freq", "idletime":
+	default:
+		c.reply(radio.ErrorStr("ERR unknown subcommand '" + c.args[0] + "'. Try OBJECT HELP."))
+		return
+	}
+
+	if len(c.args) != 2 {
+		c.reply(errArity("object|" + sub))
+		return
+	}
+
+	e := c.peek(c.args[1])
+	if e == nil {
+		c.reply(nullBulk)
+		return
+	}
+
+	lfu := c.s.opts.MaxMemoryPolicy.lfu()
+	switch {
+	case sub == "encoding":
+		c.reply(bulkStr(encodingOf(e.val)))
+	case sub == "freq" && !lfu:
+		c.reply(radio.ErrorStr("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."))
+	case sub == "freq":
+		c.reply(radio.Integer(e.freq(c.now)))
+	case lfu:
+		c.reply(radio.ErrorStr("ERR An LRU maxmemory policy is not selected, access time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."))
+	default:
+		c.reply(radio.Integer((c.now - e.accessed) / 1000))
+	}
+}
@@ -0,0 +1,175 @@
+package store_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+func TestKeys(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "Generic",
+			steps: []step{
+				{cmd: "MSET a 1 b 2 c 3", reply: okReply},
+				{cmd: "EXISTS a b x a", reply: radio.Integer(3)},
+				{cmd: "TYPE a", reply: radio.SimpleStr("string")},
+				{cmd: "TYPE x", reply: radio.SimpleStr("none")},
+				{cmd: "DEL a x b", reply: radio.Integer(2)},
+				{cmd: "DBSIZE", reply: radio.Integer(1)},
+				{cmd: "RANDOMKEY", reply: bulk("c")},
+				{cmd: "UNLINK c", reply: radio.Integer(1)},
+				{cmd: "RANDOMKEY", reply: nullBulk},
+				{cmd: "MSET a 1 b 2", reply: okReply},
+				{cmd: "FLUSHDB ASYNC", reply: okReply},
+				{cmd: "DBSIZE", reply: radio.Integer(0)},
+				{cmd: "FLUSHALL NOW", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "RENAME",
+			steps: []step{
+				{cmd: "RENAME a b", reply: radio.ErrorStr("ERR no such key")},
+				{cmd: "SET a 1 EX 100", reply: okReply},
+				{cmd: "SET b 2", reply: okReply},
+				{cmd: "RENAMENX a b", reply: radio.Integer(0)},
+				{cmd: "RENAME a a", reply: okReply},
+				{cmd: "RENAME a b", reply: okReply},
+				{cmd: "GET b", reply: bulk("1")},
+				{cmd: "TTL b", reply: radio.Integer(100)},
+				{cmd: "EXISTS a", reply: radio.Integer(0)},
+				{cmd: "RENAMENX b c", reply: radio.Integer(1)},
+			},
+		},
+		{
+			title: "EXPIRE",
+			steps: []step{
+				{cmd: "EXPIRE a 10", reply: radio.Integer(0)},
+				{cmd: "SET a 1", reply: okReply},
+				{cmd: "TTL x", reply: radio.Integer(-2)},
+				{cmd: "TTL a", reply: radio.Integer(-1)},
+				{cmd: "EXPIRE a 10 XX", reply: radio.Integer(0)},
+				{cmd: "EXPIRE a 10 GT", reply: radio.Integer(0)},
+				{cmd: "EXPIRE a 10 LT", reply: radio.Integer(1)},
+				{cmd: "EXPIRE a 20 NX", reply: radio.Integer(0)},
+				{cmd: "EXPIRE a 20 GT", reply: radio.Integer(1)},
+				{cmd: "PEXPIRE a 5000 GT", reply: radio.Integer(0)},
+				{cmd: "PEXPIRE a 5000 LT", reply: radio.Integer(1)},
+				{cmd: "PTTL a", reply: radio.Integer(5000)},
+				{cmd: "EXPIREAT a 1000100", reply: radio.Integer(1)},
+				{cmd: "EXPIRETIME a", reply: radio.Integer(1000100)},
+				{cmd: "PEXPIREAT a 1000100500", reply: radio.Integer(1)},
+				{cmd: "PEXPIRETIME a", reply: radio.Integer(1000100500)},
+				{cmd: "EXPIRE a 10 NX XX", reply: radio.ErrorStr("ERR NX and XX, GT or LT options at the same time are not compatible")},
+				{cmd: "EXPIRE a 10 GT LT", reply: radio.ErrorStr("ERR GT and LT options at the same time are not compatible")},
+				{cmd: "EXPIRE a 10 FOO", reply: radio.ErrorStr("ERR Unsupported option FOO")},
+				{cmd: "EXPIRE a 9223372036854775807", reply: radio.ErrorStr("ERR invalid expire time in 'expire' command")},
+				{cmd: "PERSIST a", reply: radio.Integer(1)},
+				{cmd: "PERSIST a", reply: radio.Integer(0)},
+				{cmd: "EXPIRETIME a", reply: radio.Integer(-1)},
+				{cmd: "EXPIRE a -1", reply: radio.Integer(1)},
+				{cmd: "EXISTS a", reply: radio.Integer(0)},
+			},
+		},
+		{
+			title: "SCAN",
+			steps: []step{
+				{cmd: "MSET a1 1 a2 2 b1 3", reply: okReply},
+				{cmd: "SCAN 0", reply: scanReply("0", "a1", "a2", "b1")},
+				{cmd: "SCAN 0 COUNT 2", reply: scanReply("3", "a1", "a2")},
+				{cmd: "SCAN 3 COUNT 2", reply: scanReply("0", "b1")},
+				{cmd: "SCAN 0 MATCH a*", reply: scanReply("0", "a1", "a2")},
+				{cmd: "SCAN 0 TYPE string MATCH *1", reply: scanReply("0", "a1", "b1")},
+				{cmd: "SCAN 0 TYPE hash", reply: scanReply("0")},
+				{cmd: "SCAN abc", reply: radio.ErrorStr("ERR invalid cursor")},
+				{cmd: "SCAN 0 COUNT 0", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "SCAN 0 COUNT", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "KEYS *1", reply: bulks("a1", "b1")},
+				{cmd: "KEYS x*", reply: bulks()},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(newClock())
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestKeys_ScanGuarantees(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	for i := 0; i < 1000; i++ {
+		s.Do(0, "SET", "key:"+strconv.Itoa(i), "v")
+	}
+
+	seen, deleted := map[string]bool{}, map[string]bool{}
+	cursor := "0"
+	for round := 0; ; round++ {
+		reply := s.Do(0, "SCAN", cursor, "COUNT", "50").(*radio.Array)
+		cursor = reply.Items[0].(*radio.BulkStr).String()
+		for _, key := range reply.Items[1].(*radio.Array).Items {
+			seen[key.(*radio.BulkStr).String()] = true
+		}
+
+		// keys deleted and added during the iteration must not cause the
+		// other keys to be missed.
+		victim := "key:" + strconv.Itoa(999-round)
+		deleted[victim] = true
+		s.Do(0, "DEL", victim)
+		s.Do(0, "SET", "new:"+strconv.Itoa(round), "v")
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	var missed []string
+	for i := 0; i < 1000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if !seen[key] && !deleted[key] {
+			missed = append(missed, key)
+		}
+	}
+	if len(missed) > 0 {
+		t.Errorf("expecting all the keys existing for the whole iteration to be returned, missed %v", missed)
+	}
+}
+
+func TestKeys_LazyExpiry(t *testing.T) {
+	clock := newClock()
+	s := newStore(clock)
+	defer s.Close()
+
+	runSteps(t, s, []step{
+		{cmd: "SET a 1 PX 100", reply: okReply},
+		{cmd: "SET b 1", reply: okReply},
+		{cmd: "DBSIZE", reply: radio.Integer(2)},
+	})
+
+	clock.Advance(100 * time.Millisecond)
+	runSteps(t, s, []step{
+		// expired keys are counted until they are removed.
+		{cmd: "DBSIZE", reply: radio.Integer(2)},
+		{cmd: "KEYS *", reply: bulks("b")},
+		{cmd: "SCAN 0", reply: scanReply("0", "b")},
+		{cmd: "DBSIZE", reply: radio.Integer(1)},
+		{cmd: "TTL a", reply: radio.Integer(-2)},
+	})
+}
+
+func scanReply(cursor string, keys ...string) *radio.Array {
+	return &radio.Array{Items: []radio.Value{bulk(cursor), bulks(keys...)}}
+}
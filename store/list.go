@@ -0,0 +1,164 @@
+package store
+
+// listMaxListpackSize is the size in bytes up to which lists use the
+// listpack encoding (list-max-listpack-size -2).
+const listMaxListpackSize = 8 * 1024
+
+// list is a list value kept in a ring buffer so that pushing and popping at
+// both ends takes constant time. Small lists (listpack encoding) are kept
+// compact: the buffer is shrunk to fit when a large list (quicklist
+// encoding) shrinks to half of the listpack size.
+type list struct {
+	buf  []string
+	head int
+	n    int
+
+	// size is the approximate size of the elements in bytes.
+	size  int
+	quick bool
+}
+
+func (l *list) encoding() string {
+	if l.quick {
+		return "quicklist"
+	}
+	return "listpack"
+}
+
+func (l *list) len() int {
+	return l.n
+}
+
+// at returns the element at the index which must be in [0, len).
+func (l *list) at(i int) string {
+	return l.buf[(l.head+i)%len(l.buf)]
+}
+
+// setAt sets the element at the index which must be in [0, len).
+func (l *list) setAt(i int, val string) {
+	j := (l.head + i) % len(l.buf)
+	l.size += elemSize(val) - elemSize(l.buf[j])
+	l.buf[j] = val
+	l.update()
+}
+
+func (l *list) pushFront(val string) {
+	l.grow()
+	l.head = (l.head - 1 + len(l.buf)) % len(l.buf)
+	l.buf[l.head] = val
+	l.n++
+	l.size += elemSize(val)
+	l.update()
+}
+
+func (l *list) pushBack(val string) {
+	l.grow()
+	l.buf[(l.head+l.n)%len(l.buf)] = val
+	l.n++
+	l.size += elemSize(val)
+	l.update()
+}
+
+// popFront removes and returns the first element. The list must not be
+// empty.
+func (l *list) popFront() string {
+	val := l.buf[l.head]
+	l.buf[l.head] = ""
+	l.head = (l.head + 1) % len(l.buf)
+	l.n--
+	l.size -= elemSize(val)
+	l.update()
+	return val
+}
+
+// popBack removes and returns the last element. The list must not be
+// empty.
+func (l *list) popBack() string {
+	j := (l.head + l.n - 1) % len(l.buf)
+	val := l.buf[j]
+	l.buf[j] = ""
+	l.n--
+	l.size -= elemSize(val)
+	l.update()
+	return val
+}
+
+// slice returns a copy of the elements in [start, end).
+func (l *list) slice(start, end int) []string {
+	items := make([]string, 0, end-start)
+	for i := start; i < end; i++ {
+		items = append(items, l.at(i))
+	}
+	return items
+}
+
+// replace replaces all the elements of the list.
+func (l *list) replace(items []string) {
+	l.buf = items
+	l.head = 0
+	l.n = len(items)
+
+	l.size = 0
+	for _, item := range items {
+		l.size += elemSize(item)
+	}
+	l.update()
+}
+
+func (l *list) grow() {
+	if l.n < len(l.buf) {
+		return
+	}
+
+	size := 2 * len(l.buf)
+	if size == 0 {
+		size = 4
+	}
+	l.resize(size)
+}
+
+func (l *list) resize(size int) {
+	buf := make([]string, size)
+	for i := 0; i < l.n; i++ {
+		buf[i] = l.at(i)
+	}
+
+	l.buf = buf
+	l.head = 0
+}
+
+// update converts the encoding of the list after a change of its size.
+func (l *list) update() {
+	switch {
+	case !l.quick && l.size > listMaxListpackSize:
+		l.quick = true
+
+	case l.quick && l.size <= listMaxListpackSize/2:
+		l.quick = false
+		l.resize(l.n)
+	}
+}
+
+// elemSize returns the approximate size of the element in a listpack.
+func elemSize(val string) int {
+	return len(val) + 2
+}
+
+// push adds the element at the front of the list if left is set or at the
+// back otherwise.
+func (l *list) push(left bool, val string) {
+	if left {
+		l.pushFront(val)
+	} else {
+		l.pushBack(val)
+	}
+}
+
+// pop removes the element at the front of the list if left is set or at
+// the back otherwise. The list must not be empty.
+func (l *list) pop(left bool) string {
+	if left {
+		return l.popFront()
+	}
+	return l.popBack()
+}
@@ -0,0 +1,612 @@
+package store
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+func init() {
+	register("lpush", -3, flagWrite|flagDenyOOM, pushCommand)
+	register("rpush", -3, flagWrite|flagDenyOOM, pushCommand)
+	register("lpushx", -3, flagWrite|flagDenyOOM, pushCommand)
+	register("rpushx", -3, flagWrite|flagDenyOOM, pushCommand)
+	register("lpop", -2, flagWrite, popCommand)
+	register("rpop", -2, flagWrite, popCommand)
+	register("llen", 2, flagReadOnly, llenCommand)
+	register("lindex", 3, flagReadOnly, lindexCommand)
+	register("lset", 4, flagWrite|flagDenyOOM, lsetCommand)
+	register("lrange", 4, flagReadOnly, lrangeCommand)
+	register("ltrim", 4, flagWrite, ltrimCommand)
+	register("lrem", 4, flagWrite, lremCommand)
+	register("linsert", 5, flagWrite|flagDenyOOM, linsertCommand)
+	register("lpos", -3, flagReadOnly, lposCommand)
+	register("lmove", 5, flagWrite|flagDenyOOM, lmoveCommand)
+	register("rpoplpush", 3, flagWrite|flagDenyOOM, lmoveCommand)
+	register("blpop", -3, flagWrite, blpopCommand)
+	register("brpop", -3, flagWrite, blpopCommand)
+	register("blmove", 6, flagWrite|flagDenyOOM, lmoveCommand)
+	register("brpoplpush", 4, flagWrite|flagDenyOOM, lmoveCommand)
+}
+
+// lookupList returns the list of the key. ok is false if the key holds
+// another type in which case the error is already written.
+func (c *call) lookupList(key string) (e *entry, l *list, ok bool) {
+	e, ok = c.lookupType(key, "list")
+	if e != nil {
+		l = e.val.(*list)
+	}
+	return e, l, ok
+}
+
+// listRange normalizes the start and end indexes (inclusive, negative
+// indexes are relative to the end) and returns the range [from, to) of the
+// list of length n.
+func listRange(start, end int64, n int) (from, to int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if end < 0 {
+		end += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	if start > end || start >= int64(n) {
+		return 0, 0
+	}
+	if end >= int64(n) {
+		end = int64(n) - 1
+	}
+	return int(start), int(end) + 1
+}
+
+func pushCommand(c *call) {
+	key := c.args[0]
+	_, l, ok := c.lookupList(key)
+	if !ok {
+		return
+	}
+
+	left := c.name[0] == 'l'
+	if l == nil {
+		if strings.HasSuffix(c.name, "x") {
+			c.reply(radio.Integer(0))
+			return
+		}
+
+		l = &list{}
+		c.add(key, l)
+	}
+
+	for _, val := range c.args[1:] {
+		l.push(left, val)
+	}
+
+	c.touch(key)
+	c.signal(key)
+	if left {
+		c.notify(keyspace.List, "lpush", key)
+	} else {
+		c.notify(keyspace.List, "rpush", key)
+	}
+	c.reply(radio.Integer(l.len()))
+}
+
+func popCommand(c *call) {
+	if len(c.args) > 2 {
+		c.reply(errSyntax)
+		return
+	}
+
+	count := int64(-1)
+	if len(c.args) == 2 {
+		n, ok := parseInt(c.args[1])
+		if !ok || n < 0 {
+			c.reply(radio.ErrorStr("ERR value is out of range, must be positive"))
+			return
+		}
+		count = n
+	}
+
+	key := c.args[0]
+	_, l, ok := c.lookupList(key)
+	if !ok {
+		return
+	}
+
+	if l == nil {
+		if count >= 0 {
+			c.reply(&radio.Array{})
+		} else {
+			c.reply(nullBulk)
+		}
+		return
+	}
+
+	if count == 0 {
+		c.reply(&radio.Array{Items: []radio.Value{}})
+		return
+	}
+
+	n := count
+	if count < 0 {
+		n = 1
+	}
+
+	var vals []string
+	for i := int64(0); i < n && l.len() > 0; i++ {
+		vals = append(vals, l.pop(c.name == "lpop"))
+	}
+
+	c.touch(key)
+	c.notify(keyspace.List, c.name, key)
+	c.removeIfEmpty(key, l.len())
+
+	if count < 0 {
+		c.reply(bulkStr(vals[0]))
+	} else {
+		c.reply(bulkArray(vals))
+	}
+}
+
+func llenCommand(c *call) {
+	_, l, ok := c.lookupList(c.args[0])
+	if !ok {
+		return
+	}
+
+	if l == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+	c.reply(radio.Integer(l.len()))
+}
+
+func lindexCommand(c *call) {
+	index, ok := parseInt(c.args[1])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	_, l, ok := c.lookupList(c.args[0])
+	if !ok {
+		return
+	}
+
+	if l == nil {
+		c.reply(nullBulk)
+		return
+	}
+
+	if index < 0 {
+		index += int64(l.len())
+	}
+	if index < 0 || index >= int64(l.len()) {
+		c.reply(nullBulk)
+		return
+	}
+	c.reply(bulkStr(l.at(int(index))))
+}
+
+func lsetCommand(c *call) {
+	index, ok := parseInt(c.args[1])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	key := c.args[0]
+	_, l, ok := c.lookupList(key)
+	if !ok {
+		return
+	}
+
+	if l == nil {
+		c.reply(errNoSuchKey)
+		return
+	}
+
+	if index < 0 {
+		index += int64(l.len())
+	}
+	if index < 0 || index >= int64(l.len()) {
+		c.reply(radio.ErrorStr("ERR index out of range"))
+		return
+	}
+
+	l.setAt(int(index), c.args[2])
+	c.touch(key)
+	c.notify(keyspace.List, "lset", key)
+	c.reply(replyOK)
+}
+
+func lrangeCommand(c *call) {
+	start, ok1 := parseInt(c.args[1])
+	end, ok2 := parseInt(c.args[2])
+	if !ok1 || !ok2 {
+		c.reply(errNotInteger)
+		return
+	}
+
+	_, l, ok := c.lookupList(c.args[0])
+	if !ok {
+		return
+	}
+
+	if l == nil {
+		c.reply(bulkArray(nil))
+		return
+	}
+
+	from, to := listRange(start, end, l.len())
+	c.reply(bulkArray(l.slice(from, to)))
+}
+
+func ltrimCommand(c *call) {
+	start, ok1 := parseInt(c.args[1])
+	end, ok2 := parseInt(c.args[2])
+	if !ok1 || !ok2 {
+		c.reply(errNotInteger)
+		return
+	}
+
+	key := c.args[0]
+	_, l, ok := c.lookupList(key)
+	if !ok {
+		return
+	}
+
+	if l == nil {
+		c.reply(replyOK)
+		return
+	}
+
+	from, to := listRange(start, end, l.len())
+	if from > 0 || to < l.len() {
+		l.replace(l.slice(from, to))
+	}
+
+	c.touch(key)
+	c.notify(keyspace.List, "ltrim", key)
+	c.removeIfEmpty(key, l.len())
+	c.reply(replyOK)
+}
+
+func lremCommand(c *call) {
+	count, ok := parseInt(c.args[1])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	key, val := c.args[0], c.args[2]
+	_, l, ok := c.lookupList(key)
+	if !ok {
+		return
+	}
+
+	if l == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	limit := count
+	if count < 0 {
+		limit = -count
+	}
+
+	items := l.slice(0, l.len())
+	keep := make([]bool, len(items))
+	removed := int64(0)
+	for i := range items {
+		j := i
+		if count < 0 {
+			j = len(items) - 1 - i
+		}
+
+		if items[j] == val && (limit == 0 || removed < limit) {
+			removed++
+			continue
+		}
+		keep[j] = true
+	}
+
+	if removed > 0 {
+		kept := make([]string, 0, len(items)-int(removed))
+		for i, item := range items {
+			if keep[i] {
+				kept = append(kept, item)
+			}
+		}
+		l.replace(kept)
+
+		c.touch(key)
+		c.notify(keyspace.List, "lrem", key)
+		c.removeIfEmpty(key, l.len())
+	}
+	c.reply(radio.Integer(removed))
+}
+
+func linsertCommand(c *call) {
+	where := strings.ToLower(c.args[1])
+	if where != "before" && where != "after" {
+		c.reply(errSyntax)
+		return
+	}
+
+	key, pivot, val := c.args[0], c.args[2], c.args[3]
+	_, l, ok := c.lookupList(key)
+	if !ok {
+		return
+	}
+
+	if l == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	items := l.slice(0, l.len())
+	for i, item := range items {
+		if item != pivot {
+			continue
+		}
+
+		if where == "after" {
+			i++
+		}
+
+		items = append(items, "")
+		copy(items[i+1:], items[i:])
+		items[i] = val
+		l.replace(items)
+
+		c.touch(key)
+		c.notify(keyspace.List, "linsert", key)
+		c.reply(radio.Integer(l.len()))
+		return
+	}
+
+	c.reply(radio.Integer(-1))
+}
+
+func lposCommand(c *call) {
+	rank, count, maxLen := int64(1), int64(1), int64(0)
+	withCount := false
+	for i := 2; i < len(c.args); i += 2 {
+		if i+1 >= len(c.args) {
+			c.reply(errSyntax)
+			return
+		}
+
+		n, ok := parseInt(c.args[i+1])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+
+		switch strings.ToLower(c.args[i]) {
+		case "rank":
+			if n == 0 {
+				c.reply(radio.ErrorStr("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"))
+				return
+			}
+			if n == math.MinInt64 {
+				c.reply(radio.ErrorStr("ERR value is out of range, value must between -9223372036854775807 and 9223372036854775807"))
+				return
+			}
+			rank = n
+
+		case "count":
+			if n < 0 {
+				c.reply(radio.ErrorStr("ERR COUNT can't be negative"))
+				return
+			}
+			count, withCount = n, true
+
+		case "maxlen":
+			if n < 0 {
+				c.reply(radio.ErrorStr("ERR MAXLEN can't be negative"))
+				return
+			}
+			maxLen = n
+
+		default:
+			c.reply(errSyntax)
+			return
+		}
+	}
+
+	_, l, ok := c.lookupList(c.args[0])
+	if !ok {
+		return
+	}
+
+	var matches []radio.Value
+	if l != nil {
+		skip := rank - 1
+		if rank < 0 {
+			skip = -rank - 1
+		}
+
+		n := l.len()
+		for i := 0; i < n && (maxLen == 0 || int64(i) < maxLen); i++ {
+			j := i
+			if rank < 0 {
+				j = n - 1 - i
+			}
+
+			if l.at(j) != c.args[1] {
+				continue
+			}
+
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			matches = append(matches, radio.Integer(j))
+			if count != 0 && int64(len(matches)) == count {
+				break
+			}
+		}
+	}
+
+	if withCount {
+		c.reply(&radio.Array{Items: append([]radio.Value{}, matches...)})
+	} else if len(matches) > 0 {
+		c.reply(matches[0])
+	} else {
+		c.reply(nullBulk)
+	}
+}
+
+// parseTimeout parses the timeout of a blocking command in seconds.
+func (c *call) parseTimeout(s string) (time.Duration, bool) {
+	secs, ok := parseFloat(s)
+	if !ok {
+		c.reply(radio.ErrorStr("ERR timeout is not a float or out of range"))
+		return 0, false
+	}
+
+	if secs < 0 {
+		c.reply(radio.ErrorStr("ERR timeout is negative"))
+		return 0, false
+	}
+
+	if secs > float64(math.MaxInt64)/float64(time.Second) {
+		c.reply(radio.ErrorStr("ERR timeout is out of range"))
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
+
+func blpopCommand(c *call) {
+	keys := c.args[:len(c.args)-1]
+	timeout, ok := c.parseTimeout(c.args[len(c.args)-1])
+	if !ok {
+		return
+	}
+
+	for _, key := range keys {
+		_, l, ok := c.lookupList(key)
+		if !ok {
+			return
+		}
+
+		if l != nil {
+			blpopServe(c, key)
+			return
+		}
+	}
+
+	c.block(keys, timeout, func(wr radio.ResponseWriter) {
+		wr.Write(&radio.Array{})
+	}, blpopServe)
+}
+
+// blpopServe pops an element of the list for BLPOP and BRPOP. Returns
+// false if the key does not hold a list.
+func blpopServe(c *call, key string) bool {
+	e := c.lookup(key)
+	if e == nil || typeOf(e.val) != "list" {
+		return false
+	}
+
+	l := e.val.(*list)
+	val := l.pop(c.name == "blpop")
+
+	c.touch(key)
+	c.notify(keyspace.List, c.name[1:], key)
+	c.removeIfEmpty(key, l.len())
+	c.reply(bulkArray([]string{key, val}))
+	return true
+}
+
+func lmoveCommand(c *call) {
+	src, dst := c.args[0], c.args[1]
+
+	var fromLeft, toLeft bool
+	switch c.name {
+	case "rpoplpush", "brpoplpush":
+		toLeft = true
+
+	default:
+		from, to := strings.ToLower(c.args[2]), strings.ToLower(c.args[3])
+		if (from != "left" && from != "right") || (to != "left" && to != "right") {
+			c.reply(errSyntax)
+			return
+		}
+		fromLeft, toLeft = from == "left", to == "left"
+	}
+
+	if c.name[0] != 'b' {
+		if !c.lmove(src, dst, fromLeft, toLeft) {
+			c.reply(nullBulk)
+		}
+		return
+	}
+
+	timeout, ok := c.parseTimeout(c.args[len(c.args)-1])
+	if !ok || c.lmove(src, dst, fromLeft, toLeft) {
+		return
+	}
+
+	c.block([]string{src}, timeout, func(wr radio.ResponseWriter) {
+		wr.Write(nullBulk)
+	}, func(c *call, key string) bool {
+		if e := c.lookup(key); e == nil || typeOf(e.val) != "list" {
+			return false
+		}
+		return c.lmove(src, dst, fromLeft, toLeft)
+	})
+}
+
+// lmove moves an element from the source list to the destination list and
+// writes the reply. Returns false without writing a reply if the source
+// list does not exist.
+func (c *call) lmove(src, dst string, fromLeft, toLeft bool) bool {
+	_, sl, ok := c.lookupList(src)
+	if !ok {
+		return true
+	}
+
+	if sl == nil {
+		return false
+	}
+
+	_, dl, ok := c.lookupList(dst)
+	if !ok {
+		return true
+	}
+
+	val := sl.pop(fromLeft)
+	if dl == nil {
+		dl = &list{}
+		c.add(dst, dl)
+	}
+	dl.push(toLeft, val)
+
+	c.touch(src)
+	c.touch(dst)
+	c.signal(dst)
+	if fromLeft {
+		c.notify(keyspace.List, "lpop", src)
+	} else {
+		c.notify(keyspace.List, "rpop", src)
+	}
+	if toLeft {
+		c.notify(keyspace.List, "lpush", dst)
+	} else {
+		c.notify(keyspace.List, "rpush", dst)
+	}
+
+	c.removeIfEmpty(src, sl.len())
+	c.reply(bulkStr(val))
+	return true
+}
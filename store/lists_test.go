@@ -0,0 +1,247 @@
+package store_test
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+	"github.com/spy16/radio/store"
+)
+
+func TestLists(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "Push&Pop",
+			steps: []step{
+				{cmd: "LPUSHX l a", reply: radio.Integer(0)},
+				{cmd: "RPUSH l c d", reply: radio.Integer(2)},
+				{cmd: "LPUSH l b a", reply: radio.Integer(4)},
+				{cmd: "RPUSHX l e", reply: radio.Integer(5)},
+				{cmd: "LRANGE l 0 -1", reply: bulks("a", "b", "c", "d", "e")},
+				{cmd: "LPOP l", reply: bulk("a")},
+				{cmd: "RPOP l 2", reply: bulks("e", "d")},
+				{cmd: "LPOP l 0", reply: bulks()},
+				{cmd: "LPOP l -1", reply: radio.ErrorStr("ERR value is out of range, must be positive")},
+				{cmd: "LPOP l 5", reply: bulks("b", "c")},
+				{cmd: "EXISTS l", reply: radio.Integer(0)},
+				{cmd: "LPOP l", reply: nullBulk},
+				{cmd: "LPOP l 1", reply: &radio.Array{}},
+				{cmd: "SET s 1", reply: okReply},
+				{cmd: "LPUSH s a", reply: wrongType},
+				{cmd: "LLEN s", reply: wrongType},
+			},
+		},
+		{
+			title: "Index",
+			steps: []step{
+				{cmd: "RPUSH l a b c", reply: radio.Integer(3)},
+				{cmd: "TYPE l", reply: radio.SimpleStr("list")},
+				{cmd: "LLEN l", reply: radio.Integer(3)},
+				{cmd: "LINDEX l 0", reply: bulk("a")},
+				{cmd: "LINDEX l -1", reply: bulk("c")},
+				{cmd: "LINDEX l 3", reply: nullBulk},
+				{cmd: "LSET l 1 x", reply: okReply},
+				{cmd: "LSET l 3 x", reply: radio.ErrorStr("ERR index out of range")},
+				{cmd: "LSET m 0 x", reply: radio.ErrorStr("ERR no such key")},
+				{cmd: "LRANGE l -100 100", reply: bulks("a", "x", "c")},
+				{cmd: "LRANGE l 2 1", reply: bulks()},
+				{cmd: "LRANGE l -2 -1", reply: bulks("x", "c")},
+				{cmd: "LRANGE m 0 -1", reply: bulks()},
+				{cmd: "LTRIM l 1 -1", reply: okReply},
+				{cmd: "LRANGE l 0 -1", reply: bulks("x", "c")},
+				{cmd: "LTRIM l 5 10", reply: okReply},
+				{cmd: "EXISTS l", reply: radio.Integer(0)},
+			},
+		},
+		{
+			title: "Edit",
+			steps: []step{
+				{cmd: "RPUSH l a b a c a", reply: radio.Integer(5)},
+				{cmd: "LREM l -2 a", reply: radio.Integer(2)},
+				{cmd: "LRANGE l 0 -1", reply: bulks("a", "b", "c")},
+				{cmd: "LINSERT l BEFORE b x", reply: radio.Integer(4)},
+				{cmd: "LINSERT l AFTER c y", reply: radio.Integer(5)},
+				{cmd: "LINSERT l AFTER z y", reply: radio.Integer(-1)},
+				{cmd: "LINSERT m AFTER a y", reply: radio.Integer(0)},
+				{cmd: "LINSERT l NEAR a y", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "LRANGE l 0 -1", reply: bulks("a", "x", "b", "c", "y")},
+				{cmd: "LREM l 0 x", reply: radio.Integer(1)},
+				{cmd: "LREM l 1 z", reply: radio.Integer(0)},
+			},
+		},
+		{
+			title: "LPOS",
+			steps: []step{
+				{cmd: "RPUSH l a b c 1 2 3 c c", reply: radio.Integer(8)},
+				{cmd: "LPOS l c", reply: radio.Integer(2)},
+				{cmd: "LPOS l x", reply: nullBulk},
+				{cmd: "LPOS l c RANK 2", reply: radio.Integer(6)},
+				{cmd: "LPOS l c RANK -1", reply: radio.Integer(7)},
+				{cmd: "LPOS l c COUNT 0", reply: ints(2, 6, 7)},
+				{cmd: "LPOS l c COUNT 2 RANK -1", reply: ints(7, 6)},
+				{cmd: "LPOS l c COUNT 0 MAXLEN 3", reply: ints(2)},
+				{cmd: "LPOS l x COUNT 1", reply: ints()},
+				{cmd: "LPOS m x COUNT 1", reply: ints()},
+				{cmd: "LPOS l c RANK 0", reply: radio.ErrorStr("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")},
+				{cmd: "LPOS l c COUNT -1", reply: radio.ErrorStr("ERR COUNT can't be negative")},
+				{cmd: "LPOS l c MAXLEN -1", reply: radio.ErrorStr("ERR MAXLEN can't be negative")},
+			},
+		},
+		{
+			title: "LMOVE",
+			steps: []step{
+				{cmd: "RPUSH a 1 2 3", reply: radio.Integer(3)},
+				{cmd: "LMOVE a b LEFT RIGHT", reply: bulk("1")},
+				{cmd: "LMOVE a b RIGHT LEFT", reply: bulk("3")},
+				{cmd: "LRANGE b 0 -1", reply: bulks("3", "1")},
+				{cmd: "RPOPLPUSH b b", reply: bulk("1")},
+				{cmd: "LRANGE b 0 -1", reply: bulks("1", "3")},
+				{cmd: "RPOPLPUSH a b", reply: bulk("2")},
+				{cmd: "EXISTS a", reply: radio.Integer(0)},
+				{cmd: "LMOVE a b LEFT LEFT", reply: nullBulk},
+				{cmd: "LMOVE b a UP LEFT", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "SET s 1", reply: okReply},
+				{cmd: "LMOVE b s LEFT LEFT", reply: wrongType},
+				{cmd: "LLEN b", reply: radio.Integer(3)},
+			},
+		},
+		{
+			title: "NonBlocking",
+			steps: []step{
+				{cmd: "RPUSH b 1 2", reply: radio.Integer(2)},
+				{cmd: "BLPOP a b 0", reply: bulks("b", "1")},
+				{cmd: "BRPOP a b 0", reply: bulks("b", "2")},
+				{cmd: "BLPOP a b 0", reply: &radio.Array{}},
+				{cmd: "BLMOVE a b LEFT LEFT 0", reply: nullBulk},
+				{cmd: "BLPOP a x", reply: radio.ErrorStr("ERR timeout is not a float or out of range")},
+				{cmd: "BLPOP a -1", reply: radio.ErrorStr("ERR timeout is negative")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(nil)
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestLists_Encoding(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	big := strings.Repeat("x", 5000)
+	runSteps(t, s, []step{
+		{cmd: "RPUSH l a b c", reply: radio.Integer(3)},
+		{cmd: "OBJECT ENCODING l", reply: bulk("listpack")},
+		{cmd: "RPUSH l " + big + " " + big, reply: radio.Integer(5)},
+		{cmd: "OBJECT ENCODING l", reply: bulk("quicklist")},
+		{cmd: "RPOP l", reply: bulk(big)},
+		{cmd: "OBJECT ENCODING l", reply: bulk("quicklist")},
+		{cmd: "RPOP l", reply: bulk(big)},
+		{cmd: "OBJECT ENCODING l", reply: bulk("listpack")},
+		{cmd: "LRANGE l 0 -1", reply: bulks("a", "b", "c")},
+	})
+}
+
+func TestLists_Blocking(t *testing.T) {
+	blocker := &radio.Blocker{}
+	s := store.New(store.Options{Blocker: blocker})
+	defer s.Close()
+
+	served := &servedCounter{Handler: &store.Handler{Store: s}}
+	blocker.Handler = served
+	srv := radiotest.NewServer(blocker)
+	defer srv.Close()
+
+	first, second, third := dial(t, srv), dial(t, srv), dial(t, srv)
+	defer first.Close()
+	defer second.Close()
+	defer third.Close()
+
+	// waiters are served in the order they blocked.
+	first.Send("BLPOP", "a", "b", "0")
+	first.Flush()
+	served.wait(t, 1)
+
+	second.Send("BLMOVE", "b", "dst", "LEFT", "RIGHT", "0")
+	second.Flush()
+	served.wait(t, 2)
+
+	expect(t, third, radio.Integer(2), "RPUSH", "b", "x", "y")
+	if v, err := first.Receive(); err != nil || !reflect.DeepEqual(bulks("b", "x"), v) {
+		t.Errorf("expecting '[b x]', got '%v' (err=%v)", v, err)
+	}
+	if v, err := second.Receive(); err != nil || !reflect.DeepEqual(bulk("y"), v) {
+		t.Errorf("expecting 'y', got '%v' (err=%v)", v, err)
+	}
+	expect(t, third, bulks("y"), "LRANGE", "dst", "0", "-1")
+	expect(t, third, radio.Integer(0), "EXISTS", "b")
+
+	// waiters time out with a null reply.
+	expect(t, first, &radio.Array{}, "BRPOP", "a", "0.01")
+	expect(t, first, nullBulk, "BLMOVE", "a", "b", "LEFT", "LEFT", "0.01")
+
+	// the database selected by the client is used.
+	expect(t, first, okReply, "SELECT", "1")
+	first.Send("BRPOP", "a", "0")
+	first.Flush()
+	served.wait(t, 9)
+
+	expect(t, third, radio.Integer(1), "RPUSH", "a", "db0")
+	expect(t, third, okReply, "SELECT", "1")
+	expect(t, third, radio.Integer(1), "RPUSH", "a", "db1")
+	if v, err := first.Receive(); err != nil || !reflect.DeepEqual(bulks("a", "db1"), v) {
+		t.Errorf("expecting '[a db1]', got '%v' (err=%v)", v, err)
+	}
+}
+
+// servedCounter counts the requests served by the handler. Blocking
+// commands return from ServeRESP once the client is blocked.
+type servedCounter struct {
+	radio.Handler
+
+	mu sync.Mutex
+	n  int
+}
+
+func (sc *servedCounter) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	sc.Handler.ServeRESP(wr, req)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.n++
+}
+
+// wait waits until n requests are served.
+func (sc *servedCounter) wait(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sc.mu.Lock()
+		served := sc.n
+		sc.mu.Unlock()
+
+		if served >= n {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting %d requests to be served, got %d", n, served)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,497 @@
+package store
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+func init() {
+	register("memory", -2, flagReadOnly, memoryCommand)
+}
+
+var errOOM = radio.ErrorStr("OOM command not allowed when used memory > 'maxmemory'.")
+
+// EvictionPolicy selects the keys evicted when the memory used by the store
+// exceeds the limit. The allkeys policies select among all the keys and the
+// volatile policies among the keys with expiry.
+type EvictionPolicy string
+
+// Eviction policies supported by the store. Like Redis, the least recently
+// used (LRU), the least frequently used (LFU) and the nearest to expire
+// (TTL) keys are approximated by sampling the keys.
+const (
+	NoEviction     EvictionPolicy = "noeviction"
+	AllKeysLRU     EvictionPolicy = "allkeys-lru"
+	VolatileLRU    EvictionPolicy = "volatile-lru"
+	AllKeysLFU     EvictionPolicy = "allkeys-lfu"
+	VolatileLFU    EvictionPolicy = "volatile-lfu"
+	AllKeysRandom  EvictionPolicy = "allkeys-random"
+	VolatileRandom EvictionPolicy = "volatile-random"
+	VolatileTTL    EvictionPolicy = "volatile-ttl"
+)
+
+var evictionPolicies = []EvictionPolicy{
+	NoEviction, AllKeysLRU, VolatileLRU, AllKeysLFU, VolatileLFU,
+	AllKeysRandom, VolatileRandom, VolatileTTL,
+}
+
+func (p EvictionPolicy) valid() bool {
+	for _, policy := range evictionPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+func (p EvictionPolicy) lfu() bool {
+	return p == AllKeysLFU || p == VolatileLFU
+}
+
+func (p EvictionPolicy) volatile() bool {
+	return strings.HasPrefix(string(p), "volatile-")
+}
+
+const (
+	// lfuInitVal is the access frequency counter of new keys, so that they
+	// are not evicted before they get a chance to be accessed.
+	lfuInitVal = 5
+
+	// lfuLogFactor and lfuDecayTime (in minutes) control the increment and
+	// the decrement of the access frequency counters (lfu-log-factor and
+	// lfu-decay-time).
+	lfuLogFactor = 10
+	lfuDecayTime = 1
+
+	// evictionPoolSize is the number of the best candidates for eviction
+	// kept across the samplings.
+	evictionPoolSize = 16
+)
+
+// The sizes of the values are estimated from the sizes of their elements
+// and approximations of the memory used by the structures holding them on
+// 64-bit platforms.
+const (
+	entryOverhead       = 96 // entry, slot of the map and creation order
+	sliceOverhead       = 24
+	stringOverhead      = 16
+	fieldOverhead       = 64
+	dictFieldOverhead   = 40 // pointer, slot of the map and creation order
+	skipnodeOverhead    = 80
+	scoredOverhead      = 24
+	streamNodeOverhead  = 64
+	streamEntryOverhead = 48
+	streamGroupOverhead = 128
+	pendingOverhead     = 64
+	consumerOverhead    = 96
+
+	// memorySamples is the default number of elements sampled to estimate
+	// the size of collections.
+	memorySamples = 5
+)
+
+// entrySize returns the estimated memory used by the key and its value.
+func entrySize(key string, val interface{}, samples int) int64 {
+	return entryOverhead + int64(len(key)) + valueSize(val, samples)
+}
+
+// valueSize returns the estimated memory used by the value. Like Redis,
+// the size of the elements of collections is extrapolated from up to
+// samples elements. All the elements are used if samples is not positive.
+func valueSize(val interface{}, samples int) int64 {
+	switch v := val.(type) {
+	case []byte:
+		return sliceOverhead + int64(len(v))
+
+	case *hash:
+		per := int64(fieldOverhead)
+		if v.dict != nil {
+			per += dictFieldOverhead
+		}
+		return sliceOverhead + sampled(v.len(), samples, func(add func(int64) bool) {
+			v.each(func(f *field) bool {
+				return add(per + int64(len(f.name)+len(f.value)))
+			})
+		})
+
+	case *list:
+		return sliceOverhead + int64(len(v.buf))*stringOverhead + int64(v.size)
+
+	case *set:
+		if v.enc == encIntset {
+			return sliceOverhead + int64(len(v.ints))*8
+		}
+
+		per := int64(stringOverhead)
+		if v.enc == encHashtable {
+			per = fieldOverhead + dictFieldOverhead
+		}
+		return sliceOverhead + sampled(v.len(), samples, func(add func(int64) bool) {
+			v.each(func(member string) bool {
+				return add(per + int64(len(member)))
+			})
+		})
+
+	case *zset:
+		per := int64(scoredOverhead)
+		if v.dict != nil {
+			per = fieldOverhead + dictFieldOverhead + skipnodeOverhead
+		}
+		return sliceOverhead + sampled(v.len(), samples, func(add func(int64) bool) {
+			v.each(0, false, func(e scored) bool {
+				return add(per + int64(len(e.member)))
+			})
+		})
+
+	case *stream:
+		size := sliceOverhead + sampled(len(v.nodes), samples, func(add func(int64) bool) {
+			for _, n := range v.nodes {
+				if !add(streamNodeOverhead + int64(n.size) + int64(len(n.entries))*streamEntryOverhead) {
+					return
+				}
+			}
+		})
+
+		for _, g := range v.groups {
+			size += streamGroupOverhead + int64(len(g.name)) + int64(len(g.pending))*pendingOverhead
+			for _, cons := range g.consumers {
+				size += consumerOverhead + int64(len(cons.name)) + int64(len(cons.pending))*stringOverhead
+			}
+		}
+		return size
+	}
+
+	return 0
+}
+
+// sampled extrapolates the size of n elements from the sizes of up to
+// samples elements passed by iterate to add.
+func sampled(n, samples int, iterate func(add func(size int64) bool)) int64 {
+	if samples <= 0 || samples > n {
+		samples = n
+	}
+
+	total, visited := int64(0), 0
+	iterate(func(size int64) bool {
+		total += size
+		visited++
+		return visited < samples
+	})
+
+	if visited == 0 {
+		return 0
+	}
+	return total * int64(n) / int64(visited)
+}
+
+// resize updates the estimated memory used by the entry.
+func (d *db) resize(e *entry) {
+	size := entrySize(e.key, e.val, memorySamples)
+	d.used += size - e.size
+	e.size = size
+}
+
+// RegisterConfig registers the maxmemory, maxmemory-policy and
+// maxmemory-samples parameters with the configuration so that the memory
+// limit can be changed using CONFIG SET.
+func (s *Store) RegisterConfig(cfg *radio.Config) {
+	names := make([]string, len(evictionPolicies))
+	for i, policy := range evictionPolicies {
+		names[i] = string(policy)
+	}
+
+	maxMemory := radio.NewMemoryConfig(s.opts.MaxMemory, 0, math.MaxInt64)
+	policy := radio.NewEnumConfig(string(s.opts.MaxMemoryPolicy), names...)
+	samples := radio.NewIntConfig(int64(s.opts.MaxMemorySamples), 1, 64)
+
+	apply := func(fn func(opts *Options)) func() error {
+		return func() error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			fn(&s.opts)
+			return nil
+		}
+	}
+
+	cfg.Register(
+		radio.ConfigParam{
+			Name:  "maxmemory",
+			Value: maxMemory,
+			OnChange: apply(func(opts *Options) {
+				opts.MaxMemory = maxMemory.Get()
+			}),
+		},
+		radio.ConfigParam{
+			Name:  "maxmemory-policy",
+			Value: policy,
+			OnChange: apply(func(opts *Options) {
+				opts.MaxMemoryPolicy = EvictionPolicy(policy.Get())
+			}),
+		},
+		radio.ConfigParam{
+			Name:  "maxmemory-samples",
+			Value: samples,
+			OnChange: apply(func(opts *Options) {
+				opts.MaxMemorySamples = int(samples.Get())
+			}),
+		},
+	)
+}
+
+// usedMemory returns the estimated memory used by the keys of all the
+// databases.
+func (s *Store) usedMemory() int64 {
+	used := int64(0)
+	for _, d := range s.dbs {
+		used += d.used
+	}
+	return used
+}
+
+// lfuIncr increments the access frequency counter logarithmically: the
+// higher the counter, the less likely an access increments it.
+func lfuIncr(counter uint8) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+
+	base := float64(counter) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// freq returns the access frequency counter of the entry decremented once
+// for every lfuDecayTime minutes elapsed since the last access.
+func (e *entry) freq(now int64) uint8 {
+	periods := (now/60000 - e.decayedAt) / lfuDecayTime
+	if periods <= 0 {
+		return e.counter
+	}
+	if periods >= int64(e.counter) {
+		return 0
+	}
+	return e.counter - uint8(periods)
+}
+
+// access records the access to the entry for the eviction policies. The
+// access frequency is only tracked when using an LFU policy.
+func (c *call) access(e *entry) {
+	e.accessed = c.now
+	if c.s.opts.MaxMemoryPolicy.lfu() {
+		e.counter = lfuIncr(e.freq(c.now))
+		e.decayedAt = c.now / 60000
+	}
+}
+
+// account updates the estimated memory used by the keys modified by the
+// call.
+func (c *call) account() {
+	for _, key := range c.modified {
+		if e := c.db.get(key); e != nil {
+			c.db.resize(e)
+		}
+	}
+}
+
+// evict evicts keys according to the eviction policy until the memory used
+// is within the limit. Returns false if the memory used still exceeds the
+// limit.
+func (c *call) evict() bool {
+	s := c.s
+	if s.opts.MaxMemory <= 0 {
+		return true
+	}
+
+	for s.usedMemory() > s.opts.MaxMemory {
+		d, e := s.evictionCandidate(c.now)
+		if e == nil {
+			return false
+		}
+
+		d.delete(e.key)
+		c.events = append(c.events, event{class: keyspace.Evicted, name: "evicted", key: e.key, db: d.id})
+		c.modified = append(c.modified, e.key)
+	}
+	return true
+}
+
+// evictionCandidate selects the key to evict. Returns a nil entry if there
+// are no keys to evict.
+func (s *Store) evictionCandidate(now int64) (*db, *entry) {
+	switch policy := s.opts.MaxMemoryPolicy; policy {
+	case AllKeysRandom, VolatileRandom:
+		return s.randomCandidate(policy.volatile())
+	case AllKeysLRU, VolatileLRU, AllKeysLFU, VolatileLFU, VolatileTTL:
+		return s.pooledCandidate(policy, now)
+	}
+	return nil, nil
+}
+
+// randomCandidate selects a random key visiting the databases in turn.
+func (s *Store) randomCandidate(volatile bool) (*db, *entry) {
+	for i := range s.dbs {
+		d := s.dbs[(s.nextDB+i)%len(s.dbs)]
+
+		// map iteration starts at a random position.
+		for _, e := range d.candidates(volatile) {
+			s.nextDB = (d.id + 1) % len(s.dbs)
+			return d, e
+		}
+	}
+	return nil, nil
+}
+
+// evictionCandidate is a key in the eviction pool. The candidates with
+// higher scores are evicted first.
+type evictionCandidate struct {
+	db    int
+	key   string
+	score uint64
+}
+
+// pooledCandidate samples the keys of every database into the eviction pool
+// and selects the best candidate still existing. Like Redis, the pool keeps
+// the best candidates across the evictions, which improves the
+// approximation of the policy.
+func (s *Store) pooledCandidate(policy EvictionPolicy, now int64) (*db, *entry) {
+	volatile := policy.volatile()
+
+	for {
+		keys := 0
+		for _, d := range s.dbs {
+			sample := d.candidates(volatile)
+			keys += len(sample)
+			s.populatePool(d, sample, policy, now)
+		}
+
+		if keys == 0 {
+			return nil, nil
+		}
+
+		for len(s.pool) > 0 {
+			best := s.pool[len(s.pool)-1]
+			s.pool = s.pool[:len(s.pool)-1]
+
+			d := s.dbs[best.db]
+			if e := d.get(best.key); e != nil && (!volatile || e.expireAt != 0) {
+				return d, e
+			}
+		}
+	}
+}
+
+// populatePool adds a sample of the keys to the eviction pool.
+func (s *Store) populatePool(d *db, keys map[string]*entry, policy EvictionPolicy, now int64) {
+	sampled := 0
+	for _, e := range keys {
+		if sampled >= s.opts.MaxMemorySamples {
+			break
+		}
+		sampled++
+
+		var score uint64
+		switch policy {
+		case AllKeysLFU, VolatileLFU:
+			score = math.MaxUint8 - uint64(e.freq(now))
+		case VolatileTTL:
+			score = math.MaxUint64 - uint64(e.expireAt)
+		default:
+			if now > e.accessed {
+				score = uint64(now - e.accessed)
+			}
+		}
+
+		s.pool = insertCandidate(s.pool, evictionCandidate{db: d.id, key: e.key, score: score})
+	}
+}
+
+// insertCandidate inserts the candidate into the pool sorted by ascending
+// score. If the pool is full, the candidate with the lowest score is
+// dropped.
+func insertCandidate(pool []evictionCandidate, cand evictionCandidate) []evictionCandidate {
+	for i, other := range pool {
+		if other.db == cand.db && other.key == cand.key {
+			pool = append(pool[:i], pool[i+1:]...)
+			break
+		}
+	}
+
+	i := sort.Search(len(pool), func(i int) bool {
+		return pool[i].score >= cand.score
+	})
+
+	if len(pool) == evictionPoolSize {
+		if i == 0 {
+			return pool
+		}
+		copy(pool, pool[1:i])
+		pool[i-1] = cand
+		return pool
+	}
+
+	pool = append(pool, evictionCandidate{})
+	copy(pool[i+1:], pool[i:])
+	pool[i] = cand
+	return pool
+}
+
+// candidates returns the keys the volatile or allkeys policies select the
+// keys to evict from.
+func (d *db) candidates(volatile bool) map[string]*entry {
+	if volatile {
+		return d.expires
+	}
+	return d.keys
+}
+
+func memoryCommand(c *call) {
+	sub := strings.ToLower(c.args[0])
+	if sub != "usage" {
+		c.reply(radio.ErrorStr("ERR unknown subcommand '" + c.args[0] + "'. Try MEMORY HELP."))
+		return
+	}
+
+	if len(c.args) < 2 {
+		c.reply(errArity("memory|" + sub))
+		return
+	}
+
+	samples := memorySamples
+	for i := 2; i < len(c.args); i++ {
+		if strings.ToLower(c.args[i]) != "samples" || i+1 >= len(c.args) {
+			c.reply(errSyntax)
+			return
+		}
+
+		n, ok := parseInt(c.args[i+1])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+		if n < 0 {
+			c.reply(errSyntax)
+			return
+		}
+
+		// zero samples all the elements.
+		samples = int(n)
+		i++
+	}
+
+	e := c.peek(c.args[1])
+	if e == nil {
+		c.reply(nullBulk)
+		return
+	}
+	c.reply(radio.Integer(entrySize(e.key, e.val, samples)))
+}
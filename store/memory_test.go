@@ -0,0 +1,280 @@
+package store_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/store"
+)
+
+func TestMemory(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "MEMORY USAGE",
+			steps: []step{
+				{cmd: "SET k v", reply: okReply},
+				{cmd: "MEMORY USAGE k", reply: radio.Integer(122)},
+				{cmd: "SET k value", reply: okReply},
+				{cmd: "MEMORY USAGE k SAMPLES 0", reply: radio.Integer(126)},
+				{cmd: "RPUSH l a b c", reply: radio.Integer(3)},
+				{cmd: "MEMORY USAGE l", reply: radio.Integer(194)},
+				{cmd: "MEMORY USAGE x", reply: nullBulk},
+				{cmd: "MEMORY USAGE k SAMPLES -1", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "MEMORY USAGE k SAMPLES a", reply: radio.ErrorStr("ERR value is not an integer or out of range")},
+				{cmd: "MEMORY USAGE k SAMPLES", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "MEMORY USAGE", reply: radio.ErrorStr("ERR wrong number of arguments for 'memory|usage' command")},
+				{cmd: "MEMORY FOO", reply: radio.ErrorStr("ERR unknown subcommand 'FOO'. Try MEMORY HELP.")},
+			},
+		},
+		{
+			title: "OBJECT",
+			steps: []step{
+				{cmd: "SET k v", reply: okReply},
+				{cmd: "OBJECT IDLETIME k", reply: radio.Integer(0)},
+				{cmd: "OBJECT IDLETIME x", reply: nullBulk},
+				{cmd: "OBJECT FREQ k", reply: radio.ErrorStr("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")},
+				{cmd: "OBJECT IDLETIME", reply: radio.ErrorStr("ERR wrong number of arguments for 'object|idletime' command")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(nil)
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestMemory_Sampling(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	for i := 0; i < 1000; i++ {
+		s.Do(0, "HSET", "h", "field:"+strconv.Itoa(1000+i), "value")
+	}
+
+	// fields of the same size are extrapolated exactly.
+	if all, sampled := s.Do(0, "MEMORY", "USAGE", "h", "SAMPLES", "0"), s.Do(0, "MEMORY", "USAGE", "h"); all != sampled {
+		t.Errorf("expecting '%v', got '%v'", all, sampled)
+	}
+
+	small := s.Do(0, "MEMORY", "USAGE", "h").(radio.Integer)
+	s.Do(0, "HSET", "h", "field:1000", string(make([]byte, 10000)))
+	if large := s.Do(0, "MEMORY", "USAGE", "h").(radio.Integer); large <= small {
+		t.Errorf("expecting usage greater than %d, got %d", small, large)
+	}
+}
+
+func TestMemory_AccessTracking(t *testing.T) {
+	clock := newClock()
+	s := newStore(clock)
+	defer s.Close()
+
+	runSteps(t, s, []step{{cmd: "SET k v", reply: okReply}})
+	clock.Advance(10 * time.Second)
+	runSteps(t, s, []step{
+		{cmd: "OBJECT IDLETIME k", reply: radio.Integer(10)},
+		{cmd: "EXISTS k", reply: radio.Integer(1)},
+		{cmd: "TYPE k", reply: radio.SimpleStr("string")},
+		{cmd: "TTL k", reply: radio.Integer(-1)},
+		{cmd: "OBJECT IDLETIME k", reply: radio.Integer(10)},
+		{cmd: "GET k", reply: bulk("v")},
+		{cmd: "OBJECT IDLETIME k", reply: radio.Integer(0)},
+	})
+
+	clock.Advance(5 * time.Second)
+	runSteps(t, s, []step{
+		{cmd: "TOUCH k", reply: radio.Integer(1)},
+		{cmd: "OBJECT IDLETIME k", reply: radio.Integer(0)},
+	})
+
+	lfu := store.New(store.Options{ExpiryInterval: -1, Now: clock.Now, MaxMemoryPolicy: store.AllKeysLFU})
+	defer lfu.Close()
+
+	runSteps(t, lfu, []step{
+		{cmd: "SET k v", reply: okReply},
+		{cmd: "OBJECT FREQ k", reply: radio.Integer(5)},
+		{cmd: "GET k", reply: bulk("v")},
+		{cmd: "OBJECT FREQ k", reply: radio.Integer(6)},
+		{cmd: "OBJECT IDLETIME k", reply: radio.ErrorStr("ERR An LRU maxmemory policy is not selected, access time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")},
+	})
+
+	// the counter decays by one every minute.
+	clock.Advance(2 * time.Minute)
+	runSteps(t, lfu, []step{{cmd: "OBJECT FREQ k", reply: radio.Integer(4)}})
+}
+
+func TestMemory_Eviction(suite *testing.T) {
+	suite.Parallel()
+
+	// keys of a single character with values of a single character use 122
+	// bytes, so that three of them fit within the limit.
+	const maxMemory = 3 * 122
+
+	cases := []struct {
+		title  string
+		policy store.EvictionPolicy
+		tick   time.Duration
+		steps  []step
+	}{
+		{
+			title:  "NoEviction",
+			policy: store.NoEviction,
+			steps: []step{
+				{cmd: "SET a 1", reply: okReply},
+				{cmd: "SET b 1", reply: okReply},
+				{cmd: "SET c 1", reply: okReply},
+				{cmd: "SET d 1", reply: okReply},
+				{cmd: "SET e 1", reply: radio.ErrorStr("OOM command not allowed when used memory > 'maxmemory'.")},
+				{cmd: "RPUSH l 1", reply: radio.ErrorStr("OOM command not allowed when used memory > 'maxmemory'.")},
+				{cmd: "GET a", reply: bulk("1")},
+				{cmd: "EXPIRE a 100", reply: radio.Integer(1)},
+				{cmd: "DEL a", reply: radio.Integer(1)},
+				{cmd: "SET e 1", reply: okReply},
+				{cmd: "DBSIZE", reply: radio.Integer(4)},
+			},
+		},
+		{
+			title:  "AllKeysLRU",
+			policy: store.AllKeysLRU,
+			steps: []step{
+				{cmd: "SET a 1", reply: okReply},
+				{cmd: "SET b 1", reply: okReply},
+				{cmd: "SET c 1", reply: okReply},
+				{cmd: "GET a", reply: bulk("1")},
+				{cmd: "SET d 1", reply: okReply},
+				{cmd: "DBSIZE", reply: radio.Integer(3)},
+				{cmd: "EXISTS b", reply: radio.Integer(0)},
+				{cmd: "SET e 1", reply: okReply},
+				{cmd: "EXISTS c", reply: radio.Integer(0)},
+				{cmd: "EXISTS a d e", reply: radio.Integer(3)},
+			},
+		},
+		{
+			title:  "VolatileLRU",
+			policy: store.VolatileLRU,
+			steps: []step{
+				{cmd: "SET a 1", reply: okReply},
+				{cmd: "SET b 1 EX 100", reply: okReply},
+				{cmd: "SET c 1", reply: okReply},
+				{cmd: "SET d 1", reply: okReply},
+				{cmd: "DBSIZE", reply: radio.Integer(3)},
+				{cmd: "EXISTS b", reply: radio.Integer(0)},
+				{cmd: "SET e 1", reply: okReply},
+				{cmd: "SET f 1", reply: radio.ErrorStr("OOM command not allowed when used memory > 'maxmemory'.")},
+			},
+		},
+		{
+			title:  "AllKeysLFU",
+			policy: store.AllKeysLFU,
+			tick:   time.Minute,
+			steps: []step{
+				{cmd: "SET b 1", reply: okReply},
+				{cmd: "SET a 1", reply: okReply},
+				{cmd: "SET c 1", reply: okReply},
+				{cmd: "GET a", reply: bulk("1")},
+				{cmd: "GET c", reply: bulk("1")},
+				{cmd: "SET d 1", reply: okReply},
+				{cmd: "GET d", reply: bulk("1")},
+				{cmd: "EXISTS b", reply: radio.Integer(0)},
+				{cmd: "DBSIZE", reply: radio.Integer(3)},
+			},
+		},
+		{
+			title:  "VolatileTTL",
+			policy: store.VolatileTTL,
+			steps: []step{
+				{cmd: "SET a 1 EX 100", reply: okReply},
+				{cmd: "SET b 1 EX 10", reply: okReply},
+				{cmd: "SET c 1 EX 50", reply: okReply},
+				{cmd: "SET d 1", reply: okReply},
+				{cmd: "DBSIZE", reply: radio.Integer(3)},
+				{cmd: "EXISTS b", reply: radio.Integer(0)},
+			},
+		},
+		{
+			title:  "AllKeysRandom",
+			policy: store.AllKeysRandom,
+			steps: []step{
+				{cmd: "SET a 1", reply: okReply},
+				{cmd: "SET b 1", reply: okReply},
+				{cmd: "SET c 1", reply: okReply},
+				{cmd: "SET d 1", reply: okReply},
+				{cmd: "DBSIZE", reply: radio.Integer(3)},
+				{cmd: "SET e 1", reply: okReply},
+				{cmd: "SET f 1", reply: okReply},
+				{cmd: "DBSIZE", reply: radio.Integer(3)},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			clock := newClock()
+			s := store.New(store.Options{
+				ExpiryInterval:  -1,
+				Now:             clock.Now,
+				MaxMemory:       maxMemory,
+				MaxMemoryPolicy: cs.policy,
+			})
+			defer s.Close()
+
+			// the commands are executed a tick apart, so that the keys
+			// to evict are deterministic.
+			tick := cs.tick
+			if tick == 0 {
+				tick = time.Second
+			}
+			for _, st := range cs.steps {
+				runSteps(t, s, []step{st})
+				clock.Advance(tick)
+			}
+		})
+	}
+}
+
+func TestMemory_Config(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	cfg := &radio.Config{}
+	s.RegisterConfig(cfg)
+
+	runSteps(t, s, []step{
+		{cmd: "SET a 1", reply: okReply},
+		{cmd: "SET b 1", reply: okReply},
+		{cmd: "SET c 1", reply: okReply},
+		{cmd: "SET d 1", reply: okReply},
+	})
+
+	if err := cfg.Set("maxmemory", "366", "maxmemory-policy", "allkeys-lru", "maxmemory-samples", "10"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runSteps(t, s, []step{
+		{cmd: "DBSIZE", reply: radio.Integer(3)},
+	})
+
+	if err := cfg.Set("maxmemory-policy", "volatile-lfu"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runSteps(t, s, []step{
+		{cmd: "SET e 1", reply: okReply},
+		{cmd: "SET f 1", reply: radio.ErrorStr("OOM command not allowed when used memory > 'maxmemory'.")},
+		{cmd: "OBJECT FREQ e", reply: radio.Integer(5)},
+	})
+
+	if v, _ := cfg.Get("maxmemory-samples"); v != "10" {
+		t.Errorf("expecting '10', got '%s'", v)
+	}
+}
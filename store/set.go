@@ -0,0 +1,232 @@
+package store
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+const (
+	// setMaxIntsetEntries, setMaxListpackEntries and setMaxListpackValue
+	// are the limits of the compact encodings of sets
+	// (set-max-intset-entries, set-max-listpack-entries/value).
+	setMaxIntsetEntries   = 512
+	setMaxListpackEntries = 128
+	setMaxListpackValue   = 64
+)
+
+const (
+	encIntset = iota
+	encListpack
+	encHashtable
+)
+
+// set is a set value. Sets of integers are kept in a sorted slice of
+// integers (intset encoding) and other small sets in a slice of members in
+// the order they were added (listpack encoding). Sets exceeding the limits
+// of these encodings are converted to a dict (hashtable encoding). Like
+// Redis, sets are never converted back.
+type set struct {
+	enc     int
+	ints    []int64
+	members []string
+	dict    *dict
+}
+
+// newSet creates an empty set with an encoding suitable for the first
+// member and the expected number of members.
+func newSet(first string, size int) *set {
+	if _, ok := parseInt(first); ok && size <= setMaxIntsetEntries {
+		return &set{enc: encIntset}
+	}
+
+	if size <= setMaxListpackEntries && len(first) <= setMaxListpackValue {
+		return &set{enc: encListpack}
+	}
+	return &set{enc: encHashtable, dict: newDict(size)}
+}
+
+func (s *set) encoding() string {
+	switch s.enc {
+	case encIntset:
+		return "intset"
+	case encListpack:
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+func (s *set) len() int {
+	switch s.enc {
+	case encIntset:
+		return len(s.ints)
+	case encListpack:
+		return len(s.members)
+	}
+	return s.dict.len()
+}
+
+func (s *set) has(member string) bool {
+	switch s.enc {
+	case encIntset:
+		n, ok := parseInt(member)
+		if !ok {
+			return false
+		}
+		i := s.search(n)
+		return i < len(s.ints) && s.ints[i] == n
+
+	case encListpack:
+		for _, m := range s.members {
+			if m == member {
+				return true
+			}
+		}
+		return false
+	}
+
+	return s.dict.get(member) != nil
+}
+
+// add adds the member and returns true if it was not already a member.
+func (s *set) add(member string) bool {
+	if s.enc == encIntset {
+		n, ok := parseInt(member)
+		if !ok {
+			if len(s.ints) < setMaxListpackEntries && len(member) <= setMaxListpackValue {
+				s.convert(encListpack)
+			} else {
+				s.convert(encHashtable)
+			}
+			return s.add(member)
+		}
+
+		i := s.search(n)
+		if i < len(s.ints) && s.ints[i] == n {
+			return false
+		}
+
+		s.ints = append(s.ints, 0)
+		copy(s.ints[i+1:], s.ints[i:])
+		s.ints[i] = n
+
+		if len(s.ints) > setMaxIntsetEntries {
+			s.convert(encHashtable)
+		}
+		return true
+	}
+
+	if s.has(member) {
+		return false
+	}
+
+	if s.enc == encListpack {
+		if len(s.members) < setMaxListpackEntries && len(member) <= setMaxListpackValue {
+			s.members = append(s.members, member)
+			return true
+		}
+		s.convert(encHashtable)
+	}
+
+	s.dict.add(member, "")
+	return true
+}
+
+// remove removes the member and returns true if it was a member.
+func (s *set) remove(member string) bool {
+	switch s.enc {
+	case encIntset:
+		n, ok := parseInt(member)
+		if !ok {
+			return false
+		}
+
+		i := s.search(n)
+		if i == len(s.ints) || s.ints[i] != n {
+			return false
+		}
+		s.ints = append(s.ints[:i], s.ints[i+1:]...)
+		return true
+
+	case encListpack:
+		for i, m := range s.members {
+			if m == member {
+				s.members = append(s.members[:i], s.members[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+
+	return s.dict.remove(member)
+}
+
+// each invokes fn for every member until fn returns false.
+func (s *set) each(fn func(member string) bool) {
+	s.scan(0, fn)
+}
+
+// scan invokes fn for the members starting at the cursor until fn returns
+// false. Returns the cursor to continue from or zero if all the members are
+// visited. Sets using the compact encodings are always visited fully.
+func (s *set) scan(cursor uint64, fn func(member string) bool) uint64 {
+	switch s.enc {
+	case encIntset:
+		for _, n := range s.ints {
+			if !fn(strconv.FormatInt(n, 10)) {
+				break
+			}
+		}
+		return 0
+
+	case encListpack:
+		for _, m := range s.members {
+			if !fn(m) {
+				break
+			}
+		}
+		return 0
+	}
+
+	return s.dict.scan(cursor, func(f *field) bool {
+		return fn(f.name)
+	})
+}
+
+// random returns a random member. The set must not be empty.
+func (s *set) random() string {
+	switch s.enc {
+	case encIntset:
+		return strconv.FormatInt(s.ints[rand.Intn(len(s.ints))], 10)
+	case encListpack:
+		return s.members[rand.Intn(len(s.members))]
+	}
+	return s.dict.random().name
+}
+
+func (s *set) search(n int64) int {
+	return sort.Search(len(s.ints), func(i int) bool {
+		return s.ints[i] >= n
+	})
+}
+
+func (s *set) convert(enc int) {
+	var members []string
+	s.each(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+
+	s.enc = enc
+	s.ints = nil
+	s.members = nil
+	if enc == encListpack {
+		s.members = members
+		return
+	}
+
+	s.dict = newDict(len(members))
+	for _, m := range members {
+		s.dict.add(m, "")
+	}
+}
@@ -0,0 +1,550 @@
+package store
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+func init() {
+	register("sadd", -3, flagWrite|flagDenyOOM, saddCommand)
+	register("srem", -3, flagWrite, sremCommand)
+	register("scard", 2, flagReadOnly, scardCommand)
+	register("sismember", 3, flagReadOnly, sismemberCommand)
+	register("smismember", -3, flagReadOnly, sismemberCommand)
+	register("smembers", 2, flagReadOnly, smembersCommand)
+	register("spop", -2, flagWrite, spopCommand)
+	register("srandmember", -2, flagReadOnly, srandmemberCommand)
+	register("sscan", -3, flagReadOnly, sscanCommand)
+	register("smove", 4, flagWrite, smoveCommand)
+	register("sinter", -2, flagReadOnly, setopCommand)
+	register("sunion", -2, flagReadOnly, setopCommand)
+	register("sdiff", -2, flagReadOnly, setopCommand)
+	register("sinterstore", -3, flagWrite|flagDenyOOM, setopCommand)
+	register("sunionstore", -3, flagWrite|flagDenyOOM, setopCommand)
+	register("sdiffstore", -3, flagWrite|flagDenyOOM, setopCommand)
+	register("sintercard", -3, flagReadOnly, sintercardCommand)
+}
+
+// lookupSet returns the set of the key. ok is false if the key holds
+// another type in which case the error is already written.
+func (c *call) lookupSet(key string) (e *entry, s *set, ok bool) {
+	e, ok = c.lookupType(key, "set")
+	if e != nil {
+		s = e.val.(*set)
+	}
+	return e, s, ok
+}
+
+// lookupSets returns the sets of the keys. Sets of the keys that do not
+// exist are nil. ok is false if any of the keys holds another type in which
+// case the error is already written.
+func (c *call) lookupSets(keys []string) ([]*set, bool) {
+	sets := make([]*set, len(keys))
+	for i, key := range keys {
+		_, s, ok := c.lookupSet(key)
+		if !ok {
+			return nil, false
+		}
+		sets[i] = s
+	}
+	return sets, true
+}
+
+func saddCommand(c *call) {
+	key := c.args[0]
+	_, s, ok := c.lookupSet(key)
+	if !ok {
+		return
+	}
+
+	if s == nil {
+		s = newSet(c.args[1], len(c.args)-1)
+		c.add(key, s)
+	}
+
+	added := 0
+	for _, member := range c.args[1:] {
+		if s.add(member) {
+			added++
+		}
+	}
+
+	if added > 0 {
+		c.touch(key)
+		c.notify(keyspace.Set, "sadd", key)
+	}
+	c.reply(radio.Integer(added))
+}
+
+func sremCommand(c *call) {
+	key := c.args[0]
+	_, s, ok := c.lookupSet(key)
+	if !ok {
+		return
+	}
+
+	if s == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	removed := 0
+	for _, member := range c.args[1:] {
+		if s.remove(member) {
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		c.touch(key)
+		c.notify(keyspace.Set, "srem", key)
+		c.removeIfEmpty(key, s.len())
+	}
+	c.reply(radio.Integer(removed))
+}
+
+func scardCommand(c *call) {
+	_, s, ok := c.lookupSet(c.args[0])
+	if !ok {
+		return
+	}
+
+	if s == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+	c.reply(radio.Integer(s.len()))
+}
+
+func sismemberCommand(c *call) {
+	_, s, ok := c.lookupSet(c.args[0])
+	if !ok {
+		return
+	}
+
+	results := make([]radio.Value, len(c.args)-1)
+	for i, member := range c.args[1:] {
+		if s != nil && s.has(member) {
+			results[i] = radio.Integer(1)
+		} else {
+			results[i] = radio.Integer(0)
+		}
+	}
+
+	if c.name == "sismember" {
+		c.reply(results[0])
+	} else {
+		c.reply(&radio.Array{Items: results})
+	}
+}
+
+func smembersCommand(c *call) {
+	_, s, ok := c.lookupSet(c.args[0])
+	if !ok {
+		return
+	}
+	c.reply(bulkArray(members(s)))
+}
+
+// members returns the members of the set which may be nil.
+func members(s *set) []string {
+	if s == nil {
+		return nil
+	}
+
+	members := make([]string, 0, s.len())
+	s.each(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+// parseCount parses the optional count argument of SPOP, SRANDMEMBER and
+// HRANDFIELD. withCount is false if there is no count argument.
+func (c *call) parseCount(args []string) (count int64, withCount, ok bool) {
+	if len(args) == 0 {
+		return 0, false, true
+	}
+
+	if len(args) > 1 {
+		c.reply(errSyntax)
+		return 0, false, false
+	}
+
+	n, ok := parseInt(args[0])
+	if !ok {
+		c.reply(errNotInteger)
+		return 0, false, false
+	}
+
+	if c.name == "spop" && n < 0 {
+		c.reply(radio.ErrorStr("ERR value is out of range, must be positive"))
+		return 0, false, false
+	}
+
+	if n < -math.MaxInt64/2 {
+		c.reply(radio.ErrorStr("ERR value is out of range"))
+		return 0, false, false
+	}
+	return n, true, true
+}
+
+func spopCommand(c *call) {
+	count, withCount, ok := c.parseCount(c.args[1:])
+	if !ok {
+		return
+	}
+
+	key := c.args[0]
+	_, s, ok := c.lookupSet(key)
+	if !ok {
+		return
+	}
+
+	if s == nil {
+		if withCount {
+			c.reply(bulkArray(nil))
+		} else {
+			c.reply(nullBulk)
+		}
+		return
+	}
+
+	if !withCount {
+		count = 1
+	}
+
+	var popped []string
+	if count >= int64(s.len()) {
+		popped = members(s)
+	} else {
+		popped = sample(s, int(count))
+	}
+
+	if len(popped) > 0 {
+		for _, member := range popped {
+			s.remove(member)
+		}
+
+		c.touch(key)
+		c.notify(keyspace.Set, "spop", key)
+		c.removeIfEmpty(key, s.len())
+	}
+
+	if withCount {
+		c.reply(bulkArray(popped))
+	} else {
+		c.reply(bulkStr(popped[0]))
+	}
+}
+
+func srandmemberCommand(c *call) {
+	count, withCount, ok := c.parseCount(c.args[1:])
+	if !ok {
+		return
+	}
+
+	_, s, ok := c.lookupSet(c.args[0])
+	if !ok {
+		return
+	}
+
+	switch {
+	case !withCount && s == nil:
+		c.reply(nullBulk)
+
+	case !withCount:
+		c.reply(bulkStr(s.random()))
+
+	case s == nil || count == 0:
+		c.reply(bulkArray(nil))
+
+	case count < 0:
+		picked := make([]string, -count)
+		for i := range picked {
+			picked[i] = s.random()
+		}
+		c.reply(bulkArray(picked))
+
+	case count >= int64(s.len()):
+		c.reply(bulkArray(members(s)))
+
+	default:
+		c.reply(bulkArray(sample(s, int(count))))
+	}
+}
+
+// sample returns n distinct random members of the set which must have more
+// than n members.
+func sample(s *set, n int) []string {
+	if n*3 > s.len() {
+		// picking randomly would mostly find the members already picked.
+		all := members(s)
+		for i := 0; i < n; i++ {
+			j := i + rand.Intn(len(all)-i)
+			all[i], all[j] = all[j], all[i]
+		}
+		return all[:n]
+	}
+
+	picked := make(map[string]bool, n)
+	result := make([]string, 0, n)
+	for len(result) < n {
+		member := s.random()
+		if !picked[member] {
+			picked[member] = true
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+func sscanCommand(c *call) {
+	opts, ok := c.parseScan(c.args[1:])
+	if !ok {
+		return
+	}
+
+	_, s, ok := c.lookupSet(c.args[0])
+	if !ok {
+		return
+	}
+
+	var matched []string
+	var next uint64
+	if s != nil {
+		visited := int64(0)
+		next = s.scan(opts.cursor, func(member string) bool {
+			visited++
+			if opts.pattern == "" || radio.MatchPattern(opts.pattern, member) {
+				matched = append(matched, member)
+			}
+			return s.enc != encHashtable || visited < opts.count
+		})
+	}
+
+	c.reply(&radio.Array{Items: []radio.Value{
+		bulkStr(strconv.FormatUint(next, 10)),
+		bulkArray(matched),
+	}})
+}
+
+func smoveCommand(c *call) {
+	src, dst, member := c.args[0], c.args[1], c.args[2]
+	_, ss, ok := c.lookupSet(src)
+	if !ok {
+		return
+	}
+
+	_, ds, ok := c.lookupSet(dst)
+	if !ok {
+		return
+	}
+
+	if ss == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	if src == dst {
+		if ss.has(member) {
+			c.reply(radio.Integer(1))
+		} else {
+			c.reply(radio.Integer(0))
+		}
+		return
+	}
+
+	if !ss.remove(member) {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	c.touch(src)
+	c.notify(keyspace.Set, "srem", src)
+	c.removeIfEmpty(src, ss.len())
+
+	if ds == nil {
+		ds = newSet(member, 1)
+		c.add(dst, ds)
+	}
+
+	if ds.add(member) {
+		c.touch(dst)
+		c.notify(keyspace.Set, "sadd", dst)
+	}
+	c.reply(radio.Integer(1))
+}
+
+func setopCommand(c *call) {
+	keys := c.args
+	store := strings.HasSuffix(c.name, "store")
+	if store {
+		keys = c.args[1:]
+	}
+
+	sets, ok := c.lookupSets(keys)
+	if !ok {
+		return
+	}
+
+	var result []string
+	switch strings.TrimSuffix(c.name, "store") {
+	case "sinter":
+		result = intersect(sets, 0)
+	case "sunion":
+		result = union(sets)
+	case "sdiff":
+		result = diff(sets)
+	}
+
+	if !store {
+		c.reply(bulkArray(result))
+		return
+	}
+
+	dst := c.args[0]
+	if len(result) == 0 {
+		if c.delete(dst) {
+			c.notify(keyspace.Generic, "del", dst)
+		}
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	s := newSet(result[0], len(result))
+	for _, member := range result {
+		s.add(member)
+	}
+
+	c.set(dst, s, false)
+	c.touch(dst)
+	c.notify(keyspace.Set, c.name, dst)
+	c.reply(radio.Integer(len(result)))
+}
+
+// intersect returns the members of the intersection of the sets in the
+// order of the smallest set. limit, if not zero, limits the number of
+// members returned.
+func intersect(sets []*set, limit int) []string {
+	for _, s := range sets {
+		if s == nil {
+			return nil
+		}
+	}
+
+	sorted := append([]*set(nil), sets...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].len() < sorted[j].len()
+	})
+
+	var result []string
+	sorted[0].each(func(member string) bool {
+		for _, other := range sorted[1:] {
+			if !other.has(member) {
+				return true
+			}
+		}
+
+		result = append(result, member)
+		return limit == 0 || len(result) < limit
+	})
+	return result
+}
+
+// union returns the members of the union of the sets in the order they are
+// found.
+func union(sets []*set) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, s := range sets {
+		if s == nil {
+			continue
+		}
+
+		s.each(func(member string) bool {
+			if !seen[member] {
+				seen[member] = true
+				result = append(result, member)
+			}
+			return true
+		})
+	}
+	return result
+}
+
+// diff returns the members of the first set that are not members of the
+// other sets.
+func diff(sets []*set) []string {
+	if sets[0] == nil {
+		return nil
+	}
+
+	var result []string
+	sets[0].each(func(member string) bool {
+		for _, other := range sets[1:] {
+			if other != nil && other.has(member) {
+				return true
+			}
+		}
+
+		result = append(result, member)
+		return true
+	})
+	return result
+}
+
+func sintercardCommand(c *call) {
+	numKeys, ok := parseInt(c.args[0])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	if numKeys <= 0 {
+		c.reply(radio.ErrorStr("ERR numkeys should be greater than 0"))
+		return
+	}
+
+	if numKeys > int64(len(c.args)-1) {
+		c.reply(radio.ErrorStr("ERR Number of keys can't be greater than number of args"))
+		return
+	}
+
+	keys, rest := c.args[1:1+numKeys], c.args[1+numKeys:]
+	limit := int64(0)
+	if len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(rest[0], "limit") {
+			c.reply(errSyntax)
+			return
+		}
+
+		n, ok := parseInt(rest[1])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+		if n < 0 {
+			c.reply(radio.ErrorStr("ERR LIMIT can't be negative"))
+			return
+		}
+		limit = n
+	}
+
+	sets, ok := c.lookupSets(keys)
+	if !ok {
+		return
+	}
+
+	if limit > math.MaxInt32 {
+		limit = 0
+	}
+	c.reply(radio.Integer(len(intersect(sets, int(limit)))))
+}
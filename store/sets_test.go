@@ -0,0 +1,192 @@
+package store_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/spy16/radio"
+)
+
+func TestSets(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "SADD",
+			steps: []step{
+				{cmd: "SADD s c a b a", reply: radio.Integer(3)},
+				{cmd: "SADD s a d", reply: radio.Integer(1)},
+				{cmd: "TYPE s", reply: radio.SimpleStr("set")},
+				{cmd: "SCARD s", reply: radio.Integer(4)},
+				{cmd: "SCARD x", reply: radio.Integer(0)},
+				{cmd: "SMEMBERS s", reply: bulks("c", "a", "b", "d")},
+				{cmd: "SMEMBERS x", reply: bulks()},
+				{cmd: "SISMEMBER s a", reply: radio.Integer(1)},
+				{cmd: "SISMEMBER s x", reply: radio.Integer(0)},
+				{cmd: "SMISMEMBER s a x d", reply: ints(1, 0, 1)},
+				{cmd: "SREM s a x", reply: radio.Integer(1)},
+				{cmd: "SREM s b c d", reply: radio.Integer(3)},
+				{cmd: "EXISTS s", reply: radio.Integer(0)},
+				{cmd: "SET str 1", reply: okReply},
+				{cmd: "SADD str a", reply: wrongType},
+				{cmd: "SMEMBERS str", reply: wrongType},
+			},
+		},
+		{
+			title: "Random",
+			steps: []step{
+				{cmd: "SPOP s", reply: nullBulk},
+				{cmd: "SPOP s 2", reply: bulks()},
+				{cmd: "SRANDMEMBER s", reply: nullBulk},
+				{cmd: "SRANDMEMBER s 2", reply: bulks()},
+				{cmd: "SADD s 1", reply: radio.Integer(1)},
+				{cmd: "SRANDMEMBER s", reply: bulk("1")},
+				{cmd: "SRANDMEMBER s 5", reply: bulks("1")},
+				{cmd: "SRANDMEMBER s -3", reply: bulks("1", "1", "1")},
+				{cmd: "SRANDMEMBER s 0", reply: bulks()},
+				{cmd: "SPOP s -1", reply: radio.ErrorStr("ERR value is out of range, must be positive")},
+				{cmd: "SPOP s 0", reply: bulks()},
+				{cmd: "SPOP s", reply: bulk("1")},
+				{cmd: "EXISTS s", reply: radio.Integer(0)},
+				{cmd: "SADD s 1 2 3", reply: radio.Integer(3)},
+				{cmd: "SPOP s 10", reply: bulks("1", "2", "3")},
+				{cmd: "EXISTS s", reply: radio.Integer(0)},
+			},
+		},
+		{
+			title: "SMOVE",
+			steps: []step{
+				{cmd: "SADD a 1 2", reply: radio.Integer(2)},
+				{cmd: "SMOVE a b 1", reply: radio.Integer(1)},
+				{cmd: "SMOVE a b 3", reply: radio.Integer(0)},
+				{cmd: "SMOVE a a 2", reply: radio.Integer(1)},
+				{cmd: "SMOVE x b 2", reply: radio.Integer(0)},
+				{cmd: "SMOVE a b 2", reply: radio.Integer(1)},
+				{cmd: "EXISTS a", reply: radio.Integer(0)},
+				{cmd: "SMEMBERS b", reply: bulks("1", "2")},
+				{cmd: "SET str 1", reply: okReply},
+				{cmd: "SMOVE b str 1", reply: wrongType},
+			},
+		},
+		{
+			title: "Algebra",
+			steps: []step{
+				{cmd: "SADD a 1 2 3 4", reply: radio.Integer(4)},
+				{cmd: "SADD b 3 4 5", reply: radio.Integer(3)},
+				{cmd: "SADD c 4 6", reply: radio.Integer(2)},
+				{cmd: "SINTER a b", reply: bulks("3", "4")},
+				{cmd: "SINTER a b c", reply: bulks("4")},
+				{cmd: "SINTER a x", reply: bulks()},
+				{cmd: "SUNION a b x c", reply: bulks("1", "2", "3", "4", "5", "6")},
+				{cmd: "SDIFF a b x c", reply: bulks("1", "2")},
+				{cmd: "SDIFF x a", reply: bulks()},
+				{cmd: "SINTERSTORE d a b", reply: radio.Integer(2)},
+				{cmd: "SMEMBERS d", reply: bulks("3", "4")},
+				{cmd: "SUNIONSTORE d d c", reply: radio.Integer(3)},
+				{cmd: "SMEMBERS d", reply: bulks("3", "4", "6")},
+				{cmd: "SDIFFSTORE d a a", reply: radio.Integer(0)},
+				{cmd: "EXISTS d", reply: radio.Integer(0)},
+				{cmd: "SET str 1", reply: okReply},
+				{cmd: "SINTER a str", reply: wrongType},
+				{cmd: "SUNIONSTORE str a", reply: radio.Integer(4)},
+				{cmd: "TYPE str", reply: radio.SimpleStr("set")},
+				{cmd: "SINTERCARD 2 a b", reply: radio.Integer(2)},
+				{cmd: "SINTERCARD 2 a b LIMIT 1", reply: radio.Integer(1)},
+				{cmd: "SINTERCARD 1 a LIMIT 0", reply: radio.Integer(4)},
+				{cmd: "SINTERCARD 0 a", reply: radio.ErrorStr("ERR numkeys should be greater than 0")},
+				{cmd: "SINTERCARD 3 a b", reply: radio.ErrorStr("ERR Number of keys can't be greater than number of args")},
+				{cmd: "SINTERCARD 1 a LIMIT -1", reply: radio.ErrorStr("ERR LIMIT can't be negative")},
+				{cmd: "SINTERCARD 1 a FOO 1", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "SSCAN",
+			steps: []step{
+				{cmd: "SADD s a1 a2 b1", reply: radio.Integer(3)},
+				{cmd: "SSCAN s 0", reply: scanReply("0", "a1", "a2", "b1")},
+				{cmd: "SSCAN s 0 MATCH a* COUNT 1", reply: scanReply("0", "a1", "a2")},
+				{cmd: "SSCAN s 0 NOVALUES", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "SSCAN x 0", reply: scanReply("0")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(nil)
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestSets_Encoding(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	runSteps(t, s, []step{
+		{cmd: "SADD ints 3 1 2", reply: radio.Integer(3)},
+		{cmd: "OBJECT ENCODING ints", reply: bulk("intset")},
+		{cmd: "SMEMBERS ints", reply: bulks("1", "2", "3")},
+		{cmd: "SADD ints a", reply: radio.Integer(1)},
+		{cmd: "OBJECT ENCODING ints", reply: bulk("listpack")},
+		{cmd: "SMEMBERS ints", reply: bulks("1", "2", "3", "a")},
+		{cmd: "SADD strs 01", reply: radio.Integer(1)},
+		{cmd: "OBJECT ENCODING strs", reply: bulk("listpack")},
+	})
+
+	for i := 0; i < 513; i++ {
+		s.Do(0, "SADD", "bigints", strconv.Itoa(i))
+		if i == 511 {
+			runSteps(t, s, []step{{cmd: "OBJECT ENCODING bigints", reply: bulk("intset")}})
+		}
+	}
+
+	for i := 0; i < 129; i++ {
+		s.Do(0, "SADD", "bigstrs", "m"+strconv.Itoa(i))
+		if i == 127 {
+			runSteps(t, s, []step{{cmd: "OBJECT ENCODING bigstrs", reply: bulk("listpack")}})
+		}
+	}
+
+	runSteps(t, s, []step{
+		{cmd: "OBJECT ENCODING bigints", reply: bulk("hashtable")},
+		{cmd: "OBJECT ENCODING bigstrs", reply: bulk("hashtable")},
+		{cmd: "SCARD bigints", reply: radio.Integer(513)},
+		{cmd: "SISMEMBER bigints 512", reply: radio.Integer(1)},
+		{cmd: "SREM bigstrs m0", reply: radio.Integer(1)},
+		{cmd: "SCARD bigstrs", reply: radio.Integer(128)},
+		{cmd: "OBJECT ENCODING x", reply: nullBulk},
+		{cmd: "OBJECT FOO x", reply: radio.ErrorStr("ERR unknown subcommand 'FOO'. Try OBJECT HELP.")},
+	})
+
+	members := map[string]bool{}
+	cursor := "0"
+	for {
+		reply := s.Do(0, "SSCAN", "bigints", cursor, "COUNT", "100").(*radio.Array)
+		cursor = reply.Items[0].(*radio.BulkStr).String()
+		for _, m := range reply.Items[1].(*radio.Array).Items {
+			members[m.(*radio.BulkStr).String()] = true
+		}
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if len(members) != 513 {
+		t.Errorf("expecting SSCAN to return all the 513 members, got %d", len(members))
+	}
+
+	popped := s.Do(0, "SPOP", "bigints", "10").(*radio.Array)
+	if len(popped.Items) != 10 {
+		t.Errorf("expecting 10 popped members, got %d", len(popped.Items))
+	}
+	if v := s.Do(0, "SCARD", "bigints"); v != radio.Integer(503) {
+		t.Errorf("expecting 503 members after SPOP, got %v", v)
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import "math/rand"
+
+const (
+	// skiplistMaxLevel is the maximum level of the skiplist nodes, enough
+	// for 2^64 elements.
+	skiplistMaxLevel = 32
+
+	// skiplistP is the probability of a node having an additional level.
+	skiplistP = 0.25
+)
+
+// skiplist is the skiplist of sorted sets as described in William Pugh's
+// paper and implemented by Redis: the nodes are sorted by score and then by
+// member, every level keeps the span of its links to find the rank of an
+// element and the nodes link back to walk the list in reverse.
+type skiplist struct {
+	header *skipnode
+	tail   *skipnode
+	length int
+	level  int
+}
+
+type skipnode struct {
+	member   string
+	score    float64
+	backward *skipnode
+	levels   []skiplevel
+}
+
+type skiplevel struct {
+	forward *skipnode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skipnode{levels: make([]skiplevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+// before reports whether the node sorts before the element.
+func (n *skipnode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// insert inserts the element which must not exist.
+func (sl *skiplist) insert(score float64, member string) {
+	var update [skiplistMaxLevel]*skipnode
+	var rank [skiplistMaxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i != sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+
+		for x.levels[i].forward != nil && x.levels[i].forward.before(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skipnode{member: member, score: score, levels: make([]skiplevel, level)}
+	for i := 0; i < level; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// the untouched levels span the new node too.
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete deletes the element and returns true if it exists.
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skipnode
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.before(score, member) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+
+	for sl.level > 1 && sl.header.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// byRank returns the node at the 1-based rank or nil if the rank is out of
+// range.
+func (sl *skiplist) byRank(rank int) *skipnode {
+	x := sl.header
+	traversed := 0
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+
+		if traversed == rank && x != sl.header {
+			return x
+		}
+	}
+	return nil
+}
+
+// countWhile returns the number of leading nodes for which pred is true.
+// pred must be true for a prefix of the nodes and false for the rest.
+func (sl *skiplist) countWhile(pred func(n *skipnode) bool) int {
+	x := sl.header
+	n := 0
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && pred(x.levels[i].forward) {
+			n += x.levels[i].span
+			x = x.levels[i].forward
+		}
+	}
+	return n
+}
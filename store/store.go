@@ -0,0 +1,426 @@
+// Package store provides an embeddable, concurrency-safe, in-memory keyspace
+// compatible with Redis along with a Handler serving it. Store supports
+// multiple databases, keys with expiry and string, hash, list, set, sorted
+// set and stream values along with the generic key commands. Strings can be
+// used as bitmaps and as HyperLogLogs and sorted sets as geospatial indexes
+// in the same format as Redis. Small collections use compact encodings
+// similar to Redis. Expired keys (and hash fields) are removed when they are
+// accessed and by a periodic active expiry cycle. The memory used by the keys
+// is estimated so that keys can be evicted with the policies of Redis when it
+// exceeds a limit.
+package store
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/internal/reply"
+	"github.com/spy16/radio/keyspace"
+	"github.com/spy16/radio/tracking"
+)
+
+// Options control the behaviour of the store. Zero values are replaced with
+// defaults.
+type Options struct {
+	// Databases is the number of databases. Defaults to 16.
+	Databases int
+
+	// ExpiryInterval is the interval of the active expiry cycle which
+	// removes expired keys that are not accessed. Defaults to 100ms and a
+	// negative value disables the active expiry.
+	ExpiryInterval time.Duration
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	// Notifier, if set, is notified of the keyspace events.
+	Notifier *keyspace.Notifier
+
+	// Tracker, if set, is used to track the keys read by the clients and to
+	// invalidate the modified keys.
+	Tracker *tracking.Tracker
+
+	// Blocker, if set, is used to block the clients executing blocking
+	// commands (e.g., BLPOP). Without a Blocker, blocking commands time out
+	// immediately if none of the keys is ready. The Blocker must wrap the
+	// Handler of the store to serve CLIENT UNBLOCK.
+	Blocker *radio.Blocker
+
+	// MaxMemory is the limit in bytes of the memory used by the keys as
+	// estimated by the store. Keys are evicted according to MaxMemoryPolicy
+	// before executing the commands when the memory used exceeds the limit,
+	// and the commands that may use more memory are rejected if it still
+	// exceeds it. Zero disables the limit.
+	MaxMemory int64
+
+	// MaxMemoryPolicy is the eviction policy. Defaults to NoEviction, which
+	// also replaces unknown policies.
+	MaxMemoryPolicy EvictionPolicy
+
+	// MaxMemorySamples is the number of keys of every database sampled to
+	// select the keys to evict. Defaults to 5.
+	MaxMemorySamples int
+}
+
+// Store is an in-memory keyspace. Commands are executed one at a time so
+// that every command is atomic. Close must be called to stop the active
+// expiry cycle.
+type Store struct {
+	opts Options
+
+	mu  sync.Mutex
+	dbs []*db
+
+	// pool is the eviction pool and nextDB the database the next random
+	// eviction starts from.
+	pool   []evictionCandidate
+	nextDB int
+
+	// effects serializes the side effects (e.g., notifications) of the
+	// commands in the order the commands were executed.
+	effects sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New initializes a store with the options and starts the active expiry
+// cycle.
+func New(opts Options) *Store {
+	if opts.Databases <= 0 {
+		opts.Databases = 16
+	}
+	if opts.ExpiryInterval == 0 {
+		opts.ExpiryInterval = 100 * time.Millisecond
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if !opts.MaxMemoryPolicy.valid() {
+		opts.MaxMemoryPolicy = NoEviction
+	}
+	if opts.MaxMemorySamples <= 0 {
+		opts.MaxMemorySamples = 5
+	}
+
+	s := &Store{
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	for i := 0; i < opts.Databases; i++ {
+		s.dbs = append(s.dbs, newDB(i))
+	}
+
+	if opts.ExpiryInterval > 0 {
+		go s.expireCycle()
+	} else {
+		close(s.done)
+	}
+
+	return s
+}
+
+// Close stops the active expiry cycle. The store can still be used.
+func (s *Store) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+
+	<-s.done
+	return nil
+}
+
+// Do executes the command on the database and returns the reply. Do is
+// useful for accessing the store directly (e.g., to set up or inspect the
+// data in tests).
+func (s *Store) Do(db int, cmd string, args ...string) radio.Value {
+	if db < 0 || db >= len(s.dbs) {
+		return errDBIndex
+	}
+
+	rec := &reply.Recorder{}
+	req := &radio.Request{Command: cmd, Args: args}
+	if !s.exec(rec, req, db) {
+		return unknownCommand(req)
+	}
+
+	vals := rec.Values()
+	if len(vals) == 0 {
+		return nil
+	}
+	return vals[len(vals)-1]
+}
+
+// exec executes the request on the database. Returns false if the command
+// is not known.
+func (s *Store) exec(wr radio.ResponseWriter, req *radio.Request, db int) bool {
+	name := strings.ToLower(req.Command)
+	cmd, found := commands[name]
+	if !found {
+		return false
+	}
+
+	if (cmd.arity > 0 && len(req.Args)+1 != cmd.arity) || (cmd.arity < 0 && len(req.Args)+1 < -cmd.arity) {
+		wr.Write(errArity(name))
+		return true
+	}
+
+	c := &call{
+		s:    s,
+		wr:   wr,
+		req:  req,
+		name: name,
+		args: req.Args,
+	}
+
+	s.mu.Lock()
+	c.db = s.dbs[db]
+	c.now = s.now()
+	if !c.evict() && cmd.flags&flagDenyOOM != 0 {
+		c.reply(errOOM)
+	} else {
+		cmd.fn(c)
+	}
+	s.finish(c, cmd.flags&flagReadOnly != 0)
+
+	return true
+}
+
+// resume serves the request blocked on the key once the key is signalled
+// ready. Returns false if the request could not be served.
+func (s *Store) resume(wr radio.ResponseWriter, req *radio.Request, name string, db int, key string, serve func(c *call, key string) bool) bool {
+	c := &call{
+		s:    s,
+		wr:   wr,
+		req:  req,
+		name: name,
+		args: req.Args,
+	}
+
+	s.mu.Lock()
+	c.db = s.dbs[db]
+	c.now = s.now()
+	served := serve(c, key)
+	s.finish(c, false)
+
+	return served
+}
+
+// finish updates the memory used by the modified keys, unlocks the store and
+// applies the side effects of the call. The store must be locked.
+func (s *Store) finish(c *call, readOnly bool) {
+	c.account()
+	s.effects.Lock()
+	s.mu.Unlock()
+	c.applyEffects(readOnly)
+	s.effects.Unlock()
+
+	if s.opts.Blocker != nil {
+		for _, key := range c.ready {
+			s.opts.Blocker.Ready(readyKey(c.db.id, key))
+		}
+	}
+}
+
+func (s *Store) now() int64 {
+	return s.opts.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// call is the execution context of a command. Commands collect the side
+// effects of the execution which are applied once the store is unlocked.
+type call struct {
+	s    *Store
+	db   *db
+	wr   radio.ResponseWriter
+	req  *radio.Request
+	name string
+	args []string
+	now  int64
+
+	events   []event
+	modified []string
+	ready    []string
+	flushed  bool
+}
+
+type event struct {
+	class keyspace.Flags
+	name  string
+	key   string
+	db    int
+}
+
+// reply writes the reply of the command.
+func (c *call) reply(v radio.Value) {
+	c.wr.Write(v)
+}
+
+// lookup returns the entry of the key removing it if it is expired and
+// records the access for the eviction policies.
+func (c *call) lookup(key string) *entry {
+	e := c.peek(key)
+	if e != nil {
+		c.access(e)
+	}
+	return e
+}
+
+// peek returns the entry of the key removing it if it is expired without
+// recording the access.
+func (c *call) peek(key string) *entry {
+	e := c.db.get(key)
+	if e == nil {
+		return nil
+	}
+
+	if e.expireAt != 0 && e.expireAt <= c.now {
+		c.expired(c.db, e)
+		return nil
+	}
+
+	return e
+}
+
+// lookupType returns the entry of the key if it holds a value of the same
+// type as typ. If the key holds a different type, a WRONGTYPE error is
+// written and ok is false.
+func (c *call) lookupType(key string, typ string) (e *entry, ok bool) {
+	e = c.lookup(key)
+	if e != nil && typeOf(e.val) != typ {
+		c.reply(errWrongType)
+		return nil, false
+	}
+
+	return e, true
+}
+
+// add adds a new key and notifies the new key event.
+func (c *call) add(key string, val interface{}) *entry {
+	e := c.db.add(key, val)
+	e.accessed = c.now
+	e.counter = lfuInitVal
+	e.decayedAt = c.now / 60000
+	c.notify(keyspace.New, "new", key)
+	return e
+}
+
+// set sets the value of the key creating it if required. The expiry is
+// removed unless keepTTL is set.
+func (c *call) set(key string, val interface{}, keepTTL bool) *entry {
+	e := c.lookup(key)
+	if e == nil {
+		return c.add(key, val)
+	}
+
+	e.val = val
+	delete(c.db.volatile, key)
+	if !keepTTL {
+		c.db.setExpire(e, 0)
+	}
+	return e
+}
+
+// delete deletes the key if it exists.
+func (c *call) delete(key string) bool {
+	if c.lookup(key) == nil {
+		return false
+	}
+
+	c.db.delete(key)
+	c.touch(key)
+	return true
+}
+
+// expired deletes the expired entry from the database.
+func (c *call) expired(d *db, e *entry) {
+	d.delete(e.key)
+	c.events = append(c.events, event{class: keyspace.Expired, name: "expired", key: e.key, db: d.id})
+	c.modified = append(c.modified, e.key)
+}
+
+// touch marks the key as modified.
+func (c *call) touch(key string) {
+	c.modified = append(c.modified, key)
+}
+
+// signal marks the key as ready for the clients blocked on it.
+func (c *call) signal(key string) {
+	c.ready = append(c.ready, key)
+}
+
+// block blocks the client until one of the keys is signalled ready and
+// serve serves the request. serve must return false without writing a reply
+// if the request cannot be served (e.g., if the key is empty again or holds
+// another type). onTimeout writes the reply when the timeout expires. The
+// request times out immediately if there is no Blocker or the request has no
+// client (e.g., when using Do).
+func (c *call) block(keys []string, timeout time.Duration, onTimeout func(wr radio.ResponseWriter), serve func(c *call, key string) bool) {
+	b := c.s.opts.Blocker
+	if b == nil || c.req.Client == nil {
+		onTimeout(c.wr)
+		return
+	}
+
+	s, req, name, db := c.s, c.req, c.name, c.db.id
+	byReadyKey := map[string]string{}
+	readyKeys := make([]string, len(keys))
+	for i, key := range keys {
+		readyKeys[i] = readyKey(db, key)
+		byReadyKey[readyKeys[i]] = key
+	}
+
+	// the store is locked, so the keys cannot be signalled before the
+	// request is parked.
+	b.Block(c.wr, c.req, radio.Wait{
+		Keys:      readyKeys,
+		Timeout:   timeout,
+		OnTimeout: onTimeout,
+		Ready: func(wr radio.ResponseWriter, key string) bool {
+			return s.resume(wr, req, name, db, byReadyKey[key], serve)
+		},
+	})
+}
+
+func readyKey(db int, key string) string {
+	return strconv.Itoa(db) + ":" + key
+}
+
+// notify records the keyspace event on the key in the current database.
+func (c *call) notify(class keyspace.Flags, name, key string) {
+	c.events = append(c.events, event{class: class, name: name, key: key, db: c.db.id})
+}
+
+// applyEffects publishes the keyspace events, invalidates the modified keys
+// and tracks the keys read by the command if it is read-only.
+func (c *call) applyEffects(readOnly bool) {
+	opts := &c.s.opts
+
+	if opts.Notifier != nil {
+		for _, ev := range c.events {
+			opts.Notifier.Notify(ev.class, ev.name, ev.key, ev.db)
+		}
+	}
+
+	if opts.Tracker == nil {
+		return
+	}
+
+	if c.flushed {
+		opts.Tracker.InvalidateAll()
+	} else if len(c.modified) > 0 {
+		opts.Tracker.Invalidate(c.req, c.modified...)
+	}
+
+	if readOnly {
+		if keys, ok := radio.DefaultKeySpecs.Keys(c.req); ok && len(keys) > 0 {
+			opts.Tracker.Track(c.req, keys...)
+		}
+	}
+}
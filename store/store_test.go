@@ -0,0 +1,99 @@
+package store_test
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/store"
+)
+
+// step is a command executed on the store along with the expected reply.
+type step struct {
+	cmd   string
+	reply radio.Value
+}
+
+// runSteps executes the steps on database 0 of the store. Commands are split
+// on spaces.
+func runSteps(t *testing.T, s *store.Store, steps []step) {
+	t.Helper()
+
+	for _, st := range steps {
+		parts := strings.Split(st.cmd, " ")
+		reply := s.Do(0, parts[0], parts[1:]...)
+		if !reflect.DeepEqual(st.reply, reply) {
+			t.Errorf("%s: expecting '%#v', got '%#v'", st.cmd, st.reply, reply)
+		}
+	}
+}
+
+func newStore(clock *fakeClock) *store.Store {
+	opts := store.Options{ExpiryInterval: -1}
+	if clock != nil {
+		opts.Now = clock.Now
+	}
+	return store.New(opts)
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000000, 0)}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.now = fc.now.Add(d)
+}
+
+func bulk(s string) *radio.BulkStr {
+	return &radio.BulkStr{Value: []byte(s)}
+}
+
+func bulks(strs ...string) *radio.Array {
+	arr := &radio.Array{Items: []radio.Value{}}
+	for _, s := range strs {
+		arr.Items = append(arr.Items, bulk(s))
+	}
+	return arr
+}
+
+var (
+	okReply   = radio.SimpleStr("OK")
+	nullBulk  = &radio.BulkStr{}
+	wrongType = radio.ErrorStr("WRONGTYPE Operation against a key holding the wrong kind of value")
+)
+
+func TestStore_Do(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	runSteps(t, s, []step{
+		{cmd: "SET a 1", reply: okReply},
+		{cmd: "get a", reply: bulk("1")},
+		{cmd: "GET", reply: radio.ErrorStr("ERR wrong number of arguments for 'get' command")},
+		{cmd: "FOO a b", reply: radio.ErrorStr("ERR unknown command 'FOO', with args beginning with: 'a' 'b' ")},
+	})
+
+	if v := s.Do(16, "GET", "a"); v != radio.ErrorStr("ERR DB index is out of range") {
+		t.Errorf("expecting DB index error, got '%v'", v)
+	}
+
+	if v := s.Do(1, "GET", "a"); !reflect.DeepEqual(nullBulk, v) {
+		t.Errorf("expecting databases to be isolated, got '%v'", v)
+	}
+}
@@ -0,0 +1,538 @@
+package store
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// streamNodeMaxEntries and streamNodeMaxBytes are the limits of the
+	// nodes of streams (stream-node-max-entries and stream-node-max-bytes).
+	streamNodeMaxEntries = 100
+	streamNodeMaxBytes   = 4096
+)
+
+// streamID is the ID of a stream entry.
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{ms: math.MaxUint64, seq: math.MaxUint64}
+
+// parseStreamID parses an ID of the form ms-seq. If the sequence number is
+// omitted, it is missingSeq.
+func parseStreamID(s string, missingSeq uint64) (id streamID, ok bool) {
+	ms, seq := s, ""
+	i := strings.IndexByte(s, '-')
+	if i >= 0 {
+		ms, seq = s[:i], s[i+1:]
+	}
+
+	var err error
+	if id.ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return id, false
+	}
+
+	if i < 0 {
+		id.seq = missingSeq
+	} else if id.seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return id, false
+	}
+	return id, true
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+func (id streamID) isZero() bool {
+	return id == streamID{}
+}
+
+// next returns the smallest ID greater than the ID. ok is false if the ID is
+// the maximum ID.
+func (id streamID) next() (next streamID, ok bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{ms: id.ms, seq: id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{ms: id.ms + 1}, true
+	}
+	return id, false
+}
+
+// prev returns the greatest ID less than the ID. ok is false if the ID is
+// zero.
+func (id streamID) prev() (prev streamID, ok bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{ms: id.ms, seq: id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{ms: id.ms - 1, seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// stream is a stream value. Like Redis, which keeps the entries in
+// listpacks indexed by a radix tree of their first IDs, the entries are
+// kept in nodes of limited size indexed by a sorted slice. Deleted entries
+// are only flagged and a node is removed once all of its entries are
+// deleted.
+type stream struct {
+	nodes        []*streamNode
+	length       int
+	lastID       streamID
+	maxDeletedID streamID
+	entriesAdded int64
+	groups       map[string]*streamGroup
+}
+
+type streamNode struct {
+	entries []streamEntry
+	live    int
+	size    int
+}
+
+type streamEntry struct {
+	id      streamID
+	fields  []string
+	deleted bool
+}
+
+// streamPos is the position of an entry in the nodes of a stream.
+type streamPos struct {
+	node, entry int
+}
+
+func newStream() *stream {
+	return &stream{groups: map[string]*streamGroup{}}
+}
+
+// add appends the entry. The ID must be greater than the last ID.
+func (s *stream) add(id streamID, fields []string) {
+	size := 0
+	for _, f := range fields {
+		size += len(f) + 2
+	}
+
+	var n *streamNode
+	if len(s.nodes) > 0 {
+		n = s.nodes[len(s.nodes)-1]
+	}
+
+	if n == nil || len(n.entries) >= streamNodeMaxEntries || n.size+size > streamNodeMaxBytes {
+		n = &streamNode{}
+		s.nodes = append(s.nodes, n)
+	}
+
+	n.entries = append(n.entries, streamEntry{id: id, fields: fields})
+	n.live++
+	n.size += size
+
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// search returns the position of the first entry, possibly deleted, with
+// an ID greater than or equal to the ID, or greater than the ID if after is
+// set. The position is past the last node if there is no such entry.
+func (s *stream) search(id streamID, after bool) streamPos {
+	found := func(e streamEntry) bool {
+		if after {
+			return id.less(e.id)
+		}
+		return !e.id.less(id)
+	}
+
+	// the first node with an entry past the ID.
+	ni := sort.Search(len(s.nodes), func(i int) bool {
+		entries := s.nodes[i].entries
+		return found(entries[len(entries)-1])
+	})
+	if ni == len(s.nodes) {
+		return streamPos{node: ni}
+	}
+
+	entries := s.nodes[ni].entries
+	ei := sort.Search(len(entries), func(i int) bool {
+		return found(entries[i])
+	})
+	return streamPos{node: ni, entry: ei}
+}
+
+func (s *stream) at(pos streamPos) *streamEntry {
+	if pos.node < 0 || pos.node >= len(s.nodes) {
+		return nil
+	}
+	return &s.nodes[pos.node].entries[pos.entry]
+}
+
+func (s *stream) step(pos streamPos, rev bool) streamPos {
+	if rev {
+		pos.entry--
+		if pos.entry < 0 {
+			pos.node--
+			if pos.node >= 0 {
+				pos.entry = len(s.nodes[pos.node].entries) - 1
+			}
+		}
+		return pos
+	}
+
+	pos.entry++
+	if pos.entry == len(s.nodes[pos.node].entries) {
+		pos.node++
+		pos.entry = 0
+	}
+	return pos
+}
+
+// each invokes fn for the entries with IDs within [start, end], in
+// ascending order or in descending order if rev is set, until fn returns
+// false.
+func (s *stream) each(start, end streamID, rev bool, fn func(e *streamEntry) bool) {
+	pos := s.search(start, false)
+	if rev {
+		pos = s.step(s.search(end, true), true)
+	}
+
+	for e := s.at(pos); e != nil; e = s.at(pos) {
+		if (!rev && end.less(e.id)) || (rev && e.id.less(start)) {
+			return
+		}
+
+		if !e.deleted && !fn(e) {
+			return
+		}
+		pos = s.step(pos, rev)
+	}
+}
+
+// entries returns up to count (zero for all) entries with IDs within
+// [start, end].
+func (s *stream) entries(start, end streamID, rev bool, count int64) []*streamEntry {
+	var entries []*streamEntry
+	s.each(start, end, rev, func(e *streamEntry) bool {
+		entries = append(entries, e)
+		return count == 0 || int64(len(entries)) < count
+	})
+	return entries
+}
+
+// after returns up to count (zero for all) entries with IDs greater than
+// the ID.
+func (s *stream) after(id streamID, count int64) []*streamEntry {
+	start, ok := id.next()
+	if !ok {
+		return nil
+	}
+	return s.entries(start, maxStreamID, false, count)
+}
+
+// get returns the entry with the ID or nil if there is no such entry.
+func (s *stream) get(id streamID) *streamEntry {
+	e := s.at(s.search(id, false))
+	if e == nil || e.id != id || e.deleted {
+		return nil
+	}
+	return e
+}
+
+// first returns the first entry or nil if the stream is empty.
+func (s *stream) first() *streamEntry {
+	entries := s.entries(streamID{}, maxStreamID, false, 1)
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[0]
+}
+
+// last returns the last entry or nil if the stream is empty.
+func (s *stream) last() *streamEntry {
+	entries := s.entries(streamID{}, maxStreamID, true, 1)
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[0]
+}
+
+// firstID returns the ID of the first entry or zero if the stream is empty.
+func (s *stream) firstID() streamID {
+	if e := s.first(); e != nil {
+		return e.id
+	}
+	return streamID{}
+}
+
+// delete deletes the entry and returns true if it exists.
+func (s *stream) delete(id streamID) bool {
+	pos := s.search(id, false)
+	e := s.at(pos)
+	if e == nil || e.id != id || e.deleted {
+		return false
+	}
+
+	s.remove(pos)
+	if s.maxDeletedID.less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+func (s *stream) remove(pos streamPos) {
+	n := s.nodes[pos.node]
+	n.entries[pos.entry].deleted = true
+	n.live--
+	s.length--
+
+	if n.live == 0 {
+		s.nodes = append(s.nodes[:pos.node], s.nodes[pos.node+1:]...)
+	}
+}
+
+// trimOptions are the MAXLEN and MINID options of XADD and XTRIM.
+type trimOptions struct {
+	enabled bool
+	byMinID bool
+	maxLen  int64
+	minID   streamID
+	approx  bool
+	limit   int64
+}
+
+// trim trims the stream and returns the number of entries removed. Like
+// Redis, approximate trimming only removes whole nodes.
+func (s *stream) trim(opts trimOptions) int {
+	// keep reports whether the entry (or the node ending with the entry)
+	// must be kept when length entries would remain.
+	keep := func(e *streamEntry, length int) bool {
+		if opts.byMinID {
+			return !e.id.less(opts.minID)
+		}
+		return int64(length) < opts.maxLen
+	}
+
+	removed := 0
+	for len(s.nodes) > 0 {
+		n := s.nodes[0]
+		if opts.approx {
+			if opts.limit > 0 && int64(removed+n.live) > opts.limit {
+				break
+			}
+
+			if keep(&n.entries[len(n.entries)-1], s.length-n.live) {
+				break
+			}
+
+			s.nodes = s.nodes[1:]
+			s.length -= n.live
+			removed += n.live
+			continue
+		}
+
+		pos := streamPos{}
+		for n.entries[pos.entry].deleted {
+			pos.entry++
+		}
+
+		if keep(&n.entries[pos.entry], s.length-1) {
+			break
+		}
+
+		s.remove(pos)
+		removed++
+	}
+	return removed
+}
+
+// streamGroup is a consumer group of a stream.
+type streamGroup struct {
+	name        string
+	lastID      streamID
+	entriesRead int64 // -1 if unknown
+	pending     []*pendingEntry
+	consumers   map[string]*streamConsumer
+}
+
+// pendingEntry is an entry delivered to a consumer of a group and not yet
+// acknowledged.
+type pendingEntry struct {
+	id            streamID
+	consumer      *streamConsumer
+	deliveryTime  int64
+	deliveryCount int64
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64
+	activeTime int64 // -1 if the consumer never read or claimed an entry
+	pending    map[streamID]*pendingEntry
+}
+
+func newStreamGroup(name string, lastID streamID, entriesRead int64) *streamGroup {
+	return &streamGroup{
+		name:        name,
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		consumers:   map[string]*streamConsumer{},
+	}
+}
+
+// consumer returns the consumer of the group, creating it if create is
+// set. created is true if the consumer is created.
+func (g *streamGroup) consumer(name string, create bool, now int64) (cons *streamConsumer, created bool) {
+	cons = g.consumers[name]
+	if cons == nil && create {
+		cons = &streamConsumer{
+			name:       name,
+			seenTime:   now,
+			activeTime: -1,
+			pending:    map[streamID]*pendingEntry{},
+		}
+		g.consumers[name] = cons
+		created = true
+	}
+	return cons, created
+}
+
+// search returns the index of the first pending entry with an ID greater
+// than or equal to the ID.
+func (g *streamGroup) search(id streamID) int {
+	return sort.Search(len(g.pending), func(i int) bool {
+		return !g.pending[i].id.less(id)
+	})
+}
+
+func (g *streamGroup) findPending(id streamID) *pendingEntry {
+	i := g.search(id)
+	if i < len(g.pending) && g.pending[i].id == id {
+		return g.pending[i]
+	}
+	return nil
+}
+
+// deliver records the delivery of the entry to the consumer. An entry
+// already pending is assigned to the consumer.
+func (g *streamGroup) deliver(id streamID, cons *streamConsumer, now int64) *pendingEntry {
+	i := g.search(id)
+	if i < len(g.pending) && g.pending[i].id == id {
+		pe := g.pending[i]
+		delete(pe.consumer.pending, id)
+		pe.consumer = cons
+		pe.deliveryTime = now
+		pe.deliveryCount = 1
+		cons.pending[id] = pe
+		return pe
+	}
+
+	pe := &pendingEntry{id: id, consumer: cons, deliveryTime: now, deliveryCount: 1}
+	g.pending = append(g.pending, nil)
+	copy(g.pending[i+1:], g.pending[i:])
+	g.pending[i] = pe
+	cons.pending[id] = pe
+	return pe
+}
+
+// claim assigns the pending entry to the consumer.
+func (pe *pendingEntry) claim(cons *streamConsumer) {
+	delete(pe.consumer.pending, pe.id)
+	pe.consumer = cons
+	cons.pending[pe.id] = pe
+}
+
+// ack removes the entry from the pending entries and returns true if it
+// was pending.
+func (g *streamGroup) ack(id streamID) bool {
+	i := g.search(id)
+	if i == len(g.pending) || g.pending[i].id != id {
+		return false
+	}
+
+	delete(g.pending[i].consumer.pending, id)
+	g.pending = append(g.pending[:i], g.pending[i+1:]...)
+	return true
+}
+
+// deleteConsumer deletes the consumer along with its pending entries and
+// returns the number of pending entries deleted.
+func (g *streamGroup) deleteConsumer(cons *streamConsumer) int {
+	n := len(cons.pending)
+	for id := range cons.pending {
+		g.ack(id)
+	}
+	delete(g.consumers, cons.name)
+	return n
+}
+
+// estimateEntriesRead estimates the number of entries read by a group whose
+// last delivered ID is the ID, i.e., the logical offset of the ID from the
+// first entry ever added. Returns -1 if it cannot be estimated.
+func (s *stream) estimateEntriesRead(id streamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+
+	if s.length == 0 && !s.lastID.less(id) {
+		return s.entriesAdded
+	}
+
+	if id == s.lastID {
+		return s.entriesAdded
+	} else if s.lastID.less(id) {
+		return -1
+	}
+
+	// without tombstones, the entries before the first entry are trimmed.
+	first := s.firstID()
+	if s.maxDeletedID.isZero() || s.maxDeletedID.less(first) {
+		if id.less(first) {
+			return s.entriesAdded - int64(s.length)
+		} else if id == first {
+			return s.entriesAdded - int64(s.length) + 1
+		}
+	}
+	return -1
+}
+
+// delivered updates the number of entries read by the group once the entry
+// is delivered to one of its consumers.
+func (s *stream) delivered(g *streamGroup, id streamID) {
+	if g.entriesRead >= 0 && !g.lastID.less(s.maxDeletedID) {
+		g.entriesRead++
+	} else {
+		g.entriesRead = s.estimateEntriesRead(id)
+	}
+	g.lastID = id
+}
+
+// lag returns the number of entries not yet delivered to the group or -1
+// if it is unknown.
+func (s *stream) lag(g *streamGroup) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+
+	if g.entriesRead >= 0 && !g.lastID.less(s.maxDeletedID) {
+		return s.entriesAdded - g.entriesRead
+	}
+
+	read := s.estimateEntriesRead(g.lastID)
+	if read < 0 {
+		return -1
+	}
+	return s.entriesAdded - read
+}
+
+func sortIDs(ids []streamID) {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].less(ids[j])
+	})
+}
@@ -0,0 +1,634 @@
+package store
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+func init() {
+	register("xgroup", -2, flagWrite, xgroupCommand)
+	register("xack", -4, flagWrite, xackCommand)
+	register("xpending", -3, flagReadOnly, xpendingCommand)
+	register("xclaim", -6, flagWrite, xclaimCommand)
+	register("xautoclaim", -6, flagWrite, xautoclaimCommand)
+	register("xinfo", -2, flagReadOnly, xinfoCommand)
+}
+
+func noGroup(key, group string) radio.ErrorStr {
+	return radio.ErrorStr("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+func noGroupForKey(key, group string) radio.ErrorStr {
+	return radio.ErrorStr("NOGROUP No such consumer group '" + group + "' for key name '" + key + "'")
+}
+
+// parseEntriesRead parses the ENTRIESREAD option of XGROUP CREATE and
+// SETID.
+func (c *call) parseEntriesRead(args []string, mkStream *bool) (entriesRead int64, ok bool) {
+	entriesRead = -1
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "mkstream" && mkStream != nil:
+			*mkStream = true
+
+		case opt == "entriesread" && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				c.reply(errNotInteger)
+				return 0, false
+			}
+			if n < -1 {
+				c.reply(radio.ErrorStr("ERR value for ENTRIESREAD must be positive or -1"))
+				return 0, false
+			}
+			entriesRead = n
+			i++
+
+		default:
+			c.reply(errSyntax)
+			return 0, false
+		}
+	}
+	return entriesRead, true
+}
+
+func xgroupCommand(c *call) {
+	sub := strings.ToLower(c.args[0])
+	switch {
+	case sub == "create" && len(c.args) >= 4 && len(c.args) <= 7:
+	case sub == "setid" && len(c.args) >= 4 && len(c.args) <= 6:
+	case sub == "destroy" && len(c.args) == 3:
+	case (sub == "createconsumer" || sub == "delconsumer") && len(c.args) == 4:
+	case sub == "create" || sub == "setid" || sub == "destroy" || sub == "createconsumer" || sub == "delconsumer":
+		c.reply(errArity("xgroup|" + sub))
+		return
+	default:
+		c.reply(radio.ErrorStr("ERR unknown subcommand '" + c.args[0] + "'. Try XGROUP HELP."))
+		return
+	}
+
+	key, group := c.args[1], c.args[2]
+	mkStream := false
+	var entriesRead int64
+	if sub == "create" || sub == "setid" {
+		opt := &mkStream
+		if sub == "setid" {
+			opt = nil
+		}
+
+		var ok bool
+		if entriesRead, ok = c.parseEntriesRead(c.args[4:], opt); !ok {
+			return
+		}
+	}
+
+	_, s, ok := c.lookupStream(key)
+	if !ok {
+		return
+	}
+
+	if s == nil && !mkStream {
+		c.reply(radio.ErrorStr("ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."))
+		return
+	}
+
+	var id streamID
+	if sub == "create" || sub == "setid" {
+		if c.args[3] == "$" {
+			if s != nil {
+				id = s.lastID
+			}
+		} else if id, ok = c.parseID(c.args[3], 0); !ok {
+			return
+		}
+	}
+
+	if sub == "create" {
+		if s == nil {
+			s = newStream()
+			c.add(key, s)
+		} else if s.groups[group] != nil {
+			c.reply(radio.ErrorStr("BUSYGROUP Consumer Group name already exists"))
+			return
+		}
+
+		s.groups[group] = newStreamGroup(group, id, entriesRead)
+		c.touch(key)
+		c.notify(keyspace.Stream, "xgroup-create", key)
+		c.reply(replyOK)
+		return
+	}
+
+	g := s.groups[group]
+	if sub == "destroy" {
+		if g == nil {
+			c.reply(radio.Integer(0))
+			return
+		}
+
+		delete(s.groups, group)
+		c.touch(key)
+		c.notify(keyspace.Stream, "xgroup-destroy", key)
+		c.reply(radio.Integer(1))
+		return
+	}
+
+	if g == nil {
+		c.reply(noGroupForKey(key, group))
+		return
+	}
+
+	switch sub {
+	case "setid":
+		g.lastID, g.entriesRead = id, entriesRead
+		c.notify(keyspace.Stream, "xgroup-setid", key)
+		c.reply(replyOK)
+
+	case "createconsumer":
+		_, created := g.consumer(c.args[3], true, c.now)
+		if !created {
+			c.reply(radio.Integer(0))
+			return
+		}
+
+		c.notify(keyspace.Stream, "xgroup-createconsumer", key)
+		c.reply(radio.Integer(1))
+
+	case "delconsumer":
+		cons, _ := g.consumer(c.args[3], false, c.now)
+		if cons == nil {
+			c.reply(radio.Integer(0))
+			return
+		}
+
+		c.notify(keyspace.Stream, "xgroup-delconsumer", key)
+		c.reply(radio.Integer(g.deleteConsumer(cons)))
+	}
+}
+
+func xackCommand(c *call) {
+	ids := make([]streamID, len(c.args)-2)
+	for i, arg := range c.args[2:] {
+		id, ok := c.parseID(arg, 0)
+		if !ok {
+			return
+		}
+		ids[i] = id
+	}
+
+	_, s, ok := c.lookupStream(c.args[0])
+	if !ok {
+		return
+	}
+
+	acked := 0
+	if s != nil && s.groups[c.args[1]] != nil {
+		g := s.groups[c.args[1]]
+		for _, id := range ids {
+			if g.ack(id) {
+				acked++
+			}
+		}
+	}
+	c.reply(radio.Integer(acked))
+}
+
+func xpendingCommand(c *call) {
+	key, group, args := c.args[0], c.args[1], c.args[2:]
+
+	minIdle := int64(0)
+	if len(args) > 0 && strings.EqualFold(args[0], "idle") {
+		if len(args) < 2 {
+			c.reply(errSyntax)
+			return
+		}
+
+		n, ok := parseInt(args[1])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+		minIdle, args = n, args[2:]
+		if len(args) == 0 {
+			c.reply(errSyntax)
+			return
+		}
+	}
+
+	extended := len(args) > 0
+	if extended && len(args) != 3 && len(args) != 4 {
+		c.reply(errSyntax)
+		return
+	}
+
+	var start, end streamID
+	var count int64
+	var consumer string
+	if extended {
+		var ok bool
+		if start, ok = c.parseRangeID(args[0], true); !ok {
+			return
+		}
+		if end, ok = c.parseRangeID(args[1], false); !ok {
+			return
+		}
+
+		if count, ok = parseInt(args[2]); !ok {
+			c.reply(errNotInteger)
+			return
+		}
+		if count < 0 {
+			count = 0
+		}
+
+		if len(args) == 4 {
+			consumer = args[3]
+		}
+	}
+
+	_, g, ok := c.lookupGroup(key, group, noGroup(key, group))
+	if !ok {
+		return
+	}
+
+	if !extended {
+		if len(g.pending) == 0 {
+			c.reply(&radio.Array{Items: []radio.Value{radio.Integer(0), nullBulk, nullBulk, &radio.Array{}}})
+			return
+		}
+
+		consumers := &radio.Array{Items: []radio.Value{}}
+		for _, cons := range sortedConsumers(g) {
+			if len(cons.pending) > 0 {
+				consumers.Items = append(consumers.Items, &radio.Array{Items: []radio.Value{
+					bulkStr(cons.name),
+					bulkStr(strconv.Itoa(len(cons.pending))),
+				}})
+			}
+		}
+
+		c.reply(&radio.Array{Items: []radio.Value{
+			radio.Integer(len(g.pending)),
+			bulkStr(g.pending[0].id.String()),
+			bulkStr(g.pending[len(g.pending)-1].id.String()),
+			consumers,
+		}})
+		return
+	}
+
+	arr := &radio.Array{Items: []radio.Value{}}
+	for i := g.search(start); i < len(g.pending) && int64(len(arr.Items)) < count; i++ {
+		pe := g.pending[i]
+		if end.less(pe.id) {
+			break
+		}
+
+		idle := c.now - pe.deliveryTime
+		if (consumer != "" && pe.consumer.name != consumer) || idle < minIdle {
+			continue
+		}
+
+		arr.Items = append(arr.Items, &radio.Array{Items: []radio.Value{
+			bulkStr(pe.id.String()),
+			bulkStr(pe.consumer.name),
+			radio.Integer(idle),
+			radio.Integer(pe.deliveryCount),
+		}})
+	}
+	c.reply(arr)
+}
+
+func sortedConsumers(g *streamGroup) []*streamConsumer {
+	consumers := make([]*streamConsumer, 0, len(g.consumers))
+	for _, cons := range g.consumers {
+		consumers = append(consumers, cons)
+	}
+
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].name < consumers[j].name
+	})
+	return consumers
+}
+
+// parseMinIdle parses the min-idle-time argument of XCLAIM and XAUTOCLAIM.
+func (c *call) parseMinIdle(s string) (int64, bool) {
+	n, ok := parseInt(s)
+	if !ok {
+		c.reply(radio.ErrorStr("ERR Invalid min-idle-time argument for " + strings.ToUpper(c.name)))
+		return 0, false
+	}
+
+	if n < 0 {
+		n = 0
+	}
+	return n, true
+}
+
+func xclaimCommand(c *call) {
+	key, group, consumer := c.args[0], c.args[1], c.args[2]
+	minIdle, ok := c.parseMinIdle(c.args[3])
+	if !ok {
+		return
+	}
+
+	// the IDs are followed by the options.
+	args := c.args[4:]
+	var ids []streamID
+	for len(args) > 0 {
+		id, ok := parseStreamID(args[0], 0)
+		if !ok {
+			break
+		}
+		ids, args = append(ids, id), args[1:]
+	}
+
+	deliveryTime, retryCount := int64(-1), int64(-1)
+	var force, justID bool
+	var lastID *streamID
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "force":
+			force = true
+
+		case opt == "justid":
+			justID = true
+
+		case (opt == "idle" || opt == "time" || opt == "retrycount") && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				c.reply(errNotInteger)
+				return
+			}
+
+			switch opt {
+			case "idle":
+				deliveryTime = c.now - n
+			case "time":
+				deliveryTime = n
+			default:
+				retryCount = n
+			}
+			i++
+
+		case opt == "lastid" && i+1 < len(args):
+			id, ok := c.parseID(args[i+1], 0)
+			if !ok {
+				return
+			}
+			lastID = &id
+			i++
+
+		default:
+			c.reply(radio.ErrorStr("ERR Unrecognized XCLAIM option '" + args[i] + "'"))
+			return
+		}
+	}
+
+	if deliveryTime < 0 || deliveryTime > c.now {
+		deliveryTime = c.now
+	}
+
+	s, g, ok := c.lookupGroup(key, group, noGroup(key, group))
+	if !ok {
+		return
+	}
+
+	if lastID != nil && g.lastID.less(*lastID) {
+		g.lastID = *lastID
+	}
+
+	cons := c.streamConsumer(key, g, consumer)
+	arr := &radio.Array{Items: []radio.Value{}}
+	for _, id := range ids {
+		pe := g.findPending(id)
+		if pe == nil {
+			if !force || s.get(id) == nil {
+				continue
+			}
+
+			pe = g.deliver(id, cons, deliveryTime)
+			pe.deliveryCount = 0
+		}
+
+		if minIdle > 0 && c.now-pe.deliveryTime < minIdle {
+			continue
+		}
+
+		e := s.get(id)
+		if e == nil {
+			// the entry is deleted, so it cannot be claimed anymore.
+			g.ack(id)
+			continue
+		}
+
+		pe.claim(cons)
+		pe.deliveryTime = deliveryTime
+		if retryCount >= 0 {
+			pe.deliveryCount = retryCount
+		} else if !justID {
+			pe.deliveryCount++
+		}
+		cons.activeTime = c.now
+
+		if justID {
+			arr.Items = append(arr.Items, bulkStr(id.String()))
+		} else {
+			arr.Items = append(arr.Items, entryReply(e))
+		}
+	}
+	c.reply(arr)
+}
+
+func xautoclaimCommand(c *call) {
+	key, group, consumer := c.args[0], c.args[1], c.args[2]
+	minIdle, ok := c.parseMinIdle(c.args[3])
+	if !ok {
+		return
+	}
+
+	start, ok := c.parseRangeID(c.args[4], true)
+	if !ok {
+		return
+	}
+
+	count, justID := int64(100), false
+	args := c.args[5:]
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "count" && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				c.reply(errNotInteger)
+				return
+			}
+			if n < 1 || n > math.MaxInt64/10 {
+				c.reply(radio.ErrorStr("ERR COUNT must be > 0"))
+				return
+			}
+			count = n
+			i++
+
+		case opt == "justid":
+			justID = true
+
+		default:
+			c.reply(errSyntax)
+			return
+		}
+	}
+
+	s, g, ok := c.lookupGroup(key, group, noGroup(key, group))
+	if !ok {
+		return
+	}
+
+	cons := c.streamConsumer(key, g, consumer)
+	claimed := &radio.Array{Items: []radio.Value{}}
+	deleted := &radio.Array{Items: []radio.Value{}}
+
+	// like Redis, the number of pending entries examined is limited.
+	attempts := count * 10
+	i := g.search(start)
+	for ; i < len(g.pending) && int64(len(claimed.Items)) < count && attempts > 0; attempts-- {
+		pe := g.pending[i]
+		if minIdle > 0 && c.now-pe.deliveryTime < minIdle {
+			i++
+			continue
+		}
+
+		e := s.get(pe.id)
+		if e == nil {
+			g.ack(pe.id)
+			deleted.Items = append(deleted.Items, bulkStr(pe.id.String()))
+			continue
+		}
+
+		pe.claim(cons)
+		pe.deliveryTime = c.now
+		if !justID {
+			pe.deliveryCount++
+		}
+		cons.activeTime = c.now
+
+		if justID {
+			claimed.Items = append(claimed.Items, bulkStr(pe.id.String()))
+		} else {
+			claimed.Items = append(claimed.Items, entryReply(e))
+		}
+		i++
+	}
+
+	next := streamID{}
+	if i < len(g.pending) {
+		next = g.pending[i].id
+	}
+
+	c.reply(&radio.Array{Items: []radio.Value{bulkStr(next.String()), claimed, deleted}})
+}
+
+func xinfoCommand(c *call) {
+	sub := strings.ToLower(c.args[0])
+	switch {
+	case (sub == "stream" || sub == "groups") && len(c.args) == 2:
+	case sub == "consumers" && len(c.args) == 3:
+	case sub == "stream" || sub == "groups" || sub == "consumers":
+		c.reply(errArity("xinfo|" + sub))
+		return
+	default:
+		c.reply(radio.ErrorStr("ERR unknown subcommand '" + c.args[0] + "'. Try XINFO HELP."))
+		return
+	}
+
+	key := c.args[1]
+	_, s, ok := c.lookupStream(key)
+	if !ok {
+		return
+	}
+
+	if s == nil {
+		c.reply(errNoSuchKey)
+		return
+	}
+
+	switch sub {
+	case "stream":
+		first, last := radio.Value(nullBulk), radio.Value(nullBulk)
+		if e := s.first(); e != nil {
+			first, last = entryReply(e), entryReply(s.last())
+		}
+
+		c.reply(&radio.Array{Items: []radio.Value{
+			bulkStr("length"), radio.Integer(s.length),
+			bulkStr("radix-tree-keys"), radio.Integer(len(s.nodes)),
+			bulkStr("radix-tree-nodes"), radio.Integer(len(s.nodes)),
+			bulkStr("last-generated-id"), bulkStr(s.lastID.String()),
+			bulkStr("max-deleted-entry-id"), bulkStr(s.maxDeletedID.String()),
+			bulkStr("entries-added"), radio.Integer(s.entriesAdded),
+			bulkStr("recorded-first-entry-id"), bulkStr(s.firstID().String()),
+			bulkStr("groups"), radio.Integer(len(s.groups)),
+			bulkStr("first-entry"), first,
+			bulkStr("last-entry"), last,
+		}})
+
+	case "groups":
+		names := make([]string, 0, len(s.groups))
+		for name := range s.groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		arr := &radio.Array{Items: []radio.Value{}}
+		for _, name := range names {
+			g := s.groups[name]
+			entriesRead, lag := radio.Value(nullBulk), radio.Value(nullBulk)
+			if g.entriesRead >= 0 {
+				entriesRead = radio.Integer(g.entriesRead)
+			}
+			if n := s.lag(g); n >= 0 {
+				lag = radio.Integer(n)
+			}
+
+			arr.Items = append(arr.Items, &radio.Array{Items: []radio.Value{
+				bulkStr("name"), bulkStr(g.name),
+				bulkStr("consumers"), radio.Integer(len(g.consumers)),
+				bulkStr("pending"), radio.Integer(len(g.pending)),
+				bulkStr("last-delivered-id"), bulkStr(g.lastID.String()),
+				bulkStr("entries-read"), entriesRead,
+				bulkStr("lag"), lag,
+			}})
+		}
+		c.reply(arr)
+
+	case "consumers":
+		g := s.groups[c.args[2]]
+		if g == nil {
+			c.reply(noGroupForKey(key, c.args[2]))
+			return
+		}
+
+		arr := &radio.Array{Items: []radio.Value{}}
+		for _, cons := range sortedConsumers(g) {
+			inactive := int64(-1)
+			if cons.activeTime >= 0 {
+				inactive = c.now - cons.activeTime
+			}
+
+			arr.Items = append(arr.Items, &radio.Array{Items: []radio.Value{
+				bulkStr("name"), bulkStr(cons.name),
+				bulkStr("pending"), radio.Integer(len(cons.pending)),
+				bulkStr("idle"), radio.Integer(c.now - cons.seenTime),
+				bulkStr("inactive"), radio.Integer(inactive),
+			}})
+		}
+		c.reply(arr)
+	}
+}
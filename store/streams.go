@@ -0,0 +1,729 @@
+package store
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+var errInvalidStreamID = radio.ErrorStr("ERR Invalid stream ID specified as stream command argument")
+
+func init() {
+	register("xadd", -5, flagWrite|flagDenyOOM, xaddCommand)
+	register("xlen", 2, flagReadOnly, xlenCommand)
+	register("xdel", -3, flagWrite, xdelCommand)
+	register("xtrim", -4, flagWrite, xtrimCommand)
+	register("xrange", -4, flagReadOnly, xrangeCommand)
+	register("xrevrange", -4, flagReadOnly, xrangeCommand)
+	register("xread", -4, flagReadOnly, xreadCommand)
+	register("xreadgroup", -7, flagWrite, xreadCommand)
+}
+
+// lookupStream returns the stream of the key. ok is false if the key holds
+// another type in which case the error is already written.
+func (c *call) lookupStream(key string) (e *entry, s *stream, ok bool) {
+	e, ok = c.lookupType(key, "stream")
+	if e != nil {
+		s = e.val.(*stream)
+	}
+	return e, s, ok
+}
+
+// parseID parses the stream ID writing an error if it is invalid.
+func (c *call) parseID(s string, missingSeq uint64) (streamID, bool) {
+	id, ok := parseStreamID(s, missingSeq)
+	if !ok {
+		c.reply(errInvalidStreamID)
+	}
+	return id, ok
+}
+
+// parseRangeID parses the start or end of a range of IDs: "-" and "+" are
+// the minimum and maximum IDs and IDs prefixed with "(" are exclusive.
+func (c *call) parseRangeID(s string, isStart bool) (streamID, bool) {
+	switch s {
+	case "-":
+		return streamID{}, true
+	case "+":
+		return maxStreamID, true
+	}
+
+	missingSeq := uint64(0)
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+
+	if !strings.HasPrefix(s, "(") {
+		return c.parseID(s, missingSeq)
+	}
+
+	id, ok := c.parseID(s[1:], missingSeq)
+	if !ok {
+		return id, false
+	}
+
+	if isStart {
+		if id, ok = id.next(); !ok {
+			c.reply(radio.ErrorStr("ERR invalid start ID for the interval"))
+		}
+	} else {
+		if id, ok = id.prev(); !ok {
+			c.reply(radio.ErrorStr("ERR invalid end ID for the interval"))
+		}
+	}
+	return id, ok
+}
+
+// parseTrim parses the MAXLEN or MINID option starting at args[0] and
+// returns the number of arguments consumed.
+func (c *call) parseTrim(args []string, opts *trimOptions) (int, bool) {
+	byMinID := strings.EqualFold(args[0], "minid")
+	if opts.enabled && opts.byMinID != byMinID {
+		c.reply(radio.ErrorStr("ERR syntax error, MAXLEN and MINID options at the same time are not compatible"))
+		return 0, false
+	}
+	opts.enabled, opts.byMinID = true, byMinID
+
+	i := 1
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		opts.approx = args[i] == "~"
+		i++
+	}
+
+	if i == len(args) {
+		c.reply(errSyntax)
+		return 0, false
+	}
+
+	if byMinID {
+		id, ok := c.parseID(args[i], 0)
+		if !ok {
+			return 0, false
+		}
+		opts.minID = id
+	} else {
+		n, ok := parseInt(args[i])
+		if !ok {
+			c.reply(errNotInteger)
+			return 0, false
+		}
+		if n < 0 {
+			c.reply(radio.ErrorStr("ERR The MAXLEN argument must be >= 0."))
+			return 0, false
+		}
+		opts.maxLen = n
+	}
+	i++
+
+	opts.limit = 0
+	if opts.approx {
+		opts.limit = 100 * streamNodeMaxEntries
+	}
+
+	if i+1 < len(args) && strings.EqualFold(args[i], "limit") {
+		n, ok := parseInt(args[i+1])
+		if !ok {
+			c.reply(errNotInteger)
+			return 0, false
+		}
+		if n < 0 {
+			c.reply(radio.ErrorStr("ERR The LIMIT argument must be >= 0."))
+			return 0, false
+		}
+		if !opts.approx {
+			c.reply(radio.ErrorStr("ERR syntax error, LIMIT cannot be used without the special ~ option"))
+			return 0, false
+		}
+		opts.limit = n
+		i += 2
+	}
+	return i, true
+}
+
+// entryReply returns the entry as an array of the ID and the fields.
+func entryReply(e *streamEntry) *radio.Array {
+	return &radio.Array{Items: []radio.Value{bulkStr(e.id.String()), bulkArray(e.fields)}}
+}
+
+func entriesReply(entries []*streamEntry) *radio.Array {
+	arr := &radio.Array{Items: make([]radio.Value, len(entries))}
+	for i, e := range entries {
+		arr.Items[i] = entryReply(e)
+	}
+	return arr
+}
+
+func xaddCommand(c *call) {
+	key, args := c.args[0], c.args[1:]
+
+	noMkStream := false
+	var trim trimOptions
+opts:
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "nomkstream":
+			noMkStream = true
+			args = args[1:]
+
+		case "maxlen", "minid":
+			n, ok := c.parseTrim(args, &trim)
+			if !ok {
+				return
+			}
+			args = args[n:]
+
+		default:
+			break opts
+		}
+	}
+
+	if len(args) < 3 || len(args)%2 != 1 {
+		c.reply(errArity(c.name))
+		return
+	}
+
+	// the ID is either *, ms-* or an explicit ID.
+	var id streamID
+	autoSeq := strings.HasSuffix(args[0], "-*")
+	switch {
+	case args[0] == "*":
+	case autoSeq:
+		var ok bool
+		if id, ok = c.parseID(strings.TrimSuffix(args[0], "-*"), 0); !ok {
+			return
+		}
+	default:
+		var ok bool
+		if id, ok = c.parseID(args[0], 0); !ok {
+			return
+		}
+		if id.isZero() {
+			c.reply(radio.ErrorStr("ERR The ID specified in XADD must be greater than 0-0"))
+			return
+		}
+	}
+
+	_, s, ok := c.lookupStream(key)
+	if !ok {
+		return
+	}
+
+	if s == nil && noMkStream {
+		c.reply(nullBulk)
+		return
+	}
+
+	var last streamID
+	if s != nil {
+		last = s.lastID
+	}
+
+	tooSmall := radio.ErrorStr("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	switch {
+	case args[0] == "*":
+		now := uint64(c.now)
+		if now > last.ms {
+			id = streamID{ms: now}
+		} else if id, ok = last.next(); !ok {
+			c.reply(radio.ErrorStr("ERR The stream has exhausted the last possible ID, unable to add more items"))
+			return
+		}
+
+	case autoSeq:
+		if id.ms < last.ms {
+			c.reply(tooSmall)
+			return
+		}
+
+		if id.ms == last.ms {
+			if last.seq == math.MaxUint64 {
+				c.reply(tooSmall)
+				return
+			}
+			id.seq = last.seq + 1
+		} else if id.ms == 0 {
+			id.seq = 1
+		}
+
+	default:
+		if !last.less(id) {
+			c.reply(tooSmall)
+			return
+		}
+	}
+
+	if s == nil {
+		s = newStream()
+		c.add(key, s)
+	}
+
+	s.add(id, append([]string(nil), args[1:]...))
+	c.touch(key)
+	c.notify(keyspace.Stream, "xadd", key)
+	c.signal(key)
+
+	if trim.enabled && s.trim(trim) > 0 {
+		c.notify(keyspace.Stream, "xtrim", key)
+	}
+	c.reply(bulkStr(id.String()))
+}
+
+func xlenCommand(c *call) {
+	_, s, ok := c.lookupStream(c.args[0])
+	if !ok {
+		return
+	}
+
+	if s == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+	c.reply(radio.Integer(s.length))
+}
+
+func xdelCommand(c *call) {
+	ids := make([]streamID, len(c.args)-1)
+	for i, arg := range c.args[1:] {
+		id, ok := c.parseID(arg, 0)
+		if !ok {
+			return
+		}
+		ids[i] = id
+	}
+
+	key := c.args[0]
+	_, s, ok := c.lookupStream(key)
+	if !ok {
+		return
+	}
+
+	deleted := 0
+	if s != nil {
+		for _, id := range ids {
+			if s.delete(id) {
+				deleted++
+			}
+		}
+	}
+
+	if deleted > 0 {
+		c.touch(key)
+		c.notify(keyspace.Stream, "xdel", key)
+	}
+	c.reply(radio.Integer(deleted))
+}
+
+func xtrimCommand(c *call) {
+	var trim trimOptions
+	args := c.args[1:]
+	for len(args) > 0 {
+		if !strings.EqualFold(args[0], "maxlen") && !strings.EqualFold(args[0], "minid") {
+			c.reply(errSyntax)
+			return
+		}
+
+		n, ok := c.parseTrim(args, &trim)
+		if !ok {
+			return
+		}
+		args = args[n:]
+	}
+
+	key := c.args[0]
+	_, s, ok := c.lookupStream(key)
+	if !ok {
+		return
+	}
+
+	trimmed := 0
+	if s != nil {
+		trimmed = s.trim(trim)
+	}
+
+	if trimmed > 0 {
+		c.touch(key)
+		c.notify(keyspace.Stream, "xtrim", key)
+	}
+	c.reply(radio.Integer(trimmed))
+}
+
+func xrangeCommand(c *call) {
+	start, end := c.args[1], c.args[2]
+	rev := c.name == "xrevrange"
+	if rev {
+		start, end = end, start
+	}
+
+	startID, ok := c.parseRangeID(start, true)
+	if !ok {
+		return
+	}
+
+	endID, ok := c.parseRangeID(end, false)
+	if !ok {
+		return
+	}
+
+	count := int64(0)
+	switch len(c.args) {
+	case 3:
+	case 5:
+		if !strings.EqualFold(c.args[3], "count") {
+			c.reply(errSyntax)
+			return
+		}
+
+		n, ok := parseInt(c.args[4])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+
+		if n <= 0 {
+			// like Redis, a non-positive count selects nothing.
+			c.reply(entriesReply(nil))
+			return
+		}
+		count = n
+
+	default:
+		c.reply(errSyntax)
+		return
+	}
+
+	_, s, ok := c.lookupStream(c.args[0])
+	if !ok {
+		return
+	}
+
+	if s == nil || endID.less(startID) {
+		c.reply(entriesReply(nil))
+		return
+	}
+	c.reply(entriesReply(s.entries(startID, endID, rev, count)))
+}
+
+// readOptions are the options of XREAD and XREADGROUP.
+type readOptions struct {
+	count    int64
+	block    bool
+	timeout  time.Duration
+	noAck    bool
+	group    string
+	consumer string
+	keys     []string
+	ids      []string
+}
+
+func (c *call) parseRead() (opts readOptions, ok bool) {
+	group := c.name == "xreadgroup"
+	args := c.args
+
+	for len(args) > 0 && !strings.EqualFold(args[0], "streams") {
+		opt := strings.ToLower(args[0])
+		switch {
+		case opt == "count" && len(args) > 1:
+			n, ok := parseInt(args[1])
+			if !ok {
+				c.reply(errNotInteger)
+				return opts, false
+			}
+			if n < 0 {
+				n = 0
+			}
+			opts.count = n
+			args = args[2:]
+
+		case opt == "block" && len(args) > 1:
+			ms, ok := parseInt(args[1])
+			if !ok {
+				c.reply(radio.ErrorStr("ERR timeout is not an integer or out of range"))
+				return opts, false
+			}
+			if ms < 0 {
+				c.reply(radio.ErrorStr("ERR timeout is negative"))
+				return opts, false
+			}
+			if ms > math.MaxInt64/int64(time.Millisecond) {
+				c.reply(radio.ErrorStr("ERR timeout is out of range"))
+				return opts, false
+			}
+			opts.block, opts.timeout = true, time.Duration(ms)*time.Millisecond
+			args = args[2:]
+
+		case opt == "group" && len(args) > 2:
+			if !group {
+				c.reply(radio.ErrorStr("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead."))
+				return opts, false
+			}
+			opts.group, opts.consumer = args[1], args[2]
+			args = args[3:]
+
+		case opt == "noack" && group:
+			opts.noAck = true
+			args = args[1:]
+
+		default:
+			c.reply(errSyntax)
+			return opts, false
+		}
+	}
+
+	if len(args) == 0 {
+		c.reply(errSyntax)
+		return opts, false
+	}
+
+	if group && opts.group == "" {
+		c.reply(radio.ErrorStr("ERR Missing GROUP option for XREADGROUP"))
+		return opts, false
+	}
+
+	streams := args[1:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		c.reply(radio.ErrorStr("ERR Unbalanced '" + c.name + "' list of streams: for each stream key an ID or '$' must be specified."))
+		return opts, false
+	}
+
+	n := len(streams) / 2
+	opts.keys, opts.ids = streams[:n], streams[n:]
+	return opts, true
+}
+
+func xreadCommand(c *call) {
+	opts, ok := c.parseRead()
+	if !ok {
+		return
+	}
+
+	if opts.group != "" {
+		c.xreadgroup(opts)
+		return
+	}
+
+	// the IDs after which the entries are read.
+	after := make(map[string]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		_, s, ok := c.lookupStream(key)
+		if !ok {
+			return
+		}
+
+		switch opts.ids[i] {
+		case "$":
+			if s != nil {
+				after[key] = s.lastID
+			} else {
+				after[key] = streamID{}
+			}
+
+		case ">":
+			c.reply(radio.ErrorStr("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option."))
+			return
+
+		default:
+			id, ok := c.parseID(opts.ids[i], 0)
+			if !ok {
+				return
+			}
+			after[key] = id
+		}
+	}
+
+	result := &radio.Array{}
+	for _, key := range opts.keys {
+		_, s, _ := c.lookupStream(key)
+		if s == nil || !after[key].less(s.lastID) {
+			continue
+		}
+
+		entries := s.after(after[key], opts.count)
+		if len(entries) > 0 {
+			result.Items = append(result.Items, streamReply(key, entriesReply(entries)))
+		}
+	}
+
+	if len(result.Items) > 0 || !opts.block {
+		c.reply(result)
+		return
+	}
+
+	c.block(opts.keys, opts.timeout, func(wr radio.ResponseWriter) {
+		wr.Write(&radio.Array{})
+	}, func(c *call, key string) bool {
+		e := c.lookup(key)
+		if e == nil || typeOf(e.val) != "stream" {
+			return false
+		}
+
+		entries := e.val.(*stream).after(after[key], opts.count)
+		if len(entries) == 0 {
+			return false
+		}
+
+		c.reply(&radio.Array{Items: []radio.Value{streamReply(key, entriesReply(entries))}})
+		return true
+	})
+}
+
+// streamReply returns the reply of XREAD and XREADGROUP for a stream.
+func streamReply(key string, entries *radio.Array) *radio.Array {
+	return &radio.Array{Items: []radio.Value{bulkStr(key), entries}}
+}
+
+// lookupGroup returns the stream of the key and its consumer group. If
+// either does not exist, err is written and ok is false.
+func (c *call) lookupGroup(key, group string, err radio.ErrorStr) (s *stream, g *streamGroup, ok bool) {
+	_, s, ok = c.lookupStream(key)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if s != nil {
+		g = s.groups[group]
+	}
+
+	if g == nil {
+		c.reply(err)
+		return nil, nil, false
+	}
+	return s, g, true
+}
+
+func (c *call) xreadgroup(opts readOptions) {
+	groups := make([]*streamGroup, len(opts.keys))
+	history := make([]*streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		_, g, ok := c.lookupGroup(key, opts.group, radio.ErrorStr("NOGROUP No such key '"+key+
+			"' or consumer group '"+opts.group+"' in XREADGROUP with GROUP option"))
+		if !ok {
+			return
+		}
+		groups[i] = g
+
+		switch opts.ids[i] {
+		case ">":
+		case "$":
+			c.reply(radio.ErrorStr("ERR The $ ID is meaningful only for XREAD"))
+			return
+		default:
+			id, ok := c.parseID(opts.ids[i], 0)
+			if !ok {
+				return
+			}
+			history[i] = &id
+		}
+	}
+
+	result := &radio.Array{}
+	for i, key := range opts.keys {
+		s := c.lookup(key).val.(*stream)
+		cons := c.streamConsumer(key, groups[i], opts.consumer)
+
+		if history[i] != nil {
+			result.Items = append(result.Items, streamReply(key, c.readHistory(s, cons, *history[i], opts.count)))
+			continue
+		}
+
+		if entries := c.readGroup(s, groups[i], cons, opts); len(entries) > 0 {
+			result.Items = append(result.Items, streamReply(key, entriesReply(entries)))
+		}
+	}
+
+	if len(result.Items) > 0 || !opts.block {
+		c.reply(result)
+		return
+	}
+
+	c.block(opts.keys, opts.timeout, func(wr radio.ResponseWriter) {
+		wr.Write(&radio.Array{})
+	}, func(c *call, key string) bool {
+		e := c.lookup(key)
+		if e == nil || typeOf(e.val) != "stream" {
+			return false
+		}
+
+		s := e.val.(*stream)
+		g := s.groups[opts.group]
+		if g == nil {
+			c.reply(radio.ErrorStr("NOGROUP the consumer group this client was blocked on no longer exists"))
+			return true
+		}
+
+		cons := c.streamConsumer(key, g, opts.consumer)
+		entries := c.readGroup(s, g, cons, opts)
+		if len(entries) == 0 {
+			return false
+		}
+
+		c.reply(&radio.Array{Items: []radio.Value{streamReply(key, entriesReply(entries))}})
+		return true
+	})
+}
+
+// streamConsumer returns the consumer of the group creating it if it does
+// not exist. The consumer is marked as seen.
+func (c *call) streamConsumer(key string, g *streamGroup, name string) *streamConsumer {
+	cons, created := g.consumer(name, true, c.now)
+	if created {
+		c.notify(keyspace.Stream, "xgroup-createconsumer", key)
+	}
+	cons.seenTime = c.now
+	return cons
+}
+
+// readGroup delivers the entries not yet delivered to the group to the
+// consumer.
+func (c *call) readGroup(s *stream, g *streamGroup, cons *streamConsumer, opts readOptions) []*streamEntry {
+	entries := s.after(g.lastID, opts.count)
+	for _, e := range entries {
+		s.delivered(g, e.id)
+		if !opts.noAck {
+			g.deliver(e.id, cons, c.now)
+		}
+	}
+
+	if len(entries) > 0 {
+		cons.activeTime = c.now
+	}
+	return entries
+}
+
+// readHistory returns the entries pending for the consumer with IDs
+// greater than the ID. Deleted entries are returned with null fields.
+func (c *call) readHistory(s *stream, cons *streamConsumer, after streamID, count int64) *radio.Array {
+	arr := &radio.Array{Items: []radio.Value{}}
+	start, ok := after.next()
+	if !ok {
+		return arr
+	}
+
+	var ids []streamID
+	for id := range cons.pending {
+		if !id.less(start) {
+			ids = append(ids, id)
+		}
+	}
+	sortIDs(ids)
+
+	for _, id := range ids {
+		if count > 0 && int64(len(arr.Items)) == count {
+			break
+		}
+
+		pe := cons.pending[id]
+		e := s.get(id)
+		if e == nil {
+			arr.Items = append(arr.Items, &radio.Array{Items: []radio.Value{bulkStr(id.String()), &radio.Array{}}})
+			continue
+		}
+
+		pe.deliveryTime = c.now
+		pe.deliveryCount++
+		arr.Items = append(arr.Items, entryReply(e))
+	}
+	return arr
+}
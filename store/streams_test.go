@@ -0,0 +1,309 @@
+package store_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+	"github.com/spy16/radio/store"
+)
+
+func TestStreams(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "XADD",
+			steps: []step{
+				{cmd: "XADD s * a 1", reply: bulk("1000000000-0")},
+				{cmd: "XADD s * b 2", reply: bulk("1000000000-1")},
+				{cmd: "XADD s 1000000000-* c 3", reply: bulk("1000000000-2")},
+				{cmd: "XADD s 2000000000-5 d 4", reply: bulk("2000000000-5")},
+				{cmd: "XADD s * e 5", reply: bulk("2000000000-6")},
+				{cmd: "TYPE s", reply: radio.SimpleStr("stream")},
+				{cmd: "XLEN s", reply: radio.Integer(5)},
+				{cmd: "XLEN x", reply: radio.Integer(0)},
+				{cmd: "XADD s 5-0 f 6", reply: radio.ErrorStr("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+				{cmd: "XADD s 1-* f 6", reply: radio.ErrorStr("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+				{cmd: "XADD t 0-0 f 6", reply: radio.ErrorStr("ERR The ID specified in XADD must be greater than 0-0")},
+				{cmd: "XADD t 0-* f 6", reply: bulk("0-1")},
+				{cmd: "XADD t x f 6", reply: radio.ErrorStr("ERR Invalid stream ID specified as stream command argument")},
+				{cmd: "XADD t * f", reply: radio.ErrorStr("ERR wrong number of arguments for 'xadd' command")},
+				{cmd: "XADD u NOMKSTREAM * f 6", reply: nullBulk},
+				{cmd: "EXISTS u", reply: radio.Integer(0)},
+				{cmd: "SET str 1", reply: okReply},
+				{cmd: "XADD str * f 6", reply: wrongType},
+			},
+		},
+		{
+			title: "XRANGE",
+			steps: []step{
+				{cmd: "XADD s 1-1 a 1", reply: bulk("1-1")},
+				{cmd: "XADD s 1-2 b 2", reply: bulk("1-2")},
+				{cmd: "XADD s 2-1 c 3", reply: bulk("2-1")},
+				{cmd: "XRANGE s - +", reply: entries("1-1", "a", "1", "1-2", "b", "2", "2-1", "c", "3")},
+				{cmd: "XRANGE s 1 1", reply: entries("1-1", "a", "1", "1-2", "b", "2")},
+				{cmd: "XRANGE s (1-1 + COUNT 1", reply: entries("1-2", "b", "2")},
+				{cmd: "XREVRANGE s + - COUNT 2", reply: entries("2-1", "c", "3", "1-2", "b", "2")},
+				{cmd: "XREVRANGE s (2-1 -", reply: entries("1-2", "b", "2", "1-1", "a", "1")},
+				{cmd: "XRANGE s 2 1", reply: entries()},
+				{cmd: "XRANGE s - + COUNT 0", reply: entries()},
+				{cmd: "XRANGE x - +", reply: entries()},
+				{cmd: "XRANGE s (18446744073709551615-18446744073709551615 +", reply: radio.ErrorStr("ERR invalid start ID for the interval")},
+				{cmd: "XRANGE s x +", reply: radio.ErrorStr("ERR Invalid stream ID specified as stream command argument")},
+				{cmd: "XRANGE s - + FOO 1", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "XDEL s 1-2 9-9", reply: radio.Integer(1)},
+				{cmd: "XRANGE s - +", reply: entries("1-1", "a", "1", "2-1", "c", "3")},
+				{cmd: "XLEN s", reply: radio.Integer(2)},
+			},
+		},
+		{
+			title: "Trim",
+			steps: []step{
+				{cmd: "XADD s 1-1 a 1", reply: bulk("1-1")},
+				{cmd: "XADD s 2-1 a 1", reply: bulk("2-1")},
+				{cmd: "XADD s MAXLEN 2 3-1 a 1", reply: bulk("3-1")},
+				{cmd: "XRANGE s - +", reply: entries("2-1", "a", "1", "3-1", "a", "1")},
+				{cmd: "XADD s MINID = 3 4-1 a 1", reply: bulk("4-1")},
+				{cmd: "XLEN s", reply: radio.Integer(2)},
+				{cmd: "XTRIM s MAXLEN ~ 1", reply: radio.Integer(0)},
+				{cmd: "XTRIM s MAXLEN 0", reply: radio.Integer(2)},
+				{cmd: "EXISTS s", reply: radio.Integer(1)},
+				{cmd: "XTRIM s MAXLEN -1", reply: radio.ErrorStr("ERR The MAXLEN argument must be >= 0.")},
+				{cmd: "XTRIM s MAXLEN 1 LIMIT 10", reply: radio.ErrorStr("ERR syntax error, LIMIT cannot be used without the special ~ option")},
+				{cmd: "XTRIM s MAXLEN 1 MINID 1", reply: radio.ErrorStr("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")},
+				{cmd: "XTRIM s FOO 1", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "XREAD",
+			steps: []step{
+				{cmd: "XADD a 1-1 f 1", reply: bulk("1-1")},
+				{cmd: "XADD a 1-2 f 2", reply: bulk("1-2")},
+				{cmd: "XADD b 2-1 g 1", reply: bulk("2-1")},
+				{cmd: "XREAD STREAMS a b 0 0", reply: &radio.Array{Items: []radio.Value{
+					streamEntries("a", "1-1", "f", "1", "1-2", "f", "2"),
+					streamEntries("b", "2-1", "g", "1"),
+				}}},
+				{cmd: "XREAD COUNT 1 STREAMS a b 1-1 2-1", reply: &radio.Array{Items: []radio.Value{
+					streamEntries("a", "1-2", "f", "2"),
+				}}},
+				{cmd: "XREAD STREAMS a x $ 0", reply: &radio.Array{}},
+				{cmd: "XREAD BLOCK 10 STREAMS a $", reply: &radio.Array{}},
+				{cmd: "XREAD STREAMS a b 0", reply: radio.ErrorStr("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")},
+				{cmd: "XREAD STREAMS a >", reply: radio.ErrorStr("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")},
+				{cmd: "XREAD GROUP g c STREAMS a 0", reply: radio.ErrorStr("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")},
+				{cmd: "XREAD BLOCK -1 STREAMS a 0", reply: radio.ErrorStr("ERR timeout is negative")},
+				{cmd: "XREAD COUNT 1 a 0", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "Groups",
+			steps: []step{
+				{cmd: "XGROUP CREATE s g $", reply: radio.ErrorStr("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")},
+				{cmd: "XGROUP CREATE s g $ MKSTREAM", reply: okReply},
+				{cmd: "XGROUP CREATE s g $", reply: radio.ErrorStr("BUSYGROUP Consumer Group name already exists")},
+				{cmd: "XADD s 1-1 a 1", reply: bulk("1-1")},
+				{cmd: "XADD s 1-2 b 2", reply: bulk("1-2")},
+				{cmd: "XADD s 1-3 c 3", reply: bulk("1-3")},
+				{cmd: "XREADGROUP GROUP g alice COUNT 2 STREAMS s >", reply: &radio.Array{Items: []radio.Value{
+					streamEntries("s", "1-1", "a", "1", "1-2", "b", "2"),
+				}}},
+				{cmd: "XREADGROUP GROUP g bob STREAMS s >", reply: &radio.Array{Items: []radio.Value{
+					streamEntries("s", "1-3", "c", "3"),
+				}}},
+				{cmd: "XREADGROUP GROUP g bob STREAMS s >", reply: &radio.Array{}},
+				{cmd: "XREADGROUP GROUP g alice STREAMS s 0", reply: &radio.Array{Items: []radio.Value{
+					streamEntries("s", "1-1", "a", "1", "1-2", "b", "2"),
+				}}},
+				{cmd: "XPENDING s g", reply: &radio.Array{Items: []radio.Value{
+					radio.Integer(3), bulk("1-1"), bulk("1-3"),
+					&radio.Array{Items: []radio.Value{bulks("alice", "2"), bulks("bob", "1")}},
+				}}},
+				{cmd: "XPENDING s g - + 10 alice", reply: &radio.Array{Items: []radio.Value{
+					pending("1-1", "alice", 0, 2),
+					pending("1-2", "alice", 0, 2),
+				}}},
+				{cmd: "XACK s g 1-1 1-9", reply: radio.Integer(1)},
+				{cmd: "XCLAIM s g bob 0 1-2", reply: entries("1-2", "b", "2")},
+				{cmd: "XCLAIM s g bob 0 1-2 JUSTID RETRYCOUNT 7", reply: bulks("1-2")},
+				{cmd: "XPENDING s g - + 10", reply: &radio.Array{Items: []radio.Value{
+					pending("1-2", "bob", 0, 7),
+					pending("1-3", "bob", 0, 1),
+				}}},
+				{cmd: "XDEL s 1-3", reply: radio.Integer(1)},
+				{cmd: "XAUTOCLAIM s g carol 0 0", reply: &radio.Array{Items: []radio.Value{
+					bulk("0-0"), entries("1-2", "b", "2"), bulks("1-3"),
+				}}},
+				{cmd: "XAUTOCLAIM s g carol 0 0 COUNT 0", reply: radio.ErrorStr("ERR COUNT must be > 0")},
+				{cmd: "XINFO GROUPS s", reply: &radio.Array{Items: []radio.Value{
+					&radio.Array{Items: []radio.Value{
+						bulk("name"), bulk("g"),
+						bulk("consumers"), radio.Integer(3),
+						bulk("pending"), radio.Integer(1),
+						bulk("last-delivered-id"), bulk("1-3"),
+						bulk("entries-read"), radio.Integer(3),
+						bulk("lag"), radio.Integer(0),
+					}},
+				}}},
+				{cmd: "XGROUP DELCONSUMER s g carol", reply: radio.Integer(1)},
+				{cmd: "XGROUP CREATECONSUMER s g dave", reply: radio.Integer(1)},
+				{cmd: "XGROUP CREATECONSUMER s g dave", reply: radio.Integer(0)},
+				{cmd: "XGROUP SETID s g 0", reply: okReply},
+				{cmd: "XREADGROUP GROUP g dave NOACK STREAMS s >", reply: &radio.Array{Items: []radio.Value{
+					streamEntries("s", "1-1", "a", "1", "1-2", "b", "2"),
+				}}},
+				{cmd: "XPENDING s g", reply: &radio.Array{Items: []radio.Value{radio.Integer(0), nullBulk, nullBulk, &radio.Array{}}}},
+				{cmd: "XGROUP DESTROY s g", reply: radio.Integer(1)},
+				{cmd: "XGROUP DESTROY s g", reply: radio.Integer(0)},
+				{cmd: "XREADGROUP GROUP g alice STREAMS s >", reply: radio.ErrorStr("NOGROUP No such key 's' or consumer group 'g' in XREADGROUP with GROUP option")},
+				{cmd: "XPENDING s g", reply: radio.ErrorStr("NOGROUP No such key 's' or consumer group 'g'")},
+				{cmd: "XGROUP SETID s g 0", reply: radio.ErrorStr("NOGROUP No such consumer group 'g' for key name 's'")},
+				{cmd: "XGROUP FOO s g", reply: radio.ErrorStr("ERR unknown subcommand 'FOO'. Try XGROUP HELP.")},
+				{cmd: "XREADGROUP COUNT 1 BLOCK 0 STREAMS s >", reply: radio.ErrorStr("ERR Missing GROUP option for XREADGROUP")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(newClock())
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestStreams_Info(t *testing.T) {
+	clock := newClock()
+	s := newStore(clock)
+	defer s.Close()
+
+	runSteps(t, s, []step{
+		{cmd: "XINFO STREAM s", reply: radio.ErrorStr("ERR no such key")},
+		{cmd: "XADD s 1-1 a 1", reply: bulk("1-1")},
+		{cmd: "XADD s 1-2 b 2", reply: bulk("1-2")},
+		{cmd: "XDEL s 1-2", reply: radio.Integer(1)},
+		{cmd: "XGROUP CREATE s g 0", reply: okReply},
+		{cmd: "XINFO STREAM s", reply: &radio.Array{Items: []radio.Value{
+			bulk("length"), radio.Integer(1),
+			bulk("radix-tree-keys"), radio.Integer(1),
+			bulk("radix-tree-nodes"), radio.Integer(1),
+			bulk("last-generated-id"), bulk("1-2"),
+			bulk("max-deleted-entry-id"), bulk("1-2"),
+			bulk("entries-added"), radio.Integer(2),
+			bulk("recorded-first-entry-id"), bulk("1-1"),
+			bulk("groups"), radio.Integer(1),
+			bulk("first-entry"), entries("1-1", "a", "1").Items[0],
+			bulk("last-entry"), entries("1-1", "a", "1").Items[0],
+		}}},
+		{cmd: "XREADGROUP GROUP g c STREAMS s >", reply: &radio.Array{Items: []radio.Value{
+			streamEntries("s", "1-1", "a", "1"),
+		}}},
+	})
+
+	clock.Advance(5 * time.Second)
+	runSteps(t, s, []step{
+		{cmd: "XINFO CONSUMERS s g", reply: &radio.Array{Items: []radio.Value{
+			&radio.Array{Items: []radio.Value{
+				bulk("name"), bulk("c"),
+				bulk("pending"), radio.Integer(1),
+				bulk("idle"), radio.Integer(5000),
+				bulk("inactive"), radio.Integer(5000),
+			}},
+		}}},
+		{cmd: "XPENDING s g IDLE 6000 - + 10", reply: &radio.Array{Items: []radio.Value{}}},
+		{cmd: "XCLAIM s g d 6000 1-1", reply: &radio.Array{Items: []radio.Value{}}},
+		{cmd: "XCLAIM s g d 5000 1-1 JUSTID", reply: bulks("1-1")},
+		{cmd: "XINFO CONSUMERS s x", reply: radio.ErrorStr("NOGROUP No such consumer group 'x' for key name 's'")},
+		{cmd: "XINFO FOO s", reply: radio.ErrorStr("ERR unknown subcommand 'FOO'. Try XINFO HELP.")},
+	})
+}
+
+func TestStreams_Nodes(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	for i := 1; i <= 1000; i++ {
+		s.Do(0, "XADD", "s", strconv.Itoa(i)+"-1", "f", strconv.Itoa(i))
+	}
+
+	runSteps(t, s, []step{
+		{cmd: "XRANGE s 500 500", reply: entries("500-1", "f", "500")},
+		{cmd: "XREVRANGE s 101 (99-1", reply: entries("101-1", "f", "101", "100-1", "f", "100")},
+		{cmd: "XTRIM s MAXLEN ~ 850", reply: radio.Integer(100)},
+		{cmd: "XTRIM s MINID ~ 450 LIMIT 200", reply: radio.Integer(200)},
+		{cmd: "XTRIM s MINID 451", reply: radio.Integer(150)},
+		{cmd: "XRANGE s - + COUNT 1", reply: entries("451-1", "f", "451")},
+		{cmd: "XLEN s", reply: radio.Integer(550)},
+	})
+}
+
+func TestStreams_Blocking(t *testing.T) {
+	blocker := &radio.Blocker{}
+	s := store.New(store.Options{Blocker: blocker})
+	defer s.Close()
+
+	served := &servedCounter{Handler: &store.Handler{Store: s}}
+	blocker.Handler = served
+	srv := radiotest.NewServer(blocker)
+	defer srv.Close()
+
+	reader, consumer, writer := dial(t, srv), dial(t, srv), dial(t, srv)
+	defer reader.Close()
+	defer consumer.Close()
+	defer writer.Close()
+
+	expect(t, writer, okReply, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+
+	reader.Send("XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	reader.Flush()
+	served.wait(t, 2)
+
+	consumer.Send("XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "s", ">")
+	consumer.Flush()
+	served.wait(t, 3)
+
+	expect(t, writer, bulk("1-1"), "XADD", "s", "1-1", "f", "v")
+
+	want := &radio.Array{Items: []radio.Value{streamEntries("s", "1-1", "f", "v")}}
+	if v, err := reader.Receive(); err != nil || !reflect.DeepEqual(want, v) {
+		t.Errorf("expecting '%v', got '%v' (err=%v)", want, v, err)
+	}
+	if v, err := consumer.Receive(); err != nil || !reflect.DeepEqual(want, v) {
+		t.Errorf("expecting '%v', got '%v' (err=%v)", want, v, err)
+	}
+
+	expect(t, reader, &radio.Array{}, "XREAD", "BLOCK", "10", "STREAMS", "s", "$")
+	expect(t, writer, radio.Integer(1), "XACK", "s", "g", "1-1")
+}
+
+// entries returns the reply of XRANGE for the entries given as IDs, each
+// followed by a field and a value.
+func entries(vals ...string) *radio.Array {
+	arr := &radio.Array{Items: make([]radio.Value, 0, len(vals)/3)}
+	for i := 0; i < len(vals); i += 3 {
+		arr.Items = append(arr.Items, &radio.Array{Items: []radio.Value{
+			bulk(vals[i]), bulks(vals[i+1], vals[i+2]),
+		}})
+	}
+	return arr
+}
+
+// streamEntries returns the reply of XREAD for the entries of the stream.
+func streamEntries(key string, vals ...string) *radio.Array {
+	return &radio.Array{Items: []radio.Value{bulk(key), entries(vals...)}}
+}
+
+func pending(id, consumer string, idle, deliveries int) *radio.Array {
+	return &radio.Array{Items: []radio.Value{
+		bulk(id), bulk(consumer), radio.Integer(idle), radio.Integer(deliveries),
+	}}
+}
@@ -0,0 +1,573 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+// maxStringSize is the maximum size of a string value (proto-max-bulk-len).
+const maxStringSize = 512 * 1024 * 1024
+
+var errStringSize = radio.ErrorStr("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+func init() {
+	register("get", 2, flagReadOnly, getCommand)
+	register("set", -3, flagWrite|flagDenyOOM, setCommand)
+	register("setnx", 3, flagWrite|flagDenyOOM, setnxCommand)
+	register("setex", 4, flagWrite|flagDenyOOM, setexCommand)
+	register("psetex", 4, flagWrite|flagDenyOOM, setexCommand)
+	register("getset", 3, flagWrite|flagDenyOOM, getsetCommand)
+	register("getdel", 2, flagWrite, getdelCommand)
+	register("getex", -2, flagWrite, getexCommand)
+	register("incr", 2, flagWrite|flagDenyOOM, incrCommand)
+	register("decr", 2, flagWrite|flagDenyOOM, incrCommand)
+	register("incrby", 3, flagWrite|flagDenyOOM, incrCommand)
+	register("decrby", 3, flagWrite|flagDenyOOM, incrCommand)
+	register("incrbyfloat", 3, flagWrite|flagDenyOOM, incrbyfloatCommand)
+	register("append", 3, flagWrite|flagDenyOOM, appendCommand)
+	register("strlen", 2, flagReadOnly, strlenCommand)
+	register("getrange", 4, flagReadOnly, getrangeCommand)
+	register("substr", 4, flagReadOnly, getrangeCommand)
+	register("setrange", 4, flagWrite|flagDenyOOM, setrangeCommand)
+	register("mget", -2, flagReadOnly, mgetCommand)
+	register("mset", -3, flagWrite|flagDenyOOM, msetCommand)
+	register("msetnx", -3, flagWrite|flagDenyOOM, msetCommand)
+}
+
+// lookupString returns the value of the string key. ok is false if the key
+// holds another type in which case the error is already written.
+func (c *call) lookupString(key string) (e *entry, val []byte, ok bool) {
+	e, ok = c.lookupType(key, "string")
+	if e != nil {
+		val = e.val.([]byte)
+	}
+	return e, val, ok
+}
+
+func getCommand(c *call) {
+	e, val, ok := c.lookupString(c.args[0])
+	if !ok {
+		return
+	}
+
+	if e == nil {
+		c.reply(nullBulk)
+		return
+	}
+	c.reply(bulk(val))
+}
+
+// expiry holds the expiry options of SET and GETEX.
+type expiry struct {
+	at      int64 // unix milliseconds
+	keepTTL bool
+	persist bool
+}
+
+// parseExpiry parses the EX, PX, EXAT and PXAT options at args[i] and sets
+// the absolute expiry time. Returns the number of arguments consumed and
+// the error to reply with if the option is invalid.
+func (c *call) parseExpiry(args []string, i int, exp *expiry) (int, radio.Value) {
+	opt := strings.ToLower(args[i])
+	if i+1 >= len(args) || exp.at != 0 || exp.keepTTL || exp.persist {
+		return 0, errSyntax
+	}
+
+	n, ok := parseInt(args[i+1])
+	if !ok {
+		return 0, errNotInteger
+	}
+
+	invalid := radio.ErrorStr("ERR invalid expire time in '" + c.name + "' command")
+	if n <= 0 {
+		return 0, invalid
+	}
+
+	switch opt {
+	case "ex":
+		if n > math.MaxInt64/1000-c.now/1000 {
+			return 0, invalid
+		}
+		exp.at = c.now + n*1000
+	case "px":
+		if n > math.MaxInt64-c.now {
+			return 0, invalid
+		}
+		exp.at = c.now + n
+	case "exat":
+		if n > math.MaxInt64/1000 {
+			return 0, invalid
+		}
+		exp.at = n * 1000
+	case "pxat":
+		exp.at = n
+	}
+
+	return 2, nil
+}
+
+func setCommand(c *call) {
+	key, val := c.args[0], c.args[1]
+
+	var nx, xx, get bool
+	var exp expiry
+	for i := 2; i < len(c.args); i++ {
+		switch opt := strings.ToLower(c.args[i]); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "get":
+			get = true
+		case "keepttl":
+			if exp.at != 0 {
+				c.reply(errSyntax)
+				return
+			}
+			exp.keepTTL = true
+
+		case "ex", "px", "exat", "pxat":
+			n, err := c.parseExpiry(c.args, i, &exp)
+			if err != nil {
+				c.reply(err)
+				return
+			}
+			i += n - 1
+
+		default:
+			c.reply(errSyntax)
+			return
+		}
+	}
+
+	if nx && xx {
+		c.reply(errSyntax)
+		return
+	}
+
+	// the existing value is overwritten irrespective of its type unless it
+	// is required for GET.
+	var e *entry
+	var old []byte
+	if get {
+		var ok bool
+		if e, old, ok = c.lookupString(key); !ok {
+			return
+		}
+	} else {
+		e = c.lookup(key)
+	}
+
+	if (nx && e != nil) || (xx && e == nil) {
+		if get {
+			c.reply(bulkOrNull(old))
+		} else {
+			c.reply(nullBulk)
+		}
+		return
+	}
+
+	e = c.set(key, []byte(val), exp.keepTTL)
+	c.touch(key)
+	c.notify(keyspace.String, "set", key)
+	if exp.at != 0 {
+		c.db.setExpire(e, exp.at)
+		c.notify(keyspace.Generic, "expire", key)
+	}
+
+	if get {
+		c.reply(bulkOrNull(old))
+	} else {
+		c.reply(replyOK)
+	}
+}
+
+func bulkOrNull(val []byte) radio.Value {
+	if val == nil {
+		return nullBulk
+	}
+	return bulk(val)
+}
+
+func setnxCommand(c *call) {
+	if c.lookup(c.args[0]) != nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	c.set(c.args[0], []byte(c.args[1]), false)
+	c.touch(c.args[0])
+	c.notify(keyspace.String, "set", c.args[0])
+	c.reply(radio.Integer(1))
+}
+
+func setexCommand(c *call) {
+	opt := "ex"
+	if c.name == "psetex" {
+		opt = "px"
+	}
+
+	var exp expiry
+	if _, err := c.parseExpiry([]string{opt, c.args[1]}, 0, &exp); err != nil {
+		c.reply(err)
+		return
+	}
+
+	key := c.args[0]
+	e := c.set(key, []byte(c.args[2]), false)
+	c.db.setExpire(e, exp.at)
+	c.touch(key)
+	c.notify(keyspace.String, "set", key)
+	c.notify(keyspace.Generic, "expire", key)
+	c.reply(replyOK)
+}
+
+func getsetCommand(c *call) {
+	key := c.args[0]
+	_, old, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	c.set(key, []byte(c.args[1]), false)
+	c.touch(key)
+	c.notify(keyspace.String, "set", key)
+	c.reply(bulkOrNull(old))
+}
+
+func getdelCommand(c *call) {
+	key := c.args[0]
+	e, val, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	if e == nil {
+		c.reply(nullBulk)
+		return
+	}
+
+	c.delete(key)
+	c.notify(keyspace.Generic, "del", key)
+	c.reply(bulk(val))
+}
+
+func getexCommand(c *call) {
+	key := c.args[0]
+
+	var exp expiry
+	for i := 1; i < len(c.args); i++ {
+		switch opt := strings.ToLower(c.args[i]); opt {
+		case "persist":
+			if exp.at != 0 {
+				c.reply(errSyntax)
+				return
+			}
+			exp.persist = true
+
+		case "ex", "px", "exat", "pxat":
+			n, err := c.parseExpiry(c.args, i, &exp)
+			if err != nil {
+				c.reply(err)
+				return
+			}
+			i += n - 1
+
+		default:
+			c.reply(errSyntax)
+			return
+		}
+	}
+
+	e, val, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	if e == nil {
+		c.reply(nullBulk)
+		return
+	}
+
+	switch {
+	case exp.at != 0 && exp.at <= c.now:
+		c.delete(key)
+		c.notify(keyspace.Generic, "del", key)
+
+	case exp.at != 0:
+		c.db.setExpire(e, exp.at)
+		c.touch(key)
+		c.notify(keyspace.Generic, "expire", key)
+
+	case exp.persist && e.expireAt != 0:
+		c.db.setExpire(e, 0)
+		c.touch(key)
+		c.notify(keyspace.Generic, "persist", key)
+	}
+
+	c.reply(bulk(val))
+}
+
+func incrCommand(c *call) {
+	var by int64 = 1
+	switch c.name {
+	case "decr":
+		by = -1
+
+	case "incrby", "decrby":
+		n, ok := parseInt(c.args[1])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+
+		by = n
+		if c.name == "decrby" {
+			if n == math.MinInt64 {
+				c.reply(radio.ErrorStr("ERR decrement would overflow"))
+				return
+			}
+			by = -n
+		}
+	}
+
+	key := c.args[0]
+	e, val, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	var cur int64
+	if e != nil {
+		if cur, ok = parseInt(string(val)); !ok {
+			c.reply(errNotInteger)
+			return
+		}
+	}
+
+	if (by > 0 && cur > math.MaxInt64-by) || (by < 0 && cur < math.MinInt64-by) {
+		c.reply(radio.ErrorStr("ERR increment or decrement would overflow"))
+		return
+	}
+	cur += by
+
+	c.set(key, []byte(strconv.FormatInt(cur, 10)), true)
+	c.touch(key)
+	c.notify(keyspace.String, "incrby", key)
+	c.reply(radio.Integer(cur))
+}
+
+func incrbyfloatCommand(c *call) {
+	by, ok := parseFloat(c.args[1])
+	if !ok {
+		c.reply(errNotFloat)
+		return
+	}
+
+	key := c.args[0]
+	e, val, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	var cur float64
+	if e != nil {
+		if cur, ok = parseFloat(string(val)); !ok {
+			c.reply(errNotFloat)
+			return
+		}
+	}
+
+	cur += by
+	if math.IsNaN(cur) || math.IsInf(cur, 0) {
+		c.reply(radio.ErrorStr("ERR increment would produce NaN or Infinity"))
+		return
+	}
+
+	s := formatFloat(cur)
+	c.set(key, []byte(s), true)
+	c.touch(key)
+	c.notify(keyspace.String, "incrbyfloat", key)
+	c.reply(bulkStr(s))
+}
+
+func appendCommand(c *call) {
+	key := c.args[0]
+	e, val, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	if len(val)+len(c.args[1]) > maxStringSize {
+		c.reply(errStringSize)
+		return
+	}
+
+	if e == nil {
+		e = c.add(key, []byte(c.args[1]))
+	} else {
+		// appending never modifies the bytes of the existing value which
+		// may still be referenced by replies that are not yet written.
+		e.val = append(val, c.args[1]...)
+	}
+
+	c.touch(key)
+	c.notify(keyspace.String, "append", key)
+	c.reply(radio.Integer(len(e.val.([]byte))))
+}
+
+func strlenCommand(c *call) {
+	_, val, ok := c.lookupString(c.args[0])
+	if !ok {
+		return
+	}
+	c.reply(radio.Integer(len(val)))
+}
+
+func getrangeCommand(c *call) {
+	start, ok1 := parseInt(c.args[1])
+	end, ok2 := parseInt(c.args[2])
+	if !ok1 || !ok2 {
+		c.reply(errNotInteger)
+		return
+	}
+
+	_, val, ok := c.lookupString(c.args[0])
+	if !ok {
+		return
+	}
+
+	c.reply(bulk(substr(val, start, end)))
+}
+
+// substr returns the bytes between the inclusive start and end offsets
+// where negative offsets are relative to the end.
+func substr(val []byte, start, end int64) []byte {
+	if start < 0 && end < 0 && start > end {
+		return []byte{}
+	}
+
+	start, end, ok := clampRange(start, end, int64(len(val)))
+	if !ok {
+		return []byte{}
+	}
+	return val[start : end+1]
+}
+
+// clampRange converts the inclusive start and end offsets, where negative
+// offsets are relative to the end, to offsets within n. ok is false if the
+// range is empty.
+func clampRange(start, end, n int64) (int64, int64, bool) {
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+
+	return start, end, start <= end
+}
+
+func setrangeCommand(c *call) {
+	offset, ok := parseInt(c.args[1])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	if offset < 0 {
+		c.reply(radio.ErrorStr("ERR offset is out of range"))
+		return
+	}
+
+	key, data := c.args[0], c.args[2]
+	e, val, ok := c.lookupString(key)
+	if !ok {
+		return
+	}
+
+	if len(data) == 0 {
+		c.reply(radio.Integer(len(val)))
+		return
+	}
+
+	if offset+int64(len(data)) > maxStringSize {
+		c.reply(errStringSize)
+		return
+	}
+
+	size := int(offset) + len(data)
+	if size < len(val) {
+		size = len(val)
+	}
+
+	// the value is copied as the existing bytes may still be referenced by
+	// replies that are not yet written.
+	updated := make([]byte, size)
+	copy(updated, val)
+	copy(updated[offset:], data)
+
+	if e == nil {
+		c.add(key, updated)
+	} else {
+		e.val = updated
+	}
+
+	c.touch(key)
+	c.notify(keyspace.String, "setrange", key)
+	c.reply(radio.Integer(size))
+}
+
+func mgetCommand(c *call) {
+	arr := &radio.Array{Items: make([]radio.Value, len(c.args))}
+	for i, key := range c.args {
+		e := c.lookup(key)
+		if e == nil || typeOf(e.val) != "string" {
+			arr.Items[i] = nullBulk
+			continue
+		}
+		arr.Items[i] = bulk(e.val.([]byte))
+	}
+	c.reply(arr)
+}
+
+func msetCommand(c *call) {
+	if len(c.args)%2 != 0 {
+		c.reply(errArity(c.name))
+		return
+	}
+
+	if c.name == "msetnx" {
+		for i := 0; i < len(c.args); i += 2 {
+			if c.lookup(c.args[i]) != nil {
+				c.reply(radio.Integer(0))
+				return
+			}
+		}
+	}
+
+	for i := 0; i < len(c.args); i += 2 {
+		c.set(c.args[i], []byte(c.args[i+1]), false)
+		c.touch(c.args[i])
+		c.notify(keyspace.String, "set", c.args[i])
+	}
+
+	if c.name == "msetnx" {
+		c.reply(radio.Integer(1))
+	} else {
+		c.reply(replyOK)
+	}
+}
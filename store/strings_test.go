@@ -0,0 +1,133 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+)
+
+func TestStrings(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "SET",
+			steps: []step{
+				{cmd: "SET a 1 NX", reply: okReply},
+				{cmd: "SET a 2 NX", reply: nullBulk},
+				{cmd: "SET a 3 XX GET", reply: bulk("1")},
+				{cmd: "SET b 3 XX", reply: nullBulk},
+				{cmd: "SET b 1 NX GET", reply: nullBulk},
+				{cmd: "GET b", reply: bulk("1")},
+				{cmd: "SET a 1 NX XX", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "SET a 1 EX 10 PX 100", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "SET a 1 EX 10 KEEPTTL", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "SET a 1 EX 0", reply: radio.ErrorStr("ERR invalid expire time in 'set' command")},
+				{cmd: "SET a 1 EX abc", reply: radio.ErrorStr("ERR value is not an integer or out of range")},
+				{cmd: "SET a 1 FOO", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "SETNX a 1", reply: radio.Integer(0)},
+				{cmd: "SETNX c 1", reply: radio.Integer(1)},
+				{cmd: "GETSET c 2", reply: bulk("1")},
+				{cmd: "GETDEL c", reply: bulk("2")},
+				{cmd: "GETDEL c", reply: nullBulk},
+			},
+		},
+		{
+			title: "INCR",
+			steps: []step{
+				{cmd: "INCR a", reply: radio.Integer(1)},
+				{cmd: "INCRBY a 10", reply: radio.Integer(11)},
+				{cmd: "DECR a", reply: radio.Integer(10)},
+				{cmd: "DECRBY a -5", reply: radio.Integer(15)},
+				{cmd: "INCRBY a 01", reply: radio.ErrorStr("ERR value is not an integer or out of range")},
+				{cmd: "SET a 9223372036854775807", reply: okReply},
+				{cmd: "INCR a", reply: radio.ErrorStr("ERR increment or decrement would overflow")},
+				{cmd: "SET a abc", reply: okReply},
+				{cmd: "INCR a", reply: radio.ErrorStr("ERR value is not an integer or out of range")},
+				{cmd: "SET a 10.50", reply: okReply},
+				{cmd: "INCRBYFLOAT a 0.1", reply: bulk("10.6")},
+				{cmd: "INCRBYFLOAT a -5", reply: bulk("5.6")},
+				{cmd: "INCRBYFLOAT a 5.0e3", reply: bulk("5005.6")},
+				{cmd: "INCRBYFLOAT a abc", reply: radio.ErrorStr("ERR value is not a valid float")},
+				{cmd: "INCRBYFLOAT b 3", reply: bulk("3")},
+			},
+		},
+		{
+			title: "Ranges",
+			steps: []step{
+				{cmd: "APPEND a Hello", reply: radio.Integer(5)},
+				{cmd: "APPEND a World", reply: radio.Integer(10)},
+				{cmd: "STRLEN a", reply: radio.Integer(10)},
+				{cmd: "STRLEN b", reply: radio.Integer(0)},
+				{cmd: "GETRANGE a 0 4", reply: bulk("Hello")},
+				{cmd: "GETRANGE a -5 -1", reply: bulk("World")},
+				{cmd: "GETRANGE a 5 100", reply: bulk("World")},
+				{cmd: "GETRANGE a 6 2", reply: bulk("")},
+				{cmd: "GETRANGE a -1 -5", reply: bulk("")},
+				{cmd: "GETRANGE b 0 -1", reply: bulk("")},
+				{cmd: "SETRANGE a 5 Redis", reply: radio.Integer(10)},
+				{cmd: "GET a", reply: bulk("HelloRedis")},
+				{cmd: "SETRANGE b 2 x", reply: radio.Integer(3)},
+				{cmd: "GET b", reply: bulk("\x00\x00x")},
+				{cmd: "SETRANGE a -1 x", reply: radio.ErrorStr("ERR offset is out of range")},
+				{cmd: "SETRANGE a 536870911 xx", reply: radio.ErrorStr("ERR string exceeds maximum allowed size (proto-max-bulk-len)")},
+			},
+		},
+		{
+			title: "Multi",
+			steps: []step{
+				{cmd: "MSET a 1 b 2", reply: okReply},
+				{cmd: "MSET a 1 b", reply: radio.ErrorStr("ERR wrong number of arguments for 'mset' command")},
+				{cmd: "MSETNX b 3 c 3", reply: radio.Integer(0)},
+				{cmd: "MSETNX c 3 d 4", reply: radio.Integer(1)},
+				{cmd: "MGET a b x c d", reply: &radio.Array{Items: []radio.Value{bulk("1"), bulk("2"), nullBulk, bulk("3"), bulk("4")}}},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(nil)
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestStrings_Expiry(t *testing.T) {
+	clock := newClock()
+	s := newStore(clock)
+	defer s.Close()
+
+	runSteps(t, s, []step{
+		{cmd: "SET a 1 EX 10", reply: okReply},
+		{cmd: "TTL a", reply: radio.Integer(10)},
+		{cmd: "SET a 2 KEEPTTL", reply: okReply},
+		{cmd: "PTTL a", reply: radio.Integer(10000)},
+		{cmd: "INCR a", reply: radio.Integer(3)},
+		{cmd: "PTTL a", reply: radio.Integer(10000)},
+		{cmd: "SET a 2", reply: okReply},
+		{cmd: "TTL a", reply: radio.Integer(-1)},
+		{cmd: "SETEX b 5 x", reply: okReply},
+		{cmd: "PSETEX c 500 x", reply: okReply},
+		{cmd: "SETEX b 0 x", reply: radio.ErrorStr("ERR invalid expire time in 'setex' command")},
+		{cmd: "GETEX a PX 2000", reply: bulk("2")},
+		{cmd: "PTTL a", reply: radio.Integer(2000)},
+		{cmd: "GETEX a PERSIST", reply: bulk("2")},
+		{cmd: "TTL a", reply: radio.Integer(-1)},
+		{cmd: "SET d 1 PXAT 1000001000", reply: okReply},
+		{cmd: "PEXPIRETIME d", reply: radio.Integer(1000001000)},
+	})
+
+	clock.Advance(time.Second)
+	runSteps(t, s, []step{
+		{cmd: "GET c", reply: nullBulk},
+		{cmd: "GET d", reply: nullBulk},
+		{cmd: "TTL b", reply: radio.Integer(4)},
+	})
+}
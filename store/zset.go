@@ -0,0 +1,239 @@
+package store
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const (
+	// zsetMaxListpackEntries and zsetMaxListpackValue are the limits of
+	// the listpack encoding of sorted sets (zset-max-listpack-entries and
+	// zset-max-listpack-value).
+	zsetMaxListpackEntries = 128
+	zsetMaxListpackValue   = 64
+)
+
+// zset is a sorted set value. Small sorted sets are kept in a slice sorted
+// by score and member (listpack encoding). Sorted sets exceeding the limits
+// of the listpack encoding are converted to a skiplist for the ordered
+// operations and a dict mapping the members to their scores (skiplist
+// encoding). Like Redis, sorted sets are never converted back.
+type zset struct {
+	entries []scored
+	dict    *dict
+	sl      *skiplist
+}
+
+// scored is a member of a sorted set with its score.
+type scored struct {
+	member string
+	score  float64
+}
+
+// before reports whether the element sorts before the other.
+func (e scored) before(other scored) bool {
+	return e.score < other.score || (e.score == other.score && e.member < other.member)
+}
+
+func (z *zset) encoding() string {
+	if z.dict != nil {
+		return "skiplist"
+	}
+	return "listpack"
+}
+
+func (z *zset) len() int {
+	if z.dict != nil {
+		return z.dict.len()
+	}
+	return len(z.entries)
+}
+
+// score returns the score of the member. ok is false if it is not a member.
+func (z *zset) score(member string) (score float64, ok bool) {
+	if z.dict != nil {
+		f := z.dict.get(member)
+		if f == nil {
+			return 0, false
+		}
+		return f.score, true
+	}
+
+	i := z.index(member)
+	if i < 0 {
+		return 0, false
+	}
+	return z.entries[i].score, true
+}
+
+// set sets the score of the member and returns true if it was not already
+// a member.
+func (z *zset) set(member string, score float64) bool {
+	if z.dict != nil {
+		f := z.dict.get(member)
+		if f != nil {
+			if f.score != score {
+				z.sl.delete(f.score, member)
+				z.sl.insert(score, member)
+				f.score = score
+			}
+			return false
+		}
+
+		z.dict.add(member, "").score = score
+		z.sl.insert(score, member)
+		return true
+	}
+
+	added := true
+	if i := z.index(member); i >= 0 {
+		if z.entries[i].score == score {
+			return false
+		}
+		z.entries = append(z.entries[:i], z.entries[i+1:]...)
+		added = false
+	}
+
+	if added && (len(z.entries) >= zsetMaxListpackEntries || len(member) > zsetMaxListpackValue) {
+		z.convert()
+		return z.set(member, score)
+	}
+
+	e := scored{member: member, score: score}
+	i := sort.Search(len(z.entries), func(i int) bool {
+		return !z.entries[i].before(e)
+	})
+	z.entries = append(z.entries, scored{})
+	copy(z.entries[i+1:], z.entries[i:])
+	z.entries[i] = e
+	return added
+}
+
+// remove removes the member and returns true if it was a member.
+func (z *zset) remove(member string) bool {
+	if z.dict != nil {
+		f := z.dict.get(member)
+		if f == nil {
+			return false
+		}
+
+		z.sl.delete(f.score, member)
+		z.dict.remove(member)
+		return true
+	}
+
+	i := z.index(member)
+	if i < 0 {
+		return false
+	}
+	z.entries = append(z.entries[:i], z.entries[i+1:]...)
+	return true
+}
+
+// rank returns the 0-based rank of the member in ascending order or -1 if
+// it is not a member.
+func (z *zset) rank(member string) int {
+	score, ok := z.score(member)
+	if !ok {
+		return -1
+	}
+
+	e := scored{member: member, score: score}
+	return z.countWhile(func(other scored) bool {
+		return other.before(e)
+	})
+}
+
+// countWhile returns the number of leading elements in ascending order for
+// which pred is true. pred must be true for a prefix of the elements and
+// false for the rest.
+func (z *zset) countWhile(pred func(e scored) bool) int {
+	if z.dict != nil {
+		return z.sl.countWhile(func(n *skipnode) bool {
+			return pred(scored{member: n.member, score: n.score})
+		})
+	}
+
+	return sort.Search(len(z.entries), func(i int) bool {
+		return !pred(z.entries[i])
+	})
+}
+
+// each invokes fn for the elements starting at the 0-based rank from,
+// in ascending order or in descending order if rev is set, until fn
+// returns false.
+func (z *zset) each(from int, rev bool, fn func(e scored) bool) {
+	if from < 0 || from >= z.len() {
+		return
+	}
+
+	if z.dict != nil {
+		for n := z.sl.byRank(from + 1); n != nil; {
+			if !fn(scored{member: n.member, score: n.score}) {
+				return
+			}
+
+			if rev {
+				n = n.backward
+			} else {
+				n = n.levels[0].forward
+			}
+		}
+		return
+	}
+
+	for i := from; i >= 0 && i < len(z.entries); {
+		if !fn(z.entries[i]) {
+			return
+		}
+
+		if rev {
+			i--
+		} else {
+			i++
+		}
+	}
+}
+
+// scan invokes fn for the elements starting at the cursor until fn returns
+// false. Returns the cursor to continue from or zero if all the elements
+// are visited. Sorted sets using the listpack encoding are always visited
+// fully.
+func (z *zset) scan(cursor uint64, fn func(e scored) bool) uint64 {
+	if z.dict != nil {
+		return z.dict.scan(cursor, func(f *field) bool {
+			return fn(scored{member: f.name, score: f.score})
+		})
+	}
+
+	z.each(0, false, fn)
+	return 0
+}
+
+// random returns a random element. The sorted set must not be empty.
+func (z *zset) random() scored {
+	if z.dict != nil {
+		f := z.dict.random()
+		return scored{member: f.name, score: f.score}
+	}
+	return z.entries[rand.Intn(len(z.entries))]
+}
+
+func (z *zset) index(member string) int {
+	for i, e := range z.entries {
+		if e.member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+func (z *zset) convert() {
+	z.dict = newDict(len(z.entries))
+	z.sl = newSkiplist()
+	for _, e := range z.entries {
+		z.dict.add(e.member, "").score = e.score
+		z.sl.insert(e.score, e.member)
+	}
+	z.entries = nil
+}
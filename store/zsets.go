@@ -0,0 +1,1083 @@
+package store
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/keyspace"
+)
+
+func init() {
+	register("zadd", -4, flagWrite|flagDenyOOM, zaddCommand)
+	register("zincrby", 4, flagWrite|flagDenyOOM, zaddCommand)
+	register("zrem", -3, flagWrite, zremCommand)
+	register("zcard", 2, flagReadOnly, zcardCommand)
+	register("zscore", 3, flagReadOnly, zscoreCommand)
+	register("zmscore", -3, flagReadOnly, zscoreCommand)
+	register("zrank", -3, flagReadOnly, zrankCommand)
+	register("zrevrank", -3, flagReadOnly, zrankCommand)
+	register("zcount", 4, flagReadOnly, zcountCommand)
+	register("zlexcount", 4, flagReadOnly, zcountCommand)
+	register("zrange", -4, flagReadOnly, zrangeCommand)
+	register("zrevrange", -4, flagReadOnly, zrangeCommand)
+	register("zrangebyscore", -4, flagReadOnly, zrangeCommand)
+	register("zrevrangebyscore", -4, flagReadOnly, zrangeCommand)
+	register("zrangebylex", -4, flagReadOnly, zrangeCommand)
+	register("zrevrangebylex", -4, flagReadOnly, zrangeCommand)
+	register("zrangestore", -5, flagWrite|flagDenyOOM, zrangeCommand)
+	register("zremrangebyrank", 4, flagWrite, zremrangeCommand)
+	register("zremrangebyscore", 4, flagWrite, zremrangeCommand)
+	register("zremrangebylex", 4, flagWrite, zremrangeCommand)
+	register("zpopmin", -2, flagWrite, zpopCommand)
+	register("zpopmax", -2, flagWrite, zpopCommand)
+	register("bzpopmin", -3, flagWrite, bzpopCommand)
+	register("bzpopmax", -3, flagWrite, bzpopCommand)
+	register("zrandmember", -2, flagReadOnly, zrandmemberCommand)
+	register("zscan", -3, flagReadOnly, zscanCommand)
+	register("zunion", -3, flagReadOnly, zsetopCommand)
+	register("zinter", -3, flagReadOnly, zsetopCommand)
+	register("zdiff", -3, flagReadOnly, zsetopCommand)
+	register("zunionstore", -4, flagWrite|flagDenyOOM, zsetopCommand)
+	register("zinterstore", -4, flagWrite|flagDenyOOM, zsetopCommand)
+	register("zdiffstore", -4, flagWrite|flagDenyOOM, zsetopCommand)
+}
+
+// lookupZset returns the sorted set of the key. ok is false if the key
+// holds another type in which case the error is already written.
+func (c *call) lookupZset(key string) (e *entry, z *zset, ok bool) {
+	e, ok = c.lookupType(key, "zset")
+	if e != nil {
+		z = e.val.(*zset)
+	}
+	return e, z, ok
+}
+
+// storeZset stores the elements as the sorted set of the key for the
+// commands storing their results. The key is deleted if there are no
+// elements.
+func (c *call) storeZset(key string, elems []scored) {
+	if len(elems) == 0 {
+		if c.delete(key) {
+			c.notify(keyspace.Generic, "del", key)
+		}
+		return
+	}
+
+	z := &zset{}
+	for _, e := range elems {
+		z.set(e.member, e.score)
+	}
+
+	c.set(key, z, false)
+	c.touch(key)
+	c.notify(keyspace.ZSet, c.name, key)
+	c.signal(key)
+}
+
+// scoredReply returns the members, followed by their scores if withScores
+// is set, as an array.
+func scoredReply(elems []scored, withScores bool) *radio.Array {
+	arr := &radio.Array{Items: make([]radio.Value, 0, len(elems))}
+	for _, e := range elems {
+		arr.Items = append(arr.Items, bulkStr(e.member))
+		if withScores {
+			arr.Items = append(arr.Items, bulkStr(formatDouble(e.score)))
+		}
+	}
+	return arr
+}
+
+func zaddCommand(c *call) {
+	key := c.args[0]
+
+	var nx, xx, gt, lt, ch, incr bool
+	pairs := c.args[1:]
+	if c.name == "zincrby" {
+		incr = true
+	} else {
+	opts:
+		for len(pairs) > 0 {
+			switch strings.ToLower(pairs[0]) {
+			case "nx":
+				nx = true
+			case "xx":
+				xx = true
+			case "gt":
+				gt = true
+			case "lt":
+				lt = true
+			case "ch":
+				ch = true
+			case "incr":
+				incr = true
+			default:
+				break opts
+			}
+			pairs = pairs[1:]
+		}
+	}
+
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		c.reply(errSyntax)
+		return
+	}
+
+	if nx && xx {
+		c.reply(radio.ErrorStr("ERR XX and NX options at the same time are not compatible"))
+		return
+	}
+
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		c.reply(radio.ErrorStr("ERR GT, LT, and/or NX options at the same time are not compatible"))
+		return
+	}
+
+	if incr && len(pairs) > 2 {
+		c.reply(radio.ErrorStr("ERR INCR option supports a single increment-element pair"))
+		return
+	}
+
+	scores := make([]float64, len(pairs)/2)
+	for i := range scores {
+		score, ok := parseFloat(pairs[2*i])
+		if !ok {
+			c.reply(errNotFloat)
+			return
+		}
+		scores[i] = score
+	}
+
+	_, z, ok := c.lookupZset(key)
+	if !ok {
+		return
+	}
+
+	created := z == nil
+	if created {
+		if xx {
+			if incr {
+				c.reply(nullBulk)
+			} else {
+				c.reply(radio.Integer(0))
+			}
+			return
+		}
+		z = &zset{}
+	}
+
+	added, changed := 0, 0
+	aborted := false
+	var score float64
+	for i := range scores {
+		member := pairs[2*i+1]
+		score = scores[i]
+
+		cur, exists := z.score(member)
+		if !exists {
+			if xx {
+				aborted = true
+				continue
+			}
+
+			z.set(member, score)
+			added++
+			continue
+		}
+
+		if nx {
+			aborted = true
+			continue
+		}
+
+		if incr {
+			score += cur
+			if math.IsNaN(score) {
+				c.reply(radio.ErrorStr("ERR resulting score is not a number (NaN)"))
+				return
+			}
+		}
+
+		if (gt && score <= cur) || (lt && score >= cur) {
+			aborted = true
+			continue
+		}
+
+		if score != cur {
+			z.set(member, score)
+			changed++
+		}
+	}
+
+	if added+changed > 0 {
+		if created {
+			c.add(key, z)
+		}
+
+		c.touch(key)
+		if incr {
+			c.notify(keyspace.ZSet, "zincr", key)
+		} else {
+			c.notify(keyspace.ZSet, "zadd", key)
+		}
+
+		if added > 0 {
+			c.signal(key)
+		}
+	}
+
+	switch {
+	case incr && aborted:
+		c.reply(nullBulk)
+	case incr:
+		c.reply(bulkStr(formatDouble(score)))
+	case ch:
+		c.reply(radio.Integer(added + changed))
+	default:
+		c.reply(radio.Integer(added))
+	}
+}
+
+func zremCommand(c *call) {
+	key := c.args[0]
+	_, z, ok := c.lookupZset(key)
+	if !ok {
+		return
+	}
+
+	removed := 0
+	if z != nil {
+		for _, member := range c.args[1:] {
+			if z.remove(member) {
+				removed++
+			}
+		}
+	}
+
+	if removed > 0 {
+		c.touch(key)
+		c.notify(keyspace.ZSet, "zrem", key)
+		c.removeIfEmpty(key, z.len())
+	}
+	c.reply(radio.Integer(removed))
+}
+
+func zcardCommand(c *call) {
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	}
+
+	if z == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+	c.reply(radio.Integer(z.len()))
+}
+
+func zscoreCommand(c *call) {
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	}
+
+	results := make([]radio.Value, len(c.args)-1)
+	for i, member := range c.args[1:] {
+		results[i] = nullBulk
+		if z == nil {
+			continue
+		}
+
+		if score, ok := z.score(member); ok {
+			results[i] = bulkStr(formatDouble(score))
+		}
+	}
+
+	if c.name == "zscore" {
+		c.reply(results[0])
+	} else {
+		c.reply(&radio.Array{Items: results})
+	}
+}
+
+func zrankCommand(c *call) {
+	if len(c.args) > 3 || (len(c.args) == 3 && !strings.EqualFold(c.args[2], "withscore")) {
+		c.reply(errSyntax)
+		return
+	}
+
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	}
+
+	withScore := len(c.args) == 3
+	rank := -1
+	if z != nil {
+		rank = z.rank(c.args[1])
+	}
+
+	if rank < 0 {
+		if withScore {
+			c.reply(&radio.Array{})
+		} else {
+			c.reply(nullBulk)
+		}
+		return
+	}
+
+	if c.name == "zrevrank" {
+		rank = z.len() - 1 - rank
+	}
+
+	if !withScore {
+		c.reply(radio.Integer(rank))
+		return
+	}
+
+	score, _ := z.score(c.args[1])
+	c.reply(&radio.Array{Items: []radio.Value{
+		radio.Integer(rank),
+		bulkStr(formatDouble(score)),
+	}})
+}
+
+const (
+	byRank = iota
+	byScore
+	byLex
+)
+
+// zrangeSpec is a range of elements of a sorted set as selected by the
+// ZRANGE family of commands.
+type zrangeSpec struct {
+	by          int
+	rev         bool
+	start, stop int64
+	scores      scoreRange
+	lex         lexRange
+	withScores  bool
+
+	limit         bool
+	offset, count int64 // a negative count selects all the elements
+}
+
+// scoreRange is a range of scores with optionally exclusive bounds.
+type scoreRange struct {
+	min, max     float64
+	minEx, maxEx bool
+}
+
+// lexRange is a range of members. The members of the range are only
+// meaningful if all the elements have the same score.
+type lexRange struct {
+	min, max lexBound
+}
+
+// lexBound is a bound of a lexRange: a member or, if inf is -1 or 1, the
+// negative or positive infinity ("-" and "+").
+type lexBound struct {
+	value string
+	ex    bool
+	inf   int
+}
+
+func parseScoreBound(s string) (score float64, ex, ok bool) {
+	if strings.HasPrefix(s, "(") {
+		ex, s = true, s[1:]
+	}
+
+	score, ok = parseFloat(s)
+	return score, ex, ok
+}
+
+func parseLexBound(s string) (b lexBound, ok bool) {
+	switch {
+	case s == "-":
+		b.inf = -1
+	case s == "+":
+		b.inf = 1
+	case strings.HasPrefix(s, "("):
+		b.value, b.ex = s[1:], true
+	case strings.HasPrefix(s, "["):
+		b.value = s[1:]
+	default:
+		return b, false
+	}
+	return b, true
+}
+
+// before reports whether the member sorts before the bound. A member equal
+// to the bound sorts before it if orEqual is set.
+func (b lexBound) before(member string, orEqual bool) bool {
+	if b.inf != 0 {
+		return b.inf > 0
+	}
+	return member < b.value || (orEqual && member == b.value)
+}
+
+// parseRange parses the start and stop (or min and max) arguments of the
+// range.
+func (c *call) parseRange(spec *zrangeSpec, start, stop string) bool {
+	if spec.rev && spec.by != byRank {
+		// reversed ranges of scores and members start with the maximum.
+		start, stop = stop, start
+	}
+
+	switch spec.by {
+	case byRank:
+		var ok1, ok2 bool
+		spec.start, ok1 = parseInt(start)
+		spec.stop, ok2 = parseInt(stop)
+		if !ok1 || !ok2 {
+			c.reply(errNotInteger)
+			return false
+		}
+
+	case byScore:
+		var ok1, ok2 bool
+		spec.scores.min, spec.scores.minEx, ok1 = parseScoreBound(start)
+		spec.scores.max, spec.scores.maxEx, ok2 = parseScoreBound(stop)
+		if !ok1 || !ok2 {
+			c.reply(radio.ErrorStr("ERR min or max is not a float"))
+			return false
+		}
+
+	case byLex:
+		var ok1, ok2 bool
+		spec.lex.min, ok1 = parseLexBound(start)
+		spec.lex.max, ok2 = parseLexBound(stop)
+		if !ok1 || !ok2 {
+			c.reply(radio.ErrorStr("ERR min or max not valid string range item"))
+			return false
+		}
+	}
+	return true
+}
+
+// parseZrange parses the arguments, following the key, of the ZRANGE
+// family of commands.
+func (c *call) parseZrange(args []string) (spec zrangeSpec, ok bool) {
+	spec.count = -1
+	switch c.name {
+	case "zrevrange":
+		spec.rev = true
+	case "zrangebyscore":
+		spec.by = byScore
+	case "zrevrangebyscore":
+		spec.by, spec.rev = byScore, true
+	case "zrangebylex":
+		spec.by = byLex
+	case "zrevrangebylex":
+		spec.by, spec.rev = byLex, true
+	}
+
+	unified := c.name == "zrange" || c.name == "zrangestore"
+	withScores := c.name != "zrangestore" && !strings.HasSuffix(c.name, "bylex")
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "withscores" && withScores:
+			spec.withScores = true
+
+		case opt == "limit" && c.name != "zrevrange" && i+2 < len(args):
+			offset, ok1 := parseInt(args[i+1])
+			count, ok2 := parseInt(args[i+2])
+			if !ok1 || !ok2 {
+				c.reply(errNotInteger)
+				return spec, false
+			}
+			spec.limit, spec.offset, spec.count = true, offset, count
+			i += 2
+
+		case opt == "byscore" && unified:
+			spec.by = byScore
+
+		case opt == "bylex" && unified:
+			spec.by = byLex
+
+		case opt == "rev" && unified:
+			spec.rev = true
+
+		default:
+			c.reply(errSyntax)
+			return spec, false
+		}
+	}
+
+	if spec.limit && spec.by == byRank {
+		c.reply(radio.ErrorStr("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"))
+		return spec, false
+	}
+
+	if spec.withScores && spec.by == byLex {
+		c.reply(radio.ErrorStr("ERR syntax error, WITHSCORES not supported in combination with BYLEX"))
+		return spec, false
+	}
+
+	return spec, c.parseRange(&spec, args[0], args[1])
+}
+
+// ranks returns the range [from, to) of the ascending ranks of the
+// elements within the range ignoring the limit.
+func (spec *zrangeSpec) ranks(z *zset) (from, to int) {
+	switch spec.by {
+	case byScore:
+		r := spec.scores
+		from = z.countWhile(func(e scored) bool {
+			return e.score < r.min || (r.minEx && e.score == r.min)
+		})
+		to = z.countWhile(func(e scored) bool {
+			return e.score < r.max || (!r.maxEx && e.score == r.max)
+		})
+
+	case byLex:
+		r := spec.lex
+		from = z.countWhile(func(e scored) bool {
+			return r.min.before(e.member, r.min.ex)
+		})
+		to = z.countWhile(func(e scored) bool {
+			return r.max.before(e.member, !r.max.ex)
+		})
+
+	default:
+		n := z.len()
+		from, to = listRange(spec.start, spec.stop, n)
+		if spec.rev {
+			from, to = n-to, n-from
+		}
+	}
+
+	if to < from {
+		to = from
+	}
+	return from, to
+}
+
+// selection returns the elements within the range in the order of the
+// range, applying the limit.
+func (spec *zrangeSpec) selection(z *zset) []scored {
+	if z == nil || spec.offset < 0 {
+		return nil
+	}
+
+	from, to := spec.ranks(z)
+	n := int64(to-from) - spec.offset
+	if spec.count >= 0 && spec.count < n {
+		n = spec.count
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	start := from + int(spec.offset)
+	if spec.rev {
+		start = to - 1 - int(spec.offset)
+	}
+
+	elems := make([]scored, 0, n)
+	z.each(start, spec.rev, func(e scored) bool {
+		elems = append(elems, e)
+		return int64(len(elems)) < n
+	})
+	return elems
+}
+
+func zrangeCommand(c *call) {
+	args := c.args
+	if c.name == "zrangestore" {
+		args = c.args[1:]
+	}
+
+	spec, ok := c.parseZrange(args[1:])
+	if !ok {
+		return
+	}
+
+	_, z, ok := c.lookupZset(args[0])
+	if !ok {
+		return
+	}
+
+	elems := spec.selection(z)
+	if c.name != "zrangestore" {
+		c.reply(scoredReply(elems, spec.withScores))
+		return
+	}
+
+	c.storeZset(c.args[0], elems)
+	c.reply(radio.Integer(len(elems)))
+}
+
+func zcountCommand(c *call) {
+	spec := zrangeSpec{by: byScore}
+	if c.name == "zlexcount" {
+		spec.by = byLex
+	}
+
+	if !c.parseRange(&spec, c.args[1], c.args[2]) {
+		return
+	}
+
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	}
+
+	if z == nil {
+		c.reply(radio.Integer(0))
+		return
+	}
+
+	from, to := spec.ranks(z)
+	c.reply(radio.Integer(to - from))
+}
+
+func zremrangeCommand(c *call) {
+	spec := zrangeSpec{count: -1}
+	switch c.name {
+	case "zremrangebyscore":
+		spec.by = byScore
+	case "zremrangebylex":
+		spec.by = byLex
+	}
+
+	if !c.parseRange(&spec, c.args[1], c.args[2]) {
+		return
+	}
+
+	key := c.args[0]
+	_, z, ok := c.lookupZset(key)
+	if !ok {
+		return
+	}
+
+	elems := spec.selection(z)
+	if len(elems) > 0 {
+		for _, e := range elems {
+			z.remove(e.member)
+		}
+
+		c.touch(key)
+		c.notify(keyspace.ZSet, c.name, key)
+		c.removeIfEmpty(key, z.len())
+	}
+	c.reply(radio.Integer(len(elems)))
+}
+
+// zpop pops up to count elements with the lowest scores, or the highest
+// scores for ZPOPMAX and BZPOPMAX.
+func (c *call) zpop(key string, z *zset, count int64) []scored {
+	if count == 0 {
+		return nil
+	}
+
+	max := strings.HasSuffix(c.name, "max")
+	from := 0
+	if max {
+		from = z.len() - 1
+	}
+
+	var popped []scored
+	z.each(from, max, func(e scored) bool {
+		popped = append(popped, e)
+		return int64(len(popped)) < count
+	})
+
+	for _, e := range popped {
+		z.remove(e.member)
+	}
+
+	c.touch(key)
+	c.notify(keyspace.ZSet, strings.TrimPrefix(c.name, "b"), key)
+	c.removeIfEmpty(key, z.len())
+	return popped
+}
+
+func zpopCommand(c *call) {
+	if len(c.args) > 2 {
+		c.reply(errSyntax)
+		return
+	}
+
+	count := int64(1)
+	if len(c.args) == 2 {
+		n, ok := parseInt(c.args[1])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+		if n < 0 {
+			c.reply(radio.ErrorStr("ERR value is out of range, must be positive"))
+			return
+		}
+		count = n
+	}
+
+	key := c.args[0]
+	_, z, ok := c.lookupZset(key)
+	if !ok {
+		return
+	}
+
+	if z == nil {
+		c.reply(bulkArray(nil))
+		return
+	}
+	c.reply(scoredReply(c.zpop(key, z, count), true))
+}
+
+func bzpopCommand(c *call) {
+	keys := c.args[:len(c.args)-1]
+	timeout, ok := c.parseTimeout(c.args[len(c.args)-1])
+	if !ok {
+		return
+	}
+
+	for _, key := range keys {
+		_, z, ok := c.lookupZset(key)
+		if !ok {
+			return
+		}
+
+		if z != nil {
+			bzpopServe(c, key)
+			return
+		}
+	}
+
+	c.block(keys, timeout, func(wr radio.ResponseWriter) {
+		wr.Write(&radio.Array{})
+	}, bzpopServe)
+}
+
+// bzpopServe pops an element of the sorted set for BZPOPMIN and BZPOPMAX.
+// Returns false if the key does not hold a sorted set.
+func bzpopServe(c *call, key string) bool {
+	e := c.lookup(key)
+	if e == nil || typeOf(e.val) != "zset" {
+		return false
+	}
+
+	popped := c.zpop(key, e.val.(*zset), 1)
+	reply := scoredReply(popped, true)
+	reply.Items = append([]radio.Value{bulkStr(key)}, reply.Items...)
+	c.reply(reply)
+	return true
+}
+
+func zrandmemberCommand(c *call) {
+	if len(c.args) > 3 || (len(c.args) == 3 && !strings.EqualFold(c.args[2], "withscores")) {
+		c.reply(errSyntax)
+		return
+	}
+
+	var count int64
+	if len(c.args) > 1 {
+		n, ok := parseInt(c.args[1])
+		if !ok {
+			c.reply(errNotInteger)
+			return
+		}
+		if n < -math.MaxInt64/2 {
+			c.reply(radio.ErrorStr("ERR value is out of range"))
+			return
+		}
+		count = n
+	}
+
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	}
+
+	if len(c.args) == 1 {
+		if z == nil {
+			c.reply(nullBulk)
+		} else {
+			c.reply(bulkStr(z.random().member))
+		}
+		return
+	}
+
+	if z == nil {
+		c.reply(bulkArray(nil))
+		return
+	}
+
+	withScores := len(c.args) == 3
+	if count < 0 {
+		picked := make([]scored, -count)
+		for i := range picked {
+			picked[i] = z.random()
+		}
+		c.reply(scoredReply(picked, withScores))
+		return
+	}
+
+	elems := make([]scored, 0, z.len())
+	z.each(0, false, func(e scored) bool {
+		elems = append(elems, e)
+		return true
+	})
+
+	if count < int64(len(elems)) {
+		for i := 0; i < int(count); i++ {
+			j := i + rand.Intn(len(elems)-i)
+			elems[i], elems[j] = elems[j], elems[i]
+		}
+		elems = elems[:count]
+	}
+	c.reply(scoredReply(elems, withScores))
+}
+
+func zscanCommand(c *call) {
+	opts, ok := c.parseScan(c.args[1:])
+	if !ok {
+		return
+	}
+
+	_, z, ok := c.lookupZset(c.args[0])
+	if !ok {
+		return
+	}
+
+	var matched []scored
+	var next uint64
+	if z != nil {
+		visited := int64(0)
+		next = z.scan(opts.cursor, func(e scored) bool {
+			visited++
+			if opts.pattern == "" || radio.MatchPattern(opts.pattern, e.member) {
+				matched = append(matched, e)
+			}
+			return z.dict == nil || visited < opts.count
+		})
+	}
+
+	c.reply(&radio.Array{Items: []radio.Value{
+		bulkStr(strconv.FormatUint(next, 10)),
+		scoredReply(matched, true),
+	}})
+}
+
+// zinput is an input of ZUNION, ZINTER and ZDIFF: a sorted set or a set
+// whose members have a score of 1. Both are nil if the key does not exist.
+type zinput struct {
+	z *zset
+	s *set
+}
+
+func (in zinput) len() int {
+	switch {
+	case in.z != nil:
+		return in.z.len()
+	case in.s != nil:
+		return in.s.len()
+	}
+	return 0
+}
+
+func (in zinput) score(member string) (float64, bool) {
+	switch {
+	case in.z != nil:
+		return in.z.score(member)
+	case in.s != nil:
+		return 1, in.s.has(member)
+	}
+	return 0, false
+}
+
+func (in zinput) each(fn func(e scored)) {
+	switch {
+	case in.z != nil:
+		in.z.each(0, false, func(e scored) bool {
+			fn(e)
+			return true
+		})
+
+	case in.s != nil:
+		in.s.each(func(member string) bool {
+			fn(scored{member: member, score: 1})
+			return true
+		})
+	}
+}
+
+// weigh returns the score multiplied by the weight. Like Redis, NaN
+// (infinity times zero) is replaced with zero.
+func weigh(score, weight float64) float64 {
+	v := score * weight
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// aggregate aggregates the scores of a member of multiple inputs.
+func aggregate(how string, a, b float64) float64 {
+	switch how {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	}
+
+	sum := a + b
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+func zsetopCommand(c *call) {
+	args := c.args
+	store := strings.HasSuffix(c.name, "store")
+	if store {
+		args = c.args[1:]
+	}
+	op := strings.TrimSuffix(c.name, "store")
+
+	numKeys, ok := parseInt(args[0])
+	if !ok {
+		c.reply(errNotInteger)
+		return
+	}
+
+	if numKeys < 1 {
+		c.reply(radio.ErrorStr("ERR at least 1 input key is needed for '" + c.name + "' command"))
+		return
+	}
+
+	if numKeys > int64(len(args)-1) {
+		c.reply(errSyntax)
+		return
+	}
+
+	keys, opts := args[1:1+numKeys], args[1+numKeys:]
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	how, withScores := "sum", false
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToLower(opts[i])
+		switch {
+		case opt == "weights" && op != "zdiff" && i+len(keys) < len(opts):
+			for j := range weights {
+				w, ok := parseFloat(opts[i+1+j])
+				if !ok {
+					c.reply(radio.ErrorStr("ERR weight value is not a float"))
+					return
+				}
+				weights[j] = w
+			}
+			i += len(keys)
+
+		case opt == "aggregate" && op != "zdiff" && i+1 < len(opts):
+			how = strings.ToLower(opts[i+1])
+			if how != "sum" && how != "min" && how != "max" {
+				c.reply(errSyntax)
+				return
+			}
+			i++
+
+		case opt == "withscores" && !store:
+			withScores = true
+
+		default:
+			c.reply(errSyntax)
+			return
+		}
+	}
+
+	inputs := make([]zinput, len(keys))
+	for i, key := range keys {
+		e := c.lookup(key)
+		if e == nil {
+			continue
+		}
+
+		switch v := e.val.(type) {
+		case *zset:
+			inputs[i].z = v
+		case *set:
+			inputs[i].s = v
+		default:
+			c.reply(errWrongType)
+			return
+		}
+	}
+
+	var elems []scored
+	switch op {
+	case "zunion":
+		scores := map[string]float64{}
+		for i, in := range inputs {
+			in.each(func(e scored) {
+				v := weigh(e.score, weights[i])
+				if cur, found := scores[e.member]; found {
+					v = aggregate(how, cur, v)
+				}
+				scores[e.member] = v
+			})
+		}
+
+		elems = make([]scored, 0, len(scores))
+		for member, score := range scores {
+			elems = append(elems, scored{member: member, score: score})
+		}
+
+	case "zinter":
+		smallest := 0
+		for i, in := range inputs {
+			if in.len() < inputs[smallest].len() {
+				smallest = i
+			}
+		}
+
+		inputs[smallest].each(func(e scored) {
+			var acc float64
+			for i, in := range inputs {
+				score, found := in.score(e.member)
+				if !found {
+					return
+				}
+
+				v := weigh(score, weights[i])
+				if i == 0 {
+					acc = v
+				} else {
+					acc = aggregate(how, acc, v)
+				}
+			}
+			elems = append(elems, scored{member: e.member, score: acc})
+		})
+
+	case "zdiff":
+		inputs[0].each(func(e scored) {
+			for _, in := range inputs[1:] {
+				if _, found := in.score(e.member); found {
+					return
+				}
+			}
+			elems = append(elems, e)
+		})
+	}
+
+	sort.Slice(elems, func(i, j int) bool {
+		return elems[i].before(elems[j])
+	})
+
+	if !store {
+		c.reply(scoredReply(elems, withScores))
+		return
+	}
+
+	c.storeZset(c.args[0], elems)
+	c.reply(radio.Integer(len(elems)))
+}
@@ -0,0 +1,301 @@
+package store_test
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+	"github.com/spy16/radio/store"
+)
+
+func TestZsets(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		steps []step
+	}{
+		{
+			title: "ZADD",
+			steps: []step{
+				{cmd: "ZADD z 1 a 2 b 3 c", reply: radio.Integer(3)},
+				{cmd: "TYPE z", reply: radio.SimpleStr("zset")},
+				{cmd: "ZADD z 5 a 4 d", reply: radio.Integer(1)},
+				{cmd: "ZADD z CH 6 a 4 d 7 e", reply: radio.Integer(2)},
+				{cmd: "ZADD z NX 1 a 8 f", reply: radio.Integer(1)},
+				{cmd: "ZADD z XX 1 a 9 g", reply: radio.Integer(0)},
+				{cmd: "ZADD z GT CH 0 a 3 b", reply: radio.Integer(1)},
+				{cmd: "ZADD z LT CH 9 c 2 d", reply: radio.Integer(1)},
+				{cmd: "ZRANGE z 0 -1 WITHSCORES", reply: bulks("a", "1", "d", "2", "b", "3", "c", "3", "e", "7", "f", "8")},
+				{cmd: "ZADD z INCR 2.5 a", reply: bulk("3.5")},
+				{cmd: "ZADD z NX INCR 1 a", reply: nullBulk},
+				{cmd: "ZADD z GT INCR -1 a", reply: nullBulk},
+				{cmd: "ZINCRBY z 10 x", reply: bulk("10")},
+				{cmd: "ZADD y XX 1 a", reply: radio.Integer(0)},
+				{cmd: "EXISTS y", reply: radio.Integer(0)},
+				{cmd: "ZADD z NX XX 1 a", reply: radio.ErrorStr("ERR XX and NX options at the same time are not compatible")},
+				{cmd: "ZADD z GT LT 1 a", reply: radio.ErrorStr("ERR GT, LT, and/or NX options at the same time are not compatible")},
+				{cmd: "ZADD z INCR 1 a 2 b", reply: radio.ErrorStr("ERR INCR option supports a single increment-element pair")},
+				{cmd: "ZADD z 1 a 2", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "ZADD z x a", reply: radio.ErrorStr("ERR value is not a valid float")},
+				{cmd: "ZADD z nan a", reply: radio.ErrorStr("ERR value is not a valid float")},
+				{cmd: "ZADD i inf a", reply: radio.Integer(1)},
+				{cmd: "ZINCRBY i -inf a", reply: radio.ErrorStr("ERR resulting score is not a number (NaN)")},
+				{cmd: "SET s 1", reply: okReply},
+				{cmd: "ZADD s 1 a", reply: wrongType},
+			},
+		},
+		{
+			title: "Scores",
+			steps: []step{
+				{cmd: "ZADD z 1e20 a 0.1 b inf c -inf d 1e-7 e 0.000001 f 100 g -0 h 123456789012345678 i 1.5e300 j", reply: radio.Integer(10)},
+				{cmd: "ZMSCORE z a b c d e f g h i j x", reply: &radio.Array{Items: []radio.Value{
+					bulk("1e+20"), bulk("0.1"), bulk("inf"), bulk("-inf"), bulk("1e-7"),
+					bulk("0.000001"), bulk("100"), bulk("-0"), bulk("123456789012345680"), bulk("1.5e+300"), nullBulk,
+				}}},
+				{cmd: "ZSCORE z b", reply: bulk("0.1")},
+				{cmd: "ZSCORE z x", reply: nullBulk},
+				{cmd: "ZSCORE x b", reply: nullBulk},
+				{cmd: "ZCARD z", reply: radio.Integer(10)},
+				{cmd: "ZCARD x", reply: radio.Integer(0)},
+				{cmd: "ZREM z a b x", reply: radio.Integer(2)},
+				{cmd: "ZRANK z d", reply: radio.Integer(0)},
+				{cmd: "ZRANK z c WITHSCORE", reply: &radio.Array{Items: []radio.Value{radio.Integer(7), bulk("inf")}}},
+				{cmd: "ZREVRANK z c", reply: radio.Integer(0)},
+				{cmd: "ZRANK z x", reply: nullBulk},
+				{cmd: "ZRANK z x WITHSCORE", reply: &radio.Array{}},
+				{cmd: "ZRANK z c FOO", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "ZRANGE",
+			steps: []step{
+				{cmd: "ZADD z 1 a 2 b 3 c 4 d 5 e", reply: radio.Integer(5)},
+				{cmd: "ZRANGE z 1 -2", reply: bulks("b", "c", "d")},
+				{cmd: "ZRANGE z 0 0 REV WITHSCORES", reply: bulks("e", "5")},
+				{cmd: "ZREVRANGE z 0 1", reply: bulks("e", "d")},
+				{cmd: "ZRANGE z (1 3 BYSCORE", reply: bulks("b", "c")},
+				{cmd: "ZRANGE z +inf 4 BYSCORE REV", reply: bulks("e", "d")},
+				{cmd: "ZRANGE z -inf +inf BYSCORE LIMIT 1 2", reply: bulks("b", "c")},
+				{cmd: "ZRANGE z 5 -inf BYSCORE REV LIMIT 1 -1", reply: bulks("d", "c", "b", "a")},
+				{cmd: "ZRANGE z 0 -1 BYSCORE LIMIT -1 2", reply: bulks()},
+				{cmd: "ZRANGEBYSCORE z 2 (4 WITHSCORES", reply: bulks("b", "2", "c", "3")},
+				{cmd: "ZREVRANGEBYSCORE z 4 2 LIMIT 0 1", reply: bulks("d")},
+				{cmd: "ZRANGE z 3 1 BYSCORE", reply: bulks()},
+				{cmd: "ZCOUNT z (1 3", reply: radio.Integer(2)},
+				{cmd: "ZCOUNT x 1 3", reply: radio.Integer(0)},
+				{cmd: "ZRANGE z 0 -1 LIMIT 0 1", reply: radio.ErrorStr("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")},
+				{cmd: "ZRANGE z a 1", reply: radio.ErrorStr("ERR value is not an integer or out of range")},
+				{cmd: "ZRANGE z x 1 BYSCORE", reply: radio.ErrorStr("ERR min or max is not a float")},
+				{cmd: "ZREVRANGE z 0 1 LIMIT 0 1", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "ZRANGE x 0 -1", reply: bulks()},
+			},
+		},
+		{
+			title: "ByLex",
+			steps: []step{
+				{cmd: "ZADD z 0 a 0 b 0 c 0 d 0 e", reply: radio.Integer(5)},
+				{cmd: "ZRANGE z [b (d BYLEX", reply: bulks("b", "c")},
+				{cmd: "ZRANGE z + [c BYLEX REV", reply: bulks("e", "d", "c")},
+				{cmd: "ZRANGEBYLEX z - + LIMIT 3 5", reply: bulks("d", "e")},
+				{cmd: "ZREVRANGEBYLEX z (c -", reply: bulks("b", "a")},
+				{cmd: "ZLEXCOUNT z (a [c", reply: radio.Integer(2)},
+				{cmd: "ZRANGE z - + BYLEX WITHSCORES", reply: radio.ErrorStr("ERR syntax error, WITHSCORES not supported in combination with BYLEX")},
+				{cmd: "ZRANGEBYLEX z - + WITHSCORES", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "ZRANGEBYLEX z a c", reply: radio.ErrorStr("ERR min or max not valid string range item")},
+				{cmd: "ZREMRANGEBYLEX z [a (c", reply: radio.Integer(2)},
+				{cmd: "ZRANGE z 0 -1", reply: bulks("c", "d", "e")},
+			},
+		},
+		{
+			title: "Remove",
+			steps: []step{
+				{cmd: "ZADD z 1 a 2 b 3 c 4 d 5 e", reply: radio.Integer(5)},
+				{cmd: "ZREMRANGEBYRANK z -1 -1", reply: radio.Integer(1)},
+				{cmd: "ZREMRANGEBYSCORE z (1 2", reply: radio.Integer(1)},
+				{cmd: "ZREMRANGEBYSCORE z 10 20", reply: radio.Integer(0)},
+				{cmd: "ZRANGE z 0 -1", reply: bulks("a", "c", "d")},
+				{cmd: "ZREMRANGEBYRANK z 0 -1", reply: radio.Integer(3)},
+				{cmd: "EXISTS z", reply: radio.Integer(0)},
+				{cmd: "ZREMRANGEBYRANK z 0 -1", reply: radio.Integer(0)},
+			},
+		},
+		{
+			title: "ZRANGESTORE",
+			steps: []step{
+				{cmd: "ZADD z 1 a 2 b 3 c", reply: radio.Integer(3)},
+				{cmd: "ZRANGESTORE d z 2 +inf BYSCORE", reply: radio.Integer(2)},
+				{cmd: "ZRANGE d 0 -1 WITHSCORES", reply: bulks("b", "2", "c", "3")},
+				{cmd: "ZRANGESTORE d z 5 6 BYSCORE", reply: radio.Integer(0)},
+				{cmd: "EXISTS d", reply: radio.Integer(0)},
+				{cmd: "ZRANGESTORE d z 0 -1 WITHSCORES", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "Pop",
+			steps: []step{
+				{cmd: "ZPOPMIN z", reply: bulks()},
+				{cmd: "ZADD z 1 a 2 b 3 c 4 d", reply: radio.Integer(4)},
+				{cmd: "ZPOPMIN z", reply: bulks("a", "1")},
+				{cmd: "ZPOPMAX z 2", reply: bulks("d", "4", "c", "3")},
+				{cmd: "ZPOPMAX z 0", reply: bulks()},
+				{cmd: "ZPOPMAX z -1", reply: radio.ErrorStr("ERR value is out of range, must be positive")},
+				{cmd: "BZPOPMIN x z 0", reply: bulks("z", "b", "2")},
+				{cmd: "EXISTS z", reply: radio.Integer(0)},
+				{cmd: "BZPOPMAX x z 0", reply: &radio.Array{}},
+			},
+		},
+		{
+			title: "ZRANDMEMBER",
+			steps: []step{
+				{cmd: "ZRANDMEMBER z", reply: nullBulk},
+				{cmd: "ZRANDMEMBER z 5", reply: bulks()},
+				{cmd: "ZADD z 1.5 a", reply: radio.Integer(1)},
+				{cmd: "ZRANDMEMBER z", reply: bulk("a")},
+				{cmd: "ZRANDMEMBER z 5 WITHSCORES", reply: bulks("a", "1.5")},
+				{cmd: "ZRANDMEMBER z -2", reply: bulks("a", "a")},
+				{cmd: "ZRANDMEMBER z 1 FOO", reply: radio.ErrorStr("ERR syntax error")},
+			},
+		},
+		{
+			title: "Algebra",
+			steps: []step{
+				{cmd: "ZADD a 1 x 2 y 3 z", reply: radio.Integer(3)},
+				{cmd: "ZADD b 10 y 20 z 30 w", reply: radio.Integer(3)},
+				{cmd: "SADD s y w", reply: radio.Integer(2)},
+				{cmd: "ZUNION 2 a b WITHSCORES", reply: bulks("x", "1", "y", "12", "z", "23", "w", "30")},
+				{cmd: "ZUNION 2 a b WEIGHTS 2 0.5 AGGREGATE MAX WITHSCORES", reply: bulks("x", "2", "y", "5", "z", "10", "w", "15")},
+				{cmd: "ZINTER 3 a b s WITHSCORES", reply: bulks("y", "13")},
+				{cmd: "ZINTER 2 a b AGGREGATE MIN WITHSCORES", reply: bulks("y", "2", "z", "3")},
+				{cmd: "ZINTER 2 a missing", reply: bulks()},
+				{cmd: "ZDIFF 2 a s WITHSCORES", reply: bulks("x", "1", "z", "3")},
+				{cmd: "ZUNIONSTORE d 2 a b", reply: radio.Integer(4)},
+				{cmd: "ZRANGE d 0 -1 WITHSCORES", reply: bulks("x", "1", "y", "12", "z", "23", "w", "30")},
+				{cmd: "ZINTERSTORE d 2 d a WEIGHTS 1 -1", reply: radio.Integer(3)},
+				{cmd: "ZRANGE d 0 -1 WITHSCORES", reply: bulks("x", "0", "y", "10", "z", "20")},
+				{cmd: "ZDIFFSTORE d 2 a a", reply: radio.Integer(0)},
+				{cmd: "EXISTS d", reply: radio.Integer(0)},
+				{cmd: "ZADD i inf x", reply: radio.Integer(1)},
+				{cmd: "ZUNION 2 i i WEIGHTS 1 -1 WITHSCORES", reply: bulks("x", "0")},
+				{cmd: "ZUNION 2 i i WEIGHTS 0 1 AGGREGATE MIN WITHSCORES", reply: bulks("x", "0")},
+				{cmd: "ZUNION 0 a", reply: radio.ErrorStr("ERR at least 1 input key is needed for 'zunion' command")},
+				{cmd: "ZUNION 3 a b", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "ZUNION 2 a b WEIGHTS 1", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "ZUNION 2 a b WEIGHTS 1 x", reply: radio.ErrorStr("ERR weight value is not a float")},
+				{cmd: "ZUNION 2 a b AGGREGATE AVG", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "ZDIFF 2 a b WEIGHTS 1 1", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "ZUNIONSTORE d 2 a b WITHSCORES", reply: radio.ErrorStr("ERR syntax error")},
+				{cmd: "SET str 1", reply: okReply},
+				{cmd: "ZUNION 2 a str", reply: wrongType},
+			},
+		},
+		{
+			title: "ZSCAN",
+			steps: []step{
+				{cmd: "ZADD z 1 a1 2 a2 3 b1", reply: radio.Integer(3)},
+				{cmd: "ZSCAN z 0", reply: scanReply("0", "a1", "1", "a2", "2", "b1", "3")},
+				{cmd: "ZSCAN z 0 MATCH a* COUNT 1", reply: scanReply("0", "a1", "1", "a2", "2")},
+				{cmd: "ZSCAN x 0", reply: scanReply("0")},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		cs := cs
+		suite.Run(cs.title, func(t *testing.T) {
+			s := newStore(nil)
+			defer s.Close()
+			runSteps(t, s, cs.steps)
+		})
+	}
+}
+
+func TestZsets_Encoding(t *testing.T) {
+	s := newStore(nil)
+	defer s.Close()
+
+	runSteps(t, s, []step{
+		{cmd: "ZADD small 1 a", reply: radio.Integer(1)},
+		{cmd: "OBJECT ENCODING small", reply: bulk("listpack")},
+		{cmd: "ZADD long 1 " + strings.Repeat("x", 65), reply: radio.Integer(1)},
+		{cmd: "OBJECT ENCODING long", reply: bulk("skiplist")},
+	})
+
+	// scores in reverse order of the members, with ties broken by member.
+	var want []string
+	for i := 0; i < 300; i++ {
+		member := "m" + strconv.Itoa(1000+i)
+		s.Do(0, "ZADD", "big", strconv.Itoa((299-i)/2), member)
+		if i == 127 {
+			runSteps(t, s, []step{{cmd: "OBJECT ENCODING big", reply: bulk("listpack")}})
+		}
+	}
+	for score := 0; score < 150; score++ {
+		i := 299 - 2*score
+		want = append(want, "m"+strconv.Itoa(1000+i-1), "m"+strconv.Itoa(1000+i))
+	}
+
+	runSteps(t, s, []step{
+		{cmd: "OBJECT ENCODING big", reply: bulk("skiplist")},
+		{cmd: "ZCARD big", reply: radio.Integer(300)},
+		{cmd: "ZRANGE big 0 -1", reply: bulks(want...)},
+		{cmd: "ZRANGE big 10 13", reply: bulks(want[10:14]...)},
+		{cmd: "ZRANGE big 1 0 BYSCORE REV", reply: bulks(want[3], want[2], want[1], want[0])},
+		{cmd: "ZRANK big " + want[200], reply: radio.Integer(200)},
+		{cmd: "ZREVRANK big " + want[200], reply: radio.Integer(99)},
+		{cmd: "ZCOUNT big (10 20", reply: radio.Integer(20)},
+		{cmd: "ZADD big 1000 " + want[0], reply: radio.Integer(0)},
+		{cmd: "ZRANK big " + want[0], reply: radio.Integer(299)},
+		{cmd: "ZREMRANGEBYRANK big 0 99", reply: radio.Integer(100)},
+		{cmd: "ZRANGE big 0 1", reply: bulks(want[101:103]...)},
+		{cmd: "ZPOPMAX big", reply: bulks(want[0], "1000")},
+		{cmd: "ZCARD big", reply: radio.Integer(199)},
+	})
+
+	members := map[string]bool{}
+	cursor := "0"
+	for {
+		reply := s.Do(0, "ZSCAN", "big", cursor, "COUNT", "10").(*radio.Array)
+		cursor = reply.Items[0].(*radio.BulkStr).String()
+		items := reply.Items[1].(*radio.Array).Items
+		for i := 0; i < len(items); i += 2 {
+			members[items[i].(*radio.BulkStr).String()] = true
+		}
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if len(members) != 199 {
+		t.Errorf("expecting ZSCAN to return all the 199 members, got %d", len(members))
+	}
+}
+
+func TestZsets_Blocking(t *testing.T) {
+	blocker := &radio.Blocker{}
+	s := store.New(store.Options{Blocker: blocker})
+	defer s.Close()
+
+	served := &servedCounter{Handler: &store.Handler{Store: s}}
+	blocker.Handler = served
+	srv := radiotest.NewServer(blocker)
+	defer srv.Close()
+
+	first, second := dial(t, srv), dial(t, srv)
+	defer first.Close()
+	defer second.Close()
+
+	first.Send("BZPOPMAX", "a", "b", "0")
+	first.Flush()
+	served.wait(t, 1)
+
+	expect(t, second, radio.Integer(2), "ZADD", "b", "1", "x", "2", "y")
+	if v, err := first.Receive(); err != nil || !reflect.DeepEqual(bulks("b", "y", "2"), v) {
+		t.Errorf("expecting '[b y 2]', got '%v' (err=%v)", v, err)
+	}
+	expect(t, first, &radio.Array{}, "BZPOPMIN", "a", "0.01")
+}
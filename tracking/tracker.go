@@ -0,0 +1,559 @@
+// Package tracking implements the server side of Redis client side caching.
+// Clients enable tracking using CLIENT TRACKING and are sent invalidation
+// messages when the keys they read (or, in broadcasting mode, the keys with
+// the prefixes they are interested in) are modified. Invalidation messages
+// are sent as RESP3 push messages or, to a client using RESP2, as pub/sub
+// messages on the __redis__:invalidate channel.
+package tracking
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spy16/radio"
+)
+
+// InvalidateChannel is the pub/sub channel the invalidation messages are
+// published on for clients using RESP2.
+const InvalidateChannel = "__redis__:invalidate"
+
+// Tracker tracks the keys read by the clients and sends them invalidation
+// messages. Tracker is a handler that serves CLIENT TRACKING, CLIENT
+// CACHING and CLIENT GETREDIR and passes all the other requests to the
+// wrapped handler. The wrapped handler must call Track for the keys read
+// and Invalidate for the keys modified by the requests.
+//
+// Invalidation messages are pushed to clients using RESP3 (e.g., switched
+// using HELLO 3 served by radio.Hello). Clients using RESP2 receive them only through
+// redirection to a client subscribed to __redis__:invalidate. Tracker
+// observes the SUBSCRIBE and UNSUBSCRIBE requests passed to the wrapped
+// handler (e.g., keyspace.Notifier or a pub/sub broker) to find such clients.
+// Clients can only redirect to clients that are subscribed or have tracking
+// enabled.
+type Tracker struct {
+	// Handler serves the requests not served by the tracker.
+	Handler radio.Handler
+
+	mu       sync.Mutex
+	clients  map[uint64]*client
+	keys     map[string]map[uint64]struct{}
+	prefixes map[string]map[uint64]struct{}
+}
+
+type clientKey struct{}
+
+// client holds the tracking state of a client. All fields are protected by
+// the tracker lock.
+type client struct {
+	cl *radio.Client
+
+	// wr is the writer of the CLIENT TRACKING request used to push
+	// invalidation messages. subscription is the writer of the request
+	// that subscribed the client to the invalidation channel.
+	wr           radio.ResponseWriter
+	subscription radio.ResponseWriter
+
+	enabled  bool
+	redirect uint64
+	bcast    bool
+	optin    bool
+	optout   bool
+	noloop   bool
+	prefixes []string
+
+	// caching is the CLIENT CACHING choice for the next request (1 for yes
+	// and -1 for no).
+	caching int
+}
+
+// Track records that the client of the request read the keys so that it is
+// sent an invalidation message when any of them is modified. Handlers must
+// call Track for the keys read by the request.
+func (t *Tracker) Track(req *radio.Request, keys ...string) {
+	c, ok := req.Client.Value(clientKey{}).(*client)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !c.enabled || c.bcast || (c.optin && c.caching != 1) || (c.optout && c.caching == -1) {
+		return
+	}
+
+	if t.keys == nil {
+		t.keys = map[string]map[uint64]struct{}{}
+	}
+
+	for _, key := range keys {
+		if t.keys[key] == nil {
+			t.keys[key] = map[uint64]struct{}{}
+		}
+		t.keys[key][c.cl.ID] = struct{}{}
+	}
+}
+
+// Invalidate sends invalidation messages for the modified keys to the
+// clients tracking them. The keys are no longer tracked for those clients
+// until they are read again. req is the request that modified the keys and
+// is used to skip the client if it enabled NOLOOP. req can be nil if the
+// keys are not modified by a request (e.g., when keys expire).
+func (t *Tracker) Invalidate(req *radio.Request, keys ...string) {
+	var self uint64
+	if req != nil && req.Client != nil {
+		self = req.Client.ID
+	}
+
+	t.mu.Lock()
+	invalidated := map[uint64][]string{}
+	for _, key := range keys {
+		for id := range t.keys[key] {
+			invalidated[id] = append(invalidated[id], key)
+		}
+		delete(t.keys, key)
+
+		for prefix, ids := range t.prefixes {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			for id := range ids {
+				invalidated[id] = append(invalidated[id], key)
+			}
+		}
+	}
+
+	var deliveries []delivery
+	for id, keys := range invalidated {
+		c := t.clients[id]
+		if c == nil || !c.enabled || (c.noloop && id == self) {
+			continue
+		}
+
+		items := make([]radio.Value, len(keys))
+		for i, key := range keys {
+			items[i] = &radio.BulkStr{Value: []byte(key)}
+		}
+		deliveries = append(deliveries, t.deliveryTo(c, &radio.Array{Items: items}))
+	}
+	t.mu.Unlock()
+
+	deliver(deliveries)
+}
+
+// InvalidateAll sends an invalidation message for all the keys to all the
+// clients with tracking enabled (e.g., when the databases are flushed).
+func (t *Tracker) InvalidateAll() {
+	t.mu.Lock()
+	t.keys = nil
+
+	var deliveries []delivery
+	for _, c := range t.clients {
+		if c.enabled {
+			deliveries = append(deliveries, t.deliveryTo(c, &radio.Array{}))
+		}
+	}
+	t.mu.Unlock()
+
+	deliver(deliveries)
+}
+
+// ServeRESP serves the tracking commands and passes the other requests to
+// the wrapped handler.
+func (t *Tracker) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	cmd := strings.ToLower(req.Command)
+	if cmd == "client" && len(req.Args) > 0 {
+		switch strings.ToLower(req.Args[0]) {
+		case "tracking":
+			t.serveTracking(wr, req)
+			return
+
+		case "caching":
+			t.serveCaching(wr, req)
+			return
+
+		case "getredir":
+			t.serveGetRedir(wr, req)
+			return
+		}
+	}
+
+	switch cmd {
+	case "subscribe":
+		for _, channel := range req.Args {
+			if channel == InvalidateChannel {
+				t.subscribed(req.Client, wr)
+				break
+			}
+		}
+
+	case "unsubscribe":
+		for _, channel := range req.Args {
+			if channel == InvalidateChannel {
+				t.subscribed(req.Client, nil)
+				break
+			}
+		}
+
+		if len(req.Args) == 0 {
+			t.subscribed(req.Client, nil)
+		}
+
+	case "reset":
+		t.reset(req.Client)
+	}
+
+	t.Handler.ServeRESP(wr, req)
+
+	// the CLIENT CACHING choice applies only to the next request.
+	if c, ok := req.Client.Value(clientKey{}).(*client); ok {
+		t.mu.Lock()
+		c.caching = 0
+		t.mu.Unlock()
+	}
+}
+
+func (t *Tracker) serveTracking(wr radio.ResponseWriter, req *radio.Request) {
+	if req.Client == nil {
+		wr.Write(radio.ErrorStr("ERR CLIENT TRACKING requires a client connection"))
+		return
+	}
+
+	if len(req.Args) < 2 {
+		wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'client|tracking' command"))
+		return
+	}
+
+	opts := client{}
+	switch strings.ToLower(req.Args[1]) {
+	case "on":
+		opts.enabled = true
+	case "off":
+	default:
+		wr.Write(radio.ErrorStr("ERR syntax error"))
+		return
+	}
+
+	for i := 2; i < len(req.Args); i++ {
+		switch strings.ToLower(req.Args[i]) {
+		case "redirect":
+			if i+1 >= len(req.Args) {
+				wr.Write(radio.ErrorStr("ERR syntax error"))
+				return
+			}
+			i++
+
+			id, err := strconv.ParseUint(req.Args[i], 10, 64)
+			if err != nil {
+				wr.Write(radio.ErrorStr("ERR value is not an integer or out of range"))
+				return
+			}
+			opts.redirect = id
+
+		case "prefix":
+			if i+1 >= len(req.Args) {
+				wr.Write(radio.ErrorStr("ERR syntax error"))
+				return
+			}
+			i++
+			opts.prefixes = append(opts.prefixes, req.Args[i])
+
+		case "bcast":
+			opts.bcast = true
+		case "optin":
+			opts.optin = true
+		case "optout":
+			opts.optout = true
+		case "noloop":
+			opts.noloop = true
+
+		default:
+			wr.Write(radio.ErrorStr("ERR syntax error"))
+			return
+		}
+	}
+
+	if !opts.enabled {
+		t.disable(req.Client)
+		wr.Write(radio.SimpleStr("OK"))
+		return
+	}
+
+	if err := t.enable(req.Client, wr, opts); err != nil {
+		wr.Write(err)
+		return
+	}
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func (t *Tracker) serveCaching(wr radio.ResponseWriter, req *radio.Request) {
+	if len(req.Args) != 2 {
+		wr.Write(radio.ErrorStr("ERR wrong number of arguments for 'client|caching' command"))
+		return
+	}
+
+	caching := 0
+	switch strings.ToLower(req.Args[1]) {
+	case "yes":
+		caching = 1
+	case "no":
+		caching = -1
+	default:
+		wr.Write(radio.ErrorStr("ERR syntax error"))
+		return
+	}
+
+	c, _ := req.Client.Value(clientKey{}).(*client)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c == nil || !c.enabled || (caching == 1 && !c.optin) || (caching == -1 && !c.optout) {
+		if caching == 1 {
+			wr.Write(radio.ErrorStr("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode."))
+		} else {
+			wr.Write(radio.ErrorStr("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode."))
+		}
+		return
+	}
+
+	c.caching = caching
+	wr.Write(radio.SimpleStr("OK"))
+}
+
+func (t *Tracker) serveGetRedir(wr radio.ResponseWriter, req *radio.Request) {
+	c, _ := req.Client.Value(clientKey{}).(*client)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case c == nil || !c.enabled:
+		wr.Write(radio.Integer(-1))
+	default:
+		wr.Write(radio.Integer(c.redirect))
+	}
+}
+
+// enable enables tracking for the client with the options. Returns an error
+// reply if the options are invalid.
+func (t *Tracker) enable(cl *radio.Client, wr radio.ResponseWriter, opts client) radio.Value {
+	if len(opts.prefixes) > 0 && !opts.bcast {
+		return radio.ErrorStr("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+
+	if opts.optin && opts.optout {
+		return radio.ErrorStr("ERR You can't use both OPTIN and OPTOUT")
+	}
+
+	if opts.bcast && (opts.optin || opts.optout) {
+		return radio.ErrorStr("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+
+	for i, prefix := range opts.prefixes {
+		for _, other := range opts.prefixes[i+1:] {
+			if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+				return radio.ErrorStr("ERR Prefix '" + other + "' overlaps with another provided prefix '" + prefix + "'. Prefixes for a single client must not overlap.")
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if opts.redirect != 0 {
+		if target := t.clients[opts.redirect]; target == nil || target.cl.Context().Err() != nil {
+			return radio.ErrorStr("ERR The client ID you want redirect to does not exist")
+		}
+	}
+
+	c := t.client(cl)
+	if c.enabled && (c.bcast != opts.bcast || c.optin != opts.optin || c.optout != opts.optout) {
+		return radio.ErrorStr("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+	}
+
+	if opts.bcast {
+		if len(opts.prefixes) == 0 {
+			opts.prefixes = []string{""}
+		}
+
+		if t.prefixes == nil {
+			t.prefixes = map[string]map[uint64]struct{}{}
+		}
+
+		for _, prefix := range opts.prefixes {
+			if t.prefixes[prefix] == nil {
+				t.prefixes[prefix] = map[uint64]struct{}{}
+			}
+			t.prefixes[prefix][cl.ID] = struct{}{}
+		}
+	}
+
+	c.wr = wr
+	c.enabled = true
+	c.redirect = opts.redirect
+	c.bcast = opts.bcast
+	c.optin = opts.optin
+	c.optout = opts.optout
+	c.noloop = opts.noloop
+	for _, prefix := range opts.prefixes {
+		if !contains(c.prefixes, prefix) {
+			c.prefixes = append(c.prefixes, prefix)
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) disable(cl *radio.Client) {
+	c, ok := cl.Value(clientKey{}).(*client)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.disableLocked(c)
+}
+
+// disableLocked disables tracking for the client. The keys read by the
+// client are removed from the tracking table lazily. t.mu must be held.
+func (t *Tracker) disableLocked(c *client) {
+	for _, prefix := range c.prefixes {
+		delete(t.prefixes[prefix], c.cl.ID)
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+
+	c.enabled = false
+	c.redirect = 0
+	c.bcast, c.optin, c.optout, c.noloop = false, false, false, false
+	c.prefixes = nil
+	c.caching = 0
+}
+
+func (t *Tracker) reset(cl *radio.Client) {
+	c, ok := cl.Value(clientKey{}).(*client)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.disableLocked(c)
+	c.subscription = nil
+}
+
+// subscribed records the writer of the client subscribed to the invalidation
+// channel or removes it if wr is nil.
+func (t *Tracker) subscribed(cl *radio.Client, wr radio.ResponseWriter) {
+	if cl == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if wr == nil {
+		if c, ok := cl.Value(clientKey{}).(*client); ok {
+			c.subscription = nil
+		}
+		return
+	}
+
+	t.client(cl).subscription = wr
+}
+
+// client returns the state of the client creating it if required. The state
+// is removed when the server closes the connection of the client. t.mu must
+// be held.
+func (t *Tracker) client(cl *radio.Client) *client {
+	if c, ok := cl.Value(clientKey{}).(*client); ok {
+		return c
+	}
+
+	c := &client{cl: cl}
+	cl.SetValue(clientKey{}, c)
+
+	if t.clients == nil {
+		t.clients = map[uint64]*client{}
+	}
+	t.clients[cl.ID] = c
+
+	cl.OnClose(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.disableLocked(c)
+		delete(t.clients, cl.ID)
+	})
+
+	return c
+}
+
+type delivery struct {
+	wr  radio.ResponseWriter
+	msg radio.Value
+}
+
+// deliveryTo returns the invalidation message for the client, taking
+// redirection into account. t.mu must be held.
+func (t *Tracker) deliveryTo(c *client, keys *radio.Array) delivery {
+	target := c
+	if c.redirect != 0 {
+		target = t.clients[c.redirect]
+		if target == nil || target.cl.Context().Err() != nil {
+			if c.cl.Protocol() >= 3 {
+				return delivery{wr: c.wr, msg: &radio.Push{Items: []radio.Value{
+					&radio.BulkStr{Value: []byte("tracking-redir-broken")},
+					radio.Integer(c.redirect),
+				}}}
+			}
+			return delivery{}
+		}
+	}
+
+	if target.cl.Protocol() >= 3 {
+		wr := target.wr
+		if wr == nil {
+			wr = target.subscription
+		}
+
+		return delivery{wr: wr, msg: &radio.Push{Items: []radio.Value{
+			&radio.BulkStr{Value: []byte("invalidate")},
+			keys,
+		}}}
+	}
+
+	if target.subscription == nil {
+		return delivery{}
+	}
+
+	return delivery{wr: target.subscription, msg: &radio.Array{Items: []radio.Value{
+		&radio.BulkStr{Value: []byte("message")},
+		&radio.BulkStr{Value: []byte(InvalidateChannel)},
+		keys,
+	}}}
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver writes the messages without holding the lock so that slow clients
+// do not block the other clients.
+func deliver(deliveries []delivery) {
+	for _, d := range deliveries {
+		if d.wr != nil {
+			d.wr.Write(d.msg)
+		}
+	}
+}
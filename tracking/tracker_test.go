@@ -0,0 +1,377 @@
+package tracking_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spy16/radio"
+	"github.com/spy16/radio/radiotest"
+	"github.com/spy16/radio/tracking"
+)
+
+func TestTracker(suite *testing.T) {
+	suite.Parallel()
+
+	suite.Run("Default", func(t *testing.T) {
+		srv, _ := newServer()
+		defer srv.Close()
+
+		cached, writer := dial(t, srv), dial(t, srv)
+		defer cached.Close()
+		defer writer.Close()
+
+		hello(t, cached)
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "TRACKING", "on")
+		expect(t, cached, radio.Integer(0), "CLIENT", "GETREDIR")
+		expect(t, cached, bulk(""), "GET", "foo")
+
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "foo", "1")
+		receive(t, cached, invalidate("foo"))
+
+		// foo is not tracked until it is read again.
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "foo", "2")
+		expect(t, cached, radio.SimpleStr("PONG"), "PING")
+
+		expect(t, cached, bulk("2"), "GET", "foo")
+		expect(t, writer, radio.SimpleStr("OK"), "FLUSHALL")
+		receive(t, cached, &radio.Push{Items: []radio.Value{bulk("invalidate"), &radio.Array{}}})
+
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "TRACKING", "off")
+		expect(t, cached, radio.Integer(-1), "CLIENT", "GETREDIR")
+		expect(t, cached, bulk(""), "GET", "foo")
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "foo", "3")
+		expect(t, cached, radio.SimpleStr("PONG"), "PING")
+	})
+
+	suite.Run("Redirect", func(t *testing.T) {
+		srv, _ := newServer()
+		defer srv.Close()
+
+		subscriber, cached, writer := dial(t, srv), dial(t, srv), dial(t, srv)
+		defer subscriber.Close()
+		defer cached.Close()
+		defer writer.Close()
+
+		id, _ := subscriber.Do("CLIENT", "ID")
+		redirect := id.(radio.Integer).String()
+
+		expect(t, cached, radio.ErrorStr("ERR The client ID you want redirect to does not exist"), "CLIENT", "TRACKING", "on", "REDIRECT", redirect)
+
+		expect(t, subscriber, subscription("subscribe", tracking.InvalidateChannel, 1), "SUBSCRIBE", tracking.InvalidateChannel)
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "TRACKING", "on", "REDIRECT", redirect)
+		expect(t, cached, id, "CLIENT", "GETREDIR")
+		expect(t, cached, bulk(""), "GET", "foo")
+
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "foo", "1")
+		receive(t, subscriber, &radio.Array{Items: []radio.Value{
+			bulk("message"),
+			bulk(tracking.InvalidateChannel),
+			&radio.Array{Items: []radio.Value{bulk("foo")}},
+		}})
+	})
+
+	suite.Run("RedirectBroken", func(t *testing.T) {
+		srv, _ := newServer()
+		defer srv.Close()
+
+		subscriber, cached, writer := dial(t, srv), dial(t, srv), dial(t, srv)
+		defer cached.Close()
+		defer writer.Close()
+
+		id, _ := subscriber.Do("CLIENT", "ID")
+		expect(t, subscriber, subscription("subscribe", tracking.InvalidateChannel, 1), "SUBSCRIBE", tracking.InvalidateChannel)
+
+		hello(t, cached)
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "TRACKING", "on", "REDIRECT", id.(radio.Integer).String())
+		expect(t, cached, bulk(""), "GET", "foo")
+		subscriber.Close()
+
+		// wait for the server to notice the disconnect.
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			v, err := writer.Do("CLIENT", "TRACKING", "on", "REDIRECT", id.(radio.Integer).String())
+			if err != nil {
+				t.Fatalf("expecting no error, got '%v'", err)
+			}
+
+			if _, ok := v.(radio.ErrorStr); ok {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("expecting redirect client to be removed after disconnect")
+			}
+			writer.Do("CLIENT", "TRACKING", "off")
+			time.Sleep(time.Millisecond)
+		}
+
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "foo", "1")
+		receive(t, cached, &radio.Push{Items: []radio.Value{bulk("tracking-redir-broken"), id}})
+	})
+
+	suite.Run("BCAST", func(t *testing.T) {
+		srv, _ := newServer()
+		defer srv.Close()
+
+		cached, writer := dial(t, srv), dial(t, srv)
+		defer cached.Close()
+		defer writer.Close()
+
+		hello(t, cached)
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "TRACKING", "on", "BCAST", "PREFIX", "user:", "PREFIX", "item:")
+
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "other", "1")
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "user:1", "1")
+		receive(t, cached, invalidate("user:1"))
+
+		// keys are invalidated every time without being read.
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "item:1", "1")
+		receive(t, cached, invalidate("item:1"))
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "user:1", "2")
+		receive(t, cached, invalidate("user:1"))
+
+		expect(t, cached, radio.ErrorStr("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode."),
+			"CLIENT", "TRACKING", "on")
+	})
+
+	suite.Run("OPTIN", func(t *testing.T) {
+		srv, _ := newServer()
+		defer srv.Close()
+
+		cached, writer := dial(t, srv), dial(t, srv)
+		defer cached.Close()
+		defer writer.Close()
+
+		hello(t, cached)
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "TRACKING", "on", "OPTIN")
+		expect(t, cached, bulk(""), "GET", "a")
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "CACHING", "yes")
+		expect(t, cached, bulk(""), "GET", "b")
+		expect(t, cached, bulk(""), "GET", "c")
+
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "a", "1")
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "c", "1")
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "b", "1")
+		receive(t, cached, invalidate("b"))
+
+		expect(t, cached, radio.ErrorStr("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode."), "CLIENT", "CACHING", "no")
+	})
+
+	suite.Run("OPTOUT", func(t *testing.T) {
+		srv, _ := newServer()
+		defer srv.Close()
+
+		cached, writer := dial(t, srv), dial(t, srv)
+		defer cached.Close()
+		defer writer.Close()
+
+		hello(t, cached)
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "TRACKING", "on", "OPTOUT")
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "CACHING", "no")
+		expect(t, cached, bulk(""), "GET", "a")
+		expect(t, cached, bulk(""), "GET", "b")
+
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "a", "1")
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "b", "1")
+		receive(t, cached, invalidate("b"))
+	})
+
+	suite.Run("NOLOOP", func(t *testing.T) {
+		srv, _ := newServer()
+		defer srv.Close()
+
+		cached, writer := dial(t, srv), dial(t, srv)
+		defer cached.Close()
+		defer writer.Close()
+
+		hello(t, cached)
+		expect(t, cached, radio.SimpleStr("OK"), "CLIENT", "TRACKING", "on", "BCAST", "NOLOOP")
+
+		expect(t, cached, radio.SimpleStr("OK"), "SET", "a", "1")
+		expect(t, writer, radio.SimpleStr("OK"), "SET", "b", "1")
+		receive(t, cached, invalidate("b"))
+	})
+}
+
+func TestTracker_Errors(suite *testing.T) {
+	suite.Parallel()
+
+	cases := []struct {
+		title string
+		args  []string
+		err   radio.ErrorStr
+	}{
+		{
+			title: "NoArgs",
+			args:  []string{"TRACKING"},
+			err:   "ERR wrong number of arguments for 'client|tracking' command",
+		},
+		{
+			title: "InvalidMode",
+			args:  []string{"TRACKING", "maybe"},
+			err:   "ERR syntax error",
+		},
+		{
+			title: "UnknownOption",
+			args:  []string{"TRACKING", "on", "FAST"},
+			err:   "ERR syntax error",
+		},
+		{
+			title: "InvalidRedirect",
+			args:  []string{"TRACKING", "on", "REDIRECT", "abc"},
+			err:   "ERR value is not an integer or out of range",
+		},
+		{
+			title: "PrefixWithoutBCAST",
+			args:  []string{"TRACKING", "on", "PREFIX", "a"},
+			err:   "ERR PREFIX option requires BCAST mode to be enabled",
+		},
+		{
+			title: "OPTINAndOPTOUT",
+			args:  []string{"TRACKING", "on", "OPTIN", "OPTOUT"},
+			err:   "ERR You can't use both OPTIN and OPTOUT",
+		},
+		{
+			title: "BCASTAndOPTIN",
+			args:  []string{"TRACKING", "on", "BCAST", "OPTIN"},
+			err:   "ERR OPTIN and OPTOUT are not compatible with BCAST",
+		},
+		{
+			title: "OverlappingPrefixes",
+			args:  []string{"TRACKING", "on", "BCAST", "PREFIX", "foo", "PREFIX", "foobar"},
+			err:   "ERR Prefix 'foobar' overlaps with another provided prefix 'foo'. Prefixes for a single client must not overlap.",
+		},
+		{
+			title: "CachingWithoutTracking",
+			args:  []string{"CACHING", "yes"},
+			err:   "ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.",
+		},
+		{
+			title: "CachingInvalid",
+			args:  []string{"CACHING", "maybe"},
+			err:   "ERR syntax error",
+		},
+	}
+
+	for i, cs := range cases {
+		cs := cs
+		id := uint64(i + 1)
+		suite.Run(cs.title, func(t *testing.T) {
+			tr := &tracking.Tracker{}
+
+			rec := radiotest.NewRecorder()
+			tr.ServeRESP(rec, &radio.Request{
+				Command: "CLIENT",
+				Args:    cs.args,
+				Client:  &radio.Client{ID: id},
+			})
+
+			if last := rec.Last(); last != cs.err {
+				t.Errorf("expecting '%v', got '%v'", cs.err, last)
+			}
+		})
+	}
+}
+
+func newServer() (*radiotest.Server, *tracking.Tracker) {
+	tr := &tracking.Tracker{}
+	tr.Handler = &stringsHandler{tr: tr, data: map[string]string{}}
+	return radiotest.NewServer(&radio.Hello{Handler: tr}), tr
+}
+
+// stringsHandler implements GET, SET and FLUSHALL with tracking along with
+// CLIENT ID and SUBSCRIBE.
+type stringsHandler struct {
+	tr *tracking.Tracker
+
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (sh *stringsHandler) ServeRESP(wr radio.ResponseWriter, req *radio.Request) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	switch req.Command {
+	case "CLIENT":
+		wr.Write(radio.Integer(req.Client.ID))
+
+	case "SUBSCRIBE":
+		wr.Write(subscription("subscribe", req.Args[0], 1))
+
+	case "PING":
+		wr.Write(radio.SimpleStr("PONG"))
+
+	case "GET":
+		sh.tr.Track(req, req.Args[0])
+		wr.Write(bulk(sh.data[req.Args[0]]))
+
+	case "SET":
+		sh.data[req.Args[0]] = req.Args[1]
+		sh.tr.Invalidate(req, req.Args[0])
+		wr.Write(radio.SimpleStr("OK"))
+
+	case "FLUSHALL":
+		sh.data = map[string]string{}
+		sh.tr.InvalidateAll()
+		wr.Write(radio.SimpleStr("OK"))
+
+	default:
+		wr.Write(radio.ErrorStr("ERR unknown command '" + req.Command + "'"))
+	}
+}
+
+func dial(t *testing.T, srv *radiotest.Server) *radio.Conn {
+	conn, err := srv.Dial()
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	return conn
+}
+
+// hello switches the connection to RESP3.
+func hello(t *testing.T, conn *radio.Conn) {
+	v, err := conn.Do("HELLO", "3")
+	if err != nil {
+		t.Fatalf("not expecting error, got '%v'", err)
+	}
+	if _, ok := v.(*radio.Map); !ok {
+		t.Fatalf("expecting HELLO to reply with a map, got '%#v'", v)
+	}
+}
+
+func expect(t *testing.T, conn *radio.Conn, expected radio.Value, cmd string, args ...string) {
+	t.Helper()
+
+	v, err := conn.Do(cmd, args...)
+	if err != nil || !reflect.DeepEqual(expected, v) {
+		t.Errorf("%s %v: expecting '%v', got '%v' (err=%v)", cmd, args, expected, v, err)
+	}
+}
+
+func receive(t *testing.T, conn *radio.Conn, expected radio.Value) {
+	t.Helper()
+
+	v, err := conn.Receive()
+	if err != nil || !reflect.DeepEqual(expected, v) {
+		t.Errorf("expecting '%v', got '%v' (err=%v)", expected, v, err)
+	}
+}
+
+func invalidate(keys ...string) *radio.Push {
+	arr := &radio.Array{Items: []radio.Value{}}
+	for _, key := range keys {
+		arr.Items = append(arr.Items, bulk(key))
+	}
+	return &radio.Push{Items: []radio.Value{bulk("invalidate"), arr}}
+}
+
+func subscription(kind, channel string, count int) *radio.Array {
+	return &radio.Array{Items: []radio.Value{bulk(kind), bulk(channel), radio.Integer(count)}}
+}
+
+func bulk(s string) *radio.BulkStr {
+	return &radio.BulkStr{Value: []byte(s)}
+}
@@ -102,3 +102,60 @@ func (arr *Array) String() string {
 
 	return strings.Join(strs, "\n")
 }
+
+// Push represents the RESP3 push type used by the server to send out-of-band
+// data (e.g., invalidation messages of client side caching). Push values
+// must only be sent to clients using RESP3.
+// Refer https://github.com/redis/redis-specifications/blob/master/protocol/RESP3.md
+type Push struct {
+	Items []Value
+}
+
+// Serialize returns RESP3 representation of the Push.
+func (p *Push) Serialize() string {
+	s := fmt.Sprintf(">%d\r\n", len(p.Items))
+	for _, val := range p.Items {
+		s += val.Serialize()
+	}
+
+	return s
+}
+
+func (p *Push) String() string {
+	return (&Array{Items: p.Items}).String()
+}
+
+// Map represents the RESP3 map type (e.g., the reply of HELLO 3). Items
+// holds the keys and the values alternately. Map values must only be sent
+// to clients using RESP3.
+// Refer https://github.com/redis/redis-specifications/blob/master/protocol/RESP3.md
+type Map struct {
+	Items []Value
+}
+
+// Serialize returns RESP3 representation of the Map.
+func (m *Map) Serialize() string {
+	s := fmt.Sprintf("%%%d\r\n", len(m.Items)/2)
+	for _, val := range m.Items {
+		s += val.Serialize()
+	}
+
+	return s
+}
+
+func (m *Map) String() string {
+	return (&Array{Items: m.Items}).String()
+}
+
+// Raw represents an already serialized RESP value. Raw values are written
+// as is and are useful for relaying values without re-encoding them.
+type Raw []byte
+
+// Serialize returns the raw bytes as is.
+func (raw Raw) Serialize() string {
+	return string(raw)
+}
+
+func (raw Raw) String() string {
+	return string(raw)
+}
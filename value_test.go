@@ -76,6 +76,26 @@ func TestSerialize(suite *testing.T) {
 			resp: "*0\r\n",
 			str:  "",
 		},
+		{
+			val: &radio.Push{
+				Items: []radio.Value{
+					&radio.BulkStr{Value: []byte("invalidate")},
+					&radio.Array{},
+				},
+			},
+			resp: ">2\r\n$10\r\ninvalidate\r\n*-1\r\n",
+			str:  "invalidate\n",
+		},
+		{
+			val: &radio.Map{
+				Items: []radio.Value{
+					&radio.BulkStr{Value: []byte("proto")},
+					radio.Integer(3),
+				},
+			},
+			resp: "%1\r\n$5\r\nproto\r\n:3\r\n",
+			str:  "proto\n3",
+		},
 	}
 
 	for _, cs := range cases {
@@ -18,3 +18,15 @@ func TestWriter_Write(t *testing.T) {
 		t.Errorf("expecting '%s', got '%s'", expected, actual)
 	}
 }
+
+func TestWriter_Write_Request(t *testing.T) {
+	b := &bytes.Buffer{}
+	wr := radio.NewWriter(b)
+	wr.Write(&radio.Request{Command: "SET", Args: []string{"key", ""}})
+
+	expected := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$0\r\n\r\n"
+
+	if actual := b.String(); actual != expected {
+		t.Errorf("expecting '%s', got '%s'", expected, actual)
+	}
+}